@@ -0,0 +1,99 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Function is a function expressions can call, either as name(args) or, for a method, as args[0].name(args[1:])
+type Function struct {
+	Name   string
+	Params []*Type
+	Result *Type
+	// Impl is called with arguments of the parameter types and returns a value of the result type
+	Impl func(args ...Value) (Value, error)
+}
+
+// Env declares the variables and functions available to expressions
+type Env struct {
+	vars    map[string]*Type
+	funcs   map[string][]*Function
+	methods map[string][]*Function
+}
+
+// NewEnv returns an environment with the builtin functions:
+// size(string or list), lower(string), and the string methods startsWith, endsWith and contains
+func NewEnv() *Env {
+	e := &Env{
+		vars:    make(map[string]*Type),
+		funcs:   make(map[string][]*Function),
+		methods: make(map[string][]*Function),
+	}
+
+	e.Function(&Function{Name: "size", Params: []*Type{String}, Result: Number, Impl: func(args ...Value) (Value, error) {
+		return Int64(int64(len(args[0].(string)))), nil
+	}})
+	e.Function(&Function{Name: "size", Params: []*Type{ListOf(nil)}, Result: Number, Impl: func(args ...Value) (Value, error) {
+		return Int64(int64(args[0].(List).Size())), nil
+	}})
+	e.Function(&Function{Name: "lower", Params: []*Type{String}, Result: String, Impl: func(args ...Value) (Value, error) {
+		return strings.ToLower(args[0].(string)), nil
+	}})
+
+	stringPredicates := map[string]func(s, t string) bool{
+		"startsWith": strings.HasPrefix,
+		"endsWith":   strings.HasSuffix,
+		"contains":   strings.Contains,
+	}
+	for name, predicate := range stringPredicates {
+		predicate := predicate
+		e.Method(&Function{Name: name, Params: []*Type{String, String}, Result: Bool, Impl: func(args ...Value) (Value, error) {
+			return predicate(args[0].(string), args[1].(string)), nil
+		}})
+	}
+
+	return e
+}
+
+// Declare adds a variable of the given type
+func (e *Env) Declare(name string, t *Type) {
+	e.vars[name] = t
+}
+
+// Function adds a global function, several functions of the same name are overloads told apart by their parameters
+func (e *Env) Function(f *Function) {
+	e.funcs[f.Name] = append(e.funcs[f.Name], f)
+}
+
+// Method adds a method, called on a value of the type of its first parameter
+func (e *Env) Method(f *Function) {
+	e.methods[f.Name] = append(e.methods[f.Name], f)
+}
+
+// resolve picks the overload accepting the argument types
+func resolve(overloads []*Function, args []node) (*Function, error) {
+	for _, f := range overloads {
+		if len(f.Params) != len(args) {
+			continue
+		}
+
+		ok := true
+		for i, param := range f.Params {
+			if !param.accepts(args[i].typ()) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return f, nil
+		}
+	}
+
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.typ().String()
+	}
+
+	return nil, fmt.Errorf("no overload of %s accepts (%s)", overloads[0].Name, strings.Join(types, ", "))
+}
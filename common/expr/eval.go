@@ -0,0 +1,277 @@
+package expr
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// node is a type checked expression
+type node interface {
+	typ() *Type
+	eval(vars map[string]Value) (Value, error)
+}
+
+type literal struct {
+	v Value
+	t *Type
+}
+
+func (n *literal) typ() *Type { return n.t }
+
+func (n *literal) eval(map[string]Value) (Value, error) {
+	return n.v, nil
+}
+
+type variable struct {
+	name string
+	t    *Type
+}
+
+func (n *variable) typ() *Type { return n.t }
+
+func (n *variable) eval(vars map[string]Value) (Value, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no value for variable %s", ErrEval, n.name)
+	}
+
+	return v, nil
+}
+
+type field struct {
+	x    node
+	name string
+	t    *Type
+}
+
+func (n *field) typ() *Type { return n.t }
+
+func (n *field) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, ok := x.(Object)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not an object", ErrEval, n.x.typ())
+	}
+
+	v := obj.Field(n.name)
+	if v == nil {
+		return nil, fmt.Errorf("%w: no value for field %s", ErrEval, n.name)
+	}
+
+	return v, nil
+}
+
+type call struct {
+	f    *Function
+	args []node
+}
+
+func (n *call) typ() *Type { return n.f.Result }
+
+func (n *call) eval(vars map[string]Value) (Value, error) {
+	args := make([]Value, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	v, err := n.f.Impl(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrEval, n.f.Name, err)
+	}
+
+	return v, nil
+}
+
+type list struct {
+	elems []node
+	t     *Type
+}
+
+func (n *list) typ() *Type { return n.t }
+
+func (n *list) eval(vars map[string]Value) (Value, error) {
+	l := make(values, len(n.elems))
+	for i, elem := range n.elems {
+		v, err := elem.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = v
+	}
+
+	return l, nil
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (n *unary) typ() *Type { return n.x.typ() }
+
+func (n *unary) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		return !x.(bool), nil
+	}
+
+	return new(big.Rat).Neg(x.(*big.Rat)), nil
+}
+
+// logical is && or ||, the right operand is only evaluated when the left one doesn't decide
+type logical struct {
+	or   bool
+	x, y node
+}
+
+func (n *logical) typ() *Type { return Bool }
+
+func (n *logical) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if x.(bool) == n.or {
+		return n.or, nil
+	}
+
+	return n.y.eval(vars)
+}
+
+type comparison struct {
+	op   string
+	x, y node
+}
+
+func (n *comparison) typ() *Type { return Bool }
+
+func (n *comparison) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := n.y.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmp int
+	switch x := x.(type) {
+	case *big.Rat:
+		cmp = x.Cmp(y.(*big.Rat))
+	case string:
+		switch y := y.(string); {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	case bool:
+		if x != y.(bool) {
+			cmp = 1
+		}
+	}
+
+	switch n.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type in struct {
+	x, list node
+}
+
+func (n *in) typ() *Type { return Bool }
+
+func (n *in) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := n.list.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.(List).Contains(x), nil
+}
+
+type arithmetic struct {
+	op   string
+	x, y node
+}
+
+func (n *arithmetic) typ() *Type { return Number }
+
+func (n *arithmetic) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := n.y.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	a, b := x.(*big.Rat), y.(*big.Rat)
+	switch n.op {
+	case "+":
+		return new(big.Rat).Add(a, b), nil
+	case "-":
+		return new(big.Rat).Sub(a, b), nil
+	case "*":
+		return new(big.Rat).Mul(a, b), nil
+	default:
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("%w: division by zero", ErrEval)
+		}
+		return new(big.Rat).Quo(a, b), nil
+	}
+}
+
+type concat struct {
+	x, y node
+}
+
+func (n *concat) typ() *Type { return String }
+
+func (n *concat) eval(vars map[string]Value) (Value, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := n.y.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.(string) + y.(string), nil
+}
@@ -0,0 +1,77 @@
+// Package expr is a small, statically typed expression language in the spirit of CEL.
+//
+// An expression is compiled once against an Env declaring the variables and functions it can use,
+// which catches syntax and type errors up front, and evaluated many times against variable values:
+//
+//	tx.value > 100e18 && tx.to in watchlist("cold") && !tx.success
+//
+// Values are booleans, numbers, strings, lists and objects with typed fields. Numbers are exact rationals,
+// so that wei amounts far above 2^64 compare and add without loss, and literals such as 1.5e18 are exact too.
+// The operators are, by increasing precedence: ||, &&, the comparisons == != < <= > >= and in, + and -, * and /,
+// and the unary ! and -. Lists are written [a, b, c]; strings in double or single quotes.
+package expr
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxLength is the length of the longest expression accepted, in bytes
+	MaxLength = 4096
+	// MaxDepth is the deepest nesting of sub-expressions accepted
+	MaxDepth = 64
+)
+
+var (
+	// ErrCompile is returned when an expression is malformed or doesn't type check
+	ErrCompile = errors.New("invalid expression")
+	// ErrEval is returned when evaluating a compiled expression fails, such as on a division by zero
+	ErrEval = errors.New("evaluation failed")
+)
+
+// Program is a compiled boolean expression, safe for concurrent use
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses and type checks a boolean expression against the environment
+func Compile(env *Env, source string) (*Program, error) {
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("%w: longer than %d bytes", ErrCompile, MaxLength)
+	}
+
+	p := &parser{env: env, lex: lexer{src: source}}
+	p.next()
+
+	root, err := p.parseExpr()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if root.typ() != Bool {
+		return nil, fmt.Errorf("%w: evaluates to %s, expected bool", ErrCompile, root.typ())
+	}
+
+	return &Program{source: source, root: root}, nil
+}
+
+// Source returns the expression the program was compiled from
+func (p *Program) Source() string {
+	return p.source
+}
+
+// Eval evaluates the program with the given variable values, which must have the types declared in the environment
+func (p *Program) Eval(vars map[string]Value) (bool, error) {
+	v, err := p.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	return v.(bool), nil
+}
@@ -0,0 +1,161 @@
+package expr
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// object is an Object whose fields are held in a map
+type object map[string]Value
+
+func (o object) Field(name string) Value {
+	return o[name]
+}
+
+var txType = ObjectOf("transaction", map[string]*Type{
+	"value":   Number,
+	"to":      String,
+	"success": Bool,
+})
+
+// testEnv declares tx and the watchlist function of the alert rules, with a "cold" watchlist
+func testEnv() *Env {
+	env := NewEnv()
+	env.Declare("tx", txType)
+	env.Function(&Function{Name: "watchlist", Params: []*Type{String}, Result: ListOf(String), Impl: func(args ...Value) (Value, error) {
+		if args[0].(string) == "cold" {
+			return NewStringSet("0xcold"), nil
+		}
+		return NewStringSet(), nil
+	}})
+
+	return env
+}
+
+func wei(s string) Value {
+	i, _ := new(big.Int).SetString(s, 10)
+	return Int(i)
+}
+
+func tx(value string, to string, success bool) map[string]Value {
+	return map[string]Value{"tx": object{"value": wei(value), "to": to, "success": success}}
+}
+
+func TestEvalPrecedence(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"12 / 3 / 2 == 2", true},
+		{"-2 * 3 == -6", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"1 + 1 in [2, 3]", true},
+		{"1 < 2 && 2 < 1 || 3 > 2", true},
+		{"'a' + 'b' == 'ab'", true},
+		{"0.1 + 0.2 == 0.3", true},
+		{"100e18 == 100000000000000000000", true},
+		{"0x10 == 16", true},
+		{"size([1, 2, 3]) == 3 && 'Address'.startsWith('Add')", true},
+		{"false && 1 / 0 == 1", false},
+	}
+
+	for _, tt := range tests {
+		p, err := Compile(testEnv(), tt.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.source, err)
+			continue
+		}
+
+		got, err := p.Eval(tx("0", "", true))
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.source, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		"1 + 2",
+		"1 + 'a' == 1",
+		"!1",
+		"-'a' == 'a'",
+		"tx.value && true",
+		"tx.to > 1",
+		"tx.success == 1",
+		"tx.unknown == 1",
+		"unknown == 1",
+		"size(1) == 1",
+		"watchlist(1) == []",
+		"tx.to.startsWith(1)",
+		"1 ==",
+		"(1 == 1",
+		"1e1000 > 0",
+		"'unterminated == 'a'",
+	}
+
+	for _, source := range tests {
+		if _, err := Compile(testEnv(), source); !errors.Is(err, ErrCompile) {
+			t.Errorf("Compile(%q) = %v, want ErrCompile", source, err)
+		}
+	}
+}
+
+func TestEvalDivisionByZero(t *testing.T) {
+	for _, source := range []string{
+		"1 / 0 == 1",
+		"tx.value / (tx.value - tx.value) > 0",
+		"true && 1 / (2 - 2) > 0",
+	} {
+		p, err := Compile(testEnv(), source)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", source, err)
+		}
+
+		if _, err := p.Eval(tx("5", "", true)); !errors.Is(err, ErrEval) {
+			t.Errorf("Eval(%q) = %v, want ErrEval", source, err)
+		}
+	}
+}
+
+func TestEvalAlertRule(t *testing.T) {
+	p, err := Compile(testEnv(), `tx.value > 100e18 && tx.to in watchlist("cold") && !tx.success`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		vars map[string]Value
+		want bool
+	}{
+		{"failed large transfer to the cold wallet", tx("100000000000000000001", "0xcold", false), true},
+		{"exactly 100 ether", tx("100000000000000000000", "0xcold", false), false},
+		{"above 2^64 wei", tx("1000000000000000000000000", "0xcold", false), true},
+		{"successful", tx("100000000000000000001", "0xcold", true), false},
+		{"to an address off the watchlist", tx("100000000000000000001", "0xhot", false), false},
+	}
+
+	for _, tt := range tests {
+		got, err := p.Eval(tt.vars)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: matched = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind   tokenKind
+	text   string // identifier, number literal, unquoted string or operator
+	offset int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// operators are matched longest first
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")", "[", "]", ",", "."}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, offset: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case isLetter(c):
+		for l.pos < len(l.src) && (isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], offset: start}, nil
+
+	case isDigit(c):
+		return l.number()
+
+	case c == '"' || c == '\'':
+		return l.string(c)
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, offset: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("%w at offset %d: unexpected character %q", ErrCompile, start, c)
+}
+
+// number scans a decimal number with an optional fraction and exponent, or a 0x prefixed hex integer
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], "0x") || strings.HasPrefix(l.src[l.pos:], "0X") {
+		l.pos += 2
+		for l.pos < len(l.src) && isHexDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], offset: start}, nil
+	}
+
+	l.digits()
+	if l.pos+1 < len(l.src) && l.src[l.pos] == '.' && isDigit(l.src[l.pos+1]) {
+		l.pos++
+		l.digits()
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+
+		if l.pos == len(l.src) || !isDigit(l.src[l.pos]) {
+			return token{}, fmt.Errorf("%w at offset %d: missing exponent digits", ErrCompile, start)
+		}
+		l.digits()
+	}
+
+	if l.pos < len(l.src) && isLetter(l.src[l.pos]) {
+		return token{}, fmt.Errorf("%w at offset %d: unexpected %q after number", ErrCompile, l.pos, l.src[l.pos])
+	}
+
+	return token{kind: tokNumber, text: l.src[start:l.pos], offset: start}, nil
+}
+
+func (l *lexer) digits() {
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+}
+
+// string scans a quoted string, with the \\, \", \', \n and \t escapes
+func (l *lexer) string(quote byte) (token, error) {
+	start := l.pos
+	l.pos++
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+
+		switch c {
+		case quote:
+			return token{kind: tokString, text: b.String(), offset: start}, nil
+		case '\\':
+			if l.pos == len(l.src) {
+				break
+			}
+
+			e := l.src[l.pos]
+			l.pos++
+			switch e {
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return token{}, fmt.Errorf("%w at offset %d: unknown escape \\%c", ErrCompile, l.pos-2, e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return token{}, fmt.Errorf("%w at offset %d: unterminated string", ErrCompile, start)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
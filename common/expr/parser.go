@@ -0,0 +1,402 @@
+package expr
+
+import (
+	"fmt"
+)
+
+// parser is a recursive descent parser type checking the expression as it builds it
+type parser struct {
+	env   *Env
+	lex   lexer
+	tok   token
+	err   error
+	depth int
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	// a lexing error is more telling than whatever the parser makes of the token it left behind
+	if p.err != nil {
+		return p.err
+	}
+
+	return fmt.Errorf("%w at offset %d: %s", ErrCompile, p.tok.offset, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	if p.tok.kind != tokOp {
+		return false
+	}
+
+	for _, op := range ops {
+		if p.tok.text == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %q, got %s", op, p.tok)
+	}
+
+	p.next()
+	return p.err
+}
+
+func (p *parser) parseExpr() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, p.errorf("nested deeper than %d", MaxDepth)
+	}
+
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.isOp("||") {
+		var y node
+		if y, err = p.binaryOperand(p.parseAnd, Bool, "||", x); err == nil {
+			x = &logical{or: true, x: x, y: y}
+		}
+	}
+
+	return x, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	x, err := p.parseComparison()
+	for err == nil && p.isOp("&&") {
+		var y node
+		if y, err = p.binaryOperand(p.parseComparison, Bool, "&&", x); err == nil {
+			x = &logical{x: x, y: y}
+		}
+	}
+
+	return x, err
+}
+
+// binaryOperand checks the left operand of a logical operator and parses the right one
+func (p *parser) binaryOperand(parse func() (node, error), want *Type, op string, x node) (node, error) {
+	if x.typ() != want {
+		return nil, p.errorf("%s expects %s operands, got %s", op, want, x.typ())
+	}
+
+	p.next()
+	y, err := parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if y.typ() != want {
+		return nil, p.errorf("%s expects %s operands, got %s", op, want, y.typ())
+	}
+
+	return y, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	x, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.tok.kind == tokIdent && p.tok.text == "in":
+		p.next()
+		list, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+
+		if list.typ().kind != KindList {
+			return nil, p.errorf("in expects a list on its right, got %s", list.typ())
+		}
+
+		if elem := list.typ().elem; elem != nil && !elem.accepts(x.typ()) {
+			return nil, p.errorf("cannot look for %s in %s", x.typ(), list.typ())
+		}
+
+		return &in{x: x, list: list}, nil
+
+	case p.isOp("==", "!=", "<", "<=", ">", ">="):
+		op := p.tok.text
+		p.next()
+		y, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+
+		if x.typ() != y.typ() || x.typ().kind == KindList || x.typ().kind == KindObject {
+			return nil, p.errorf("cannot compare %s %s %s", x.typ(), op, y.typ())
+		}
+
+		if x.typ() == Bool && op != "==" && op != "!=" {
+			return nil, p.errorf("booleans can't be ordered with %s", op)
+		}
+
+		return &comparison{op: op, x: x, y: y}, nil
+	}
+
+	return x, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	x, err := p.parseMultiplicative()
+	for err == nil && p.isOp("+", "-") {
+		op := p.tok.text
+		p.next()
+
+		var y node
+		if y, err = p.parseMultiplicative(); err == nil {
+			x, err = p.arithmetic(op, x, y)
+		}
+	}
+
+	return x, err
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	x, err := p.parseUnary()
+	for err == nil && p.isOp("*", "/") {
+		op := p.tok.text
+		p.next()
+
+		var y node
+		if y, err = p.parseUnary(); err == nil {
+			x, err = p.arithmetic(op, x, y)
+		}
+	}
+
+	return x, err
+}
+
+func (p *parser) arithmetic(op string, x, y node) (node, error) {
+	if op == "+" && x.typ() == String && y.typ() == String {
+		return &concat{x: x, y: y}, nil
+	}
+
+	if x.typ() != Number || y.typ() != Number {
+		return nil, p.errorf("cannot compute %s %s %s", x.typ(), op, y.typ())
+	}
+
+	return &arithmetic{op: op, x: x, y: y}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if !p.isOp("!", "-") {
+		return p.parseMember()
+	}
+
+	op := p.tok.text
+	p.next()
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, p.errorf("nested deeper than %d", MaxDepth)
+	}
+
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	if op == "!" && x.typ() != Bool {
+		return nil, p.errorf("! expects a bool, got %s", x.typ())
+	}
+
+	if op == "-" && x.typ() != Number {
+		return nil, p.errorf("- expects a number, got %s", x.typ())
+	}
+
+	return &unary{op: op, x: x}, nil
+}
+
+// parseMember parses a primary expression followed by field accesses and method calls
+func (p *parser) parseMember() (node, error) {
+	x, err := p.parsePrimary()
+	for err == nil && p.isOp(".") {
+		p.next()
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("expected a field or method name, got %s", p.tok)
+		}
+
+		name := p.tok.text
+		p.next()
+
+		if p.isOp("(") {
+			overloads, ok := p.env.methods[name]
+			if !ok {
+				return nil, p.errorf("unknown method %s", name)
+			}
+
+			var args []node
+			if args, err = p.parseArgs(); err == nil {
+				x, err = p.call(overloads, append([]node{x}, args...))
+			}
+			continue
+		}
+
+		t := x.typ()
+		if t.kind != KindObject {
+			return nil, p.errorf("%s has no field %s", t, name)
+		}
+
+		ft, ok := t.fields[name]
+		if !ok {
+			return nil, p.errorf("%s has no field %s", t, name)
+		}
+
+		x = &field{x: x, name: name, t: ft}
+	}
+
+	return x, err
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.tok
+
+	switch {
+	case tok.kind == tokNumber:
+		r, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.next()
+		return &literal{v: r, t: Number}, p.err
+
+	case tok.kind == tokString:
+		p.next()
+		return &literal{v: tok.text, t: String}, p.err
+
+	case tok.kind == tokIdent:
+		p.next()
+		if p.err != nil {
+			return nil, p.err
+		}
+
+		switch tok.text {
+		case "true", "false":
+			return &literal{v: tok.text == "true", t: Bool}, nil
+		case "in":
+			return nil, fmt.Errorf("%w at offset %d: unexpected in", ErrCompile, tok.offset)
+		}
+
+		if p.isOp("(") {
+			overloads, ok := p.env.funcs[tok.text]
+			if !ok {
+				return nil, fmt.Errorf("%w at offset %d: unknown function %s", ErrCompile, tok.offset, tok.text)
+			}
+
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+
+			return p.call(overloads, args)
+		}
+
+		t, ok := p.env.vars[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("%w at offset %d: undeclared variable %s", ErrCompile, tok.offset, tok.text)
+		}
+
+		return &variable{name: tok.text, t: t}, nil
+
+	case p.isOp("("):
+		p.next()
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		return x, p.expect(")")
+
+	case p.isOp("["):
+		return p.parseList()
+	}
+
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+// parseArgs parses a parenthesized argument list, the current token being the opening parenthesis
+func (p *parser) parseArgs() ([]node, error) {
+	p.next()
+
+	var args []node
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	return args, p.expect(")")
+}
+
+// parseList parses a list literal, whose elements must all have the same type
+func (p *parser) parseList() (node, error) {
+	p.next()
+
+	l := &list{}
+	for !p.isOp("]") {
+		if len(l.elems) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		elem, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if elem.typ().kind == KindList || elem.typ().kind == KindObject {
+			return nil, p.errorf("lists can't hold %s", elem.typ())
+		}
+
+		if len(l.elems) > 0 && elem.typ() != l.elems[0].typ() {
+			return nil, p.errorf("list mixes %s and %s", l.elems[0].typ(), elem.typ())
+		}
+		l.elems = append(l.elems, elem)
+	}
+
+	l.t = ListOf(nil)
+	if len(l.elems) > 0 {
+		l.t = ListOf(l.elems[0].typ())
+	}
+
+	return l, p.expect("]")
+}
+
+func (p *parser) call(overloads []*Function, args []node) (node, error) {
+	f, err := resolve(overloads, args)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+
+	return &call{f: f, args: args}, nil
+}
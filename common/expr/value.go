@@ -0,0 +1,184 @@
+package expr
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxExponent bounds the exponent of number literals, 2^256 is about 1.2e77
+	maxExponent = 100
+)
+
+// Kind is the family a type belongs to
+type Kind int
+
+const (
+	KindBool Kind = iota + 1
+	KindNumber
+	KindString
+	KindList
+	KindObject
+)
+
+// Type is the static type of an expression
+type Type struct {
+	kind   Kind
+	name   string
+	elem   *Type // element type of a list, nil for a list of anything
+	fields map[string]*Type
+}
+
+var (
+	Bool   = &Type{kind: KindBool, name: "bool"}
+	Number = &Type{kind: KindNumber, name: "number"}
+	String = &Type{kind: KindString, name: "string"}
+)
+
+// ListOf returns the type of the lists of elem, a nil elem accepts any list where used as a parameter
+func ListOf(elem *Type) *Type {
+	return &Type{kind: KindList, elem: elem}
+}
+
+// ObjectOf returns an object type with the given typed fields. Values of the type implement Object.
+func ObjectOf(name string, fields map[string]*Type) *Type {
+	return &Type{kind: KindObject, name: name, fields: fields}
+}
+
+func (t *Type) Kind() Kind {
+	return t.kind
+}
+
+func (t *Type) String() string {
+	if t.kind == KindList {
+		if t.elem == nil {
+			return "list"
+		}
+		return "list(" + t.elem.String() + ")"
+	}
+
+	return t.name
+}
+
+// accepts reports whether a value of type v can be used where t is expected
+func (t *Type) accepts(v *Type) bool {
+	switch {
+	case t == v:
+		return true
+	case t.kind != v.kind:
+		return false
+	case t.kind == KindList:
+		return t.elem == nil || v.elem != nil && t.elem.accepts(v.elem)
+	case t.kind == KindObject:
+		return false
+	default:
+		return true
+	}
+}
+
+// Value is the runtime value of an expression: a bool, a *big.Rat for a number, a string, a List or an Object
+type Value any
+
+// Object is the value of an object type, Field returns the value of a declared field
+type Object interface {
+	Field(name string) Value
+}
+
+// List is the value of a list type
+type List interface {
+	Size() int
+	Contains(v Value) bool
+}
+
+// StringSet is a list of strings with constant time membership, such as a large set of addresses
+type StringSet map[string]struct{}
+
+// NewStringSet returns a set of the given strings
+func NewStringSet(values ...string) StringSet {
+	s := make(StringSet, len(values))
+	for _, v := range values {
+		s[v] = struct{}{}
+	}
+
+	return s
+}
+
+func (s StringSet) Size() int {
+	return len(s)
+}
+
+func (s StringSet) Contains(v Value) bool {
+	str, ok := v.(string)
+	if !ok {
+		return false
+	}
+
+	_, ok = s[str]
+	return ok
+}
+
+// values is the value of a list literal
+type values []Value
+
+func (l values) Size() int {
+	return len(l)
+}
+
+func (l values) Contains(v Value) bool {
+	for _, e := range l {
+		if equal(e, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Int returns the number value of an integer
+func Int(i *big.Int) Value {
+	return new(big.Rat).SetInt(i)
+}
+
+// Int64 returns the number value of an integer
+func Int64(i int64) Value {
+	return new(big.Rat).SetInt64(i)
+}
+
+func equal(a, b Value) bool {
+	switch a := a.(type) {
+	case *big.Rat:
+		b, ok := b.(*big.Rat)
+		return ok && a.Cmp(b) == 0
+	case bool, string:
+		return a == b
+	default:
+		return false
+	}
+}
+
+func parseNumber(text string) (*big.Rat, error) {
+	if len(text) > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		i, ok := new(big.Int).SetString(text[2:], 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid hex number %q", text)
+		}
+		return new(big.Rat).SetInt(i), nil
+	}
+
+	// big.Rat would happily expand 1e1000000000
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(text[i+1:])
+		if err != nil || exp > maxExponent || exp < -maxExponent {
+			return nil, fmt.Errorf("exponent of %q out of range", text)
+		}
+	}
+
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+
+	return r, nil
+}
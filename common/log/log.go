@@ -1,3 +1,89 @@
 package log
 
-// implement repository-wide logging here
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Level is the minimum severity a logger writes
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Logger is the logging interface used across the repository,
+// it can be implemented on top of any logging library
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type logger struct {
+	level Level
+	out   *log.Logger
+}
+
+// New returns a logger writing the messages of at least the given level to w
+func New(w io.Writer, level Level) Logger {
+	return &logger{
+		level: level,
+		out:   log.New(w, "", log.LstdFlags),
+	}
+}
+
+// NewDefault returns a logger writing info messages and above to stderr
+func NewDefault() Logger {
+	return New(os.Stderr, LevelInfo)
+}
+
+// Discard returns a logger dropping every message
+func Discard() Logger {
+	return New(io.Discard, LevelError+1)
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.printf(LevelDebug, format, args...)
+}
+
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.printf(LevelInfo, format, args...)
+}
+
+func (l *logger) Warnf(format string, args ...interface{}) {
+	l.printf(LevelWarn, format, args...)
+}
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.printf(LevelError, format, args...)
+}
+
+func (l *logger) printf(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	l.out.Printf("%-5s %s", level, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,31 @@
+package metrics
+
+// Label is a dimension of a metric, such as the RPC method of a call
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sink receives the measurements taken across the repository,
+// it can be implemented on top of any metrics library
+type Sink interface {
+	// IncCounter adds delta to a monotonic counter
+	IncCounter(name string, delta float64, labels ...Label)
+	// SetGauge sets a value that can go up and down
+	SetGauge(name string, value float64, labels ...Label)
+	// Observe records a sample, such as a latency in seconds, in a distribution
+	Observe(name string, value float64, labels ...Label)
+}
+
+type discard struct{}
+
+// Discard returns a sink dropping every measurement
+func Discard() Sink {
+	return discard{}
+}
+
+func (discard) IncCounter(name string, delta float64, labels ...Label) {}
+
+func (discard) SetGauge(name string, value float64, labels ...Label) {}
+
+func (discard) Observe(name string, value float64, labels ...Label) {}
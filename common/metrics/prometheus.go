@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the histogram buckets, suited to latencies in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type kind int
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindHistogram
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindCounter:
+		return "counter"
+	case kindGauge:
+		return "gauge"
+	default:
+		return "histogram"
+	}
+}
+
+// Registry is a sink keeping the measurements in memory and exposing them in the Prometheus text format.
+// A name is a counter, a gauge or a histogram depending on how it is first measured, measurements of
+// another kind under the same name are dropped.
+type Registry struct {
+	mu       sync.Mutex
+	buckets  []float64
+	families map[string]*family
+}
+
+type family struct {
+	kind   kind
+	series map[string]*series // labels, rendered, to series
+}
+
+type series struct {
+	labels  []Label
+	value   float64  // counter or gauge value, histogram sum
+	count   uint64   // histogram samples
+	buckets []uint64 // histogram samples per bucket, not cumulative
+}
+
+var _ Sink = (*Registry)(nil)
+
+// NewRegistry returns an empty registry whose histograms use DefaultBuckets
+func NewRegistry() *Registry {
+	return NewRegistryWithBuckets(DefaultBuckets)
+}
+
+// NewRegistryWithBuckets returns an empty registry whose histograms use the given bucket upper bounds
+func NewRegistryWithBuckets(buckets []float64) *Registry {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	return &Registry{
+		buckets:  b,
+		families: make(map[string]*family),
+	}
+}
+
+func (r *Registry) IncCounter(name string, delta float64, labels ...Label) {
+	r.with(name, kindCounter, labels, func(s *series) {
+		s.value += delta
+	})
+}
+
+func (r *Registry) SetGauge(name string, value float64, labels ...Label) {
+	r.with(name, kindGauge, labels, func(s *series) {
+		s.value = value
+	})
+}
+
+func (r *Registry) Observe(name string, value float64, labels ...Label) {
+	r.with(name, kindHistogram, labels, func(s *series) {
+		s.value += value
+		s.count++
+		if i := sort.SearchFloat64s(r.buckets, value); i < len(r.buckets) {
+			s.buckets[i]++
+		}
+	})
+}
+
+func (r *Registry) with(name string, k kind, labels []Label, fn func(s *series)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{kind: k, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+
+	if f.kind != k {
+		return
+	}
+
+	sorted := append([]Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	key := renderLabels(sorted)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: sorted}
+		if k == kindHistogram {
+			s.buckets = make([]uint64, len(r.buckets))
+		}
+		f.series[key] = s
+	}
+
+	fn(s)
+}
+
+// WriteTo writes every measurement in the Prometheus text exposition format, sorted by name and labels
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(cw, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != kindHistogram {
+				fmt.Fprintf(cw, "%s%s %s\n", name, key, formatFloat(s.value))
+				continue
+			}
+
+			var cumulative uint64
+			for i, upper := range r.buckets {
+				cumulative += s.buckets[i]
+				fmt.Fprintf(cw, "%s_bucket%s %d\n", name, renderLabels(append(s.labels[:len(s.labels):len(s.labels)], Label{Name: "le", Value: formatFloat(upper)})), cumulative)
+			}
+			fmt.Fprintf(cw, "%s_bucket%s %d\n", name, renderLabels(append(s.labels[:len(s.labels):len(s.labels)], Label{Name: "le", Value: "+Inf"})), s.count)
+			fmt.Fprintf(cw, "%s_sum%s %s\n", name, key, formatFloat(s.value))
+			fmt.Fprintf(cw, "%s_count%s %d\n", name, key, s.count)
+		}
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the measurements to a Prometheus scrape, typically on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func renderLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// countingWriter keeps the first error and the number of bytes written, so that writes can be chained
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+
+	return n, err
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func exposition(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, b.Len())
+	}
+
+	return b.String()
+}
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	r.IncCounter("rpc_calls_total", 1, Label{Name: "method", Value: "eth_getBlockByNumber"}, Label{Name: "code", Value: "ok"})
+	r.IncCounter("rpc_calls_total", 2, Label{Name: "code", Value: "ok"}, Label{Name: "method", Value: "eth_getBlockByNumber"})
+	r.IncCounter("rpc_calls_total", 1, Label{Name: "method", Value: "eth_blockNumber"}, Label{Name: "code", Value: "ok"})
+	r.SetGauge("latest_block", 10)
+	r.SetGauge("latest_block", 12)
+
+	want := `# TYPE latest_block gauge
+latest_block 12
+# TYPE rpc_calls_total counter
+rpc_calls_total{code="ok",method="eth_blockNumber"} 1
+rpc_calls_total{code="ok",method="eth_getBlockByNumber"} 3
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+
+	if rec.Body.String() != want {
+		t.Errorf("served:\n%s\nwant:\n%s", rec.Body.String(), want)
+	}
+}
+
+func TestRegistryHistogram(t *testing.T) {
+	r := NewRegistryWithBuckets([]float64{1, 0.1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		r.Observe("tick_seconds", v, Label{Name: "stage", Value: "fetch"})
+	}
+
+	// buckets are sorted, cumulative and inclusive of their upper bound
+	want := `# TYPE tick_seconds histogram
+tick_seconds_bucket{stage="fetch",le="0.1"} 2
+tick_seconds_bucket{stage="fetch",le="1"} 3
+tick_seconds_bucket{stage="fetch",le="+Inf"} 4
+tick_seconds_sum{stage="fetch"} 2.65
+tick_seconds_count{stage="fetch"} 4
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryEscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.IncCounter("errors_total", 1, Label{Name: "error", Value: "bad \"value\"\nat C:\\node"})
+
+	want := `# TYPE errors_total counter
+errors_total{error="bad \"value\"\nat C:\\node"} 1
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryKeepsFirstKindOfName(t *testing.T) {
+	r := NewRegistry()
+	r.IncCounter("blocks", 1)
+	r.SetGauge("blocks", 100)
+	r.Observe("blocks", 0.5)
+	r.IncCounter("blocks", 1)
+
+	want := `# TYPE blocks counter
+blocks 2
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
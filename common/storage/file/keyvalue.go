@@ -0,0 +1,451 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/log"
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+)
+
+const (
+	// recordHeaderSize is the size of the length and checksum prefixing every record
+	recordHeaderSize = 8
+	// compactionThreshold is the minimum log size before compaction is considered
+	compactionThreshold = 64 << 20
+	// maxRecordSize bounds the payload of a record, a batch has to fit in it.
+	// A larger length read back from the log can only come from corruption.
+	maxRecordSize = 256 << 20
+)
+
+const (
+	opSet byte = iota
+	opDelete
+)
+
+// valueRef locates the value of a key in the log
+type valueRef struct {
+	offset int64
+	size   uint32
+}
+
+// op is a decoded write of a record, with the position of its value in the record
+type op struct {
+	key    string
+	delete bool
+	value  valueRef
+}
+
+// logFile is the log a keyValue appends its records to, an *os.File
+type logFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// keyValue persists every write to an append-only log and only keeps the location of each value in memory,
+// values are read back from the log. Each batch is a single checksummed record that is fsynced before it is
+// applied, so after a crash a batch is either fully replayed or, if its record was torn, discarded as a whole.
+type keyValue struct {
+	mu       sync.RWMutex
+	path     string
+	file     logFile
+	index    map[string]valueRef
+	logSize  int64
+	liveSize int64
+	logger   log.Logger
+}
+
+// Get retrieves a value by key. If the key does not exist, ErrKeyValueNotFound is returned.
+func (db *keyValue) Get(key string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ref, ok := db.index[key]
+	if !ok {
+		return nil, storage.ErrKeyValueNotFound
+	}
+
+	return db.read(db.file, ref)
+}
+
+// Scan calls fn with every key starting with prefix and its value, each value is read when fn is called with it.
+func (db *keyValue) Scan(prefix string, fn func(key string, value []byte) error) error {
+	db.mu.RLock()
+	var keys []string
+	for key := range db.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	db.mu.RUnlock()
+
+	for _, key := range keys {
+		value, err := db.Get(key)
+		if errors.Is(err, storage.ErrKeyValueNotFound) {
+			// deleted since the scan started
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Set durably stores a key-value pair.
+func (db *keyValue) Set(key string, value []byte) error {
+	batch := storage.NewBatch()
+	batch.Set(key, value)
+
+	return db.Write(batch)
+}
+
+// Delete durably removes a key and its value from the store.
+func (db *keyValue) Delete(key string) error {
+	batch := storage.NewBatch()
+	batch.Delete(key)
+
+	return db.Write(batch)
+}
+
+// Write appends the batch to the log as one record, syncs it and then applies it to the index.
+// If the record can't be appended or synced, the log is truncated back to its last record and nothing is applied.
+func (db *keyValue) Write(batch *storage.Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	record, ops, err := encodeRecord(batch.Ops())
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.file.Write(record); err != nil {
+		return db.rollback(fmt.Errorf("unable to append to %s: %w", db.path, err))
+	}
+
+	if err := db.file.Sync(); err != nil {
+		return db.rollback(fmt.Errorf("unable to sync %s: %w", db.path, err))
+	}
+
+	db.apply(ops, db.logSize)
+	db.logSize += int64(len(record))
+
+	// the batch is durable at this point, a failed compaction is retried with the next write
+	if db.logSize > compactionThreshold && db.logSize > 2*db.liveSize {
+		if err := db.compact(); err != nil {
+			db.logger.Errorf("unable to compact %s: %v", db.path, err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the underlying log file.
+func (db *keyValue) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.file.Close()
+}
+
+// rollback truncates the log back to its last applied record, dropping whatever part of a record failing
+// with err was written, so that the next record is appended where the index expects it
+func (db *keyValue) rollback(err error) error {
+	if terr := db.file.Truncate(db.logSize); terr != nil {
+		return errors.Join(err, fmt.Errorf("unable to truncate %s: %w", db.path, terr))
+	}
+
+	if _, serr := db.file.Seek(db.logSize, io.SeekStart); serr != nil {
+		return errors.Join(err, fmt.Errorf("unable to seek %s: %w", db.path, serr))
+	}
+
+	return err
+}
+
+// apply updates the index with the writes of the record at the given offset
+func (db *keyValue) apply(ops []op, offset int64) {
+	for _, op := range ops {
+		if old, ok := db.index[op.key]; ok {
+			db.liveSize -= int64(len(op.key)) + int64(old.size)
+			delete(db.index, op.key)
+		}
+
+		if op.delete {
+			continue
+		}
+
+		ref := valueRef{offset: offset + op.value.offset, size: op.value.size}
+		db.index[op.key] = ref
+		db.liveSize += int64(len(op.key)) + int64(ref.size)
+	}
+}
+
+func (db *keyValue) read(file io.ReaderAt, ref valueRef) ([]byte, error) {
+	value := make([]byte, ref.size)
+	if _, err := file.ReadAt(value, ref.offset); err != nil {
+		return nil, fmt.Errorf("unable to read %s at offset %d: %w", db.path, ref.offset, err)
+	}
+
+	return value, nil
+}
+
+// compact rewrites the log with one record per live key and atomically swaps it in
+func (db *keyValue) compact() error {
+	tmp := db.path + ".compact"
+	index, size, err := db.writeSnapshot(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// opened before the rename so that, once it happened, the writes always go to the new log
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, db.path); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	db.file.Close()
+	db.file = file
+	db.index = index
+	db.logSize = size
+
+	// the rename is only durable once the directory entry is
+	return syncDir(filepath.Dir(db.path))
+}
+
+// writeSnapshot copies the live values to a new log at path and syncs it, returning their locations in it and its size
+func (db *keyValue) writeSnapshot(path string) (map[string]valueRef, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	index := make(map[string]valueRef, len(db.index))
+	size := int64(0)
+	for key, ref := range db.index {
+		value, err := db.read(db.file, ref)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		record, ops, err := encodeRecord([]storage.BatchOp{{Key: key, Value: value}})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if _, err := writer.Write(record); err != nil {
+			return nil, 0, err
+		}
+
+		index[key] = valueRef{offset: size + ops[0].value.offset, size: ops[0].value.size}
+		size += int64(len(record))
+	}
+
+	if err := writer.Flush(); err != nil {
+		return nil, 0, err
+	}
+
+	return index, size, file.Sync()
+}
+
+// replay indexes every intact record of the log and truncates a torn trailing record, if any
+func (db *keyValue) replay() error {
+	reader := bufio.NewReader(db.file)
+	header := make([]byte, recordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		if length > maxRecordSize {
+			break
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		ops, err := decodePayload(payload)
+		if err != nil {
+			return fmt.Errorf("unable to decode record at offset %d: %w", db.logSize, err)
+		}
+
+		db.apply(ops, db.logSize)
+		db.logSize += int64(recordHeaderSize + len(payload))
+	}
+
+	if err := db.file.Truncate(db.logSize); err != nil {
+		return err
+	}
+
+	_, err := db.file.Seek(db.logSize, io.SeekStart)
+	return err
+}
+
+// encodeRecord returns the record of a batch along with its writes, the values located relative to the record.
+// The payload is a sequence of writes: the kind, the length prefixed key and, for a set, the length prefixed value.
+func encodeRecord(batch []storage.BatchOp) ([]byte, []op, error) {
+	record := make([]byte, recordHeaderSize)
+	ops := make([]op, len(batch))
+	for i, bop := range batch {
+		ops[i] = op{key: bop.Key, delete: bop.Delete}
+		if bop.Delete {
+			record = append(record, opDelete)
+			record = binary.AppendUvarint(record, uint64(len(bop.Key)))
+			record = append(record, bop.Key...)
+			continue
+		}
+
+		record = append(record, opSet)
+		record = binary.AppendUvarint(record, uint64(len(bop.Key)))
+		record = append(record, bop.Key...)
+		record = binary.AppendUvarint(record, uint64(len(bop.Value)))
+		ops[i].value = valueRef{offset: int64(len(record)), size: uint32(len(bop.Value))}
+		record = append(record, bop.Value...)
+
+		if len(record)-recordHeaderSize > maxRecordSize {
+			break
+		}
+	}
+
+	payload := record[recordHeaderSize:]
+	if len(payload) > maxRecordSize {
+		return nil, nil, fmt.Errorf("batch of %d bytes or more exceeds the maximum record size of %d bytes", len(payload), maxRecordSize)
+	}
+
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+
+	return record, ops, nil
+}
+
+// decodePayload returns the writes of a record payload, the values located relative to the record
+func decodePayload(payload []byte) ([]op, error) {
+	var ops []op
+	for pos := 0; pos < len(payload); {
+		kind := payload[pos]
+		pos++
+
+		key, n, err := readBytes(payload, pos)
+		if err != nil {
+			return nil, err
+		}
+		pos = n
+
+		switch kind {
+		case opDelete:
+			ops = append(ops, op{key: string(key), delete: true})
+		case opSet:
+			value, n, err := readBytes(payload, pos)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, op{key: string(key), value: valueRef{offset: int64(recordHeaderSize + n - len(value)), size: uint32(len(value))}})
+			pos = n
+		default:
+			return nil, fmt.Errorf("unknown write kind %d", kind)
+		}
+	}
+
+	return ops, nil
+}
+
+// readBytes reads the length prefixed bytes at pos and returns them with the position following them
+func readBytes(payload []byte, pos int) ([]byte, int, error) {
+	length, n := binary.Uvarint(payload[pos:])
+	if n <= 0 || length > uint64(len(payload)-pos-n) {
+		return nil, 0, errors.New("truncated write")
+	}
+
+	start := pos + n
+	end := start + int(length)
+
+	return payload[start:end], end, nil
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// NewKeyValue opens, or creates, the log at path and returns a durable keyValue storage backed by it.
+// Only the keys and the location of their values are held in memory.
+func NewKeyValue(path string) (storage.KeyValue, error) {
+	return NewKeyValueWithLogger(path, log.NewDefault())
+}
+
+// NewKeyValueWithLogger is NewKeyValue reporting the failures that don't fail a write, such as compactions, to logger.
+func NewKeyValueWithLogger(path string, logger log.Logger) (storage.KeyValue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create directory for %s: %w", path, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+
+	db := &keyValue{
+		path:   path,
+		file:   file,
+		index:  make(map[string]valueRef),
+		logger: logger,
+	}
+
+	if err := db.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to replay %s: %w", path, err)
+	}
+
+	return db, nil
+}
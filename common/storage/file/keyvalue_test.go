@@ -0,0 +1,352 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+)
+
+func open(t *testing.T, path string) *keyValue {
+	t.Helper()
+
+	kv, err := NewKeyValue(path)
+	if err != nil {
+		t.Fatalf("NewKeyValue: %v", err)
+	}
+
+	db := kv.(*keyValue)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func expectValue(t *testing.T, db storage.KeyValue, key, want string) {
+	t.Helper()
+
+	value, err := db.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+
+	if string(value) != want {
+		t.Errorf("Get(%q) = %q, want %q", key, value, want)
+	}
+}
+
+func TestKeyValueReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db := open(t, path)
+	batch := storage.NewBatch()
+	batch.Set("a", []byte("1"))
+	batch.Set("b", []byte("2"))
+	if err := db.Write(batch); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := db.Set("a", []byte("3")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if err := db.Delete("b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	db.Close()
+
+	db = open(t, path)
+	expectValue(t, db, "a", "3")
+	if _, err := db.Get("b"); !errors.Is(err, storage.ErrKeyValueNotFound) {
+		t.Errorf("Get(b) error = %v, want ErrKeyValueNotFound", err)
+	}
+}
+
+func TestKeyValueCorruptTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func(record []byte) []byte
+	}{
+		{
+			name: "torn record",
+			tail: func(record []byte) []byte { return record[:len(record)-1] },
+		},
+		{
+			name: "checksum mismatch",
+			tail: func(record []byte) []byte {
+				record[len(record)-1] ^= 0xff
+				return record
+			},
+		},
+		{
+			name: "length above the maximum record size",
+			tail: func(record []byte) []byte {
+				binary.BigEndian.PutUint32(record[0:4], maxRecordSize+1)
+				return record
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.log")
+
+			db := open(t, path)
+			if err := db.Set("kept", []byte("1")); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			size := db.logSize
+			db.Close()
+
+			record, _, err := encodeRecord([]storage.BatchOp{{Key: "lost", Value: []byte("2")}})
+			if err != nil {
+				t.Fatalf("encodeRecord: %v", err)
+			}
+
+			file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write(tt.tail(record))
+			file.Close()
+
+			db = open(t, path)
+			expectValue(t, db, "kept", "1")
+			if _, err := db.Get("lost"); !errors.Is(err, storage.ErrKeyValueNotFound) {
+				t.Errorf("Get(lost) error = %v, want ErrKeyValueNotFound", err)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Size() != size {
+				t.Errorf("log is %d bytes after replay, want the corrupt tail truncated to %d", info.Size(), size)
+			}
+		})
+	}
+}
+
+func TestKeyValueCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db := open(t, path)
+	for i := 0; i < 100; i++ {
+		if err := db.Set(fmt.Sprintf("key-%d", i%10), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	db.mu.Lock()
+	err := db.compact()
+	db.mu.Unlock()
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("compaction left its temporary file behind: %v", err)
+	}
+
+	// the compacted log is one record per key
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := 0
+	for offset := 0; offset < len(data); records++ {
+		offset += recordHeaderSize + int(binary.BigEndian.Uint32(data[offset:offset+4]))
+	}
+
+	if records != 10 {
+		t.Errorf("compacted log holds %d records, want 10", records)
+	}
+
+	if err := db.Set("after", []byte("compaction")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	db.Close()
+
+	db = open(t, path)
+	for i := 90; i < 100; i++ {
+		expectValue(t, db, fmt.Sprintf("key-%d", i%10), fmt.Sprint(i))
+	}
+	expectValue(t, db, "after", "compaction")
+}
+
+func TestKeyValueReadsValuesFromLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db := open(t, path)
+	if err := db.Set("key", []byte("value")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// only the location of the value is held in memory, a change to the log shows through
+	ref := db.index["key"]
+	file, err := os.OpenFile(path, os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("VALUE"), ref.offset)
+	file.Close()
+
+	expectValue(t, db, "key", "VALUE")
+}
+
+func TestKeyValueScan(t *testing.T) {
+	db := open(t, filepath.Join(t.TempDir(), "db.log"))
+	for _, key := range []string{"a:1", "a:2", "b:1", "a"} {
+		if err := db.Set(key, []byte("value of "+key)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	db.Delete("a:2")
+
+	found := map[string]string{}
+	err := db.Scan("a:", func(key string, value []byte) error {
+		found[key] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	if len(found) != 1 || found["a:1"] != "value of a:1" {
+		t.Errorf("Scan(%q) = %v, want only a:1", "a:", found)
+	}
+
+	stop := errors.New("stop")
+	if err := db.Scan("", func(string, []byte) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("Scan = %v, want the error returned by fn", err)
+	}
+}
+
+// faultyFile fails the next write after writing half of it, the next sync or every read, as set
+type faultyFile struct {
+	logFile
+	failWrite bool
+	failSync  bool
+	failRead  bool
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if !f.failWrite {
+		return f.logFile.Write(p)
+	}
+	f.failWrite = false
+
+	n, _ := f.logFile.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func (f *faultyFile) Sync() error {
+	if !f.failSync {
+		return f.logFile.Sync()
+	}
+	f.failSync = false
+
+	return errors.New("input/output error")
+}
+
+func (f *faultyFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.failRead {
+		return 0, errors.New("input/output error")
+	}
+
+	return f.logFile.ReadAt(p, off)
+}
+
+func TestKeyValueFailedWriteIsRolledBack(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault faultyFile
+	}{
+		{
+			name:  "partial write",
+			fault: faultyFile{failWrite: true},
+		},
+		{
+			name:  "failed sync",
+			fault: faultyFile{failSync: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db.log")
+
+			db := open(t, path)
+			if err := db.Set("before", []byte("1")); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			size := db.logSize
+
+			fault := tt.fault
+			fault.logFile = db.file
+			db.file = &fault
+			if err := db.Set("failed", []byte("2")); err == nil {
+				t.Fatal("Set succeeded with a failing log")
+			}
+
+			if _, err := db.Get("failed"); !errors.Is(err, storage.ErrKeyValueNotFound) {
+				t.Errorf("Get(failed) error = %v, want ErrKeyValueNotFound", err)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Size() != size {
+				t.Errorf("log is %d bytes after the failed write, want it truncated back to %d", info.Size(), size)
+			}
+
+			if err := db.Set("after", []byte("3")); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			expectValue(t, db, "after", "3")
+			db.Close()
+
+			db = open(t, path)
+			expectValue(t, db, "before", "1")
+			expectValue(t, db, "after", "3")
+		})
+	}
+}
+
+func TestKeyValueFailedCompactionKeepsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db := open(t, path)
+	for i := 0; i < 10; i++ {
+		if err := db.Set("key", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	fault := &faultyFile{logFile: db.file, failRead: true}
+	db.file = fault
+	if err := db.compact(); err == nil {
+		t.Fatal("compact succeeded with an unreadable log")
+	}
+
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("failed compaction left its temporary file behind: %v", err)
+	}
+
+	fault.failRead = false
+	if err := db.Set("after", []byte("compaction")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	db.Close()
+
+	db = open(t, path)
+	expectValue(t, db, "key", "9")
+	expectValue(t, db, "after", "compaction")
+}
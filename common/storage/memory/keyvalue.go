@@ -2,12 +2,15 @@ package memory
 
 import (
 	"errors"
+	"strings"
 	"sync"
 
 	"github.com/mateeullahmalik/eh_parser/common/storage"
 )
 
 type keyValue struct {
+	// mu makes batches atomic with respect to each other, single writes go straight to the map
+	mu    sync.Mutex
 	store sync.Map
 }
 
@@ -34,6 +37,43 @@ func (db *keyValue) Set(key string, value []byte) error {
 	return nil
 }
 
+// Write applies the batch, concurrent batches are applied one after another.
+func (db *keyValue) Write(batch *storage.Batch) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, op := range batch.Ops() {
+		if op.Delete {
+			db.store.Delete(op.Key)
+			continue
+		}
+		db.store.Store(op.Key, op.Value)
+	}
+
+	return nil
+}
+
+// Scan calls fn with every key starting with prefix and its value.
+func (db *keyValue) Scan(prefix string, fn func(key string, value []byte) error) error {
+	var keys []string
+	var values [][]byte
+	db.store.Range(func(key, value interface{}) bool {
+		if k := key.(string); strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+			values = append(values, value.([]byte))
+		}
+		return true
+	})
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // NewKeyValue returns a new instance of keyValue storage.
 func NewKeyValue() storage.KeyValue {
 	return &keyValue{}
@@ -20,4 +20,73 @@ type KeyValue interface {
 
 	// Delete deletes a key.
 	Delete(key string) error
+
+	// Write applies all the writes of the batch atomically, either all of them are persisted or none.
+	Write(batch *Batch) error
+
+	// Scan calls fn with every key starting with prefix and its value, in no particular order.
+	// fn can write to the database, the writes may or may not be seen by the rest of the scan.
+	// Scanning stops at the first error fn returns.
+	Scan(prefix string, fn func(key string, value []byte) error) error
+}
+
+// BatchOp is a single write of a batch, a nil Value with Delete set removes the key.
+type BatchOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// Batch collects writes to be applied atomically by KeyValue.Write.
+// Writing the same key twice keeps the last write only.
+type Batch struct {
+	ops   []BatchOp
+	index map[string]int
+}
+
+// NewBatch returns an empty batch.
+func NewBatch() *Batch {
+	return &Batch{
+		index: make(map[string]int),
+	}
+}
+
+// Set stages a key-value pair.
+func (b *Batch) Set(key string, value []byte) {
+	b.put(BatchOp{Key: key, Value: value})
+}
+
+// Delete stages the removal of a key.
+func (b *Batch) Delete(key string) {
+	b.put(BatchOp{Key: key, Delete: true})
+}
+
+// Lookup returns the staged write for a key, so that reads within a unit of work see its own writes.
+func (b *Batch) Lookup(key string) (op BatchOp, ok bool) {
+	i, ok := b.index[key]
+	if !ok {
+		return BatchOp{}, false
+	}
+
+	return b.ops[i], true
+}
+
+// Ops returns the staged writes in the order their keys were first written.
+func (b *Batch) Ops() []BatchOp {
+	return b.ops
+}
+
+// Len returns the number of staged writes.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) put(op BatchOp) {
+	if i, ok := b.index[op.Key]; ok {
+		b.ops[i] = op
+		return
+	}
+
+	b.index[op.Key] = len(b.ops)
+	b.ops = append(b.ops, op)
 }
@@ -3,7 +3,9 @@ package ethereum
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"math/big"
 	"net"
 	"strconv"
 	"strings"
@@ -29,29 +31,157 @@ type TransactionResult struct {
 	V                string `json:"v"`
 	R                string `json:"r"`
 	S                string `json:"s"`
+
+	// Typed transaction (EIP-2718) fields, empty for legacy transactions
+	Type                 string                 `json:"type"`
+	ChainID              string                 `json:"chainId"`
+	YParity              string                 `json:"yParity"`
+	MaxFeePerGas         string                 `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string                 `json:"maxPriorityFeePerGas"`
+	MaxFeePerBlobGas     string                 `json:"maxFeePerBlobGas"`
+	AccessList           AccessList             `json:"accessList"`
+	BlobVersionedHashes  []string               `json:"blobVersionedHashes"`
+	AuthorizationList    []SetCodeAuthorization `json:"authorizationList"`
+}
+
+type AccessList []AccessTuple
+
+// AccessTuple is a single EIP-2930 access list entry
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// SetCodeAuthorization is a single EIP-7702 authorization list entry
+type SetCodeAuthorization struct {
+	ChainID string `json:"chainId"`
+	Address string `json:"address"`
+	Nonce   string `json:"nonce"`
+	YParity string `json:"yParity"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+}
+
+type Receipts []Receipt
+
+// Receipt struct to hold the consensus fields of a transaction receipt
+type Receipt struct {
+	TransactionHash   string `json:"transactionHash"`
+	TransactionIndex  string `json:"transactionIndex"`
+	Type              string `json:"type"`
+	Root              string `json:"root"`
+	Status            string `json:"status"`
+	CumulativeGasUsed string `json:"cumulativeGasUsed"`
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	LogsBloom         string `json:"logsBloom"`
+	Logs              []Log  `json:"logs"`
+}
+
+// Log struct to hold a single log entry emitted by a transaction
+type Log struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+// Header struct to hold the block header details
+type Header struct {
+	Difficulty       string `json:"difficulty"`
+	ExtraData        string `json:"extraData"`
+	GasLimit         string `json:"gasLimit"`
+	GasUsed          string `json:"gasUsed"`
+	Hash             string `json:"hash"`
+	LogsBloom        string `json:"logsBloom"`
+	Miner            string `json:"miner"`
+	MixHash          string `json:"mixHash"`
+	Nonce            string `json:"nonce"`
+	Number           string `json:"number"`
+	ParentHash       string `json:"parentHash"`
+	ReceiptsRoot     string `json:"receiptsRoot"`
+	Sha3Uncles       string `json:"sha3Uncles"`
+	Size             string `json:"size"`
+	StateRoot        string `json:"stateRoot"`
+	Timestamp        string `json:"timestamp"`
+	TotalDifficulty  string `json:"totalDifficulty"`
+	TransactionsRoot string `json:"transactionsRoot"`
+
+	// Header fields introduced by later forks, empty on blocks that predate them
+	BaseFeePerGas         string `json:"baseFeePerGas"`
+	WithdrawalsRoot       string `json:"withdrawalsRoot"`
+	BlobGasUsed           string `json:"blobGasUsed"`
+	ExcessBlobGas         string `json:"excessBlobGas"`
+	ParentBeaconBlockRoot string `json:"parentBeaconBlockRoot"`
+	RequestsHash          string `json:"requestsHash"`
 }
 
 // Block struct to hold block details and an array of Transactions
 type Block struct {
-	Difficulty       string             `json:"difficulty"`
-	ExtraData        string             `json:"extraData"`
-	GasLimit         string             `json:"gasLimit"`
-	GasUsed          string             `json:"gasUsed"`
-	Hash             string             `json:"hash"`
-	LogsBloom        string             `json:"logsBloom"`
-	Miner            string             `json:"miner"`
-	MixHash          string             `json:"mixHash"`
-	Nonce            string             `json:"nonce"`
-	Number           string             `json:"number"`
-	ParentHash       string             `json:"parentHash"`
-	ReceiptsRoot     string             `json:"receiptsRoot"`
-	Sha3Uncles       string             `json:"sha3Uncles"`
-	Size             string             `json:"size"`
-	StateRoot        string             `json:"stateRoot"`
-	Timestamp        string             `json:"timestamp"`
-	TotalDifficulty  string             `json:"totalDifficulty"`
-	Transactions     TransactionResults `json:"transactions"`
-	TransactionsRoot string             `json:"transactionsRoot"`
+	Header
+	Transactions TransactionResults `json:"transactions"`
+	Withdrawals  []Withdrawal       `json:"withdrawals"`
+}
+
+// Withdrawal struct to hold a validator withdrawal credited by a block, since Shanghai
+type Withdrawal struct {
+	Index          string `json:"index"`
+	ValidatorIndex string `json:"validatorIndex"`
+	Address        string `json:"address"`
+	Amount         string `json:"amount"` // in gwei
+}
+
+const (
+	// errCodeMethodNotFound is the JSON-RPC error code returned for unsupported methods
+	errCodeMethodNotFound = -32601
+)
+
+var (
+	// ErrMethodNotFound is returned when the node doesn't implement an optional method such as trace_filter
+	ErrMethodNotFound = errors.New("method not supported by the node")
+
+	// ErrFilterNotFound is returned when polling a filter the node no longer knows, filters expire
+	// when they aren't polled for a while and don't survive a restart of the node
+	ErrFilterNotFound = errors.New("filter not found")
+)
+
+type Traces []Trace
+
+// Trace struct to hold the fields of a trace_filter result used to locate transactions and the value they move
+type Trace struct {
+	BlockHash       string       `json:"blockHash"`
+	BlockNumber     uint64       `json:"blockNumber"`
+	TransactionHash string       `json:"transactionHash"`
+	Type            string       `json:"type"`
+	TraceAddress    []int        `json:"traceAddress"` // empty for the transaction itself
+	Action          TraceAction  `json:"action"`
+	Result          *TraceResult `json:"result"`
+	Error           string       `json:"error"`
+}
+
+// TraceAction struct to hold what a call, create or suicide trace did
+type TraceAction struct {
+	CallType string `json:"callType"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Value    string `json:"value"`
+
+	// suicide traces send the balance of the destroyed contract to the refund address
+	Address       string `json:"address"`
+	RefundAddress string `json:"refundAddress"`
+	Balance       string `json:"balance"`
+}
+
+// TraceResult struct to hold the address of the contract deployed by a create trace
+type TraceResult struct {
+	Address string `json:"address"`
+}
+
+// TraceFilter struct to hold the trace_filter parameters
+type TraceFilter struct {
+	FromBlock   string   `json:"fromBlock"`
+	ToBlock     string   `json:"toBlock"`
+	FromAddress []string `json:"fromAddress,omitempty"`
+	ToAddress   []string `json:"toAddress,omitempty"`
 }
 
 type client struct {
@@ -74,18 +204,236 @@ func (client *client) GetLatestBlockNumber(ctx context.Context) (int32, error) {
 }
 
 func (client *client) GetBlockTransactions(ctx context.Context, block int32) (TransactionResults, error) {
-	result := Block{}
-	if err := client.callFor(ctx, &result, "eth_getBlockByNumber", block, true); err != nil {
-		return nil, fmt.Errorf("failed to get block: %w", err)
+	result, err := client.GetBlock(ctx, block)
+	if err != nil {
+		return nil, err
 	}
 
 	return result.Transactions, nil
 }
 
+func (client *client) GetBlock(ctx context.Context, block int32) (*Block, error) {
+	var result *Block
+	if err := client.callFor(ctx, &result, "eth_getBlockByNumber", ToBlockNumArg(block), true); err != nil {
+		return nil, fmt.Errorf("failed to get block: %w", err)
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("block %d not found", block)
+	}
+
+	return result, nil
+}
+
+func (client *client) GetHeader(ctx context.Context, block int32) (*Header, error) {
+	return client.GetHeaderByTag(ctx, ToBlockNumArg(block))
+}
+
+// GetHeaderByTag returns the header of the block identified by a hex number or a tag such as latest, safe or finalized
+func (client *client) GetHeaderByTag(ctx context.Context, tag string) (*Header, error) {
+	var result *Header
+	if err := client.callFor(ctx, &result, "eth_getBlockByNumber", tag, false); err != nil {
+		return nil, fmt.Errorf("failed to get block header: %w", err)
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("block %s not found", tag)
+	}
+
+	return result, nil
+}
+
+func (client *client) GetBlockReceipts(ctx context.Context, block *Block) (Receipts, error) {
+	if len(block.Transactions) == 0 {
+		return Receipts{}, nil
+	}
+
+	res, err := client.CallWithContext(ctx, "eth_getBlockReceipts", block.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call eth_getBlockReceipts: %w", err)
+	}
+
+	// not every node implements eth_getBlockReceipts, fall back to fetching them one by one
+	if res.Error != nil && res.Error.Code == errCodeMethodNotFound {
+		return client.getTransactionReceipts(ctx, block.Transactions)
+	}
+
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to get block receipts: %w", res.Error)
+	}
+
+	var receipts Receipts
+	if err := res.GetObject(&receipts); err != nil {
+		return nil, fmt.Errorf("failed to decode block receipts: %w", err)
+	}
+
+	return receipts, nil
+}
+
+func (client *client) getTransactionReceipts(ctx context.Context, txs TransactionResults) (Receipts, error) {
+	receipts := make(Receipts, len(txs))
+	for i, tx := range txs {
+		if err := client.callFor(ctx, &receipts[i], "eth_getTransactionReceipt", tx.Hash); err != nil {
+			return nil, fmt.Errorf("failed to get receipt for transaction %s: %w", tx.Hash, err)
+		}
+	}
+
+	return receipts, nil
+}
+
+// TraceFilter returns the traces, internal calls included, matching the filter.
+// It is only served by nodes exposing the trace namespace (Erigon, Nethermind, Reth, ...),
+// others return ErrMethodNotFound.
+func (client *client) TraceFilter(ctx context.Context, filter TraceFilter) (Traces, error) {
+	res, err := client.CallWithContext(ctx, "trace_filter", filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call trace_filter: %w", err)
+	}
+
+	if res.Error != nil && res.Error.Code == errCodeMethodNotFound {
+		return nil, fmt.Errorf("trace_filter: %w", ErrMethodNotFound)
+	}
+
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to filter traces: %w", res.Error)
+	}
+
+	var traces Traces
+	if err := res.GetObject(&traces); err != nil {
+		return nil, fmt.Errorf("failed to decode traces: %w", err)
+	}
+
+	return traces, nil
+}
+
+// NewPendingTransactionFilter installs a filter collecting the hashes of the transactions entering the node's mempool
+func (client *client) NewPendingTransactionFilter(ctx context.Context) (string, error) {
+	res, err := client.CallWithContext(ctx, "eth_newPendingTransactionFilter")
+	if err != nil {
+		return "", fmt.Errorf("failed to call eth_newPendingTransactionFilter: %w", err)
+	}
+
+	if res.Error != nil && res.Error.Code == errCodeMethodNotFound {
+		return "", fmt.Errorf("eth_newPendingTransactionFilter: %w", ErrMethodNotFound)
+	}
+
+	if res.Error != nil {
+		return "", fmt.Errorf("failed to install pending transaction filter: %w", res.Error)
+	}
+
+	var id string
+	if err := res.GetObject(&id); err != nil {
+		return "", fmt.Errorf("failed to decode filter id: %w", err)
+	}
+
+	return id, nil
+}
+
+// GetFilterChanges returns the transaction hashes collected by a pending transaction filter since it was last polled
+func (client *client) GetFilterChanges(ctx context.Context, id string) ([]string, error) {
+	res, err := client.CallWithContext(ctx, "eth_getFilterChanges", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call eth_getFilterChanges: %w", err)
+	}
+
+	// the code varies across nodes, the message doesn't
+	if res.Error != nil && strings.Contains(strings.ToLower(res.Error.Message), "filter not found") {
+		return nil, fmt.Errorf("filter %s: %w", id, ErrFilterNotFound)
+	}
+
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to get filter changes: %w", res.Error)
+	}
+
+	var hashes []string
+	if err := res.GetObject(&hashes); err != nil {
+		return nil, fmt.Errorf("failed to decode filter changes: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// GetTransactionByHash returns a pending or mined transaction, nil when the node doesn't know it
+func (client *client) GetTransactionByHash(ctx context.Context, hash string) (*TransactionResult, error) {
+	var result *TransactionResult
+	if err := client.callFor(ctx, &result, "eth_getTransactionByHash", hash); err != nil {
+		return nil, fmt.Errorf("failed to get transaction %s: %w", hash, err)
+	}
+
+	return result, nil
+}
+
+// GetTransactionsByHash looks up transactions with a single batch request. Every hash the node answered for
+// is in the result, mapped to nil when the node doesn't know the transaction; a hash whose lookup failed is left out.
+func (client *client) GetTransactionsByHash(ctx context.Context, hashes []string) (map[string]*TransactionResult, error) {
+	requests := make(jsonrpc.RPCRequests, len(hashes))
+	for i, hash := range hashes {
+		requests[i] = jsonrpc.NewRequest("eth_getTransactionByHash", hash)
+	}
+
+	responses, err := client.CallBatchWithContext(ctx, requests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %d transactions: %w", len(hashes), err)
+	}
+
+	found := make(map[string]*TransactionResult, len(hashes))
+	for i, hash := range hashes {
+		res := responses.GetByID(i)
+		if res == nil || res.Error != nil {
+			continue
+		}
+
+		var result *TransactionResult
+		if err := res.GetObject(&result); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction %s: %w", hash, err)
+		}
+		found[hash] = result
+	}
+
+	return found, nil
+}
+
+// GetTransactionCount returns the number of transactions sent by the address as of a block number or tag,
+// with the pending tag it also counts the ones the node can execute next from its mempool
+func (client *client) GetTransactionCount(ctx context.Context, address string, tag string) (uint64, error) {
+	var result string
+	if err := client.callFor(ctx, &result, "eth_getTransactionCount", address, tag); err != nil {
+		return 0, fmt.Errorf("failed to get transaction count of %s: %w", address, err)
+	}
+
+	count, err := strconv.ParseUint(result, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid transaction count %q of %s: %w", result, address, err)
+	}
+
+	return count, nil
+}
+
+// GetBalance returns the balance in wei of the address at the block with the given hash, as per EIP-1898,
+// so that it refers to that very block even if it was reorged out since
+func (client *client) GetBalance(ctx context.Context, address string, blockHash string) (*big.Int, error) {
+	var result string
+	if err := client.callFor(ctx, &result, "eth_getBalance", address, map[string]string{"blockHash": blockHash}); err != nil {
+		return nil, fmt.Errorf("failed to get balance of %s: %w", address, err)
+	}
+
+	balance, err := decodeQuantity(result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid balance of %s: %w", address, err)
+	}
+
+	return balance, nil
+}
+
 func (client *client) callFor(ctx context.Context, object interface{}, method string, params ...interface{}) error {
 	return client.CallForWithContext(ctx, object, method, params)
 }
 
+// ToBlockNumArg formats a block number as expected by block parameters of the JSON-RPC API
+func ToBlockNumArg(block int32) string {
+	return fmt.Sprintf("0x%x", block)
+}
+
 // NewClient returns a new Client instance.
 func NewClient(config *Config) *client {
 	//Configure network addressing
@@ -99,6 +447,7 @@ func NewClient(config *Config) *client {
 		CustomHeaders: map[string]string{
 			"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(config.Username+":"+config.Password)),
 		},
+		Metrics: config.Metrics,
 	}
 
 	//Return a Client interface with the proper RPCClient configurations
@@ -1,5 +1,7 @@
 package ethereum
 
+import "github.com/mateeullahmalik/eh_parser/common/metrics"
+
 const (
 	defaultHostname = "localhost"
 	defaultPort     = 4444
@@ -10,6 +12,9 @@ type Config struct {
 	Port     int
 	Username string
 	Password string
+
+	// Metrics receives the count and the latency of the RPC calls by method, status and endpoint, they are discarded when nil
+	Metrics metrics.Sink
 }
 
 func NewConfig() *Config {
@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	// the long inputs straddle the 136 byte rate, their hashes come from golang.org/x/crypto/sha3.NewLegacyKeccak256
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{strings.Repeat("a", 135), "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+		{strings.Repeat("a", 136), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{strings.Repeat("a", 137), "d869f639c7046b4929fc92a4d988a8b22c55fbadb802c0c66ebcd484f1915f39"},
+		{strings.Repeat("a", 1000), "b6a4ac1f51884d71f30fa397a5e155de3099e11fc0edef5d08b646e621e19de9"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(Keccak256([]byte(tt.input))); got != tt.want {
+			t.Errorf("Keccak256 of %d bytes = %s, want %s", len(tt.input), got, tt.want)
+		}
+	}
+}
+
+func TestKeccak256Concatenates(t *testing.T) {
+	input := []byte(strings.Repeat("abc", 100))
+	want := hex.EncodeToString(Keccak256(input))
+
+	if got := hex.EncodeToString(Keccak256(input[:7], nil, input[7:140], input[140:])); got != want {
+		t.Errorf("Keccak256 of the parts = %s, want the hash of their concatenation %s", got, want)
+	}
+}
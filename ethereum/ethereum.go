@@ -1,8 +1,22 @@
 package ethereum
 
-import "context"
+import (
+	"context"
+	"math/big"
+)
 
 type Client interface {
 	GetLatestBlockNumber(ctx context.Context) (int32, error)
 	GetBlockTransactions(ctx context.Context, block int32) (TransactionResults, error)
+	GetBlock(ctx context.Context, block int32) (*Block, error)
+	GetHeader(ctx context.Context, block int32) (*Header, error)
+	GetHeaderByTag(ctx context.Context, tag string) (*Header, error)
+	GetBlockReceipts(ctx context.Context, block *Block) (Receipts, error)
+	TraceFilter(ctx context.Context, filter TraceFilter) (Traces, error)
+	NewPendingTransactionFilter(ctx context.Context) (string, error)
+	GetFilterChanges(ctx context.Context, id string) ([]string, error)
+	GetTransactionByHash(ctx context.Context, hash string) (*TransactionResult, error)
+	GetTransactionsByHash(ctx context.Context, hashes []string) (map[string]*TransactionResult, error)
+	GetTransactionCount(ctx context.Context, address string, tag string) (uint64, error)
+	GetBalance(ctx context.Context, address string, blockHash string) (*big.Int, error)
 }
@@ -6,22 +6,30 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
 	"time"
 
 	"encoding/json"
+
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
 )
 
 const (
 	jsonrpcVersion = "2.0"
 	timeout        = 30 * time.Second
+
+	// names of the measurements reported to RPCClientOpts.Metrics, labelled with the method, the status and the endpoint
+	metricRequests        = "rpc_requests_total"
+	metricRequestDuration = "rpc_request_duration_seconds"
 )
 
 // RPCClient sends JSON-RPC requests over HTTP to the provided JSON-RPC backend.
 type RPCClient interface {
 	CallWithContext(ctx context.Context, method string, params ...interface{}) (*RPCResponse, error)
 	CallForWithContext(ctx context.Context, out interface{}, method string, params ...interface{}) error
+	CallBatchWithContext(ctx context.Context, requests RPCRequests) (RPCResponses, error)
 }
 
 type RPCRequest struct {
@@ -75,14 +83,18 @@ func (e *HTTPError) Error() string {
 
 type rpcClient struct {
 	endpoint      string
+	endpointLabel string // endpoint without credentials, as reported in the metrics
 	httpClient    *http.Client
 	customHeaders map[string]string
+	metrics       metrics.Sink
 }
 
 // RPCClientOpts can be provided to NewClientWithOpts() to change configuration of RPCClient.
 type RPCClientOpts struct {
 	HTTPClient    *http.Client
 	CustomHeaders map[string]string
+	// Metrics receives the count and the latency of the calls, they are discarded when nil
+	Metrics metrics.Sink
 }
 
 // RPCResponses is of type []*RPCResponse.
@@ -92,6 +104,17 @@ type RPCResponses []*RPCResponse
 // This type is used to provide helper functions on the request list
 type RPCRequests []*RPCRequest
 
+// GetByID returns the response with the given id, nil if there is none
+func (responses RPCResponses) GetByID(id int) *RPCResponse {
+	for _, response := range responses {
+		if response != nil && response.ID == id {
+			return response
+		}
+	}
+
+	return nil
+}
+
 // NewClient returns a new RPCClient instance with default configuration.
 func NewClient(endpoint string) RPCClient {
 	return NewClientWithOpts(endpoint, nil)
@@ -101,14 +124,20 @@ func NewClient(endpoint string) RPCClient {
 func NewClientWithOpts(endpoint string, opts *RPCClientOpts) RPCClient {
 	rpcClient := &rpcClient{
 		endpoint:      endpoint,
+		endpointLabel: endpointLabel(endpoint),
 		httpClient:    &http.Client{},
 		customHeaders: make(map[string]string),
+		metrics:       metrics.Discard(),
 	}
 
 	if opts == nil {
 		return rpcClient
 	}
 
+	if opts.Metrics != nil {
+		rpcClient.metrics = opts.Metrics
+	}
+
 	if opts.HTTPClient != nil {
 		rpcClient.httpClient = opts.HTTPClient
 	}
@@ -129,7 +158,62 @@ func (client *rpcClient) CallWithContext(ctx context.Context, method string, par
 		JSONRPC: jsonrpcVersion,
 	}
 
-	return client.doCall(ctx, request)
+	start := time.Now()
+	rpcResponse, err := client.doCall(ctx, request)
+	client.observe(method, time.Since(start), rpcResponse, err)
+
+	return rpcResponse, err
+}
+
+// CallBatchWithContext sends the requests as a single JSON-RPC batch. Their ids are set to their position in the list
+// and, since a node answers a batch in any order, the responses are to be matched with RPCResponses.GetByID.
+// A node rejecting the batch as a whole answers with a single error, which is returned.
+func (client *rpcClient) CallBatchWithContext(ctx context.Context, requests RPCRequests) (RPCResponses, error) {
+	if len(requests) == 0 {
+		return RPCResponses{}, nil
+	}
+
+	for i, request := range requests {
+		request.ID = i
+		request.JSONRPC = jsonrpcVersion
+	}
+
+	start := time.Now()
+	responses, err := client.doBatchCall(ctx, requests)
+	client.observe(requests[0].Method, time.Since(start), &RPCResponse{}, err)
+
+	return responses, err
+}
+
+// observe records the outcome of a call: ok, rpc_error when the node answered with an error,
+// http_<code> for an HTTP error without a JSON-RPC answer, and error when no answer was read
+func (client *rpcClient) observe(method string, latency time.Duration, rpcResponse *RPCResponse, err error) {
+	status := "ok"
+	if httpErr, ok := err.(*HTTPError); ok {
+		status = "http_" + strconv.Itoa(httpErr.Code)
+	} else if err != nil {
+		status = "error"
+	} else if rpcResponse.Error != nil {
+		status = "rpc_error"
+	}
+
+	labels := []metrics.Label{
+		{Name: "method", Value: method},
+		{Name: "status", Value: status},
+		{Name: "endpoint", Value: client.endpointLabel},
+	}
+	client.metrics.IncCounter(metricRequests, 1, labels...)
+	client.metrics.Observe(metricRequestDuration, latency.Seconds(), labels...)
+}
+
+// endpointLabel strips the credentials, query and fragment off an endpoint
+func endpointLabel(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "invalid"
+	}
+
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
 }
 
 func (client *rpcClient) CallForWithContext(ctx context.Context, out interface{}, method string, params ...interface{}) error {
@@ -219,6 +303,56 @@ func (client *rpcClient) doCall(cctx context.Context, RPCRequest *RPCRequest) (*
 	return rpcResponse, nil
 }
 
+func (client *rpcClient) doBatchCall(cctx context.Context, requests RPCRequests) (RPCResponses, error) {
+	ctx, cancel := context.WithTimeout(cctx, timeout)
+	defer cancel()
+
+	httpRequest, err := client.newRequest(ctx, requests)
+	if err != nil {
+		return nil, fmt.Errorf("rpc batch of %d %v() on %v: %v", len(requests), requests[0].Method, client.endpoint, err.Error())
+	}
+	httpRequest.Close = true
+	httpResponse, err := client.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("rpc batch of %d %v() on %v: %v", len(requests), requests[0].Method, httpRequest.URL.String(), err.Error())
+	}
+	defer httpResponse.Body.Close()
+
+	var body json.RawMessage
+	if err := json.NewDecoder(httpResponse.Body).Decode(&body); err != nil {
+		if httpResponse.StatusCode >= 400 {
+			return nil, &HTTPError{
+				Code: httpResponse.StatusCode,
+				err:  fmt.Errorf("rpc batch on %v status code: %v. could not decode body to rpc responses: %v", httpRequest.URL.String(), httpResponse.StatusCode, err.Error()),
+			}
+		}
+		return nil, fmt.Errorf("rpc batch on %v status code: %v. could not decode body to rpc responses: %v", httpRequest.URL.String(), httpResponse.StatusCode, err.Error())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+
+	// a batch the node doesn't accept is answered with a single response
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var rpcResponse RPCResponse
+		if err := decoder.Decode(&rpcResponse); err != nil {
+			return nil, fmt.Errorf("rpc batch on %v: could not decode body to rpc response: %v", httpRequest.URL.String(), err.Error())
+		}
+		if rpcResponse.Error != nil {
+			return nil, fmt.Errorf("rpc batch on %v rejected: %w", httpRequest.URL.String(), rpcResponse.Error)
+		}
+		return nil, fmt.Errorf("rpc batch on %v answered with a single response", httpRequest.URL.String())
+	}
+
+	var responses RPCResponses
+	if err := decoder.Decode(&responses); err != nil {
+		return nil, fmt.Errorf("rpc batch on %v status code: %v. could not decode body to rpc responses: %v", httpRequest.URL.String(), httpResponse.StatusCode, err.Error())
+	}
+
+	return responses, nil
+}
+
 // Params is a helper function that uses the same parameter syntax as Call().
 func Params(params ...interface{}) interface{} {
 	var finalParams interface{}
@@ -0,0 +1,64 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCallBatchWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var requests []RPCRequest
+		if err := json.Unmarshal(body, &requests); err != nil {
+			t.Errorf("request isn't a batch: %s", body)
+		}
+
+		// answered in reverse order, the last one with an error
+		var responses []map[string]interface{}
+		for i := len(requests) - 1; i >= 0; i-- {
+			response := map[string]interface{}{"jsonrpc": "2.0", "id": requests[i].ID, "result": requests[i].Params}
+			if i == len(requests)-1 {
+				response = map[string]interface{}{"jsonrpc": "2.0", "id": requests[i].ID, "error": map[string]interface{}{"code": -32000, "message": "failed"}}
+			}
+			responses = append(responses, response)
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	requests := RPCRequests{NewRequest("echo", "a"), NewRequest("echo", "b"), NewRequest("echo", "c")}
+
+	responses, err := client.CallBatchWithContext(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("CallBatchWithContext: %v", err)
+	}
+
+	for i, want := range []string{"a", "b"} {
+		var got []string
+		if err := responses.GetByID(i).GetObject(&got); err != nil || len(got) != 1 || got[0] != want {
+			t.Errorf("response %d = %v (%v), want [%s]", i, got, err, want)
+		}
+	}
+
+	if res := responses.GetByID(2); res == nil || res.Error == nil {
+		t.Errorf("response 2 = %+v, want the error", res)
+	}
+}
+
+func TestCallBatchWithContextRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch requests not supported"}}`)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).CallBatchWithContext(context.Background(), RPCRequests{NewRequest("echo", "a")})
+	if err == nil {
+		t.Error("CallBatchWithContext succeeded on a node rejecting batches")
+	}
+}
@@ -0,0 +1,54 @@
+package rlp
+
+import (
+	"math/big"
+)
+
+// EncodeBytes returns the RLP encoding of a byte string
+func EncodeBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+
+	return append(encodeLength(len(b), 0x80), b...)
+}
+
+// EncodeUint returns the RLP encoding of an unsigned integer.
+// Zero is encoded as the empty string, as required by the yellow paper.
+func EncodeUint(i uint64) []byte {
+	return EncodeBigInt(new(big.Int).SetUint64(i))
+}
+
+// EncodeBigInt returns the RLP encoding of a non-negative big integer.
+// A nil value is encoded as zero.
+func EncodeBigInt(i *big.Int) []byte {
+	if i == nil {
+		return EncodeBytes(nil)
+	}
+
+	return EncodeBytes(i.Bytes())
+}
+
+// EncodeList returns the RLP encoding of a list whose items are already RLP encoded
+func EncodeList(items ...[]byte) []byte {
+	size := 0
+	for _, item := range items {
+		size += len(item)
+	}
+
+	out := encodeLength(size, 0xc0)
+	for _, item := range items {
+		out = append(out, item...)
+	}
+
+	return out
+}
+
+func encodeLength(length int, offset byte) []byte {
+	if length < 56 {
+		return []byte{offset + byte(length)}
+	}
+
+	lenBytes := big.NewInt(int64(length)).Bytes()
+	return append([]byte{offset + 55 + byte(len(lenBytes))}, lenBytes...)
+}
@@ -0,0 +1,42 @@
+package rlp
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// the vectors come from the RLP page of the Ethereum documentation
+func TestEncode(t *testing.T) {
+	lorem := "Lorem ipsum dolor sit amet, consectetur adipisicing elit"
+	big2_64, _ := new(big.Int).SetString("10000000000000000", 16)
+
+	tests := []struct {
+		name    string
+		encoded []byte
+		want    string
+	}{
+		{"empty string", EncodeBytes(nil), "80"},
+		{"single byte", EncodeBytes([]byte{0x00}), "00"},
+		{"byte above 0x7f", EncodeBytes([]byte{0x80}), "8180"},
+		{"short string", EncodeBytes([]byte("dog")), "83646f67"},
+		{"55 byte string", EncodeBytes([]byte(strings.Repeat("a", 55))), "b7" + strings.Repeat("61", 55)},
+		{"long string", EncodeBytes([]byte(lorem)), "b838" + hex.EncodeToString([]byte(lorem))},
+		{"zero", EncodeUint(0), "80"},
+		{"small integer", EncodeUint(15), "0f"},
+		{"two byte integer", EncodeUint(1024), "820400"},
+		{"big integer", EncodeBigInt(big2_64), "89010000000000000000"},
+		{"nil big integer", EncodeBigInt(nil), "80"},
+		{"empty list", EncodeList(), "c0"},
+		{"list of strings", EncodeList(EncodeBytes([]byte("cat")), EncodeBytes([]byte("dog"))), "c88363617483646f67"},
+		{"set theoretical representation of three", EncodeList(EncodeList(), EncodeList(EncodeList()), EncodeList(EncodeList(), EncodeList(EncodeList()))), "c7c0c1c0c3c0c1c0"},
+		{"long list", EncodeList(EncodeBytes([]byte(lorem))), "f83ab838" + hex.EncodeToString([]byte(lorem))},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.encoded); got != tt.want {
+			t.Errorf("%s: encoded %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package trie
+
+import (
+	"github.com/mateeullahmalik/eh_parser/ethereum/crypto"
+	"github.com/mateeullahmalik/eh_parser/ethereum/rlp"
+)
+
+// EmptyRoot is the root hash of an empty Merkle-Patricia trie
+var EmptyRoot = crypto.Keccak256(rlp.EncodeBytes(nil))
+
+type entry struct {
+	key   []byte // nibbles
+	value []byte
+}
+
+// DeriveRoot returns the root hash of the Merkle-Patricia trie that maps rlp(index) to values[index].
+// This is how the transactionsRoot, receiptsRoot and withdrawalsRoot of a block header are computed.
+func DeriveRoot(values [][]byte) []byte {
+	if len(values) == 0 {
+		return EmptyRoot
+	}
+
+	entries := make([]entry, len(values))
+	for i, value := range values {
+		entries[i] = entry{
+			key:   toNibbles(rlp.EncodeUint(uint64(i))),
+			value: value,
+		}
+	}
+
+	return crypto.Keccak256(encodeNode(entries, 0))
+}
+
+// encodeNode returns the RLP encoding of the node holding the given entries,
+// where all entries share the first depth nibbles of their keys
+func encodeNode(entries []entry, depth int) []byte {
+	if len(entries) == 1 {
+		return rlp.EncodeList(
+			rlp.EncodeBytes(hexPrefix(entries[0].key[depth:], true)),
+			rlp.EncodeBytes(entries[0].value),
+		)
+	}
+
+	if prefix := commonPrefix(entries, depth); prefix > 0 {
+		return rlp.EncodeList(
+			rlp.EncodeBytes(hexPrefix(entries[0].key[depth:depth+prefix], false)),
+			reference(encodeNode(entries, depth+prefix)),
+		)
+	}
+
+	var children [16][]entry
+	var value []byte
+	for _, e := range entries {
+		if len(e.key) == depth {
+			value = e.value
+			continue
+		}
+		children[e.key[depth]] = append(children[e.key[depth]], e)
+	}
+
+	items := make([][]byte, 17)
+	for i, child := range children {
+		if len(child) == 0 {
+			items[i] = rlp.EncodeBytes(nil)
+			continue
+		}
+		items[i] = reference(encodeNode(child, depth+1))
+	}
+	items[16] = rlp.EncodeBytes(value)
+
+	return rlp.EncodeList(items...)
+}
+
+// reference returns how a child node is embedded in its parent:
+// nodes shorter than 32 bytes are inlined, larger ones are referenced by their hash
+func reference(node []byte) []byte {
+	if len(node) < 32 {
+		return node
+	}
+
+	return rlp.EncodeBytes(crypto.Keccak256(node))
+}
+
+func commonPrefix(entries []entry, depth int) int {
+	prefix := 0
+	for {
+		if depth+prefix >= len(entries[0].key) {
+			return prefix
+		}
+
+		nibble := entries[0].key[depth+prefix]
+		for _, e := range entries[1:] {
+			if depth+prefix >= len(e.key) || e.key[depth+prefix] != nibble {
+				return prefix
+			}
+		}
+		prefix++
+	}
+}
+
+func toNibbles(key []byte) []byte {
+	nibbles := make([]byte, len(key)*2)
+	for i, b := range key {
+		nibbles[i*2] = b >> 4
+		nibbles[i*2+1] = b & 0x0f
+	}
+
+	return nibbles
+}
+
+// hexPrefix implements the compact encoding of a nibble path with a leaf flag
+func hexPrefix(nibbles []byte, leaf bool) []byte {
+	flag := byte(0)
+	if leaf {
+		flag = 2
+	}
+
+	var out []byte
+	if len(nibbles)%2 == 1 {
+		out = append(out, (flag+1)<<4|nibbles[0])
+		nibbles = nibbles[1:]
+	} else {
+		out = append(out, flag<<4)
+	}
+
+	for i := 0; i < len(nibbles); i += 2 {
+		out = append(out, nibbles[i]<<4|nibbles[i+1])
+	}
+
+	return out
+}
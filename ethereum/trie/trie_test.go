@@ -0,0 +1,30 @@
+package trie
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDeriveRoot(t *testing.T) {
+	// the only transaction of mainnet block 46147, the first one ever included
+	tx, _ := hex.DecodeString("f86780862d79883d2000825208945df9b87991262f6ba471f09758cde1c0fc1de734827a69801ca088ff6cf0fefd94db46111149ae4bfc179e9b94721fffd821d38d16464b3f71d0a045e0aff800961cfce805daef7016b9b675c137a6a41a548f7b60a3484c06a33a")
+
+	tests := []struct {
+		name   string
+		values [][]byte
+		want   string
+	}{
+		{"empty trie", nil, "56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"},
+		{"transactions of block 46147", [][]byte{tx}, "4513310fcb9f6f616972a3b948dc5d547f280849a87ebb5af0191f98b87be598"},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(DeriveRoot(tt.values)); got != tt.want {
+			t.Errorf("%s: root %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if got := hex.EncodeToString(EmptyRoot); got != tests[0].want {
+		t.Errorf("EmptyRoot = %s, want %s", got, tests[0].want)
+	}
+}
@@ -0,0 +1,368 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/mateeullahmalik/eh_parser/ethereum/crypto"
+	"github.com/mateeullahmalik/eh_parser/ethereum/rlp"
+	"github.com/mateeullahmalik/eh_parser/ethereum/trie"
+)
+
+var (
+	// ErrVerification is wrapped by every VerificationError so callers can match on it with errors.Is
+	ErrVerification = errors.New("block verification failed")
+)
+
+// VerificationError is returned when the data served by the node does not hash to the values committed in the block
+type VerificationError struct {
+	Block    string // block hash as reported by the node
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("block %s: %s mismatch: header has %s, computed %s", e.Block, e.Field, e.Expected, e.Actual)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return ErrVerification
+}
+
+// VerifyBlock recomputes the block hash from the RLP encoded header, the hash of every transaction,
+// and the transactions, withdrawals and receipts tries, and compares them to what the node reported.
+// It returns a *VerificationError on the first mismatch.
+func VerifyBlock(block *Block, receipts Receipts) error {
+	if err := VerifyBody(block); err != nil {
+		return err
+	}
+
+	if len(receipts) != len(block.Transactions) {
+		return &VerificationError{
+			Block:    block.Hash,
+			Field:    "receipt count",
+			Expected: fmt.Sprint(len(block.Transactions)),
+			Actual:   fmt.Sprint(len(receipts)),
+		}
+	}
+
+	var err error
+	rcpts := make([][]byte, len(receipts))
+	for i, receipt := range receipts {
+		if rcpts[i], err = encodeReceipt(&receipt); err != nil {
+			return fmt.Errorf("unable to encode receipt for transaction %s: %w", receipt.TransactionHash, err)
+		}
+	}
+
+	return compareHash(&block.Header, "receiptsRoot", block.ReceiptsRoot, trie.DeriveRoot(rcpts))
+}
+
+// VerifyBody is VerifyBlock without the receipts: it checks the block hash, the hash of every transaction,
+// the transactions trie and, since Shanghai, the withdrawals trie
+func VerifyBody(block *Block) error {
+	if err := VerifyHeader(&block.Header); err != nil {
+		return err
+	}
+
+	var err error
+	txs := make([][]byte, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		if txs[i], err = encodeTransaction(&tx); err != nil {
+			return fmt.Errorf("unable to encode transaction %s: %w", tx.Hash, err)
+		}
+
+		if err := compareHash(&block.Header, "transaction hash", tx.Hash, crypto.Keccak256(txs[i])); err != nil {
+			return err
+		}
+	}
+
+	if err := compareHash(&block.Header, "transactionsRoot", block.TransactionsRoot, trie.DeriveRoot(txs)); err != nil {
+		return err
+	}
+
+	if block.WithdrawalsRoot == "" {
+		return nil
+	}
+
+	withdrawals := make([][]byte, len(block.Withdrawals))
+	for i, w := range block.Withdrawals {
+		if withdrawals[i], err = encodeWithdrawal(&w); err != nil {
+			return fmt.Errorf("unable to encode withdrawal %s: %w", w.Index, err)
+		}
+	}
+
+	return compareHash(&block.Header, "withdrawalsRoot", block.WithdrawalsRoot, trie.DeriveRoot(withdrawals))
+}
+
+// VerifyHeader recomputes the block hash from the RLP encoded header and compares it to what the node reported
+func VerifyHeader(header *Header) error {
+	encoded, err := encodeHeader(header)
+	if err != nil {
+		return fmt.Errorf("unable to encode header of block %s: %w", header.Hash, err)
+	}
+
+	return compareHash(header, "hash", header.Hash, crypto.Keccak256(encoded))
+}
+
+func compareHash(header *Header, field string, expected string, actual []byte) error {
+	exp, err := decodeHex(expected)
+	if err != nil || !bytes.Equal(exp, actual) {
+		return &VerificationError{
+			Block:    header.Hash,
+			Field:    field,
+			Expected: expected,
+			Actual:   "0x" + hex.EncodeToString(actual),
+		}
+	}
+
+	return nil
+}
+
+// rlpEncoder accumulates RLP items decoded from the hex encoded JSON-RPC fields,
+// keeping the first decoding error so that encoders can be written as a flat list of fields
+type rlpEncoder struct {
+	items [][]byte
+	err   error
+}
+
+func (e *rlpEncoder) bytes(s string) {
+	b, err := decodeHex(s)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	e.items = append(e.items, rlp.EncodeBytes(b))
+}
+
+func (e *rlpEncoder) quantity(s string) {
+	i, err := decodeQuantity(s)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	e.items = append(e.items, rlp.EncodeBigInt(i))
+}
+
+func (e *rlpEncoder) list(items []byte) {
+	e.items = append(e.items, items)
+}
+
+func (e *rlpEncoder) encode() ([]byte, error) {
+	return rlp.EncodeList(e.items...), e.err
+}
+
+func encodeHeader(b *Header) ([]byte, error) {
+	e := &rlpEncoder{}
+	e.bytes(b.ParentHash)
+	e.bytes(b.Sha3Uncles)
+	e.bytes(b.Miner)
+	e.bytes(b.StateRoot)
+	e.bytes(b.TransactionsRoot)
+	e.bytes(b.ReceiptsRoot)
+	e.bytes(b.LogsBloom)
+	e.quantity(b.Difficulty)
+	e.quantity(b.Number)
+	e.quantity(b.GasLimit)
+	e.quantity(b.GasUsed)
+	e.quantity(b.Timestamp)
+	e.bytes(b.ExtraData)
+	e.bytes(b.MixHash)
+	e.bytes(b.Nonce)
+
+	// fields added by forks are appended in order and are present only after their activation
+	optional := []struct {
+		value    string
+		quantity bool
+	}{
+		{b.BaseFeePerGas, true},          // London
+		{b.WithdrawalsRoot, false},       // Shanghai
+		{b.BlobGasUsed, true},            // Cancun
+		{b.ExcessBlobGas, true},          // Cancun
+		{b.ParentBeaconBlockRoot, false}, // Cancun
+		{b.RequestsHash, false},          // Prague
+	}
+
+	for _, field := range optional {
+		if field.value == "" {
+			break
+		}
+
+		if field.quantity {
+			e.quantity(field.value)
+		} else {
+			e.bytes(field.value)
+		}
+	}
+
+	return e.encode()
+}
+
+// encodeTransaction returns the consensus encoding of a transaction, which is the RLP list for legacy
+// transactions and the type byte followed by the RLP list for EIP-2718 typed transactions
+func encodeTransaction(tx *TransactionResult) ([]byte, error) {
+	txType, err := decodeQuantity(tx.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	yParity := tx.YParity
+	if yParity == "" {
+		yParity = tx.V
+	}
+
+	e := &rlpEncoder{}
+	switch txType.Uint64() {
+	case 0:
+		e.quantity(tx.Nonce)
+		e.quantity(tx.GasPrice)
+		e.quantity(tx.Gas)
+		e.bytes(tx.To)
+		e.quantity(tx.Value)
+		e.bytes(tx.Input)
+		e.quantity(tx.V)
+		e.quantity(tx.R)
+		e.quantity(tx.S)
+		return e.encode()
+	case 1:
+		e.quantity(tx.ChainID)
+		e.quantity(tx.Nonce)
+		e.quantity(tx.GasPrice)
+		e.quantity(tx.Gas)
+		e.bytes(tx.To)
+		e.quantity(tx.Value)
+		e.bytes(tx.Input)
+		encodeAccessList(e, tx.AccessList)
+	case 2:
+		e.quantity(tx.ChainID)
+		e.quantity(tx.Nonce)
+		e.quantity(tx.MaxPriorityFeePerGas)
+		e.quantity(tx.MaxFeePerGas)
+		e.quantity(tx.Gas)
+		e.bytes(tx.To)
+		e.quantity(tx.Value)
+		e.bytes(tx.Input)
+		encodeAccessList(e, tx.AccessList)
+	case 3:
+		e.quantity(tx.ChainID)
+		e.quantity(tx.Nonce)
+		e.quantity(tx.MaxPriorityFeePerGas)
+		e.quantity(tx.MaxFeePerGas)
+		e.quantity(tx.Gas)
+		e.bytes(tx.To)
+		e.quantity(tx.Value)
+		e.bytes(tx.Input)
+		encodeAccessList(e, tx.AccessList)
+		e.quantity(tx.MaxFeePerBlobGas)
+		hashes := &rlpEncoder{}
+		for _, h := range tx.BlobVersionedHashes {
+			hashes.bytes(h)
+		}
+		encodeNested(e, hashes)
+	case 4:
+		e.quantity(tx.ChainID)
+		e.quantity(tx.Nonce)
+		e.quantity(tx.MaxPriorityFeePerGas)
+		e.quantity(tx.MaxFeePerGas)
+		e.quantity(tx.Gas)
+		e.bytes(tx.To)
+		e.quantity(tx.Value)
+		e.bytes(tx.Input)
+		encodeAccessList(e, tx.AccessList)
+		auths := &rlpEncoder{}
+		for _, auth := range tx.AuthorizationList {
+			a := &rlpEncoder{}
+			a.quantity(auth.ChainID)
+			a.bytes(auth.Address)
+			a.quantity(auth.Nonce)
+			a.quantity(auth.YParity)
+			a.quantity(auth.R)
+			a.quantity(auth.S)
+			encodeNested(auths, a)
+		}
+		encodeNested(e, auths)
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %s", tx.Type)
+	}
+
+	e.quantity(yParity)
+	e.quantity(tx.R)
+	e.quantity(tx.S)
+
+	payload, err := e.encode()
+	return append([]byte{byte(txType.Uint64())}, payload...), err
+}
+
+func encodeAccessList(e *rlpEncoder, accessList AccessList) {
+	tuples := &rlpEncoder{}
+	for _, tuple := range accessList {
+		t := &rlpEncoder{}
+		t.bytes(tuple.Address)
+		keys := &rlpEncoder{}
+		for _, key := range tuple.StorageKeys {
+			keys.bytes(key)
+		}
+		encodeNested(t, keys)
+		encodeNested(tuples, t)
+	}
+	encodeNested(e, tuples)
+}
+
+// encodeNested appends the list built by inner to outer, propagating any decoding error
+func encodeNested(outer, inner *rlpEncoder) {
+	list, err := inner.encode()
+	if err != nil && outer.err == nil {
+		outer.err = err
+	}
+	outer.list(list)
+}
+
+// encodeWithdrawal returns the consensus encoding of a withdrawal, the RLP list [index, validatorIndex, address, amount]
+func encodeWithdrawal(w *Withdrawal) ([]byte, error) {
+	e := &rlpEncoder{}
+	e.quantity(w.Index)
+	e.quantity(w.ValidatorIndex)
+	e.bytes(w.Address)
+	e.quantity(w.Amount)
+
+	return e.encode()
+}
+
+// encodeReceipt returns the consensus encoding of a receipt, prefixed with the transaction type when typed
+func encodeReceipt(r *Receipt) ([]byte, error) {
+	txType, err := decodeQuantity(r.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &rlpEncoder{}
+	// pre-Byzantium receipts carry the intermediate state root instead of a status code
+	if r.Root != "" {
+		e.bytes(r.Root)
+	} else {
+		e.quantity(r.Status)
+	}
+	e.quantity(r.CumulativeGasUsed)
+	e.bytes(r.LogsBloom)
+
+	logs := &rlpEncoder{}
+	for _, log := range r.Logs {
+		l := &rlpEncoder{}
+		l.bytes(log.Address)
+		topics := &rlpEncoder{}
+		for _, topic := range log.Topics {
+			topics.bytes(topic)
+		}
+		encodeNested(l, topics)
+		l.bytes(log.Data)
+		encodeNested(logs, l)
+	}
+	encodeNested(e, logs)
+
+	payload, err := e.encode()
+	if txType.Sign() == 0 {
+		return payload, err
+	}
+
+	return append([]byte{byte(txType.Uint64())}, payload...), err
+}
@@ -0,0 +1,320 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/ethereum/crypto"
+	"github.com/mateeullahmalik/eh_parser/ethereum/trie"
+)
+
+func TestEncodeWithdrawal(t *testing.T) {
+	w := Withdrawal{Index: "0x0", ValidatorIndex: "0x1", Address: "0x0000000000000000000000000000000000000001", Amount: "0x10"}
+
+	encoded, err := encodeWithdrawal(&w)
+	if err != nil {
+		t.Fatalf("encodeWithdrawal: %v", err)
+	}
+
+	want := "d8800194000000000000000000000000000000000000000110"
+	if got := hex.EncodeToString(encoded); got != want {
+		t.Errorf("encodeWithdrawal = %s, want %s", got, want)
+	}
+}
+
+// sealedBlock returns a block with the given transactions, receipts and withdrawals whose hashes and roots are consistent
+func sealedBlock(t *testing.T, txs TransactionResults, receipts Receipts, withdrawals []Withdrawal) *Block {
+	t.Helper()
+
+	root := func(n int, encode func(i int) ([]byte, error)) string {
+		encoded := make([][]byte, n)
+		for i := range encoded {
+			var err error
+			if encoded[i], err = encode(i); err != nil {
+				t.Fatalf("encoding item %d: %v", i, err)
+			}
+		}
+		return "0x" + hex.EncodeToString(trie.DeriveRoot(encoded))
+	}
+
+	for i := range txs {
+		encoded, err := encodeTransaction(&txs[i])
+		if err != nil {
+			t.Fatalf("encodeTransaction: %v", err)
+		}
+		txs[i].Hash = "0x" + hex.EncodeToString(crypto.Keccak256(encoded))
+	}
+
+	zero := "0x" + hex.EncodeToString(make([]byte, 32))
+	b := &Block{
+		Header: Header{
+			ParentHash:       zero,
+			Sha3Uncles:       zero,
+			Miner:            "0x" + hex.EncodeToString(make([]byte, 20)),
+			StateRoot:        zero,
+			TransactionsRoot: root(len(txs), func(i int) ([]byte, error) { return encodeTransaction(&txs[i]) }),
+			ReceiptsRoot:     root(len(receipts), func(i int) ([]byte, error) { return encodeReceipt(&receipts[i]) }),
+			LogsBloom:        "0x" + hex.EncodeToString(make([]byte, 256)),
+			Difficulty:       "0x0",
+			Number:           "0x1",
+			GasLimit:         "0x1c9c380",
+			GasUsed:          "0x0",
+			Timestamp:        "0x64",
+			ExtraData:        "0x",
+			MixHash:          zero,
+			Nonce:            "0x0000000000000000",
+			BaseFeePerGas:    "0x7",
+			WithdrawalsRoot:  root(len(withdrawals), func(i int) ([]byte, error) { return encodeWithdrawal(&withdrawals[i]) }),
+		},
+		Transactions: txs,
+		Withdrawals:  withdrawals,
+	}
+
+	header, err := encodeHeader(&b.Header)
+	if err != nil {
+		t.Fatalf("encodeHeader: %v", err)
+	}
+	b.Hash = "0x" + hex.EncodeToString(crypto.Keccak256(header))
+
+	return b
+}
+
+func TestVerifyBodyWithdrawalsRoot(t *testing.T) {
+	withdrawals := func() []Withdrawal {
+		return []Withdrawal{
+			{Index: "0x0", ValidatorIndex: "0x1", Address: "0x0000000000000000000000000000000000000001", Amount: "0x10"},
+			{Index: "0x1", ValidatorIndex: "0x2", Address: "0x0000000000000000000000000000000000000002", Amount: "0x20"},
+		}
+	}
+
+	if err := VerifyBody(sealedBlock(t, nil, nil, withdrawals())); err != nil {
+		t.Fatalf("VerifyBody of a consistent block: %v", err)
+	}
+
+	if err := VerifyBody(sealedBlock(t, nil, nil, nil)); err != nil {
+		t.Fatalf("VerifyBody of a block without withdrawals: %v", err)
+	}
+
+	tampered := sealedBlock(t, nil, nil, withdrawals())
+	tampered.Withdrawals[1].Amount = "0x21"
+
+	var verr *VerificationError
+	err := VerifyBody(tampered)
+	if !errors.As(err, &verr) || verr.Field != "withdrawalsRoot" || !errors.Is(err, ErrVerification) {
+		t.Errorf("VerifyBody of a tampered withdrawal = %v, want a withdrawalsRoot mismatch", err)
+	}
+}
+
+// The sandbox the tests run in has no network access, so the known answers below are limited to mainnet data
+// that is small enough to be written down: the genesis block, block 1, the first transaction ever mined and
+// the EIP-155 example. Typed transactions and receipts are checked against encodings assembled by hand
+// from the field order of their EIPs.
+
+var (
+	emptyRoot  = "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"
+	emptyBloom = "0x" + strings.Repeat("00", 256)
+	recipient  = "0x" + strings.Repeat("35", 20)
+)
+
+func TestVerifyMainnetHeaders(t *testing.T) {
+	zero := "0x" + strings.Repeat("00", 32)
+	genesis := &Block{Header: Header{
+		Hash:             "0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3",
+		ParentHash:       zero,
+		Sha3Uncles:       "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+		Miner:            "0x0000000000000000000000000000000000000000",
+		StateRoot:        "0xd7f8974fb5ac78d9ac099b9ad5018bedc2ce0a72dad1827a1709da30580f0544",
+		TransactionsRoot: emptyRoot,
+		ReceiptsRoot:     emptyRoot,
+		LogsBloom:        emptyBloom,
+		Difficulty:       "0x400000000",
+		Number:           "0x0",
+		GasLimit:         "0x1388",
+		GasUsed:          "0x0",
+		Timestamp:        "0x0",
+		ExtraData:        "0x11bbe8db4e347b4e8c937c1c8370e4b5ed33adb3db69cbdb7a38e1e50b1b82fa",
+		MixHash:          zero,
+		Nonce:            "0x0000000000000042",
+	}}
+
+	first := &Block{Header: Header{
+		Hash:             "0x88e96d4537bea4d9c05d12549907b32561d3bf31f45aae734cdc119f13406cb6",
+		ParentHash:       genesis.Hash,
+		Sha3Uncles:       "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+		Miner:            "0x05a56e2d52c817161883f50c441c3228cfe54d9f",
+		StateRoot:        "0xd67e4d450343046425ae4271474353857ab860dbc0a1dde64b41b5cd3a532bf3",
+		TransactionsRoot: emptyRoot,
+		ReceiptsRoot:     emptyRoot,
+		LogsBloom:        emptyBloom,
+		Difficulty:       "0x3ff800000",
+		Number:           "0x1",
+		GasLimit:         "0x1388",
+		GasUsed:          "0x0",
+		Timestamp:        "0x55ba4224",
+		ExtraData:        "0x476574682f76312e302e302f6c696e75782f676f312e342e32",
+		MixHash:          "0x969b900de27b6ac6a67742365dd65f55a0526c41fd18e1b16f1a1215c2e66f59",
+		Nonce:            "0x539bd4979fef1ec4",
+	}}
+
+	for _, b := range []*Block{genesis, first} {
+		if err := VerifyBlock(b, Receipts{}); err != nil {
+			t.Errorf("VerifyBlock of mainnet block %s: %v", b.Number, err)
+		}
+	}
+
+	first.Timestamp = "0x55ba4225"
+	var verr *VerificationError
+	if err := VerifyHeader(&first.Header); !errors.As(err, &verr) || verr.Field != "hash" {
+		t.Errorf("VerifyHeader of a tampered header = %v, want a hash mismatch", err)
+	}
+}
+
+func TestEncodeTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   TransactionResult
+		want string
+		hash string // set for transactions whose hash is known
+	}{
+		{
+			name: "legacy, the first transaction mined on mainnet",
+			tx: TransactionResult{Type: "0x0", Nonce: "0x0", GasPrice: "0x2d79883d2000", Gas: "0x5208",
+				To: "0x5df9b87991262f6ba471f09758cde1c0fc1de734", Value: "0x7a69", Input: "0x", V: "0x1c",
+				R: "0x88ff6cf0fefd94db46111149ae4bfc179e9b94721fffd821d38d16464b3f71d0",
+				S: "0x45e0aff800961cfce805daef7016b9b675c137a6a41a548f7b60a3484c06a33a"},
+			want: "f86780862d79883d2000825208945df9b87991262f6ba471f09758cde1c0fc1de734827a69801ca088ff6cf0fefd94db46111149ae4bfc179e9b94721fffd821d38d16464b3f71d0a045e0aff800961cfce805daef7016b9b675c137a6a41a548f7b60a3484c06a33a",
+			hash: "5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060",
+		},
+		{
+			name: "legacy with replay protection, the EIP-155 example",
+			tx: TransactionResult{Type: "0x0", Nonce: "0x9", GasPrice: "0x4a817c800", Gas: "0x5208", To: recipient,
+				Value: "0xde0b6b3a7640000", Input: "0x", V: "0x25",
+				R: "0x28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276",
+				S: "0x67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"},
+			want: "f86c098504a817c80082520894" + strings.Repeat("35", 20) + "880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83",
+		},
+		{
+			name: "EIP-2930 access list",
+			tx: TransactionResult{Type: "0x1", ChainID: "0x1", Nonce: "0x0", GasPrice: "0x1", Gas: "0x5208", To: recipient,
+				Value: "0x0", Input: "0x", YParity: "0x1", V: "0x1", R: "0x1", S: "0x2",
+				AccessList: AccessList{{Address: recipient, StorageKeys: []string{"0x" + strings.Repeat("00", 32)}}}},
+			// 0x01 || [chainId, nonce, gasPrice, gas, to, value, data, [[address, [key]]], yParity, r, s]
+			want: "01f85a01800182520894" + strings.Repeat("35", 20) + "8080f838f794" + strings.Repeat("35", 20) + "e1a0" + strings.Repeat("00", 32) + "010102",
+		},
+		{
+			name: "EIP-1559 dynamic fee",
+			tx: TransactionResult{Type: "0x2", ChainID: "0x1", Nonce: "0x0", MaxPriorityFeePerGas: "0x1", MaxFeePerGas: "0x2",
+				Gas: "0x5208", To: recipient, Value: "0x1", Input: "0x", YParity: "0x1", V: "0x1", R: "0x1", S: "0x2"},
+			// 0x02 || [chainId, nonce, maxPriorityFee, maxFee, gas, to, value, data, accessList, yParity, r, s]
+			want: "02e201800102825208" + "94" + strings.Repeat("35", 20) + "0180c0010102",
+		},
+		{
+			name: "EIP-4844 blob",
+			tx: TransactionResult{Type: "0x3", ChainID: "0x1", Nonce: "0x0", MaxPriorityFeePerGas: "0x1", MaxFeePerGas: "0x2",
+				Gas: "0x5208", To: recipient, Value: "0x0", Input: "0x", MaxFeePerBlobGas: "0x3", YParity: "0x0", V: "0x0", R: "0x1", S: "0x2",
+				BlobVersionedHashes: []string{"0x01" + strings.Repeat("00", 31)}},
+			// 0x03 || [chainId, nonce, maxPriorityFee, maxFee, gas, to, value, data, accessList, maxFeePerBlobGas, hashes, yParity, r, s]
+			want: "03f84501800102825208" + "94" + strings.Repeat("35", 20) + "8080c003e1a001" + strings.Repeat("00", 31) + "800102",
+		},
+	}
+
+	for _, tt := range tests {
+		encoded, err := encodeTransaction(&tt.tx)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := hex.EncodeToString(encoded); got != tt.want {
+			t.Errorf("%s: encoded %s, want %s", tt.name, got, tt.want)
+		}
+
+		if got := hex.EncodeToString(crypto.Keccak256(encoded)); tt.hash != "" && got != tt.hash {
+			t.Errorf("%s: hash %s, want %s", tt.name, got, tt.hash)
+		}
+	}
+}
+
+func TestEncodeReceipt(t *testing.T) {
+	topic := "0x" + strings.Repeat("00", 31) + "01"
+	log := Log{Address: recipient, Topics: []string{topic}, Data: "0x"}
+
+	tests := []struct {
+		name    string
+		receipt Receipt
+		want    string
+	}{
+		{
+			name:    "pre-Byzantium with a state root",
+			receipt: Receipt{Type: "0x0", Root: "0x" + strings.Repeat("11", 32), CumulativeGasUsed: "0x5208", LogsBloom: emptyBloom},
+			// [root, cumulativeGasUsed, bloom, logs]
+			want: "f90128a0" + strings.Repeat("11", 32) + "825208b90100" + strings.Repeat("00", 256) + "c0",
+		},
+		{
+			name:    "EIP-1559 with a log",
+			receipt: Receipt{Type: "0x2", Status: "0x1", CumulativeGasUsed: "0x5208", LogsBloom: emptyBloom, Logs: []Log{log}},
+			// 0x02 || [status, cumulativeGasUsed, bloom, [[address, [topic], data]]]
+			want: "02f9014301825208b90100" + strings.Repeat("00", 256) + "f83af83894" + strings.Repeat("35", 20) + "e1a0" + topic[2:] + "80",
+		},
+	}
+
+	for _, tt := range tests {
+		encoded, err := encodeReceipt(&tt.receipt)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := hex.EncodeToString(encoded); got != tt.want {
+			t.Errorf("%s: encoded %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyBlockTransactionTypes(t *testing.T) {
+	receiptsOf := func(txs TransactionResults) Receipts {
+		receipts := make(Receipts, len(txs))
+		for i, tx := range txs {
+			receipts[i] = Receipt{Type: tx.Type, Status: "0x1", CumulativeGasUsed: fmt.Sprintf("0x%x", 21000*(i+1)), LogsBloom: emptyBloom,
+				Logs: []Log{{Address: recipient, Topics: []string{"0x" + strings.Repeat("00", 31) + "01"}, Data: "0x"}}}
+		}
+		return receipts
+	}
+
+	// a block with one transaction of each type, sealed along with their receipts
+	block := func() *Block {
+		txs := TransactionResults{
+			{Type: "0x0", Nonce: "0x0", GasPrice: "0x1", Gas: "0x5208", To: recipient, Value: "0x1", Input: "0x", V: "0x25", R: "0x1", S: "0x2"},
+			{Type: "0x1", ChainID: "0x1", Nonce: "0x1", GasPrice: "0x1", Gas: "0x5208", To: recipient, Value: "0x0", Input: "0xa9059cbb",
+				AccessList: AccessList{{Address: recipient, StorageKeys: []string{"0x" + strings.Repeat("00", 32)}}}, YParity: "0x1", R: "0x1", S: "0x2"},
+			{Type: "0x2", ChainID: "0x1", Nonce: "0x2", MaxPriorityFeePerGas: "0x1", MaxFeePerGas: "0x2", Gas: "0x5208", To: recipient, Value: "0x1", Input: "0x", YParity: "0x0", R: "0x1", S: "0x2"},
+			{Type: "0x3", ChainID: "0x1", Nonce: "0x3", MaxPriorityFeePerGas: "0x1", MaxFeePerGas: "0x2", Gas: "0x5208", To: recipient, Value: "0x0", Input: "0x",
+				MaxFeePerBlobGas: "0x3", BlobVersionedHashes: []string{"0x01" + strings.Repeat("00", 31)}, YParity: "0x1", R: "0x1", S: "0x2"},
+		}
+
+		return sealedBlock(t, txs, receiptsOf(txs), nil)
+	}
+
+	b := block()
+	if err := VerifyBlock(b, receiptsOf(b.Transactions)); err != nil {
+		t.Fatalf("VerifyBlock of a consistent block: %v", err)
+	}
+
+	var verr *VerificationError
+	for i := range b.Transactions {
+		tampered := block()
+		tampered.Transactions[i].Value = "0x2"
+		if err := VerifyBlock(tampered, receiptsOf(b.Transactions)); !errors.As(err, &verr) || verr.Field != "transaction hash" {
+			t.Errorf("VerifyBlock with transaction %d tampered = %v, want a transaction hash mismatch", i, err)
+		}
+
+		receipts := receiptsOf(b.Transactions)
+		receipts[i].Status = "0x0"
+		if err := VerifyBlock(block(), receipts); !errors.As(err, &verr) || verr.Field != "receiptsRoot" {
+			t.Errorf("VerifyBlock with receipt %d tampered = %v, want a receiptsRoot mismatch", i, err)
+		}
+	}
+}
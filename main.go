@@ -3,20 +3,39 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
 	"github.com/mateeullahmalik/eh_parser/ethereum"
 	"github.com/mateeullahmalik/eh_parser/parser"
 	infraEth "github.com/mateeullahmalik/eh_parser/parser/infrastructure/ethereum"
 	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/webhook"
 )
 
+const metricsAddr = ":9100"
+
 func main() {
 	// example of how to use the parser
-	ethereumClient := ethereum.NewClient(ethereum.NewConfig())
+	registry := metrics.NewRegistry()
+
+	ethereumConfig := ethereum.NewConfig()
+	ethereumConfig.Metrics = registry
+	ethereumClient := ethereum.NewClient(ethereumConfig)
+
+	config := parser.NewConfig()
+	config.Metrics = registry
+	config.Subscriptions = memory.NewSubscriptionMemoryStore()
+	config.Webhook.Deliveries = memory.NewDeliveryMemoryStore()
+	config.Webhook.Sender = webhook.NewSender()
+	config.Alerts.Rules = memory.NewAlertRuleMemoryStore()
+	config.Alerts.Watchlists = memory.NewWatchlistMemoryStore()
+	config.Mempool.Transactions = memory.NewPendingTransactionMemoryStore()
 
-	txnsParser := parser.NewClient(
+	txnsParser := parser.NewClientWithConfig(
 		infraEth.NewEthereumBlockchain(ethereumClient),
 		memory.NewTransactionMemoryStore(),
+		config,
 	)
 
 	if err := txnsParser.Run(context.Background()); err != nil {
@@ -26,6 +45,12 @@ func main() {
 	txnsParser.Subscribe("0x1234567890abcdef1234567890abcdef12345678")
 	fmt.Println("Subscribed to address 0x1234567890abcdef1234567890abcdef12345678")
 
+	// Prometheus scrapes the RPC, parser and storage measurements from /metrics
+	http.Handle("/metrics", registry)
+	if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+		panic(err) // To Do: use a better error handling mechanism
+	}
+
 	// Get transactions for the address won't work withouth connecting to the Ethereum blockchain
 	// txns, err := txnsParser.GetTransactions("0x1234567890abcdef1234567890abcdef12345678")
 	// if err != nil {
@@ -0,0 +1,396 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/mateeullahmalik/eh_parser/common/expr"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// alertRule is a rule along with its compiled expression
+type alertRule struct {
+	rule    domain.AlertRule
+	program *expr.Program
+}
+
+// txType is what alert rules see of a transaction as tx
+var txType = expr.ObjectOf("transaction", map[string]*expr.Type{
+	"from":     expr.String,
+	"to":       expr.String,
+	"hash":     expr.String,
+	"block":    expr.Number,
+	"value":    expr.Number,
+	"gas":      expr.Number,
+	"gasPrice": expr.Number,
+	"method":   expr.String,
+	"success":  expr.Bool,
+	"status":   expr.String,
+	"state":    expr.String,
+})
+
+// txObject exposes a transaction to alert rules, amounts are in wei
+type txObject struct {
+	tx *domain.Transaction
+}
+
+func (o txObject) Field(name string) expr.Value {
+	switch name {
+	case "from":
+		return o.tx.From
+	case "to":
+		return o.tx.To
+	case "hash":
+		return o.tx.TxID
+	case "block":
+		return expr.Int64(int64(o.tx.Block))
+	case "value":
+		return hexNumber(o.tx.Value)
+	case "gas":
+		return hexNumber(o.tx.Gas)
+	case "gasPrice":
+		return hexNumber(o.tx.GasPrice)
+	case "method":
+		return o.tx.Method
+	case "success":
+		return o.tx.Status == domain.ExecutionSucceeded
+	case "status":
+		return o.tx.Status.String()
+	case "state":
+		return o.tx.State.String()
+	default:
+		return nil
+	}
+}
+
+// hexNumber converts a quantity reported by the node, nil when it isn't one so that evaluating the rule fails
+func hexNumber(s string) expr.Value {
+	i, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil
+	}
+
+	return expr.Int(i)
+}
+
+// newAlertEnv declares tx and the watchlist function, which looks watchlists up while the rules are evaluated
+// under alertMu. Unknown watchlists are empty.
+func (c *Client) newAlertEnv() *expr.Env {
+	env := expr.NewEnv()
+	env.Declare("tx", txType)
+	env.Function(&expr.Function{
+		Name:   "watchlist",
+		Params: []*expr.Type{expr.String},
+		Result: expr.ListOf(expr.String),
+		Impl: func(args ...expr.Value) (expr.Value, error) {
+			if set, ok := c.watchlists[args[0].(string)]; ok {
+				return set, nil
+			}
+			return expr.StringSet(nil), nil
+		},
+	})
+
+	return env
+}
+
+// OnAlert registers a handler called with every alert raised by a rule, from the processing loop
+func (c *Client) OnAlert(handler func(domain.Alert)) {
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	c.alertHandlers = append(c.alertHandlers, handler)
+}
+
+// ValidateAlertExpression compiles an expression without saving it, returning why it is invalid if it is
+func (c *Client) ValidateAlertExpression(expression string) error {
+	_, err := expr.Compile(c.alertEnv, expression)
+	return err
+}
+
+// CreateAlertRule compiles and saves a new rule, it applies from the next committed block on
+func (c *Client) CreateAlertRule(rule domain.AlertRule) (domain.AlertRule, error) {
+	program, err := expr.Compile(c.alertEnv, rule.Expression)
+	if err != nil {
+		return domain.AlertRule{}, fmt.Errorf("cannot create alert rule: %w", err)
+	}
+
+	now := c.clock.Now().UTC()
+	rule.ID = newID()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	if err := c.saveAlertRule(rule); err != nil {
+		return domain.AlertRule{}, err
+	}
+	c.alertRules = append(c.alertRules, &alertRule{rule: rule, program: program})
+
+	return rule, nil
+}
+
+// UpdateAlertRule replaces the name, expression and disabled flag of the rule with the same ID
+func (c *Client) UpdateAlertRule(rule domain.AlertRule) (domain.AlertRule, error) {
+	program, err := expr.Compile(c.alertEnv, rule.Expression)
+	if err != nil {
+		return domain.AlertRule{}, fmt.Errorf("cannot update alert rule %s: %w", rule.ID, err)
+	}
+
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	i := c.findAlertRule(rule.ID)
+	if i < 0 {
+		return domain.AlertRule{}, fmt.Errorf("alert rule %s not found", rule.ID)
+	}
+
+	rule.CreatedAt = c.alertRules[i].rule.CreatedAt
+	rule.UpdatedAt = c.clock.Now().UTC()
+	if err := c.saveAlertRule(rule); err != nil {
+		return domain.AlertRule{}, err
+	}
+	c.alertRules[i] = &alertRule{rule: rule, program: program}
+
+	return rule, nil
+}
+
+// DeleteAlertRule removes a rule
+func (c *Client) DeleteAlertRule(id string) error {
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	i := c.findAlertRule(id)
+	if i < 0 {
+		return fmt.Errorf("alert rule %s not found", id)
+	}
+
+	if c.config.Alerts.Rules != nil {
+		if err := c.config.Alerts.Rules.Delete(id); err != nil {
+			return fmt.Errorf("unable to delete alert rule %s: %w", id, err)
+		}
+	}
+	c.alertRules = append(c.alertRules[:i], c.alertRules[i+1:]...)
+
+	return nil
+}
+
+// GetAlertRule returns a rule by ID
+func (c *Client) GetAlertRule(id string) (domain.AlertRule, error) {
+	c.alertMu.RLock()
+	defer c.alertMu.RUnlock()
+
+	i := c.findAlertRule(id)
+	if i < 0 {
+		return domain.AlertRule{}, fmt.Errorf("alert rule %s not found", id)
+	}
+
+	return c.alertRules[i].rule, nil
+}
+
+// ListAlertRules returns every rule in creation order
+func (c *Client) ListAlertRules() []domain.AlertRule {
+	c.alertMu.RLock()
+	defer c.alertMu.RUnlock()
+
+	rules := make([]domain.AlertRule, len(c.alertRules))
+	for i, r := range c.alertRules {
+		rules[i] = r.rule
+	}
+
+	return rules
+}
+
+// SetWatchlist creates or replaces a watchlist, rules see the new addresses from the next committed block on
+func (c *Client) SetWatchlist(name string, addresses []string) error {
+	w := domain.Watchlist{Name: name, Addresses: make([]string, len(addresses))}
+	for i, address := range addresses {
+		a, err := domain.NormalizeAddress(address)
+		if err != nil {
+			return fmt.Errorf("cannot set watchlist %s: %w", name, err)
+		}
+		w.Addresses[i] = a
+	}
+
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	if c.config.Alerts.Watchlists != nil {
+		if err := c.config.Alerts.Watchlists.Save(w); err != nil {
+			return fmt.Errorf("unable to save watchlist %s: %w", name, err)
+		}
+	}
+	c.watchlists[name] = expr.NewStringSet(w.Addresses...)
+
+	return nil
+}
+
+// DeleteWatchlist removes a watchlist, rules referring to it then see it empty
+func (c *Client) DeleteWatchlist(name string) error {
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	if c.config.Alerts.Watchlists != nil {
+		if err := c.config.Alerts.Watchlists.Delete(name); err != nil {
+			return fmt.Errorf("unable to delete watchlist %s: %w", name, err)
+		}
+	}
+	delete(c.watchlists, name)
+
+	return nil
+}
+
+// GetWatchlists returns every watchlist, sorted by name
+func (c *Client) GetWatchlists() []domain.Watchlist {
+	c.alertMu.RLock()
+	defer c.alertMu.RUnlock()
+
+	lists := make([]domain.Watchlist, 0, len(c.watchlists))
+	for name, set := range c.watchlists {
+		w := domain.Watchlist{Name: name, Addresses: make([]string, 0, len(set))}
+		for address := range set {
+			w.Addresses = append(w.Addresses, address)
+		}
+		sort.Strings(w.Addresses)
+		lists = append(lists, w)
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+
+	return lists
+}
+
+// loadAlertRules replaces the rules and watchlists with the persisted ones.
+// A persisted rule that no longer compiles is kept out and logged rather than failing the start.
+func (c *Client) loadAlertRules() error {
+	cfg := c.config.Alerts
+
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	if cfg.Watchlists != nil {
+		lists, err := cfg.Watchlists.GetAll()
+		if err != nil {
+			return fmt.Errorf("error loading watchlists: %w", err)
+		}
+
+		c.watchlists = make(map[string]expr.StringSet, len(lists))
+		for _, w := range lists {
+			c.watchlists[w.Name] = expr.NewStringSet(w.Addresses...)
+		}
+	}
+
+	if cfg.Rules == nil {
+		return nil
+	}
+
+	rules, err := cfg.Rules.GetAll()
+	if err != nil {
+		return fmt.Errorf("error loading alert rules: %w", err)
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+
+	c.alertRules = c.alertRules[:0]
+	for _, rule := range rules {
+		program, err := expr.Compile(c.alertEnv, rule.Expression)
+		if err != nil {
+			c.logger.Errorf("Not loading alert rule %s: %v", rule.ID, err)
+			continue
+		}
+		c.alertRules = append(c.alertRules, &alertRule{rule: rule, program: program})
+	}
+
+	return nil
+}
+
+// evaluateAlerts runs the enabled rules against committed transactions and hands the alerts to the handlers.
+// A rule failing on a transaction, such as on a division by zero, is logged and raises no alert.
+func (c *Client) evaluateAlerts(txs domain.Transactions) {
+	alerts, handlers := c.matchAlertRules(txs)
+
+	// called without the lock, so that handlers can manage rules
+	for _, alert := range alerts {
+		for _, handler := range handlers {
+			handler(alert)
+		}
+	}
+}
+
+// raiseAlerts hands alerts of the built-in rules to the handlers
+func (c *Client) raiseAlerts(alerts []domain.Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	c.alertMu.RLock()
+	handlers := make([]func(domain.Alert), len(c.alertHandlers))
+	copy(handlers, c.alertHandlers)
+	c.alertMu.RUnlock()
+
+	c.metrics.IncCounter(metricAlertsRaised, float64(len(alerts)))
+	for _, alert := range alerts {
+		for _, handler := range handlers {
+			handler(alert)
+		}
+	}
+}
+
+func (c *Client) matchAlertRules(txs domain.Transactions) ([]domain.Alert, []func(domain.Alert)) {
+	c.alertMu.RLock()
+	defer c.alertMu.RUnlock()
+
+	if len(c.alertRules) == 0 || len(txs) == 0 {
+		return nil, nil
+	}
+
+	var alerts []domain.Alert
+	now := c.clock.Now().UTC()
+	for i := range txs {
+		vars := map[string]expr.Value{"tx": txObject{tx: &txs[i]}}
+
+		for _, r := range c.alertRules {
+			if r.rule.Disabled {
+				continue
+			}
+
+			matched, err := r.program.Eval(vars)
+			if err != nil {
+				c.metrics.IncCounter(metricAlertRuleErrors, 1)
+				c.logger.Warnf("Error evaluating alert rule %s on transaction %s: %v", r.rule.ID, txs[i].TxID, err)
+				continue
+			}
+
+			if matched {
+				c.metrics.IncCounter(metricAlertsRaised, 1)
+				alerts = append(alerts, domain.Alert{RuleID: r.rule.ID, RuleName: r.rule.Name, Transaction: txs[i], CreatedAt: now})
+			}
+		}
+	}
+
+	handlers := make([]func(domain.Alert), len(c.alertHandlers))
+	copy(handlers, c.alertHandlers)
+
+	return alerts, handlers
+}
+
+func (c *Client) findAlertRule(id string) int {
+	for i, r := range c.alertRules {
+		if r.rule.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *Client) saveAlertRule(rule domain.AlertRule) error {
+	if c.config.Alerts.Rules == nil {
+		return nil
+	}
+
+	if err := c.config.Alerts.Rules.Save(rule); err != nil {
+		return fmt.Errorf("unable to save alert rule %s: %w", rule.ID, err)
+	}
+
+	return nil
+}
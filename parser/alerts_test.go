@@ -0,0 +1,247 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/expr"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// alerts collects the alerts raised by a client
+type alerts struct {
+	mu     sync.Mutex
+	raised []domain.Alert
+}
+
+func (a *alerts) add(alert domain.Alert) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.raised = append(a.raised, alert)
+}
+
+// byRule returns the hashes of the transactions each rule raised an alert for
+func (a *alerts) byRule() map[string][]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	hashes := make(map[string][]string)
+	for _, alert := range a.raised {
+		hashes[alert.RuleID] = append(hashes[alert.RuleID], alert.Transaction.TxID)
+	}
+
+	return hashes
+}
+
+func TestAlertRuleLifecycle(t *testing.T) {
+	store := memory.NewAlertRuleMemoryStore()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cfg := testConfig()
+	cfg.Alerts.Rules = store
+	cfg.Clock = frozenClock{now: created}
+	c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+
+	rule, err := c.CreateAlertRule(domain.AlertRule{Name: "large", Expression: "tx.value > 100e18"})
+	if err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+
+	if rule.ID == "" || !rule.CreatedAt.Equal(created) || !rule.UpdatedAt.Equal(created) {
+		t.Errorf("created rule %+v, want an ID and the creation time", rule)
+	}
+
+	if got, err := c.GetAlertRule(rule.ID); err != nil || got != rule {
+		t.Errorf("GetAlertRule = %+v, %v, want %+v", got, err, rule)
+	}
+
+	updated := created.Add(time.Hour)
+	c.clock = frozenClock{now: updated}
+	rule.Name = "very large"
+	rule.Expression = "tx.value > 1000e18"
+	rule.CreatedAt = time.Time{}
+	if rule, err = c.UpdateAlertRule(rule); err != nil {
+		t.Fatalf("UpdateAlertRule: %v", err)
+	}
+
+	if !rule.CreatedAt.Equal(created) || !rule.UpdatedAt.Equal(updated) {
+		t.Errorf("updated rule %+v, want the creation time kept and the update time set", rule)
+	}
+
+	persisted, err := store.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	if len(persisted) != 1 || persisted[0] != rule {
+		t.Errorf("persisted rules %+v, want the updated rule", persisted)
+	}
+
+	if _, err := c.UpdateAlertRule(domain.AlertRule{ID: "unknown", Expression: "true"}); err == nil {
+		t.Error("UpdateAlertRule of an unknown rule succeeded")
+	}
+
+	if err := c.DeleteAlertRule(rule.ID); err != nil {
+		t.Fatalf("DeleteAlertRule: %v", err)
+	}
+
+	if _, err := c.GetAlertRule(rule.ID); err == nil {
+		t.Error("GetAlertRule of a deleted rule succeeded")
+	}
+
+	if rules := c.ListAlertRules(); len(rules) != 0 {
+		t.Errorf("ListAlertRules = %+v after deleting the only rule", rules)
+	}
+
+	if persisted, _ := store.GetAll(); len(persisted) != 0 {
+		t.Errorf("persisted rules %+v after deleting the only rule", persisted)
+	}
+
+	if err := c.DeleteAlertRule(rule.ID); err == nil {
+		t.Error("deleting a deleted rule succeeded")
+	}
+}
+
+func TestAlertRuleInvalidExpression(t *testing.T) {
+	tests := []string{
+		"tx.value",
+		"tx.value >",
+		"tx.unknown == 1",
+		"tx.to in watchlist(1)",
+		"tx.success == 'yes'",
+	}
+
+	store := memory.NewAlertRuleMemoryStore()
+	cfg := testConfig()
+	cfg.Alerts.Rules = store
+	c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+
+	valid, err := c.CreateAlertRule(domain.AlertRule{Name: "valid", Expression: "!tx.success"})
+	if err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+
+	for _, expression := range tests {
+		if err := c.ValidateAlertExpression(expression); !errors.Is(err, expr.ErrCompile) {
+			t.Errorf("ValidateAlertExpression(%q) = %v, want ErrCompile", expression, err)
+		}
+
+		if _, err := c.CreateAlertRule(domain.AlertRule{Expression: expression}); !errors.Is(err, expr.ErrCompile) {
+			t.Errorf("CreateAlertRule(%q) = %v, want ErrCompile", expression, err)
+		}
+
+		update := valid
+		update.Expression = expression
+		if _, err := c.UpdateAlertRule(update); !errors.Is(err, expr.ErrCompile) {
+			t.Errorf("UpdateAlertRule(%q) = %v, want ErrCompile", expression, err)
+		}
+	}
+
+	if got, _ := c.GetAlertRule(valid.ID); got.Expression != valid.Expression {
+		t.Errorf("rule expression is %q after rejected updates, want %q", got.Expression, valid.Expression)
+	}
+
+	if persisted, _ := store.GetAll(); len(persisted) != 1 || persisted[0] != valid {
+		t.Errorf("persisted rules %+v, want only the valid rule", persisted)
+	}
+}
+
+func TestAlertRulesRaiseAlertsForCommittedTransactions(t *testing.T) {
+	chain := newFakeChain(1)
+	chain.extend(3, "a")
+	chain.setTransactions(1, domain.Transaction{From: addrA, To: addrB, TxID: "large", Value: "0x56bc75e2d63100001", Status: domain.ExecutionSucceeded})
+	chain.setTransactions(2, domain.Transaction{From: addrA, To: addrC, TxID: "small", Value: "0x1", Status: domain.ExecutionSucceeded})
+	chain.setTransactions(3, domain.Transaction{From: addrA, To: addrB, TxID: "failed", Value: "0x1", Status: domain.ExecutionFailed})
+
+	var raised alerts
+	cfg := testConfig()
+	c := NewClientWithConfig(chain, memory.NewTransactionMemoryStore(), cfg)
+	c.OnAlert(raised.add)
+
+	if err := c.SetWatchlist("cold", []string{"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"}); err != nil {
+		t.Fatalf("SetWatchlist: %v", err)
+	}
+
+	rules := map[string]string{
+		"large":    "tx.value > 100e18",
+		"cold":     `tx.to in watchlist("cold")`,
+		"missing":  `tx.to in watchlist("missing")`,
+		"failed":   "!tx.success",
+		"disabled": "true",
+	}
+	ids := make(map[string]string)
+	for name, expression := range rules {
+		rule, err := c.CreateAlertRule(domain.AlertRule{Name: name, Expression: expression, Disabled: name == "disabled"})
+		if err != nil {
+			t.Fatalf("CreateAlertRule(%q): %v", expression, err)
+		}
+		ids[rule.ID] = name
+	}
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	t.Cleanup(func() { c.Stop(context.Background()) })
+	subscribeAndSync(t, c, chain, addrA, nil)
+
+	got := make(map[string][]string)
+	for id, hashes := range raised.byRule() {
+		got[ids[id]] = hashes
+	}
+
+	want := map[string][]string{
+		"large":  {"large"},
+		"cold":   {"large", "failed"},
+		"failed": {"failed"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("alerts raised by rule %v, want %v", got, want)
+	}
+	for name, hashes := range want {
+		if len(got[name]) != len(hashes) {
+			t.Errorf("rule %s raised alerts for %v, want %v", name, got[name], hashes)
+			continue
+		}
+		for i := range hashes {
+			if got[name][i] != hashes[i] {
+				t.Errorf("rule %s raised alerts for %v, want %v", name, got[name], hashes)
+			}
+		}
+	}
+}
+
+func TestAlertRulesReloaded(t *testing.T) {
+	rules := memory.NewAlertRuleMemoryStore()
+	watchlists := memory.NewWatchlistMemoryStore()
+	cfg := testConfig()
+	cfg.Alerts.Rules = rules
+	cfg.Alerts.Watchlists = watchlists
+
+	first := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+	rule, err := first.CreateAlertRule(domain.AlertRule{Name: "cold", Expression: `tx.to in watchlist("cold")`})
+	if err != nil {
+		t.Fatalf("CreateAlertRule: %v", err)
+	}
+
+	if err := first.SetWatchlist("cold", []string{addrB}); err != nil {
+		t.Fatalf("SetWatchlist: %v", err)
+	}
+
+	// persisted by an older version, it no longer compiles
+	if err := rules.Save(domain.AlertRule{ID: "stale", Expression: "tx.removed == 1", CreatedAt: rule.CreatedAt.Add(time.Second)}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	second := startClient(t, newFakeChain(1), cfg)
+	if got := second.ListAlertRules(); len(got) != 1 || got[0] != rule {
+		t.Errorf("reloaded rules %+v, want %+v without the stale rule", got, rule)
+	}
+
+	if got := second.GetWatchlists(); len(got) != 1 || got[0].Name != "cold" || len(got[0].Addresses) != 1 || got[0].Addresses[0] != addrB {
+		t.Errorf("reloaded watchlists %+v, want cold holding %s", got, addrB)
+	}
+}
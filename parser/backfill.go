@@ -0,0 +1,351 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+// errSubscriptionEnded stops the backfill of a subscription that ended
+var errSubscriptionEnded = errors.New("subscription ended")
+
+const (
+	// backfillChunkSize is the number of blocks searched per trace_filter request
+	backfillChunkSize = 10000
+	// backfillSaveInterval is the number of blocks scanned one by one between two saves of the progress
+	backfillSaveInterval = 1000
+)
+
+// backfill scans the blocks mined before a subscription started receiving them live
+type backfill struct {
+	mu       sync.RWMutex
+	progress domain.BackfillProgress
+	running  bool
+
+	activated    chan struct{} // closed once the processing loop fixed where the backfill ends
+	activateOnce sync.Once
+}
+
+func newBackfill(progress domain.BackfillProgress) *backfill {
+	b := &backfill{
+		progress:  progress,
+		activated: make(chan struct{}),
+	}
+
+	// a backfill restored while running already knows where it ends
+	if progress.Status == domain.BackfillRunning {
+		b.activateOnce.Do(func() { close(b.activated) })
+	}
+
+	return b
+}
+
+func (b *backfill) get() domain.BackfillProgress {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.progress
+}
+
+func (b *backfill) update(fn func(p *domain.BackfillProgress)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	fn(&b.progress)
+}
+
+// activate ends the backfill right before the first block the subscription receives live
+func (b *backfill) activate(next int32) {
+	b.activateOnce.Do(func() {
+		b.update(func(p *domain.BackfillProgress) {
+			p.To = next - 1
+		})
+		close(b.activated)
+	})
+}
+
+// unfinished reports whether the backfill still has blocks to scan
+func (b *backfill) unfinished() bool {
+	status := b.get().Status
+	return status == domain.BackfillWaiting || status == domain.BackfillRunning
+}
+
+// backfillMatcher matches the transactions of the address of a subscription that it wants
+type backfillMatcher struct {
+	s *Subscription
+}
+
+var _ domain.TransactionMatcher = backfillMatcher{}
+
+func (m backfillMatcher) Contains(address string) bool {
+	return address == m.s.Address
+}
+
+func (m backfillMatcher) MatchesTransaction(tx domain.Transaction) bool {
+	return m.s.trackBalance || m.s.filter.Matches(m.s.Address, tx)
+}
+
+// GetBackfillProgress returns the progress of the backfill requested by a subscription
+func (c *Client) GetBackfillProgress(id string) (domain.BackfillProgress, error) {
+	s, err := c.GetSubscription(id)
+	if err != nil {
+		return domain.BackfillProgress{}, err
+	}
+
+	if s.backfill == nil {
+		return domain.BackfillProgress{}, fmt.Errorf("no backfill was requested by subscription %s", id)
+	}
+
+	return s.backfill.get(), nil
+}
+
+// startBackfill runs the backfill of a subscription in the background unless it is finished or already running.
+// One that doesn't know where it ends yet waits for the processing loop to pick the subscription up.
+func (c *Client) startBackfill(s *Subscription) {
+	b := s.backfill
+	if b == nil || !b.unfinished() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.running {
+		return
+	}
+
+	select {
+	case <-b.activated:
+	default:
+		c.queueWatch(watchUpdate{backfill: s})
+	}
+
+	b.running = c.goWorker(func(ctx context.Context) {
+		c.runBackfill(ctx, s)
+
+		b.mu.Lock()
+		b.running = false
+		b.mu.Unlock()
+	})
+}
+
+// resumeBackfills starts the backfills that a previous run left unfinished
+func (c *Client) resumeBackfills() {
+	c.subscriptions.Range(func(key, value interface{}) bool {
+		c.startBackfill(value.(*Subscription))
+		return true
+	})
+}
+
+// runBackfill waits until the processing loop picks the subscription up, then scans everything before that block.
+// Tip-following and the backfill never deliver the same block to the subscription. A backfill interrupted by
+// a stop resumes from its saved progress on the next run.
+func (c *Client) runBackfill(ctx context.Context, s *Subscription) {
+	b := s.backfill
+	select {
+	case <-ctx.Done():
+		return
+	case <-b.activated:
+	}
+
+	b.update(func(p *domain.BackfillProgress) {
+		p.Status = domain.BackfillRunning
+	})
+	c.saveBackfill(s)
+
+	p := b.get()
+	err := c.scanHistory(ctx, s, p.Current+1, p.To)
+	if ctx.Err() != nil || errors.Is(err, errSubscriptionEnded) {
+		// resumed from the saved progress on the next run, if the subscription is restored
+		c.saveBackfill(s)
+		return
+	}
+
+	if err != nil {
+		c.logger.Errorf("Backfill of subscription %s failed: %v", s.ID, err)
+		b.update(func(p *domain.BackfillProgress) {
+			p.Status = domain.BackfillFailed
+			p.Error = err.Error()
+		})
+	} else {
+		b.update(func(p *domain.BackfillProgress) {
+			p.Status = domain.BackfillDone
+		})
+	}
+
+	c.saveBackfill(s)
+}
+
+// saveBackfill persists the progress of the backfill with the subscription record
+func (c *Client) saveBackfill(s *Subscription) {
+	if c.config.Subscriptions == nil {
+		return
+	}
+
+	// a subscription ending deletes its record after closing, it mustn't be saved again then
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	info := s.info
+	progress := s.backfill.get()
+	info.Backfill = &progress
+
+	if err := c.config.Subscriptions.Save(info); err != nil {
+		c.logger.Errorf("Error saving backfill progress of subscription %s: %v", s.ID, err)
+		return
+	}
+
+	s.info = info
+}
+
+// scanHistory searches the range in chunks with GetBlocksWithAddressActivity so only blocks touching
+// the address are fetched, and falls back to filtering every block when the node can't do that
+func (c *Client) scanHistory(ctx context.Context, s *Subscription, from, to int32) error {
+	for start := from; start <= to; start += backfillChunkSize {
+		end := min(start+backfillChunkSize-1, to)
+
+		blocks, err := c.ethClient.GetBlocksWithAddressActivity(ctx, start, end, s.Address)
+		if errors.Is(err, ethereum.ErrNotSupported) {
+			c.logger.Warnf("Backfill of subscription %s falls back to scanning every block: %v", s.ID, err)
+			return c.scanBlocks(ctx, s, start, to)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error locating activity in blocks %d to %d: %w", start, end, err)
+		}
+
+		for _, block := range blocks {
+			if err := c.backfillBlock(ctx, s, block); err != nil {
+				return err
+			}
+		}
+
+		s.backfill.update(func(p *domain.BackfillProgress) {
+			p.Current = end
+		})
+		c.saveBackfill(s)
+	}
+
+	return nil
+}
+
+func (c *Client) scanBlocks(ctx context.Context, s *Subscription, from, to int32) error {
+	for block := from; block <= to; block++ {
+		if err := c.backfillBlock(ctx, s, block); err != nil {
+			return err
+		}
+
+		s.backfill.update(func(p *domain.BackfillProgress) {
+			p.Current = block
+		})
+
+		if (block-from+1)%backfillSaveInterval == 0 {
+			c.saveBackfill(s)
+		}
+	}
+
+	return nil
+}
+
+// backfillBlock stores the transactions of the block the subscription wants that weren't stored yet,
+// and delivers all of them to the subscription only
+func (c *Client) backfillBlock(ctx context.Context, s *Subscription, block int32) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.done:
+		return errSubscriptionEnded
+	default:
+	}
+
+	blk, err := c.fetchTransactions(ctx, block, backfillMatcher{s: s})
+	if err != nil {
+		return fmt.Errorf("error fetching transactions from block %d: %w", block, err)
+	}
+
+	if len(blk.Transactions) == 0 {
+		return nil
+	}
+
+	fresh, err := c.storeBackfilled(ctx, blk)
+	if err != nil {
+		return err
+	}
+
+	if err := c.enqueueSubscriptionWebhooks(s, blk.BlockHeader, blk.Transactions); err != nil {
+		return fmt.Errorf("error enqueuing webhooks for block %d: %w", block, err)
+	}
+
+	c.metrics.IncCounter(metricTxsStored, float64(len(fresh)))
+	s.backfill.update(func(p *domain.BackfillProgress) {
+		p.Found += len(blk.Transactions)
+	})
+
+	for _, tx := range blk.Transactions {
+		s.deliver(ctx, domain.Event{Type: domain.EventTransaction, Transaction: tx})
+	}
+
+	return nil
+}
+
+// storeBackfilled adds the transactions of a backfilled block that weren't stored yet to the ones that were,
+// for instance because the counterparty is watched too, and returns them
+func (c *Client) storeBackfilled(ctx context.Context, b domain.Block) (domain.Transactions, error) {
+	// keep out of the way of the processing loop, a rollback could otherwise interleave with the replacement
+	c.processMu.Lock()
+	defer c.processMu.Unlock()
+
+	if header, ok := c.headers.get(b.Number); ok && header.Hash != b.Hash {
+		return nil, fmt.Errorf("block %d is now %s, it was processed as %s", b.Number, b.Hash, header.Hash)
+	}
+
+	stored, err := c.txnStore.GetAllByBlock(b.Number)
+	if err != nil {
+		return nil, fmt.Errorf("error getting transactions of block %d: %w", b.Number, err)
+	}
+
+	known := make(map[string]struct{}, len(stored))
+	for _, tx := range stored {
+		known[tx.TxID] = struct{}{}
+	}
+
+	state := domain.StatePending
+	if tip := c.tip.Load(); tip != nil {
+		state = c.stateFor(b.Number, *tip)
+	}
+
+	fresh := make(domain.Transactions, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if _, ok := known[tx.TxID]; !ok {
+			tx.State = state
+			fresh = append(fresh, tx)
+		}
+	}
+
+	if len(fresh) == 0 {
+		return fresh, nil
+	}
+
+	if err := c.writeSinks(ctx, domain.Block{BlockHeader: b.BlockHeader, Transactions: fresh}); err != nil {
+		return nil, err
+	}
+
+	if err := c.txnStore.Replace(b.Number, append(stored, fresh...)); err != nil {
+		return nil, fmt.Errorf("error storing transactions for block %d: %w", b.Number, err)
+	}
+
+	return fresh, nil
+}
@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// waitForBackfill waits until the backfill of the subscription is done
+func waitForBackfill(t *testing.T, c *Client, sub *Subscription) domain.BackfillProgress {
+	t.Helper()
+
+	var p domain.BackfillProgress
+	waitFor(t, "the backfill", func() bool {
+		var err error
+		if p, err = c.GetBackfillProgress(sub.ID); err != nil {
+			t.Fatalf("GetBackfillProgress: %v", err)
+		}
+		return p.Status == domain.BackfillDone
+	})
+
+	return p
+}
+
+// receivedBlocks drains the events delivered so far and returns the blocks of their transactions
+func receivedBlocks(sub *Subscription) []int32 {
+	var blocks []int32
+	timeout := time.After(20 * time.Millisecond)
+	for {
+		select {
+		case event := <-sub.Events():
+			blocks = append(blocks, event.Transaction.Block)
+		case <-timeout:
+			return blocks
+		}
+	}
+}
+
+// wantBlocks checks that every block from first to last was received once,
+// the live blocks can arrive while the backfill is still delivering older ones
+func wantBlocks(t *testing.T, got []int32, first, last int32) {
+	t.Helper()
+
+	slices.Sort(got)
+	want := make([]int32, 0, last-first+1)
+	for block := first; block <= last; block++ {
+		want = append(want, block)
+	}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("received blocks %v, want %d to %d once each", got, first, last)
+	}
+}
+
+func TestBackfillOfWatchedCounterparty(t *testing.T) {
+	chain := newFakeChain(10)
+	c := startClient(t, chain, testConfig())
+	receiver := subscribeAndSync(t, c, chain, addrB, nil)
+
+	sender, err := c.SubscribeWithOpts(addrA, &SubscribeOpts{FromGenesis: true})
+	if err != nil {
+		t.Fatalf("SubscribeWithOpts: %v", err)
+	}
+
+	// the backfill ends where tip-following picks the subscription up, on the next block
+	chain.extend(1, "a")
+	waitForBlock(t, c, chain.head())
+	waitForBackfill(t, c, sender)
+
+	for number := int32(1); number <= chain.head(); number++ {
+		txs, err := c.GetTransactionsByBlock(number)
+		if err != nil {
+			t.Fatalf("GetTransactionsByBlock: %v", err)
+		}
+
+		if len(txs) != 1 {
+			t.Errorf("block %d holds %d transactions, want 1", number, len(txs))
+		}
+	}
+
+	wantBlocks(t, receivedBlocks(sender), 1, chain.head())
+	wantBlocks(t, receivedBlocks(receiver), 1, chain.head())
+}
+
+func TestBackfillOfLaterSubscription(t *testing.T) {
+	chain := newFakeChain(10)
+	c := startClient(t, chain, testConfig())
+	first := subscribeAndSync(t, c, chain, addrA, nil)
+
+	second, err := c.SubscribeWithOpts(addrA, &SubscribeOpts{FromBlock: 3})
+	if err != nil {
+		t.Fatalf("SubscribeWithOpts: %v", err)
+	}
+
+	chain.extend(1, "a")
+	waitForBlock(t, c, chain.head())
+
+	p := waitForBackfill(t, c, second)
+	want := domain.BackfillProgress{SubscriptionID: second.ID, Address: addrA, Status: domain.BackfillDone, From: 3, To: 9, Current: 9, Found: 7}
+	if p != want {
+		t.Errorf("progress = %+v, want %+v", p, want)
+	}
+
+	wantBlocks(t, receivedBlocks(second), 3, chain.head())
+	wantBlocks(t, receivedBlocks(first), 1, chain.head())
+
+	if _, err := c.GetBackfillProgress(first.ID); err == nil {
+		t.Error("progress of a subscription without backfill")
+	}
+}
+
+func TestBackfillResumesAfterRestart(t *testing.T) {
+	chain := newFakeChain(10)
+	subs := memory.NewSubscriptionMemoryStore()
+
+	// a run ended while blocks 1 and 2 were backfilled, tip-following had taken over from block 6
+	info := domain.Subscription{
+		ID:         "interrupted",
+		Address:    addrA,
+		StartBlock: 1,
+		Backfill: &domain.BackfillProgress{
+			SubscriptionID: "interrupted",
+			Address:        addrA,
+			Status:         domain.BackfillRunning,
+			From:           1,
+			To:             5,
+			Current:        2,
+			Found:          2,
+		},
+	}
+	if err := subs.Save(info); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	cfg := testConfig()
+	cfg.StartBlock = "6"
+	cfg.Subscriptions = subs
+	c := startClient(t, chain, cfg)
+
+	sub, err := c.GetSubscription(info.ID)
+	if err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+
+	waitForBlock(t, c, chain.head())
+	if p := waitForBackfill(t, c, sub); p.Found != 5 || p.Current != 5 {
+		t.Errorf("progress = %+v, want blocks 3 to 5 scanned", p)
+	}
+
+	for number := int32(1); number <= 5; number++ {
+		txs, err := c.GetTransactionsByBlock(number)
+		if err != nil {
+			t.Fatalf("GetTransactionsByBlock: %v", err)
+		}
+
+		want := 0
+		if number > 2 {
+			want = 1
+		}
+
+		if len(txs) != want {
+			t.Errorf("block %d holds %d transactions, only blocks 3 to 5 were left to backfill", number, len(txs))
+		}
+	}
+
+	saved, err := subs.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	if len(saved) != 1 || saved[0].Backfill == nil || saved[0].Backfill.Status != domain.BackfillDone {
+		t.Errorf("persisted subscriptions = %+v, want the backfill saved as done", saved)
+	}
+}
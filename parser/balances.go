@@ -0,0 +1,404 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+const (
+	// maxBalanceDiscrepancies is the number of discrepancies kept per address, the oldest are forgotten first
+	maxBalanceDiscrepancies = 100
+)
+
+// gwei is the number of wei in a gwei, the unit of withdrawals
+var gwei = big.NewInt(1e9)
+
+// runningBalance is the balance of a tracked address: the balance the node reported at the anchor block
+// plus the changes observed in the blocks committed since
+type runningBalance struct {
+	anchored      bool
+	anchor        *big.Int
+	anchorBlock   int32
+	changes       map[int32]*big.Int // committed block to the change observed in it, past the anchor
+	reconciledAt  time.Time
+	discrepancies []domain.BalanceDiscrepancy
+}
+
+// at returns the running balance as of the given block
+func (r *runningBalance) at(block int32) *big.Int {
+	balance := new(big.Int).Set(r.anchor)
+	for number, change := range r.changes {
+		if number > r.anchorBlock && number <= block {
+			balance.Add(balance, change)
+		}
+	}
+
+	return balance
+}
+
+// reanchor sets the balance the node reported at a block, the changes up to it are then accounted for
+func (r *runningBalance) reanchor(balance *big.Int, block int32) {
+	r.anchored = true
+	r.anchor = balance
+	r.anchorBlock = block
+	for number := range r.changes {
+		if number <= block {
+			delete(r.changes, number)
+		}
+	}
+}
+
+// recordBalances records the changes a committed block brings to the tracked addresses: the values of successful
+// transactions, the fees of the ones they sent and the withdrawals credited to them. The block must hold every
+// matched transaction, including the ones the pipeline filters dropped from storage.
+func (c *Client) recordBalances(b domain.Block) {
+	c.balanceMu.Lock()
+	defer c.balanceMu.Unlock()
+
+	c.balanceHead = b.BlockHeader
+	if len(c.balances) == 0 {
+		return
+	}
+
+	changes := make(map[string]*big.Int)
+	change := func(address string) *big.Int {
+		if _, ok := c.balances[address]; !ok {
+			return nil
+		}
+
+		if _, ok := changes[address]; !ok {
+			changes[address] = new(big.Int)
+		}
+
+		return changes[address]
+	}
+
+	for _, tx := range b.Transactions {
+		value := quantity(tx.Value)
+		if tx.Status == domain.ExecutionFailed {
+			value = new(big.Int)
+		}
+
+		if from := change(tx.From); from != nil {
+			from.Sub(from, value)
+			from.Sub(from, txFee(tx))
+		}
+
+		if to := change(tx.To); to != nil {
+			to.Add(to, value)
+		}
+	}
+
+	for _, w := range b.Withdrawals {
+		if to := change(w.Address); to != nil {
+			to.Add(to, new(big.Int).Mul(quantity(w.AmountGwei), gwei))
+		}
+	}
+
+	for address, delta := range changes {
+		r := c.balances[address]
+		if r.changes == nil {
+			r.changes = make(map[int32]*big.Int)
+		}
+		r.changes[b.Number] = delta
+	}
+}
+
+// rollbackBalances forgets the changes of the blocks reverted by a reorg. Balances anchored past the common
+// ancestor are anchored again on the next reconciliation.
+func (c *Client) rollbackBalances(ancestor domain.BlockHeader) {
+	c.balanceMu.Lock()
+	defer c.balanceMu.Unlock()
+
+	c.balanceEpoch++
+	c.balanceHead = ancestor
+	for _, r := range c.balances {
+		for number := range r.changes {
+			if number > ancestor.Number {
+				delete(r.changes, number)
+			}
+		}
+
+		if r.anchored && r.anchorBlock > ancestor.Number {
+			r.anchored = false
+		}
+	}
+}
+
+// resetBalances clears the running balances at the start of a run, they are anchored again on the first reconciliation
+func (c *Client) resetBalances() {
+	c.balanceMu.Lock()
+	defer c.balanceMu.Unlock()
+
+	c.balances = make(map[string]*runningBalance)
+	c.balanceHead = domain.BlockHeader{}
+	c.balanceEpoch++
+	c.balanceTraces = c.config.Balances.InternalTransfers
+}
+
+// runBalanceReconciler reconciles the running balances with the node every reconcile interval
+func (c *Client) runBalanceReconciler(ctx context.Context) {
+	for {
+		if err := c.reconcileBalances(ctx); err != nil && ctx.Err() == nil {
+			c.metrics.IncCounter(metricBalanceErrors, 1)
+			c.logger.Warnf("Error reconciling balances: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(c.config.Balances.ReconcileInterval):
+		}
+	}
+}
+
+// reconcileBalances compares the running balances with the balances the node reports at the last committed block,
+// looked up by hash so that both refer to the same block. Each difference is reported as a discrepancy over
+// the blocks since the previous reconciliation, then the balance is anchored again on the node's.
+func (c *Client) reconcileBalances(ctx context.Context) error {
+	tracked := c.balanceTracked()
+
+	c.balanceMu.Lock()
+	for address := range c.balances {
+		if _, ok := tracked[address]; !ok {
+			delete(c.balances, address)
+		}
+	}
+	for address := range tracked {
+		if _, ok := c.balances[address]; !ok {
+			c.balances[address] = &runningBalance{}
+		}
+	}
+
+	head, epoch, traces := c.balanceHead, c.balanceEpoch, c.balanceTraces
+	expected := make(map[string]*big.Int)
+	anchors := make(map[string]int32)
+	from := head.Number
+	for address, r := range c.balances {
+		if r.anchored {
+			expected[address] = r.at(head.Number)
+			anchors[address] = r.anchorBlock
+			from = min(from, r.anchorBlock+1)
+		}
+	}
+	c.balanceMu.Unlock()
+
+	if head.Hash == "" || len(tracked) == 0 {
+		return nil
+	}
+
+	if traces && len(anchors) > 0 && from <= head.Number {
+		if err := c.applyInternalTransfers(ctx, from, head.Number, anchors, expected); err != nil {
+			return err
+		}
+	}
+
+	actual := make(map[string]*big.Int, len(tracked))
+	for address := range tracked {
+		balance, err := c.ethClient.GetBalance(ctx, address, head.Hash)
+		if err != nil {
+			return fmt.Errorf("error getting the balance of %s at block %d: %w", address, head.Number, err)
+		}
+		actual[address] = balance
+	}
+
+	now := c.clock.Now().UTC()
+
+	c.balanceMu.Lock()
+	if c.balanceEpoch != epoch {
+		c.balanceMu.Unlock()
+		return nil
+	}
+
+	var alerts []domain.Alert
+	for address, balance := range actual {
+		r, ok := c.balances[address]
+		if !ok {
+			continue
+		}
+
+		if want, ok := expected[address]; ok && want.Cmp(balance) != 0 {
+			d := domain.BalanceDiscrepancy{
+				Address:    address,
+				FromBlock:  anchors[address] + 1,
+				ToBlock:    head.Number,
+				Expected:   want,
+				Actual:     balance,
+				Difference: new(big.Int).Sub(balance, want),
+				DetectedAt: now,
+			}
+
+			r.discrepancies = append(r.discrepancies, d)
+			if len(r.discrepancies) > maxBalanceDiscrepancies {
+				r.discrepancies = r.discrepancies[len(r.discrepancies)-maxBalanceDiscrepancies:]
+			}
+
+			c.metrics.IncCounter(metricBalanceDiscrepancies, 1)
+			c.logger.Warnf("Balance of %s is off by %s wei between blocks %d and %d", address, d.Difference, d.FromBlock, d.ToBlock)
+			alerts = append(alerts, domain.Alert{RuleID: domain.AlertRuleBalanceDiscrepancy, RuleName: "Balance discrepancy", Balance: &d, CreatedAt: now})
+		}
+
+		r.reanchor(balance, head.Number)
+		r.reconciledAt = now
+	}
+	c.balanceMu.Unlock()
+
+	c.raiseAlerts(alerts)
+
+	return nil
+}
+
+// applyInternalTransfers adds the internal transfers within [from, to] past the anchor of each address to its expected balance.
+// Looking them up is given up for the run when the node can't trace calls.
+func (c *Client) applyInternalTransfers(ctx context.Context, from, to int32, anchors map[string]int32, expected map[string]*big.Int) error {
+	addresses := make([]string, 0, len(anchors))
+	for address := range anchors {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	transfers, err := c.ethClient.GetInternalTransfers(ctx, from, to, addresses)
+	if errors.Is(err, ethereum.ErrNotSupported) {
+		c.logger.Warnf("Not accounting for internal transfers in balances: %v", err)
+
+		c.balanceMu.Lock()
+		c.balanceTraces = false
+		c.balanceMu.Unlock()
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error getting internal transfers between blocks %d and %d: %w", from, to, err)
+	}
+
+	for _, t := range transfers {
+		value := quantity(t.Value)
+		if anchor, ok := anchors[t.From]; ok && t.Block > anchor {
+			expected[t.From].Sub(expected[t.From], value)
+		}
+
+		if anchor, ok := anchors[t.To]; ok && t.Block > anchor {
+			expected[t.To].Add(expected[t.To], value)
+		}
+	}
+
+	return nil
+}
+
+// balanceTracked returns the addresses with a subscription tracking their balance
+func (c *Client) balanceTracked() map[string]struct{} {
+	tracked := make(map[string]struct{})
+	c.subscribers.Range(func(key, value interface{}) bool {
+		for _, s := range value.(*subscriber).handles() {
+			if s.trackBalance {
+				tracked[key.(string)] = struct{}{}
+				break
+			}
+		}
+		return true
+	})
+
+	return tracked
+}
+
+// GetBalance returns the running balance of an address tracking its balance. Internal transfers are only
+// accounted for once reconciled.
+func (c *Client) GetBalance(address string) (domain.Balance, error) {
+	address, err := domain.NormalizeAddress(address)
+	if err != nil {
+		return domain.Balance{}, err
+	}
+
+	c.balanceMu.Lock()
+	defer c.balanceMu.Unlock()
+
+	r, ok := c.balances[address]
+	if !ok || !r.anchored {
+		return domain.Balance{}, fmt.Errorf("no balance for %s yet", address)
+	}
+
+	return domain.Balance{
+		Address:         address,
+		Balance:         r.at(c.balanceHead.Number),
+		Block:           c.balanceHead.Number,
+		ReconciledBlock: r.anchorBlock,
+		ReconciledAt:    r.reconciledAt,
+	}, nil
+}
+
+// GetBalances returns the running balances of the addresses tracking their balance, sorted by address.
+// Addresses not reconciled yet are left out.
+func (c *Client) GetBalances() []domain.Balance {
+	c.balanceMu.Lock()
+	defer c.balanceMu.Unlock()
+
+	balances := make([]domain.Balance, 0, len(c.balances))
+	for address, r := range c.balances {
+		if !r.anchored {
+			continue
+		}
+
+		balances = append(balances, domain.Balance{
+			Address:         address,
+			Balance:         r.at(c.balanceHead.Number),
+			Block:           c.balanceHead.Number,
+			ReconciledBlock: r.anchorBlock,
+			ReconciledAt:    r.reconciledAt,
+		})
+	}
+
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Address < balances[j].Address })
+
+	return balances
+}
+
+// ReconcileBalances reconciles the running balances right away instead of waiting for the next reconciliation
+func (c *Client) ReconcileBalances(ctx context.Context) error {
+	return c.reconcileBalances(ctx)
+}
+
+// GetBalanceDiscrepancies returns the last discrepancies found for an address tracking its balance, oldest first
+func (c *Client) GetBalanceDiscrepancies(address string) ([]domain.BalanceDiscrepancy, error) {
+	address, err := domain.NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	c.balanceMu.Lock()
+	defer c.balanceMu.Unlock()
+
+	r, ok := c.balances[address]
+	if !ok {
+		return nil, fmt.Errorf("address %s is not tracking its balance", address)
+	}
+
+	return append([]domain.BalanceDiscrepancy(nil), r.discrepancies...), nil
+}
+
+// txFee returns what the sender paid for gas, zero when the receipt wasn't fetched
+func txFee(tx domain.Transaction) *big.Int {
+	price := tx.EffectiveGasPrice
+	if price == "" {
+		price = tx.GasPrice
+	}
+
+	return new(big.Int).Mul(quantity(tx.GasUsed), quantity(price))
+}
+
+// quantity reads a hex or decimal amount, zero when it isn't one
+func quantity(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return new(big.Int)
+	}
+
+	return i
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// dropFilter drops the transactions with the given id
+type dropFilter string
+
+func (f dropFilter) Keep(ctx context.Context, tx domain.Transaction) (bool, error) {
+	return tx.TxID != string(f), nil
+}
+
+func TestBalanceCountsFilteredTransactions(t *testing.T) {
+	chain := newFakeChain(3)
+
+	cfg := testConfig()
+	cfg.Pipeline.AddFilter("drop", dropFilter("dropped"), StageFail)
+	c := startClient(t, chain, cfg)
+	subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{TrackBalance: true})
+	waitFor(t, "the balance to be anchored", func() bool {
+		_, err := c.GetBalance(addrA)
+		return err == nil
+	})
+
+	c.Pause()
+	chain.extend(2, "a")
+	chain.setTransactions(3, domain.Transaction{From: addrA, To: addrC, TxID: "dropped", Value: "0x5", GasUsed: "0x2", GasPrice: "0x3"})
+	c.Resume()
+	waitForBlock(t, c, chain.head())
+
+	if err := c.ReconcileBalances(context.Background()); err != nil {
+		t.Fatalf("ReconcileBalances: %v", err)
+	}
+
+	discrepancies, err := c.GetBalanceDiscrepancies(addrA)
+	if err != nil {
+		t.Fatalf("GetBalanceDiscrepancies: %v", err)
+	}
+
+	if len(discrepancies) != 0 {
+		t.Errorf("discrepancies = %+v, the dropped transaction's value and fee weren't counted", discrepancies)
+	}
+
+	if txs, _ := c.GetTransactionsByBlock(3); len(txs) != 0 {
+		t.Errorf("block 3 holds %+v, the filter should keep it out of storage", txs)
+	}
+}
@@ -2,146 +2,518 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mateeullahmalik/eh_parser/common/expr"
+	"github.com/mateeullahmalik/eh_parser/common/log"
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
 	"github.com/mateeullahmalik/eh_parser/parser/domain"
 	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
 	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
 )
 
-type client struct {
+// Client follows the chain and stores the transactions of the subscribed addresses
+type Client struct {
+	config      *Config
 	ethClient   ethereum.EthClient
 	txnStore    transaction.Repository
 	latestBlock int32
-	subscribers sync.Map
-	isRunning   int32 // atomic; 0 means not running, 1 means running
+
+	logger  log.Logger
+	metrics metrics.Sink
+	clock   Clock
+
+	// only accessed by the processing loop
+	start          startBlock
+	positioned     bool          // whether latestBlock was set from the checkpoint or the start block
+	confirmedBlock int32         // highest block whose stored transactions were upgraded to confirmed
+	finalizedBlock int32         // highest block whose stored transactions were upgraded to finalized
+	unenqueued     *domain.Block // committed block whose webhooks still have to be enqueued
+
+	headers       *headerWindow
+	subscribers   sync.Map                 // address to *subscriber
+	watched       atomic.Pointer[watchSet] // addresses matched by the processing loop
+	watchMu       sync.Mutex
+	watchUpdates  []watchUpdate            // changes to the watch set waiting for the next tick
+	subscriptions sync.Map                 // subscription ID to *Subscription
+	subscriberN   atomic.Int64             // entries in subscribers, counted so Status doesn't walk the map
+	subscriptionN atomic.Int64             // entries in subscriptions
+	isRunning     int32                    // atomic; 0 means not running, 1 means running
+	tip           atomic.Pointer[chainTip] // chain tip seen by the last tick
+	idleThrough   atomic.Int32             // block the last tick reached with no address to process, zero otherwise
+	processMu     sync.Mutex               // held while processing new blocks, and while reprocessing a block
+	paused        atomic.Bool              // set by Pause, the processing loop skips its checks until Resume
+	health        health
+
+	lifeMu    sync.Mutex
+	state     RunState
+	runCtx    context.Context    // cancelled when the run ends, background work is tied to it
+	cancelRun context.CancelFunc // cancels runCtx
+	stopping  chan struct{}      // closed by Stop, the processing loop ends after the in-flight block
+	done      chan struct{}      // closed once the run has ended
+	runErr    error              // error that ended the run
+	workers   sync.WaitGroup     // background work of the run: backfills and webhook dispatcher
+
+	stateMu       sync.RWMutex
+	stateHandlers []func(from, to RunState)
+
+	webhookWake chan struct{}
+
+	reorgMu       sync.RWMutex
+	reorgHandlers []func(domain.ReorgEvent)
+
+	alertMu       sync.RWMutex // held for reading while evaluating the rules
+	alertRules    []*alertRule // in creation order
+	watchlists    map[string]expr.StringSet
+	alertEnv      *expr.Env
+	alertHandlers []func(domain.Alert)
+
+	pendingMu      sync.Mutex
+	pending        map[string]*domain.PendingTransaction // lowercase hash to record, settled ones until their retention ends
+	pendingSlots   map[string][]string                   // sender and nonce to the lowercase hashes of the pending transactions taking them
+	pendingSenders map[string]map[string]struct{}        // lowercase sender to the slots it has pending transactions in
+	pendingCount   int                                   // number of hashes across the slots
+
+	nonceMu sync.RWMutex
+	nonces  map[string]*domain.NonceState // tracked sender to its last checked state
+
+	balanceMu     sync.Mutex
+	balances      map[string]*runningBalance // tracked address to its running balance
+	balanceHead   domain.BlockHeader         // last committed block, whose changes are recorded
+	balanceEpoch  uint64                     // bumped by rollbacks, reconciliations spanning one are discarded
+	balanceTraces bool                       // whether internal transfers are looked up, cleared if the node can't
+
+	rescanMu sync.Mutex
+	rescans  map[string]*rescan // rescan ID to rescan, running ones and the last finished ones
 }
 
-func NewClient(eth ethereum.EthClient, store transaction.Repository) *client {
-	return &client{
-		ethClient: eth,
-		txnStore:  store,
-	}
+var _ Parser = (*Client)(nil)
+
+func NewClient(eth ethereum.EthClient, store transaction.Repository) *Client {
+	return NewClientWithConfig(eth, store, NewConfig())
 }
 
-func (c *client) Subscribe(address string) (loaded bool, err error) {
-	if atomic.LoadInt32(&c.isRunning) == 0 {
-		return false, fmt.Errorf("cannot subscribe while parser is not running")
+// NewClientWithConfig returns a client configured by a copy of config, whose intervals, backoffs, sizes,
+// logger, metrics and clock left unset take their default values
+func NewClientWithConfig(eth ethereum.EthClient, store transaction.Repository, config *Config) *Client {
+	if config == nil {
+		config = NewConfig()
 	}
+	config = config.withDefaults()
+
+	c := &Client{
+		config:    config,
+		ethClient: eth,
+		logger:    config.Logger,
+		metrics:   config.Metrics,
+		clock:     config.Clock,
+		headers:   newHeaderWindow(defaultHeaderWindow),
 
-	_, loaded = c.subscribers.LoadOrStore(address, struct{}{})
-	return !loaded, nil // If the address was already present, loaded is true, and we return false; otherwise, return true
+		webhookWake: make(chan struct{}, 1),
+		watchlists:  make(map[string]expr.StringSet),
+
+		pending:        make(map[string]*domain.PendingTransaction),
+		pendingSlots:   make(map[string][]string),
+		pendingSenders: make(map[string]map[string]struct{}),
+		nonces:         make(map[string]*domain.NonceState),
+		balances:       make(map[string]*runningBalance),
+		rescans:        make(map[string]*rescan),
+	}
+	c.txnStore = instrumentedRepository{repo: store, client: c}
+	c.alertEnv = c.newAlertEnv()
+	c.watched.Store(newWatchSet(config.WatchBloomFilter))
+
+	return c
 }
 
-func (c *client) GetTransactions(address string) (txns domain.Transactions, err error) {
+func (c *Client) GetTransactions(address string) (txns domain.Transactions, err error) {
 	if atomic.LoadInt32(&c.isRunning) == 0 {
 		return txns, fmt.Errorf("cannot subscribe while parser is not running")
 	}
 
+	address, err = domain.NormalizeAddress(address)
+	if err != nil {
+		return txns, err
+	}
+
 	txns, err = c.txnStore.GetAllByAddress(address)
 	if err != nil {
 		// To Do: Use a better logging library with structured logging support
-		log.Printf("Error retrieving transactions for address %s: %v", address, err)
+		c.logger.Errorf("Error retrieving transactions for address %s: %v", address, err)
 		return
 	}
 
 	return
 }
 
-func (c *client) GetCurrentBlock() int {
+// GetTransactionByHash returns a stored transaction, any transaction of a processed block in firehose mode
+func (c *Client) GetTransactionByHash(hash string) (domain.Transaction, error) {
+	tx, found, err := c.txnStore.GetByHash(hash)
+	if err != nil {
+		return tx, fmt.Errorf("error retrieving transaction %s: %w", hash, err)
+	}
+
+	if !found {
+		return tx, fmt.Errorf("transaction %s not found", hash)
+	}
+
+	return tx, nil
+}
+
+// GetTransactionsByBlock returns the transactions stored from a block, all of them in firehose mode
+func (c *Client) GetTransactionsByBlock(block int32) (domain.Transactions, error) {
+	txns, err := c.txnStore.GetAllByBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving transactions of block %d: %w", block, err)
+	}
+
+	return txns, nil
+}
+
+func (c *Client) GetCurrentBlock() int {
 	return int(atomic.LoadInt32(&c.latestBlock))
 }
 
-func (c *client) Run(ctx context.Context) error {
-	// Ensure Run is only executed once
-	if !atomic.CompareAndSwapInt32(&c.isRunning, 0, 1) {
+// Run starts following the chain in the background. It can be called again once a previous run has ended.
+func (c *Client) Run(ctx context.Context) error {
+	c.lifeMu.Lock()
+	prev := c.state
+	if prev.active() {
+		c.lifeMu.Unlock()
 		return fmt.Errorf("parser is already running")
 	}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				atomic.StoreInt32(&c.isRunning, 0)
-				return
-			case <-ticker.C:
-				if err := c.processNewBlocks(ctx); err != nil {
-					// To Do: Use a better logging mechanism
-					log.Printf("Error processing new blocks: %v", err)
-				}
-			}
-		}
-	}()
+	runCtx, cancel := context.WithCancel(ctx)
+	c.state = RunStateStarting
+	c.runCtx, c.cancelRun = runCtx, cancel
+	c.stopping = make(chan struct{})
+	c.done = make(chan struct{})
+	c.runErr = nil
+	atomic.StoreInt32(&c.isRunning, 1)
+	c.lifeMu.Unlock()
+
+	c.emitStateChange(prev, RunStateStarting)
+
+	c.reset()
+
+	if err := c.init(); err != nil {
+		c.finish(err)
+		return err
+	}
+
+	if c.config.Webhook.Deliveries != nil && c.config.Webhook.Sender != nil {
+		c.goWorker(c.runWebhookDispatcher)
+	}
+
+	if c.config.Mempool.Enabled {
+		c.goWorker(c.runMempoolWatcher)
+	}
+	c.goWorker(c.runNonceChecker)
+	c.goWorker(c.runBalanceReconciler)
+	c.resumeBackfills()
+
+	c.transition(RunStateRunning, func(s RunState) bool { return s == RunStateStarting })
+	go c.loop(runCtx)
 
 	return nil
 }
 
-func (c *client) collectAddresses() []string {
-	var addresses []string
-	c.subscribers.Range(func(key, value interface{}) bool {
-		address, ok := key.(string)
-		if !ok {
-			log.Printf("Invalid type for subscriber address")
-			return true
+// init resolves where to start from and restores the subscriptions
+func (c *Client) init() error {
+	start, err := parseStartBlock(c.config.StartBlock)
+	if err != nil {
+		return err
+	}
+	c.start = start
+
+	if c.config.Firehose {
+		if _, ok := c.txnStore.(instrumentedRepository).repo.(transaction.IndexedRepository); !ok {
+			return fmt.Errorf("the firehose mode requires an indexed transaction repository")
 		}
-		addresses = append(addresses, address)
-		return true
-	})
+	}
 
-	return addresses
+	if c.config.BlockFailure.Policy != FailureHalt && c.config.BlockFailure.FailedBlocks == nil {
+		return fmt.Errorf("the block failure policy requires a failed blocks repository")
+	}
+
+	if len(c.config.Webhook.SecretKey) > 0 {
+		if _, err := c.config.Webhook.cipher(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.loadCheckpoint(); err != nil {
+		return err
+	}
+
+	if err := c.loadAlertRules(); err != nil {
+		return err
+	}
+
+	if err := c.loadPendingTransactions(); err != nil {
+		return err
+	}
+
+	if err := c.restoreSubscriptions(); err != nil {
+		return err
+	}
+
+	return c.loadUnenqueued()
 }
 
-func (c *client) processTransactionsForBlock(ctx context.Context, block int32, addresses []string) error {
-	txns, err := c.ethClient.GetTransactionsWithAddressesFilter(ctx, block, addresses...)
-	if err != nil {
-		return fmt.Errorf("error fetching transactions from block %d: %w", block, err)
+// loop checks for new blocks every poll interval until the run is stopped, its context cancelled
+// or the error handler gives up
+func (c *Client) loop(ctx context.Context) {
+	var err error
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			c.finish(nil)
+			return
+		case <-c.stopping:
+			c.finish(nil)
+			return
+		case <-c.clock.After(c.config.PollInterval):
+			if !c.Paused() {
+				err = c.tick(ctx)
+			}
+			c.recordGauges()
+		}
+	}
+
+	c.logger.Errorf("Parser stopped: %v", err)
+	c.finish(err)
+}
+
+// tick processes the new blocks and returns an error only when the error handler decides to stop.
+// Its duration is measured with the system clock.
+func (c *Client) tick(ctx context.Context) error {
+	start := time.Now()
+	c.processMu.Lock()
+	err := c.processNewBlocks(ctx)
+	c.processMu.Unlock()
+	c.metrics.Observe(metricTickDuration, time.Since(start).Seconds())
+
+	if ctx.Err() != nil {
+		return nil
 	}
 
-	if len(txns) == 0 {
+	c.health.recordTick(c.clock.Now(), err)
+	if err == nil {
 		return nil
 	}
+	c.metrics.IncCounter(metricTickErrors, 1, metrics.Label{Name: "kind", Value: errorKind(err)})
+
+	if c.config.ErrorHandler == nil {
+		c.logger.Errorf("Error processing new blocks: %v", err)
+		return nil
+	}
+
+	return c.config.ErrorHandler(err)
+}
+
+// collectAddresses applies the subscriptions started and ended since the last tick to the watch set,
+// marks the new addresses as processed from the next block on and returns the snapshot to process with
+func (c *Client) collectAddresses(next int32) *watchSet {
+	c.watchMu.Lock()
+	updates := c.watchUpdates
+	c.watchUpdates = nil
+	c.watchMu.Unlock()
+
+	watched := c.watched.Load()
+	if len(updates) == 0 {
+		return watched
+	}
+
+	changes := make([]watchChange, 0, len(updates))
+	for _, update := range updates {
+		if update.change.address != "" {
+			changes = append(changes, update.change)
+		}
+	}
+
+	if len(changes) > 0 {
+		watched = watched.apply(changes)
+		c.watched.Store(watched)
+	}
+
+	for _, update := range updates {
+		if sub := update.sub; sub != nil && sub.activeFrom == 0 {
+			sub.activeFrom = next
+			close(sub.activated)
+		}
+
+		if update.backfill != nil {
+			update.backfill.activateBackfill(next)
+		}
+	}
+
+	return watched
+}
+
+// queueWatch records a change to the watch set, applied at the start of the next tick so that
+// an address is matched from a block boundary on
+func (c *Client) queueWatch(update watchUpdate) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	c.watchUpdates = append(c.watchUpdates, update)
+}
+
+// commitBlock verifies that a fetched block extends the processed chain and commits its transactions.
+// matched are the transactions before the pipeline filters, the running balances are computed from them.
+func (c *Client) commitBlock(ctx context.Context, block int32, b domain.Block, matched domain.Transactions, tip chainTip) error {
+	if b.Number != block {
+		return fmt.Errorf("node returned the wrong block: %w", &BlockGapError{Expected: block, Actual: b.Number})
+	}
+
+	if err := c.enqueueUnenqueued(); err != nil {
+		return err
+	}
+
+	// Reject the block before storing anything if it doesn't build on the last processed one
+	if err := c.headers.verify(b.BlockHeader); err != nil {
+		return fmt.Errorf("block %d does not extend the processed chain: %w", block, err)
+	}
+
+	state := c.stateFor(block, tip)
+	for i := range b.Transactions {
+		b.Transactions[i].State = state
+	}
 
-	if err := c.txnStore.SaveAll(txns); err != nil {
+	if err := c.writeSinks(ctx, b); err != nil {
+		return err
+	}
+
+	if err := c.txnStore.Commit(c.checkpointAt(b.BlockHeader), b.Transactions); err != nil {
 		return fmt.Errorf("error storing transactions for block %d: %w", block, err)
 	}
 
+	c.headers.push(b.BlockHeader)
+	c.recordBalances(domain.Block{BlockHeader: b.BlockHeader, Transactions: matched, Withdrawals: b.Withdrawals})
+	c.metrics.IncCounter(metricTxsStored, float64(len(b.Transactions)))
+
+	// Update the latest processed block
+	atomic.StoreInt32(&c.latestBlock, block)
+	c.health.recordCommit(c.clock.Now())
+	c.metrics.IncCounter(metricBlocksProcessed, 1)
+	c.metrics.SetGauge(metricLatestBlock, float64(block))
+
+	if err := c.enqueueCommitted(b); err != nil {
+		c.logger.Errorf("Retrying before the next block: %v", err)
+	}
+
+	c.notify(ctx, domain.EventTransaction, b.Transactions)
+	c.evaluateAlerts(b.Transactions)
+	c.settleMined(ctx, b.Transactions)
+	c.observeNonces(b.Transactions)
+
 	return nil
 }
 
-func (c *client) processNewBlocks(ctx context.Context) error {
-	blockCount, err := c.ethClient.GetBlockCount(ctx)
+// processRange fetches the blocks in [from, to] concurrently and commits them in order.
+// When a block doesn't build on the previous one, the orphaned blocks are rolled back and the common
+// ancestor is returned so that the caller re-ingests the canonical branch from there.
+func (c *Client) processRange(ctx context.Context, from, to int32, watched *watchSet, tip chainTip) (*domain.BlockHeader, error) {
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := max(c.config.Concurrency, 1)
+	for result := range c.fetchBlocks(fetchCtx, from, to, c.blockMatcher(watched), concurrency, 2*concurrency) {
+		err := result.err
+		if err == nil && result.failure != nil {
+			err = c.recordFailedBlock(*result.failure)
+		}
+
+		if err == nil {
+			err = c.commitBlock(ctx, result.number, result.block, result.matched, tip)
+		}
+
+		if err == nil && (c.stopRequested() || c.Paused()) {
+			return nil, nil
+		}
+
+		// The node has switched to another branch: roll back to the common ancestor and re-ingest from there
+		var mismatch *ParentMismatchError
+		if errors.As(err, &mismatch) {
+			cancel()
+
+			ancestor, rerr := c.rollback(ctx)
+			if rerr != nil {
+				return nil, fmt.Errorf("error handling reorg at block %d: %w", result.number, rerr)
+			}
+
+			return &ancestor, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error processing transactions for block %d: %w", result.number, err)
+		}
+	}
+
+	return nil, ctx.Err()
+}
+
+func (c *Client) processNewBlocks(ctx context.Context) error {
+	tip, err := c.getChainTip(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting block count: %w", err)
+		return err
+	}
+	blockCount := tip.target(c.config.Follow)
+	c.tip.Store(&tip)
+
+	if err := c.enqueueUnenqueued(); err != nil {
+		return err
+	}
+
+	if !c.positioned {
+		if err := c.position(ctx, tip); err != nil {
+			return err
+		}
 	}
 
 	lastProcessedBlock := atomic.LoadInt32(&c.latestBlock)
+	defer func() {
+		if uerr := c.upgradeStates(tip, lastProcessedBlock); uerr != nil {
+			c.logger.Errorf("Error upgrading confirmation states: %v", uerr)
+		}
+	}()
+
 	if blockCount <= lastProcessedBlock {
-		log.Println("No new blocks to process.")
+		c.logger.Debugf("No new blocks to process.")
 		return nil
 	}
 
-	addresses := c.collectAddresses()
-	if len(addresses) == 0 {
-		log.Println("No subscribers to process.")
+	if limit := c.config.MaxBlocksPerTick; limit > 0 {
+		blockCount = min(blockCount, lastProcessedBlock+limit)
+	}
+
+	watched := c.collectAddresses(lastProcessedBlock + 1)
+	if watched.len() == 0 && !c.config.Firehose {
+		c.logger.Debugf("No subscribers to process.")
+		c.idleThrough.Store(blockCount)
 		return nil
 	}
+	c.idleThrough.Store(0)
 
 	// Process transactions in blocks from lastProcessedBlock+1 to blockCount
-	for block := lastProcessedBlock + 1; block <= blockCount; block++ {
+	for from := lastProcessedBlock + 1; from <= blockCount; {
+		ancestor, err := c.processRange(ctx, from, blockCount, watched, tip)
+		if err != nil {
+			return err
+		}
 
-		if err := c.processTransactionsForBlock(ctx, block, addresses); err != nil {
-			return fmt.Errorf("error processing transactions for block %d: %w", block, err)
+		if ancestor == nil {
+			break
 		}
 
-		// Update the latest processed block
-		atomic.StoreInt32(&c.latestBlock, block)
+		// the canonical blocks get their confirmation state when committed
+		lastProcessedBlock = min(lastProcessedBlock, ancestor.Number)
+		from = ancestor.Number + 1
 	}
 
 	return nil
@@ -0,0 +1,303 @@
+package parser
+
+import (
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/log"
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/alertrule"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/delivery"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/failedblock"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/pendingtx"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/subscription"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/watchlist"
+)
+
+const (
+	defaultPollInterval  = 5 * time.Second
+	defaultConcurrency   = 4
+	defaultConfirmations = 12
+	defaultFollow        = ethereum.TagLatest
+
+	defaultBlockRetries        = 3
+	defaultBlockInitialBackoff = 500 * time.Millisecond
+	defaultBlockMaxBackoff     = 10 * time.Second
+
+	defaultWebhookMaxAttempts    = 10
+	defaultWebhookInitialBackoff = time.Second
+	defaultWebhookMaxBackoff     = 10 * time.Minute
+
+	defaultMempoolPollInterval = 2 * time.Second
+	defaultMempoolDropTimeout  = time.Hour
+	defaultMempoolRetention    = 24 * time.Hour
+
+	defaultNonceCheckInterval = 30 * time.Second
+	defaultNonceStuckAfter    = 10 * time.Minute
+
+	defaultBalanceReconcileInterval = time.Minute
+
+	defaultSubscriptionBufferSize = 256
+)
+
+type Config struct {
+	// PollInterval is the delay between two checks for new blocks
+	PollInterval time.Duration
+
+	// MaxBlocksPerTick caps the number of blocks processed by a single check so that catching up
+	// on a long range commits progress in steps, zero means no limit
+	MaxBlocksPerTick int32
+
+	// Confirmations is the number of blocks, the transaction's own included,
+	// after which a stored transaction is considered confirmed. It is 12 when unset, 1 confirms on inclusion.
+	Confirmations int32
+
+	// Follow is the block tag the parser processes up to: latest follows the tip,
+	// safe and finalized trade latency for never ingesting blocks that get reorged out
+	Follow ethereum.BlockTag
+
+	// StartBlock is where processing starts when there is no checkpoint to resume from:
+	// "latest", a block number, or a timestamp (RFC 3339 or "@" followed by unix seconds)
+	StartBlock string
+
+	// Concurrency is the number of blocks fetched in parallel when catching up. Blocks are still committed
+	// one by one in order, and at most twice this many are fetched ahead of the last committed block.
+	Concurrency int
+
+	// WatchBloomFilter prefilters the lookups in the watched addresses with a bloom filter.
+	// It speeds up matching blocks against large watch sets, at the cost of about 10 bits per address.
+	WatchBloomFilter bool
+
+	// Firehose stores every transaction of every processed block instead of those of the subscribed addresses only,
+	// blocks are then processed even without subscribers. Subscriptions, webhooks and alerts work as usual.
+	// It requires a transaction.IndexedRepository, whose cost doesn't grow with the history of addresses, such as
+	// memory.IndexedTransactionMemoryStore backed by file.NewKeyValue so that the history isn't held in memory.
+	Firehose bool
+
+	// SubscriptionBufferSize is the number of undelivered events kept per channel subscription, 256 by default.
+	// The buffer is only allocated once a subscription has an event or its channel is asked for.
+	SubscriptionBufferSize int
+
+	// Subscriptions persists subscriptions so that they are restored when the parser starts again,
+	// they only live as long as the process when nil
+	Subscriptions subscription.Repository
+
+	// BlockFailure decides what happens to a block that can't be fetched
+	BlockFailure BlockFailureConfig
+
+	Webhook WebhookConfig
+
+	// Alerts persists the alert rules and the watchlists they refer to
+	Alerts AlertConfig
+
+	// Mempool follows the transactions of the subscribed addresses from the mempool until they are settled
+	Mempool MempoolConfig
+
+	// Nonces checks the transaction sequence of the addresses subscribed with TrackNonces
+	Nonces NonceConfig
+
+	// Balances keeps a running balance of the addresses subscribed with TrackBalance
+	Balances BalanceConfig
+
+	// Pipeline holds the custom stages the transactions of processed blocks go through before they are stored
+	Pipeline PipelineConfig
+
+	// Logger defaults to info messages and above on stderr
+	Logger log.Logger
+	// Metrics receives the parser measurements, they are discarded by default. metrics.Registry exposes them to Prometheus.
+	Metrics metrics.Sink
+	// Clock defaults to the system clock, tests can replace it to drive polling and timestamps
+	Clock Clock
+	// ErrorHandler is called with the error of every failed check for new blocks. Processing is retried
+	// on the next check when it returns nil, and stops when it returns an error.
+	// By default the error is logged and processing retried.
+	ErrorHandler func(err error) error
+}
+
+// withDefaults returns a copy of the config whose unset intervals, backoffs, sizes, logger, metrics and clock,
+// nested ones included, and Confirmations take their default values. Counts where zero means none,
+// such as BlockFailure.Retries, are kept as they are.
+func (config Config) withDefaults() *Config {
+	setDefault(&config.PollInterval, defaultPollInterval)
+	setDefault(&config.Confirmations, defaultConfirmations)
+	setDefault(&config.Concurrency, defaultConcurrency)
+	setDefault(&config.SubscriptionBufferSize, defaultSubscriptionBufferSize)
+	if config.Follow == "" {
+		config.Follow = defaultFollow
+	}
+
+	setDefault(&config.BlockFailure.InitialBackoff, defaultBlockInitialBackoff)
+	setDefault(&config.BlockFailure.MaxBackoff, defaultBlockMaxBackoff)
+
+	setDefault(&config.Webhook.MaxAttempts, defaultWebhookMaxAttempts)
+	setDefault(&config.Webhook.InitialBackoff, defaultWebhookInitialBackoff)
+	setDefault(&config.Webhook.MaxBackoff, defaultWebhookMaxBackoff)
+
+	setDefault(&config.Mempool.PollInterval, defaultMempoolPollInterval)
+	setDefault(&config.Mempool.DropTimeout, defaultMempoolDropTimeout)
+	setDefault(&config.Mempool.Retention, defaultMempoolRetention)
+
+	setDefault(&config.Nonces.CheckInterval, defaultNonceCheckInterval)
+	setDefault(&config.Nonces.StuckAfter, defaultNonceStuckAfter)
+
+	setDefault(&config.Balances.ReconcileInterval, defaultBalanceReconcileInterval)
+
+	if config.Logger == nil {
+		config.Logger = log.NewDefault()
+	}
+
+	if config.Metrics == nil {
+		config.Metrics = metrics.Discard()
+	}
+
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	return &config
+}
+
+// setDefault sets a value that isn't positive to its default
+func setDefault[T int | int32 | time.Duration](value *T, def T) {
+	if *value <= 0 {
+		*value = def
+	}
+}
+
+// BlockFailurePolicy applies to a block that still can't be fetched once its retries are exhausted
+type BlockFailurePolicy int
+
+const (
+	// FailureHalt stops ingestion at the block: the check for new blocks fails, which goes to the error handler,
+	// and the block is tried again on the next check
+	FailureHalt BlockFailurePolicy = iota
+	// FailureSkip commits the block without its transactions and records it in the failed blocks
+	FailureSkip
+	// FailureDegrade commits the transactions found in the block without fetching its receipts, and records it
+	// in the failed blocks. The header and the transactions are still verified: the block is skipped when that fails,
+	// and right away when the block failed verification in the first place.
+	FailureDegrade
+)
+
+type BlockFailureConfig struct {
+	Policy BlockFailurePolicy
+
+	// Retries is the number of times a block is fetched again before the policy applies
+	Retries        int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// FailedBlocks records the skipped and degraded blocks so they can be reprocessed,
+	// it is required by the skip and degrade policies
+	FailedBlocks failedblock.Repository
+}
+
+type WebhookConfig struct {
+	// Deliveries persists deliveries until they are acknowledged, webhook subscriptions are refused when nil.
+	// It has to be durable for pending deliveries to survive restarts.
+	Deliveries delivery.Repository
+	// Sender posts deliveries to their receivers
+	Sender delivery.Sender
+
+	// SecretKey is the AES key, of 16, 24 or 32 bytes, webhook secrets are encrypted with before they are persisted.
+	// Webhook subscriptions with a secret are refused when subscriptions are persisted without it.
+	SecretKey []byte
+
+	// MaxAttempts is the number of attempts after which a delivery is dead-lettered
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type AlertConfig struct {
+	// Rules persists the alert rules, they only live as long as the process when nil
+	Rules alertrule.Repository
+	// Watchlists persists the watchlists, they only live as long as the process when nil
+	Watchlists watchlist.Repository
+}
+
+type MempoolConfig struct {
+	// Enabled watches the node's mempool, which requires support for pending transaction filters
+	Enabled bool
+	// PollInterval is the delay between two polls of the mempool
+	PollInterval time.Duration
+	// DropTimeout is how long a transaction can stay pending, since it was seen or put back by a reorg,
+	// after that it is looked up and considered dropped unless it was mined
+	DropTimeout time.Duration
+	// Retention is how long the records of settled transactions are kept
+	Retention time.Duration
+
+	// Transactions persists the records of pending transactions, they only live as long as the process when nil
+	Transactions pendingtx.Repository
+}
+
+type NonceConfig struct {
+	// CheckInterval is the delay between two checks of the tracked senders
+	CheckInterval time.Duration
+	// StuckAfter is how long a sender can have transactions waiting without its next nonce moving,
+	// and how long a transaction seen in the mempool can stay pending, before they are flagged as stuck
+	StuckAfter time.Duration
+}
+
+type BalanceConfig struct {
+	// ReconcileInterval is the delay between two reconciliations of the running balances with the node
+	ReconcileInterval time.Duration
+	// InternalTransfers accounts for the value moved by contract calls and self-destructs, found with trace_filter.
+	// Without it, or when the node can't trace calls, such transfers are reported as discrepancies.
+	InternalTransfers bool
+}
+
+// Clock tells the time and schedules the polling
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func NewConfig() *Config {
+	return &Config{
+		PollInterval:  defaultPollInterval,
+		Confirmations: defaultConfirmations,
+		Follow:        defaultFollow,
+		StartBlock:    StartLatest,
+		Concurrency:   defaultConcurrency,
+
+		SubscriptionBufferSize: defaultSubscriptionBufferSize,
+		BlockFailure: BlockFailureConfig{
+			Policy:         FailureHalt,
+			Retries:        defaultBlockRetries,
+			InitialBackoff: defaultBlockInitialBackoff,
+			MaxBackoff:     defaultBlockMaxBackoff,
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts:    defaultWebhookMaxAttempts,
+			InitialBackoff: defaultWebhookInitialBackoff,
+			MaxBackoff:     defaultWebhookMaxBackoff,
+		},
+		Mempool: MempoolConfig{
+			PollInterval: defaultMempoolPollInterval,
+			DropTimeout:  defaultMempoolDropTimeout,
+			Retention:    defaultMempoolRetention,
+		},
+		Nonces: NonceConfig{
+			CheckInterval: defaultNonceCheckInterval,
+			StuckAfter:    defaultNonceStuckAfter,
+		},
+		Balances: BalanceConfig{
+			ReconcileInterval: defaultBalanceReconcileInterval,
+			InternalTransfers: true,
+		},
+		Logger:  log.NewDefault(),
+		Metrics: metrics.Discard(),
+		Clock:   systemClock{},
+	}
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+func TestNewClientWithConfigDefaults(t *testing.T) {
+	cfg := &Config{
+		Mempool: MempoolConfig{Enabled: true, DropTimeout: time.Minute},
+		Webhook: WebhookConfig{MaxBackoff: time.Second},
+	}
+
+	c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+
+	if cfg.PollInterval != 0 || cfg.Mempool.PollInterval != 0 || cfg.Confirmations != 0 || cfg.Logger != nil {
+		t.Errorf("defaults were written into the caller's config: %+v", cfg)
+	}
+
+	got := c.config
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"PollInterval", got.PollInterval, defaultPollInterval},
+		{"Confirmations", got.Confirmations, int32(defaultConfirmations)},
+		{"Concurrency", got.Concurrency, defaultConcurrency},
+		{"Follow", got.Follow, defaultFollow},
+		{"SubscriptionBufferSize", got.SubscriptionBufferSize, defaultSubscriptionBufferSize},
+		{"Mempool.PollInterval", got.Mempool.PollInterval, defaultMempoolPollInterval},
+		{"Mempool.Retention", got.Mempool.Retention, defaultMempoolRetention},
+		{"Nonces.CheckInterval", got.Nonces.CheckInterval, defaultNonceCheckInterval},
+		{"Nonces.StuckAfter", got.Nonces.StuckAfter, defaultNonceStuckAfter},
+		{"Balances.ReconcileInterval", got.Balances.ReconcileInterval, defaultBalanceReconcileInterval},
+		{"Webhook.MaxAttempts", got.Webhook.MaxAttempts, defaultWebhookMaxAttempts},
+		{"Webhook.InitialBackoff", got.Webhook.InitialBackoff, defaultWebhookInitialBackoff},
+		{"BlockFailure.InitialBackoff", got.BlockFailure.InitialBackoff, defaultBlockInitialBackoff},
+		{"BlockFailure.MaxBackoff", got.BlockFailure.MaxBackoff, defaultBlockMaxBackoff},
+		// set values are kept
+		{"Mempool.DropTimeout", got.Mempool.DropTimeout, time.Minute},
+		{"Mempool.Enabled", got.Mempool.Enabled, true},
+		{"Webhook.MaxBackoff", got.Webhook.MaxBackoff, time.Second},
+		// zero means no retry
+		{"BlockFailure.Retries", got.BlockFailure.Retries, 0},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if got.Logger == nil || c.logger == nil {
+		t.Error("Logger wasn't defaulted")
+	}
+
+	if got.Metrics == nil || c.metrics == nil {
+		t.Error("Metrics wasn't defaulted")
+	}
+
+	if got.Clock == nil || c.clock == nil {
+		t.Error("Clock wasn't defaulted")
+	}
+}
+
+func TestUnsetConfirmationsDontConfirmOnInclusion(t *testing.T) {
+	tip := chainTip{head: 100}
+
+	tests := []struct {
+		name          string
+		confirmations int32
+		block         int32
+		want          bool
+	}{
+		{"unset, head", 0, 100, false},
+		{"unset, within the default depth", 0, 100 - defaultConfirmations + 2, false},
+		{"unset, at the default depth", 0, 100 - defaultConfirmations + 1, true},
+		{"negative, head", -1, 100, false},
+		{"on inclusion", 1, 100, true},
+		{"three, within depth", 3, 99, false},
+		{"three, at depth", 3, 98, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Confirmations = tt.confirmations
+			c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+
+			if got := tt.block <= c.confirmedLine(tip); got != tt.want {
+				t.Errorf("block %d confirmed = %v at head %d, want %v", tt.block, got, tip.head, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+// chainTip is the node's view of the chain at the start of a tick
+type chainTip struct {
+	head      int32
+	safe      int32
+	finalized int32
+}
+
+// getChainTip fetches the latest, safe and finalized block numbers.
+// Nodes that predate the merge, and some dev chains, don't know the safe and finalized tags;
+// in that case transactions are only ever confirmed by depth. The latency of the node is measured with the system clock.
+func (c *Client) getChainTip(ctx context.Context) (tip chainTip, err error) {
+	start := time.Now()
+	tip.head, err = c.ethClient.GetBlockCount(ctx)
+	c.health.recordRPC(c.clock.Now(), time.Since(start), err)
+	if err != nil {
+		return tip, withKind(errorKindRPC, fmt.Errorf("error getting block count: %w", err))
+	}
+
+	if tip.safe, err = c.ethClient.GetBlockNumberByTag(ctx, ethereum.TagSafe); err != nil {
+		c.logger.Warnf("Unable to get safe block, falling back to confirmation depth: %v", err)
+	}
+
+	if tip.finalized, err = c.ethClient.GetBlockNumberByTag(ctx, ethereum.TagFinalized); err != nil {
+		c.logger.Warnf("Unable to get finalized block, transactions won't be finalized: %v", err)
+	}
+
+	if c.config.Follow != ethereum.TagLatest && tip.target(c.config.Follow) == 0 {
+		return tip, fmt.Errorf("node doesn't report a %s block", c.config.Follow)
+	}
+
+	return tip, nil
+}
+
+// target returns the last block to process when following the given tag
+func (t chainTip) target(follow ethereum.BlockTag) int32 {
+	switch follow {
+	case ethereum.TagSafe:
+		return t.safe
+	case ethereum.TagFinalized:
+		return t.finalized
+	default:
+		return t.head
+	}
+}
+
+// confirmedLine returns the highest block whose transactions are confirmed
+func (c *Client) confirmedLine(tip chainTip) int32 {
+	line := tip.head - c.config.Confirmations + 1
+	if tip.safe > line {
+		line = tip.safe
+	}
+
+	return line
+}
+
+func (c *Client) stateFor(block int32, tip chainTip) domain.ConfirmationState {
+	switch {
+	case block <= tip.finalized:
+		return domain.StateFinalized
+	case block <= c.confirmedLine(tip):
+		return domain.StateConfirmed
+	default:
+		return domain.StatePending
+	}
+}
+
+// upgradeStates upgrades the transactions of blocks committed in previous ticks that have since
+// crossed the confirmed or finalized line. Blocks committed in this tick already carry the right state.
+func (c *Client) upgradeStates(tip chainTip, committedBefore int32) error {
+	latest := atomic.LoadInt32(&c.latestBlock)
+
+	if err := c.upgradeState(&c.confirmedBlock, min(c.confirmedLine(tip), latest), committedBefore, domain.StateConfirmed); err != nil {
+		return err
+	}
+
+	return c.upgradeState(&c.finalizedBlock, min(tip.finalized, latest), committedBefore, domain.StateFinalized)
+}
+
+func (c *Client) upgradeState(watermark *int32, line int32, committedBefore int32, state domain.ConfirmationState) error {
+	for block := *watermark + 1; block <= min(line, committedBefore); block++ {
+		if err := c.txnStore.UpdateState(block, state); err != nil {
+			return fmt.Errorf("error marking block %d as %s: %w", block, state, err)
+		}
+		*watermark = block
+	}
+
+	if line > *watermark {
+		*watermark = line
+	}
+
+	return nil
+}
+
+// GetTransactionsWithStateFilter returns the transactions of an address that reached at least the given state,
+// for example only finalized ones when crediting deposits
+func (c *Client) GetTransactionsWithStateFilter(address string, minState domain.ConfirmationState) (domain.Transactions, error) {
+	txns, err := c.GetTransactions(address)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(domain.Transactions, 0, len(txns))
+	for _, tx := range txns {
+		if tx.State >= minState {
+			filtered = append(filtered, tx)
+		}
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// countingStore counts the blocks whose states are upgraded
+type countingStore struct {
+	transaction.Repository
+	updates atomic.Int32
+}
+
+func (s *countingStore) UpdateState(block int32, state domain.ConfirmationState) error {
+	s.updates.Add(1)
+	return s.Repository.UpdateState(block, state)
+}
+
+func stateOf(t *testing.T, c *Client, block int32) domain.ConfirmationState {
+	t.Helper()
+
+	txs, err := c.GetTransactionsByBlock(block)
+	if err != nil {
+		t.Fatalf("GetTransactionsByBlock: %v", err)
+	}
+
+	if len(txs) != 1 {
+		t.Fatalf("block %d holds %d transactions, want 1", block, len(txs))
+	}
+
+	return txs[0].State
+}
+
+func TestConfirmationWatermarksSurviveRestart(t *testing.T) {
+	chain := newFakeChain(41)
+	store := &countingStore{Repository: memory.NewTransactionMemoryStore()}
+
+	cfg := testConfig()
+	cfg.Confirmations = 3
+
+	c := NewClientWithConfig(chain, store, cfg)
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	subscribeAndSync(t, c, chain, addrA, nil)
+
+	// safe is 5 blocks behind the head, finalized 20
+	if state := stateOf(t, c, 40); state != domain.StatePending {
+		t.Fatalf("block 40 is %s before the restart, want pending", state)
+	}
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	store.updates.Store(0)
+	chain.extend(5, "a")
+
+	c = startClientWithStore(t, chain, store, cfg)
+	subscribeAndSync(t, c, chain, addrA, nil)
+	waitFor(t, "block 40 to be confirmed", func() bool { return stateOf(t, c, 40) == domain.StateConfirmed })
+	waitFor(t, "block 25 to be finalized", func() bool { return stateOf(t, c, 25) == domain.StateFinalized })
+
+	// the blocks that crossed a line since the restart, not every stored block
+	if n := store.updates.Load(); n > 10 {
+		t.Errorf("%d blocks upgraded after the restart, want at most 10", n)
+	}
+}
+
+func TestConfirmationWatermarksStartAtStartBlock(t *testing.T) {
+	chain := newFakeChain(500)
+	store := &countingStore{Repository: memory.NewTransactionMemoryStore()}
+
+	cfg := testConfig()
+	cfg.StartBlock = "490"
+
+	c := startClientWithStore(t, chain, store, cfg)
+	subscribeAndSync(t, c, chain, addrA, nil)
+	chain.extend(2, "a")
+	waitForBlock(t, c, chain.head())
+
+	if n := store.updates.Load(); n > 20 {
+		t.Errorf("%d blocks upgraded, want only blocks from the start block", n)
+	}
+}
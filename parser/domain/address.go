@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mateeullahmalik/eh_parser/ethereum/crypto"
+)
+
+const (
+	// AddressLength is the length of an address in bytes
+	AddressLength = 20
+)
+
+var (
+	// ErrInvalidAddress is returned when a string isn't a valid hex encoded address
+	ErrInvalidAddress = errors.New("invalid address")
+)
+
+// AddressMatcher tells whether an address is watched, so that only its transactions are kept from a block.
+// Addresses can be passed in any case.
+type AddressMatcher interface {
+	Contains(address string) bool
+}
+
+// Address is a 20 byte account address
+type Address [AddressLength]byte
+
+// ParseAddress parses a 0x prefixed hex address. All lowercase and all uppercase addresses are accepted as is,
+// mixed case ones must carry a valid EIP-55 checksum.
+func ParseAddress(s string) (Address, error) {
+	var a Address
+
+	digits, ok := strings.CutPrefix(s, "0x")
+	if !ok {
+		digits, ok = strings.CutPrefix(s, "0X")
+	}
+
+	if !ok {
+		return a, fmt.Errorf("%w %q: missing 0x prefix", ErrInvalidAddress, s)
+	}
+
+	if len(digits) != 2*AddressLength {
+		return a, fmt.Errorf("%w %q: %d hex digits, expected %d", ErrInvalidAddress, s, len(digits), 2*AddressLength)
+	}
+
+	if _, err := hex.Decode(a[:], []byte(digits)); err != nil {
+		return a, fmt.Errorf("%w %q: %v", ErrInvalidAddress, s, err)
+	}
+
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) {
+		if checksummed := a.Hex(); checksummed[2:] != digits {
+			return a, fmt.Errorf("%w %q: bad checksum, expected %s", ErrInvalidAddress, s, checksummed)
+		}
+	}
+
+	return a, nil
+}
+
+// NormalizeAddress returns the canonical form of an address, the lowercase one nodes report
+func NormalizeAddress(s string) (string, error) {
+	a, err := ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+
+	return a.Lower(), nil
+}
+
+// Hex returns the EIP-55 checksummed form of the address
+func (a Address) Hex() string {
+	lower := hex.EncodeToString(a[:])
+	hash := crypto.Keccak256([]byte(lower))
+
+	out := []byte(lower)
+	for i, c := range out {
+		// a letter is uppercased when the matching nibble of the hash of the lowercase address is 8 or more
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+
+		if c >= 'a' && nibble >= 8 {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(out)
+}
+
+// Lower returns the lowercase form of the address, the one used as key for the subscriptions and the stored transactions
+func (a Address) Lower() string {
+	return "0x" + hex.EncodeToString(a[:])
+}
+
+func (a Address) String() string {
+	return a.Hex()
+}
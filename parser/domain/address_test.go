@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAddressHexChecksum(t *testing.T) {
+	// the test vectors of EIP-55
+	vectors := []string{
+		// all caps
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		// all lower
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+		// normal
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	}
+
+	for _, vector := range vectors {
+		a, err := ParseAddress(vector)
+		if err != nil {
+			t.Errorf("ParseAddress(%s): %v", vector, err)
+			continue
+		}
+
+		if got := a.Hex(); got != vector {
+			t.Errorf("Hex() = %s, want %s", got, vector)
+		}
+
+		if got := a.Lower(); got != strings.ToLower(vector) {
+			t.Errorf("Lower() = %s, want %s", got, strings.ToLower(vector))
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"checksummed", checksummed, false},
+		{"all lower", strings.ToLower(checksummed), false},
+		{"all upper", "0x" + strings.ToUpper(checksummed[2:]), false},
+		{"uppercase prefix", "0X" + checksummed[2:], false},
+		{"bad checksum", "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", true},
+		{"too short", checksummed[:len(checksummed)-2], true},
+		{"too long", checksummed + "00", true},
+		{"odd length", checksummed[:len(checksummed)-1], true},
+		{"missing prefix", checksummed[2:], true},
+		{"prefix only", "0x", true},
+		{"empty", "", true},
+		{"non-hex", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", true},
+		{"whitespace", " " + checksummed, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseAddress(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("ParseAddress(%q) error = %v, want ErrInvalidAddress", tt.input, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseAddress(%q): %v", tt.input, err)
+			}
+
+			if a.Hex() != checksummed {
+				t.Errorf("ParseAddress(%q) = %s, want %s", tt.input, a.Hex(), checksummed)
+			}
+		})
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	got, err := NormalizeAddress("0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359")
+	if err != nil {
+		t.Fatalf("NormalizeAddress: %v", err)
+	}
+
+	if want := "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"; got != want {
+		t.Errorf("NormalizeAddress = %s, want %s", got, want)
+	}
+
+	if _, err := NormalizeAddress("0xfb6916095ca1df60bb79ce92ce3ea74c37c5d3"); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("NormalizeAddress of a short address: error = %v, want ErrInvalidAddress", err)
+	}
+}
@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+// AlertRule is a boolean expression evaluated against every committed transaction, an alert is raised when it holds.
+// Expressions see the transaction as tx, with the fields from, to, hash, block, value, gas, gasPrice, method,
+// success and status, and can look addresses up in watchlists with watchlist("name").
+type AlertRule struct {
+	ID         string
+	Name       string
+	Expression string
+	// Disabled rules are kept but not evaluated
+	Disabled  bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// rule IDs of the built-in alerts, which aren't expressions
+const (
+	// AlertRuleNonceGap is raised when transactions of a sender tracking its nonces can't be mined for missing nonces
+	AlertRuleNonceGap = "nonce-gap"
+	// AlertRuleStuckTransactions is raised when a sender tracking its nonces has transactions waiting for too long
+	AlertRuleStuckTransactions = "stuck-transactions"
+	// AlertRuleBalanceDiscrepancy is raised when the running balance of an address differs from the node's
+	AlertRuleBalanceDiscrepancy = "balance-discrepancy"
+)
+
+// Alert is raised when a rule holds for a committed transaction, or by a built-in rule.
+// Nonce alerts carry the state of the sender, and the first transaction waiting when it is known,
+// balance alerts the discrepancy.
+type Alert struct {
+	RuleID      string
+	RuleName    string
+	Transaction Transaction
+	Nonce       *NonceState         `json:",omitempty"`
+	Balance     *BalanceDiscrepancy `json:",omitempty"`
+	CreatedAt   time.Time
+}
+
+// Watchlist is a named set of addresses alert rules can refer to
+type Watchlist struct {
+	Name      string
+	Addresses []string
+}
@@ -0,0 +1,16 @@
+package alertrule
+
+import (
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+type Repository interface {
+	// Save creates or replaces a rule
+	Save(r domain.AlertRule) error
+
+	// Delete removes a rule, deleting an unknown one is not an error
+	Delete(id string) error
+
+	// GetAll returns every rule, they are loaded when the parser starts
+	GetAll() ([]domain.AlertRule, error)
+}
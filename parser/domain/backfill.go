@@ -0,0 +1,24 @@
+package domain
+
+type BackfillStatus string
+
+const (
+	// BackfillWaiting is a backfill waiting for tip-following to pick up the subscription, which fixes where it ends
+	BackfillWaiting BackfillStatus = "waiting"
+	BackfillRunning BackfillStatus = "running"
+	BackfillDone    BackfillStatus = "done"
+	BackfillFailed  BackfillStatus = "failed"
+)
+
+// BackfillProgress reports how far the history requested by a subscription has been scanned
+type BackfillProgress struct {
+	SubscriptionID string
+	Address        string
+	Status         BackfillStatus
+	From           int32
+	To             int32
+	// Current is the last block scanned, From-1 when nothing was scanned yet
+	Current int32
+	Found   int
+	Error   string
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"math/big"
+	"time"
+)
+
+// InternalTransferKind tells what moved value inside a transaction
+type InternalTransferKind string
+
+const (
+	TransferCall         InternalTransferKind = "call"
+	TransferCreate       InternalTransferKind = "create"
+	TransferSelfDestruct InternalTransferKind = "selfdestruct"
+)
+
+// InternalTransfer is value moved by a call made by a contract rather than by the transaction itself
+type InternalTransfer struct {
+	Block int32
+	TxID  string
+	Kind  InternalTransferKind
+	From  string
+	To    string
+	// Value is the hex encoded amount in wei
+	Value string
+}
+
+// Balance is the running native balance of an address, in wei: the balance the node reported at the last
+// reconciliation plus the changes observed in the blocks committed since
+type Balance struct {
+	Address string
+	Balance *big.Int
+	// Block is the last committed block accounted for
+	Block int32
+	// ReconciledBlock is the block the balance was last checked against the node at, zero until then
+	ReconciledBlock int32
+	ReconciledAt    time.Time
+}
+
+// BalanceDiscrepancy is a difference between the running balance of an address and the balance the node reports
+type BalanceDiscrepancy struct {
+	Address string
+	// FromBlock and ToBlock bound the blocks where the unaccounted change happened, the ones since the previous reconciliation
+	FromBlock int32
+	ToBlock   int32
+	Expected  *big.Int
+	Actual    *big.Int
+	// Difference is Actual minus Expected, positive when the address received more than what was observed
+	Difference *big.Int
+	DetectedAt time.Time
+}
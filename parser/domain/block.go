@@ -0,0 +1,22 @@
+package domain
+
+type BlockHeader struct {
+	Number     int32
+	Hash       string
+	ParentHash string
+	Timestamp  int64
+}
+
+// Block is a block header along with the transactions and withdrawals of interest it contains
+type Block struct {
+	BlockHeader
+	Transactions Transactions
+	Withdrawals  []Withdrawal
+}
+
+// Withdrawal is a validator withdrawal credited to an address by a block
+type Withdrawal struct {
+	Address string
+	// AmountGwei is the hex encoded amount, in gwei unlike transaction values
+	AmountGwei string
+}
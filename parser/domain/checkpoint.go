@@ -0,0 +1,15 @@
+package domain
+
+// Checkpoint is the last block whose transactions were committed to the repository
+type Checkpoint struct {
+	Number int32
+	Hash   string
+
+	// Confirmed and Finalized are the blocks up to which the stored transactions were upgraded to these states
+	Confirmed int32
+	Finalized int32
+
+	// Headers are the most recent processed headers, oldest first and ending with the checkpoint's,
+	// so that a reorg of the checkpoint's block can still be rolled back after a restart
+	Headers []BlockHeader
+}
@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+// Delivery is a webhook payload waiting to be acknowledged by its receiver
+type Delivery struct {
+	// ID doubles as the idempotency key, it is the same for every attempt and across restarts
+	ID             string
+	SubscriptionID string
+	URL            string
+	Payload        []byte
+	// Signature is the hex encoded HMAC-SHA256 of the payload with the subscription's secret
+	Signature   string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	CreatedAt   time.Time
+}
+
+// WebhookPayload is the JSON body posted to webhook receivers
+type WebhookPayload struct {
+	ID             string              `json:"id"`
+	SubscriptionID string              `json:"subscription_id"`
+	Address        string              `json:"address"`
+	Type           EventType           `json:"type"`
+	Block          BlockHeader         `json:"block"`
+	Transactions   Transactions        `json:"transactions,omitempty"`
+	Reorg          *ReorgEvent         `json:"reorg,omitempty"`
+	Pending        *PendingTransaction `json:"pending,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+}
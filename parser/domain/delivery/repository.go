@@ -0,0 +1,33 @@
+package delivery
+
+import (
+	"context"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+type Repository interface {
+	// Enqueue persists a pending delivery, replacing any pending delivery with the same ID
+	Enqueue(d domain.Delivery) error
+
+	// GetDue returns up to limit pending deliveries whose next attempt is due, oldest first
+	GetDue(now time.Time, limit int) ([]domain.Delivery, error)
+
+	// Update records a failed attempt of a pending delivery
+	Update(d domain.Delivery) error
+
+	// Ack removes a delivery acknowledged by its receiver
+	Ack(id string) error
+
+	// DeadLetter moves a delivery that exhausted its attempts out of the pending ones
+	DeadLetter(d domain.Delivery) error
+
+	// GetDeadLetters returns the dead-lettered deliveries
+	GetDeadLetters() ([]domain.Delivery, error)
+}
+
+// Sender posts a delivery to its receiver, a nil error means the receiver acknowledged it
+type Sender interface {
+	Send(ctx context.Context, d domain.Delivery) error
+}
@@ -2,11 +2,66 @@ package ethereum
 
 import (
 	"context"
+	"errors"
+	"math/big"
 
 	"github.com/mateeullahmalik/eh_parser/parser/domain"
 )
 
+// BlockTag names a block relative to the node's view of the chain
+type BlockTag string
+
+const (
+	TagLatest    BlockTag = "latest"
+	TagSafe      BlockTag = "safe"
+	TagFinalized BlockTag = "finalized"
+	// TagPending is the block the node would build next from its mempool
+	TagPending BlockTag = "pending"
+)
+
+var (
+	// ErrNotSupported is returned by optional capabilities the node doesn't offer
+	ErrNotSupported = errors.New("not supported by the node")
+
+	// ErrVerification is wrapped by the errors of blocks and headers that don't hash to what the node reported,
+	// such data is never stored
+	ErrVerification = errors.New("block verification failed")
+)
+
 type EthClient interface {
 	GetBlockCount(ctx context.Context) (int32, error)
-	GetTransactionsWithAddressesFilter(ctx context.Context, block int32, addresses ...string) (domain.Transactions, error)
+	GetBlockNumberByTag(ctx context.Context, tag BlockTag) (int32, error)
+	GetBlockHeader(ctx context.Context, block int32) (domain.BlockHeader, error)
+	// GetTransactionsWithAddressesFilter returns the block with the transactions sent or received by a watched address
+	GetTransactionsWithAddressesFilter(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error)
+
+	// GetTransactionsWithoutReceipts is GetTransactionsWithAddressesFilter without fetching the receipts: the header
+	// and the transactions are still checked against the block hash and the transactions root, the receipts root isn't.
+	// It is a degraded mode for blocks whose receipts can't be fetched or don't verify.
+	GetTransactionsWithoutReceipts(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error)
+
+	// GetBlocksWithAddressActivity returns, in ascending order, the blocks within [from, to] where the address
+	// sent or received a transaction or an internal call. It returns ErrNotSupported when the node can't
+	// answer without a full scan, callers then fall back to filtering every block.
+	GetBlocksWithAddressActivity(ctx context.Context, from, to int32, address string) ([]int32, error)
+
+	// GetPendingTransactions returns the transactions of watched addresses that entered the node's mempool
+	// since the previous call, the first call only starts watching. It returns ErrNotSupported when the node
+	// doesn't expose its mempool. On other errors, the transactions found so far are returned along with it.
+	GetPendingTransactions(ctx context.Context, watched domain.AddressMatcher) (domain.Transactions, error)
+
+	// GetTransactionByHash looks a transaction up, Block is zero while it is pending.
+	// found is false when the node doesn't know it, for instance once it was dropped from the mempool.
+	GetTransactionByHash(ctx context.Context, hash string) (tx domain.Transaction, found bool, err error)
+
+	// GetTransactionCount returns the next nonce of the address as of the tagged block,
+	// with TagPending it accounts for the transactions the node can execute next from its mempool
+	GetTransactionCount(ctx context.Context, address string, tag BlockTag) (uint64, error)
+
+	// GetBalance returns the balance in wei of the address at the block with the given hash
+	GetBalance(ctx context.Context, address string, blockHash string) (*big.Int, error)
+
+	// GetInternalTransfers returns the value moved to or from the addresses by contracts within [from, to],
+	// transactions themselves excluded. It returns ErrNotSupported when the node can't trace calls.
+	GetInternalTransfers(ctx context.Context, from, to int32, addresses []string) ([]domain.InternalTransfer, error)
 }
@@ -0,0 +1,29 @@
+package domain
+
+type EventType string
+
+const (
+	// EventTransaction delivers a transaction of the subscribed address as it is committed
+	EventTransaction EventType = "transaction"
+	// EventRevert delivers a previously delivered transaction that was removed by a reorg
+	EventRevert EventType = "revert"
+	// EventReorg delivers the summary of a chain reorganization
+	EventReorg EventType = "reorg"
+	// EventPending delivers a transaction of the subscribed address seen in the mempool, or back in it after a reorg
+	EventPending EventType = "pending"
+	// EventMined delivers a pending transaction once it is included in a committed block
+	EventMined EventType = "mined"
+	// EventReplaced delivers a pending transaction superseded by another one with the same sender and nonce
+	EventReplaced EventType = "replaced"
+	// EventDropped delivers a pending transaction that left the mempool, or stayed in it too long, without being mined
+	EventDropped EventType = "dropped"
+)
+
+// Event is a notification pushed to subscribers, Transaction is set for transaction and revert events,
+// Reorg for reorg events and Pending for pending, mined, replaced and dropped events
+type Event struct {
+	Type        EventType
+	Transaction Transaction
+	Reorg       *ReorgEvent
+	Pending     *PendingTransaction
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// FailedBlock is a block that couldn't be fetched once its retries were exhausted and was committed anyway,
+// without its transactions or from an unverified copy. It can be reprocessed once the cause is fixed.
+type FailedBlock struct {
+	Number int32
+	Hash   string
+	// Degraded is set when the transactions were stored from the block without checking it against its
+	// receipts and header, it is unset when the block was committed without transactions
+	Degraded bool
+	Attempts int
+	Error    string
+	FailedAt time.Time
+}
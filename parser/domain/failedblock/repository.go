@@ -0,0 +1,14 @@
+package failedblock
+
+import "github.com/mateeullahmalik/eh_parser/parser/domain"
+
+type Repository interface {
+	// Save records a failed block, replacing any record of the same block
+	Save(b domain.FailedBlock) error
+
+	// Delete removes the record of a block, it does nothing when there is none
+	Delete(number int32) error
+
+	// GetAll returns the failed blocks in ascending order
+	GetAll() ([]domain.FailedBlock, error)
+}
@@ -0,0 +1,228 @@
+package domain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Direction tells which side of a transaction the subscribed address is on
+type Direction string
+
+const (
+	// DirectionAny matches the transactions sent and received by the address
+	DirectionAny Direction = ""
+	// DirectionIn matches the transactions received by the address
+	DirectionIn Direction = "in"
+	// DirectionOut matches the transactions sent by the address
+	DirectionOut Direction = "out"
+)
+
+const (
+	// MethodSelectorLength is the length of a function selector in bytes
+	MethodSelectorLength = 4
+)
+
+var (
+	// ErrInvalidFilter is returned when a filter has a malformed or contradictory rule
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// TransactionMatcher is an AddressMatcher that also tells whether a transaction of a watched address is wanted,
+// so that the transactions no subscription is interested in are dropped from a block
+type TransactionMatcher interface {
+	AddressMatcher
+	MatchesTransaction(tx Transaction) bool
+}
+
+// TransactionFilter holds the rules a transaction of the subscribed address must pass, all of them.
+// The zero value matches every transaction.
+//
+// The direction, counterparty and value rules apply to the transfer the address takes part in: the transaction
+// itself, or its token transfers when the address only takes part in those or TokenContracts is set.
+// A transaction passes them when one of its token transfers does.
+type TransactionFilter struct {
+	Direction Direction `json:",omitempty"`
+	// MinValue and MaxValue bound the transferred value, inclusive, nil for no bound.
+	// The value is in wei, or in the smallest unit of the token for a token transfer.
+	MinValue *big.Int `json:",omitempty"`
+	MaxValue *big.Int `json:",omitempty"`
+	// Counterparties, when set, is the only addresses the other side of the transaction can be
+	Counterparties []string `json:",omitempty"`
+	// ExcludedCounterparties are addresses whose transactions with the subscribed one are ignored
+	ExcludedCounterparties []string `json:",omitempty"`
+	// Methods, when set, is the only function selectors the transaction can call, such as 0xa9059cbb for an ERC-20 transfer
+	Methods []string `json:",omitempty"`
+	// SuccessOnly ignores reverted transactions and those whose outcome is unknown
+	SuccessOnly bool `json:",omitempty"`
+	// TokenContracts, when set, only matches the transactions with an ERC-20 transfer from or to the address
+	// emitted by one of these contracts, such as the deposits of a token with DirectionIn
+	TokenContracts []string `json:",omitempty"`
+}
+
+// Normalize validates the filter and returns it with lowercase addresses and selectors
+func (f TransactionFilter) Normalize() (TransactionFilter, error) {
+	switch f.Direction {
+	case DirectionAny, DirectionIn, DirectionOut:
+	default:
+		return f, fmt.Errorf("%w: unknown direction %q", ErrInvalidFilter, f.Direction)
+	}
+
+	if f.MinValue != nil && f.MinValue.Sign() < 0 || f.MaxValue != nil && f.MaxValue.Sign() < 0 {
+		return f, fmt.Errorf("%w: negative value bound", ErrInvalidFilter)
+	}
+
+	if f.MinValue != nil && f.MaxValue != nil && f.MinValue.Cmp(f.MaxValue) > 0 {
+		return f, fmt.Errorf("%w: minimum value %s above maximum value %s", ErrInvalidFilter, f.MinValue, f.MaxValue)
+	}
+
+	var err error
+	if f.Counterparties, err = normalizeAddresses(f.Counterparties); err != nil {
+		return f, fmt.Errorf("%w: counterparty: %v", ErrInvalidFilter, err)
+	}
+
+	if f.ExcludedCounterparties, err = normalizeAddresses(f.ExcludedCounterparties); err != nil {
+		return f, fmt.Errorf("%w: excluded counterparty: %v", ErrInvalidFilter, err)
+	}
+
+	if f.TokenContracts, err = normalizeAddresses(f.TokenContracts); err != nil {
+		return f, fmt.Errorf("%w: token contract: %v", ErrInvalidFilter, err)
+	}
+
+	if len(f.Methods) > 0 {
+		methods := make([]string, len(f.Methods))
+		for i, method := range f.Methods {
+			if methods[i], err = NormalizeMethodSelector(method); err != nil {
+				return f, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+			}
+		}
+		f.Methods = methods
+	}
+
+	return f, nil
+}
+
+// IsZero reports whether the filter has no rule
+func (f TransactionFilter) IsZero() bool {
+	return f.Direction == DirectionAny && f.MinValue == nil && f.MaxValue == nil &&
+		len(f.Counterparties) == 0 && len(f.ExcludedCounterparties) == 0 &&
+		len(f.Methods) == 0 && !f.SuccessOnly && len(f.TokenContracts) == 0
+}
+
+// Matches reports whether a transaction of the address passes the filter. The filter must be normalized.
+func (f TransactionFilter) Matches(address string, tx Transaction) bool {
+	if f.IsZero() {
+		return true
+	}
+
+	if len(f.Methods) > 0 && !contains(f.Methods, tx.Method) {
+		return false
+	}
+
+	if f.SuccessOnly && tx.Status != ExecutionSucceeded {
+		return false
+	}
+
+	if len(f.TokenContracts) == 0 && (tx.From == address || tx.To == address) {
+		return f.matchesTransfer(address, tx.From, tx.To, tx.Value)
+	}
+
+	for _, t := range tx.TokenTransfers {
+		if len(f.TokenContracts) > 0 && !contains(f.TokenContracts, t.Contract) {
+			continue
+		}
+
+		if (t.From == address || t.To == address) && f.matchesTransfer(address, t.From, t.To, t.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesTransfer applies the direction, counterparty and value rules to a transfer of the address
+func (f TransactionFilter) matchesTransfer(address, from, to, value string) bool {
+	outgoing := from == address
+	incoming := to == address
+
+	switch f.Direction {
+	case DirectionIn:
+		if !incoming {
+			return false
+		}
+	case DirectionOut:
+		if !outgoing {
+			return false
+		}
+	}
+
+	// the counterparty of a transfer to itself is the address
+	counterparty := to
+	if incoming && !outgoing {
+		counterparty = from
+	}
+
+	if len(f.Counterparties) > 0 && !contains(f.Counterparties, counterparty) {
+		return false
+	}
+
+	if contains(f.ExcludedCounterparties, counterparty) {
+		return false
+	}
+
+	if f.MinValue != nil || f.MaxValue != nil {
+		amount, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return false
+		}
+
+		if f.MinValue != nil && amount.Cmp(f.MinValue) < 0 || f.MaxValue != nil && amount.Cmp(f.MaxValue) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NormalizeMethodSelector returns the lowercase 0x prefixed form of a 4 byte function selector
+func NormalizeMethodSelector(s string) (string, error) {
+	digits, ok := strings.CutPrefix(strings.ToLower(s), "0x")
+	if !ok || len(digits) != 2*MethodSelectorLength {
+		return "", fmt.Errorf("method selector %q: expected 0x followed by %d hex digits", s, 2*MethodSelectorLength)
+	}
+
+	if _, err := hex.DecodeString(digits); err != nil {
+		return "", fmt.Errorf("method selector %q: %v", s, err)
+	}
+
+	return "0x" + digits, nil
+}
+
+func normalizeAddresses(addresses []string) ([]string, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]string, len(addresses))
+	for i, address := range addresses {
+		a, err := NormalizeAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		normalized[i] = a
+	}
+
+	return normalized, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
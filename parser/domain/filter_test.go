@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestTokenContractsMatchTransfers(t *testing.T) {
+	const (
+		address  = "0x00000000000000000000000000000000000000aa"
+		sender   = "0x00000000000000000000000000000000000000bb"
+		token    = "0x00000000000000000000000000000000000000cc"
+		other    = "0x00000000000000000000000000000000000000dd"
+		exchange = "0x00000000000000000000000000000000000000ee"
+	)
+
+	deposit := Transaction{From: sender, To: token, TokenTransfers: []TokenTransfer{{Contract: token, From: sender, To: address, Value: "0x64"}}}
+	withdrawal := Transaction{From: address, To: token, TokenTransfers: []TokenTransfer{{Contract: token, From: address, To: sender, Value: "0x64"}}}
+	otherToken := Transaction{From: sender, To: other, TokenTransfers: []TokenTransfer{{Contract: other, From: sender, To: address, Value: "0x64"}}}
+	// a router pulling the token from the address and paying out another one
+	swap := Transaction{From: address, To: exchange, TokenTransfers: []TokenTransfer{
+		{Contract: other, From: exchange, To: address, Value: "0x1"},
+		{Contract: token, From: address, To: exchange, Value: "0x64"},
+	}}
+
+	tests := []struct {
+		name   string
+		filter TransactionFilter
+		tx     Transaction
+		want   bool
+	}{
+		{"incoming deposit", TransactionFilter{Direction: DirectionIn, TokenContracts: []string{token}}, deposit, true},
+		{"deposit of another token", TransactionFilter{Direction: DirectionIn, TokenContracts: []string{token}}, otherToken, false},
+		{"outgoing transfer for incoming only", TransactionFilter{Direction: DirectionIn, TokenContracts: []string{token}}, withdrawal, false},
+		{"outgoing transfer", TransactionFilter{Direction: DirectionOut, TokenContracts: []string{token}}, withdrawal, true},
+		{"call of the token without transfer", TransactionFilter{TokenContracts: []string{token}}, Transaction{From: address, To: token}, false},
+		{"token sent through a router", TransactionFilter{Direction: DirectionOut, TokenContracts: []string{token}}, swap, true},
+		{"token received from a router", TransactionFilter{Direction: DirectionIn, TokenContracts: []string{token}}, swap, false},
+		{"token amount in bounds", TransactionFilter{TokenContracts: []string{token}, MinValue: big.NewInt(100)}, deposit, true},
+		{"token amount below bound", TransactionFilter{TokenContracts: []string{token}, MinValue: big.NewInt(101)}, deposit, false},
+		{"token sender as counterparty", TransactionFilter{TokenContracts: []string{token}, Counterparties: []string{sender}}, deposit, true},
+		{"excluded token sender", TransactionFilter{TokenContracts: []string{token}, ExcludedCounterparties: []string{sender}}, deposit, false},
+		{"reverted deposit", TransactionFilter{TokenContracts: []string{token}, SuccessOnly: true}, deposit, false},
+		{"deposit without token filter", TransactionFilter{Direction: DirectionIn}, deposit, true},
+		{"deposit below value bound without token filter", TransactionFilter{MinValue: big.NewInt(101)}, deposit, false},
+	}
+
+	for _, tt := range tests {
+		f, err := tt.filter.Normalize()
+		if err != nil {
+			t.Fatalf("%s: Normalize: %v", tt.name, err)
+		}
+
+		if got := f.Matches(address, tt.tx); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionAddresses(t *testing.T) {
+	tx := Transaction{From: "0xa", To: "0xb", TokenTransfers: []TokenTransfer{
+		{Contract: "0xb", From: "0xa", To: "0xc"},
+		{Contract: "0xb", From: "0xc", To: "0xd"},
+	}}
+
+	want := []string{"0xa", "0xb", "0xc", "0xd"}
+	got := tx.Addresses()
+	if len(got) != len(want) {
+		t.Fatalf("Addresses = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Addresses = %v, want %v", got, want)
+		}
+	}
+
+	if got := (Transaction{From: "0xa"}).Addresses(); len(got) != 1 || got[0] != "0xa" {
+		t.Errorf("Addresses of a contract creation = %v, want only the sender", got)
+	}
+}
@@ -0,0 +1,30 @@
+package domain
+
+import "time"
+
+// NonceState is where a sender stands in its transaction sequence as of its last check
+type NonceState struct {
+	Address string
+	// Latest is the transaction count of the sender at the latest block, the next nonce to be mined
+	Latest uint64
+	// Pending is the transaction count the node reports counting the transactions it can execute next from its mempool
+	Pending uint64
+	// Observed is one past the highest nonce of the sender seen in committed blocks, zero when none was seen
+	Observed uint64
+	// Gaps are the nonces missing before transactions of the sender seen in the mempool can be mined
+	Gaps []uint64 `json:",omitempty"`
+	// WaitingSince is when the sender started having transactions waiting without its next nonce moving,
+	// zero when none are waiting
+	WaitingSince time.Time
+	// Stuck is set once transactions have been waiting for longer than the configured threshold
+	Stuck bool
+	// StuckTransactions are the hashes of the transactions of the sender pending for longer than the threshold,
+	// in nonce order. They are only known when the mempool is watched.
+	StuckTransactions []string `json:",omitempty"`
+	CheckedAt         time.Time
+}
+
+// Next is the nonce expected to be mined next, from the node and the committed blocks
+func (s NonceState) Next() uint64 {
+	return max(s.Latest, s.Observed)
+}
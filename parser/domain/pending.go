@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PendingStatus tells what became of a transaction seen in the mempool
+type PendingStatus int
+
+const (
+	// PendingStatusPending is a transaction waiting in the mempool
+	PendingStatusPending PendingStatus = iota
+	// PendingStatusMined is a transaction included in a committed block
+	PendingStatusMined
+	// PendingStatusReplaced is a transaction superseded by another one with the same sender and nonce and a higher fee
+	PendingStatusReplaced
+	// PendingStatusDropped is a transaction that left the mempool, or stayed in it too long, without being mined
+	PendingStatusDropped
+)
+
+func (s PendingStatus) String() string {
+	switch s {
+	case PendingStatusPending:
+		return "pending"
+	case PendingStatusMined:
+		return "mined"
+	case PendingStatusReplaced:
+		return "replaced"
+	case PendingStatusDropped:
+		return "dropped"
+	default:
+		return "unknown"
+	}
+}
+
+// PendingTransaction is a transaction of a watched address followed from the mempool until it is settled.
+// Block is zero until it is mined.
+type PendingTransaction struct {
+	Transaction
+	Status PendingStatus
+	// ReplacedBy is the hash of the transaction with the same sender and nonce that superseded it
+	ReplacedBy string `json:",omitempty"`
+	FirstSeen  time.Time
+	UpdatedAt  time.Time
+}
+
+// Settled reports whether the transaction reached a final status
+func (p PendingTransaction) Settled() bool {
+	return p.Status != PendingStatusPending
+}
+
+// SenderNonce identifies the slot a transaction takes in its sender's sequence, replacements share it
+func (t Transaction) SenderNonce() string {
+	return strings.ToLower(t.From) + ":" + strconv.FormatUint(t.Nonce, 10)
+}
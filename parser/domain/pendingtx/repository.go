@@ -0,0 +1,16 @@
+package pendingtx
+
+import (
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+type Repository interface {
+	// Save creates or replaces the record of a transaction, keyed by its hash
+	Save(tx domain.PendingTransaction) error
+
+	// Delete removes a record, deleting an unknown one is not an error
+	Delete(hash string) error
+
+	// GetAll returns every record, they are loaded when the parser starts
+	GetAll() ([]domain.PendingTransaction, error)
+}
@@ -0,0 +1,11 @@
+package domain
+
+// ReorgEvent describes a chain reorganization that the parser has rolled back
+type ReorgEvent struct {
+	// CommonAncestor is the last block shared by the orphaned and the canonical branch
+	CommonAncestor BlockHeader
+	// Orphaned lists the headers of the reverted blocks, oldest first
+	Orphaned []BlockHeader
+	// Reverted lists the transactions removed from the repository
+	Reverted Transactions
+}
@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+type RescanStatus string
+
+const (
+	RescanRunning   RescanStatus = "running"
+	RescanDone      RescanStatus = "done"
+	RescanFailed    RescanStatus = "failed"
+	RescanCancelled RescanStatus = "cancelled"
+)
+
+// RescanProgress reports how far the reprocessing of a range of already processed blocks went
+type RescanProgress struct {
+	ID string
+	// Addresses are the addresses rescanned, empty for every address subscribed at the time of each block
+	Addresses []string
+	Status    RescanStatus
+	From      int32
+	To        int32
+	// Current is the last block rescanned, From-1 when nothing was rescanned yet
+	Current int32
+	// Stored is the number of transactions stored again, Found the number of those that weren't stored before
+	Stored    int
+	Found     int
+	Error     string
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// Finished reports whether the rescan ended, whatever the outcome
+func (p RescanProgress) Finished() bool {
+	return p.Status != RescanRunning
+}
@@ -0,0 +1,43 @@
+package domain
+
+import "time"
+
+// Subscription is the persisted record of a subscription
+type Subscription struct {
+	ID      string
+	Address string
+	// Owner identifies the tenant the subscription belongs to
+	Owner  string
+	Labels map[string]string
+	// StartBlock is the first block the subscription covers, the backfill start when history was requested
+	StartBlock int32
+	CreatedAt  time.Time
+	// Filter selects the transactions of the address the subscription receives
+	Filter TransactionFilter
+	// TrackNonces checks the transaction sequence of the address as a sender, see NonceState
+	TrackNonces bool `json:",omitempty"`
+	// TrackBalance keeps a running balance of the address, see Balance
+	TrackBalance bool `json:",omitempty"`
+
+	WebhookURL string
+	// WebhookSecret is never persisted nor listed, SealedWebhookSecret is what the repository keeps
+	WebhookSecret string `json:"-"`
+	// SealedWebhookSecret is the webhook secret encrypted with the parser's webhook secret key
+	SealedWebhookSecret []byte `json:",omitempty"`
+
+	// Backfill is the progress of the requested history, saved as it advances so it resumes after a restart
+	Backfill *BackfillProgress `json:",omitempty"`
+}
+
+// SubscriptionQuery selects subscriptions, empty fields match everything
+type SubscriptionQuery struct {
+	Owner   string
+	Address string
+	// Labels must all be present with the same values
+	Labels map[string]string
+
+	// Limit is the maximum number of subscriptions per page, 100 by default
+	Limit int
+	// Cursor is the value returned along with the previous page, empty for the first page
+	Cursor string
+}
@@ -0,0 +1,16 @@
+package subscription
+
+import (
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+type Repository interface {
+	// Save creates or replaces a subscription
+	Save(s domain.Subscription) error
+
+	// Delete removes a subscription, deleting an unknown one is not an error
+	Delete(id string) error
+
+	// GetAll returns every subscription, they are restored when the parser starts
+	GetAll() ([]domain.Subscription, error)
+}
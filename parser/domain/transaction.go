@@ -1,5 +1,54 @@
 package domain
 
+// ConfirmationState tells how likely a stored transaction is to be reverted by a reorg.
+// States are ordered, so a transaction with a greater state is at least as safe.
+type ConfirmationState int
+
+const (
+	// StatePending is a transaction included in a block with fewer than the required confirmations
+	StatePending ConfirmationState = iota
+	// StateConfirmed is a transaction with at least the required confirmations or at or below the safe block
+	StateConfirmed
+	// StateFinalized is a transaction at or below the finalized block, it can no longer be reverted
+	StateFinalized
+)
+
+func (s ConfirmationState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateConfirmed:
+		return "confirmed"
+	case StateFinalized:
+		return "finalized"
+	default:
+		return "unknown"
+	}
+}
+
+// ExecutionStatus tells whether a transaction succeeded, as reported by its receipt
+type ExecutionStatus int
+
+const (
+	// ExecutionUnknown is a transaction stored without its receipt, such as in a degraded block
+	ExecutionUnknown ExecutionStatus = iota
+	// ExecutionSucceeded is a transaction whose receipt reports success
+	ExecutionSucceeded
+	// ExecutionFailed is a reverted transaction, it still paid its fee
+	ExecutionFailed
+)
+
+func (s ExecutionStatus) String() string {
+	switch s {
+	case ExecutionSucceeded:
+		return "succeeded"
+	case ExecutionFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
 type Transactions []Transaction
 
 type Transaction struct {
@@ -11,4 +60,46 @@ type Transaction struct {
 	Gas      string
 	GasPrice string
 	Value    string
+	// GasUsed and EffectiveGasPrice come from the receipt, empty when it wasn't fetched
+	GasUsed           string `json:",omitempty"`
+	EffectiveGasPrice string `json:",omitempty"`
+	// Nonce is the sender's transaction count when the transaction was signed
+	Nonce uint64
+	// Method is the 4 byte selector of the called contract function, empty for plain transfers
+	Method string
+	Status ExecutionStatus
+	State  ConfirmationState
+	// Labels are added by the enrichers of the pipeline, such as token metadata, prices or address tags
+	Labels map[string]string `json:",omitempty"`
+	// TokenTransfers are the ERC-20 transfers the transaction made, decoded from its receipt, empty when it wasn't fetched
+	TokenTransfers []TokenTransfer `json:",omitempty"`
+}
+
+// TokenTransfer is an ERC-20 Transfer event emitted by a token contract
+type TokenTransfer struct {
+	Contract string
+	From     string
+	To       string
+	// Value is the amount in the smallest unit of the token, as a hex quantity
+	Value string
+}
+
+// Addresses returns the sender, the receiver and the parties of the token transfers of the transaction, each once.
+// A contract creation has no receiver.
+func (tx Transaction) Addresses() []string {
+	addresses := make([]string, 0, 2+2*len(tx.TokenTransfers))
+	add := func(address string) {
+		if address != "" && !contains(addresses, address) {
+			addresses = append(addresses, address)
+		}
+	}
+
+	add(tx.From)
+	add(tx.To)
+	for _, t := range tx.TokenTransfers {
+		add(t.From)
+		add(t.To)
+	}
+
+	return addresses
 }
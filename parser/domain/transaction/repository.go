@@ -7,13 +7,53 @@ import (
 type Repository interface {
 	ReadRepository
 	WriteRepository
+	CheckpointRepository
 }
 
 type ReadRepository interface {
 	GetAllByAddress(address string) (domain.Transactions, error)
+
+	// GetAllByBlock returns the transactions stored from the given block
+	GetAllByBlock(block int32) (domain.Transactions, error)
+
+	// GetByHash returns the transaction with the given hash, found is false when none is stored
+	GetByHash(hash string) (tx domain.Transaction, found bool, err error)
 }
 
 type WriteRepository interface {
 	Save(tx domain.Transaction) error
 	SaveAll(tx domain.Transactions) error
+
+	// Commit stores the transactions of a block and advances the checkpoint to that block atomically,
+	// so that after a restart the checkpoint never points past or before what is stored.
+	// Transactions previously stored from the same block are replaced.
+	Commit(checkpoint domain.Checkpoint, txs domain.Transactions) error
+
+	// Replace atomically swaps the transactions stored from a block for the given ones, leaving the checkpoint alone
+	Replace(block int32, txs domain.Transactions) error
+
+	// UpdateState upgrades the confirmation state of every transaction stored from the given block.
+	// Transactions already in a greater state are left untouched.
+	UpdateState(block int32, state domain.ConfirmationState) error
+
+	// DeleteByBlock removes every transaction stored from the given block and returns them
+	DeleteByBlock(block int32) (domain.Transactions, error)
+}
+
+// IndexedRepository is a repository indexing every transaction by hash, block and address, so that the cost of
+// committing a block only depends on the block and not on the history of its addresses, as the firehose mode requires
+type IndexedRepository interface {
+	Repository
+
+	// Indexed marks the repository as indexed
+	Indexed()
+}
+
+// CheckpointRepository keeps track of the last processed block so that the parser can resume after a restart
+type CheckpointRepository interface {
+	// GetCheckpoint returns the last checkpoint, found is false if no block was ever committed
+	GetCheckpoint() (checkpoint domain.Checkpoint, found bool, err error)
+
+	// SaveCheckpoint moves the checkpoint, for instance back to the common ancestor of a reorg
+	SaveCheckpoint(checkpoint domain.Checkpoint) error
 }
@@ -0,0 +1,16 @@
+package watchlist
+
+import (
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+type Repository interface {
+	// Save creates or replaces a watchlist
+	Save(w domain.Watchlist) error
+
+	// Delete removes a watchlist, deleting an unknown one is not an error
+	Delete(name string) error
+
+	// GetAll returns every watchlist, they are loaded when the parser starts
+	GetAll() ([]domain.Watchlist, error)
+}
@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSlowConsumer is the reason a subscription with the disconnect policy is closed when its buffer is full
+	ErrSlowConsumer = errors.New("subscription buffer full, consumer too slow")
+
+	// ErrChainDiscontinuity is wrapped by every error reporting that a block does not extend the processed chain
+	ErrChainDiscontinuity = errors.New("chain discontinuity")
+)
+
+// BlockGapError is returned when a block is not the direct successor of the last processed block
+type BlockGapError struct {
+	Expected int32
+	Actual   int32
+}
+
+func (e *BlockGapError) Error() string {
+	return fmt.Sprintf("expected block %d, got block %d", e.Expected, e.Actual)
+}
+
+func (e *BlockGapError) Unwrap() error {
+	return ErrChainDiscontinuity
+}
+
+// ParentMismatchError is returned when a block's parent hash is not the hash of the last processed block
+type ParentMismatchError struct {
+	Number         int32
+	ExpectedParent string
+	ActualParent   string
+}
+
+func (e *ParentMismatchError) Error() string {
+	return fmt.Sprintf("block %d has parent %s, expected %s", e.Number, e.ActualParent, e.ExpectedParent)
+}
+
+func (e *ParentMismatchError) Unwrap() error {
+	return ErrChainDiscontinuity
+}
+
+// ReorgTooDeepError is returned when no common ancestor is found within the window of recent headers
+type ReorgTooDeepError struct {
+	Depth int
+}
+
+func (e *ReorgTooDeepError) Error() string {
+	return fmt.Sprintf("no common ancestor found within the last %d blocks", e.Depth)
+}
+
+// kinds of the errors failing a check for new blocks, as counted in the metrics
+const (
+	errorKindRPC       = "rpc"
+	errorKindSource    = "source"
+	errorKindStage     = "stage"
+	errorKindStorage   = "storage"
+	errorKindChain     = "chain"
+	errorKindCancelled = "cancelled"
+	errorKindOther     = "other"
+)
+
+// kindError tags an error with its kind without changing its message
+type kindError struct {
+	kind string
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func withKind(kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &kindError{kind: kind, err: err}
+}
+
+// errorKind returns the kind of the innermost tagged error, chain errors and cancellations being recognized as such
+func errorKind(err error) string {
+	var tagged *kindError
+	var tooDeep *ReorgTooDeepError
+	switch {
+	case errors.Is(err, ErrChainDiscontinuity), errors.As(err, &tooDeep):
+		return errorKindChain
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return errorKindCancelled
+	case errors.As(err, &tagged):
+		return tagged.kind
+	default:
+		return errorKindOther
+	}
+}
@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+// fetchBlock fetches the transactions of a block, retrying with backoff, and applies the failure policy once
+// the retries are exhausted. The returned failure is set when the block had to be skipped or degraded.
+func (c *Client) fetchBlock(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, *domain.FailedBlock, error) {
+	cfg := c.config.BlockFailure
+
+	attempts := 0
+	for {
+		b, err := c.fetchSource(ctx, block, watched)
+		attempts++
+		if err == nil {
+			return b, nil, nil
+		}
+
+		err = fmt.Errorf("error fetching transactions from block %d: %w", block, err)
+		if ctx.Err() != nil {
+			return domain.Block{}, nil, err
+		}
+
+		if attempts > cfg.Retries {
+			return c.applyFailurePolicy(ctx, block, watched, attempts, err)
+		}
+
+		c.metrics.IncCounter(metricBlockRetries, 1)
+		select {
+		case <-ctx.Done():
+			return domain.Block{}, nil, err
+		case <-c.clock.After(exponentialBackoff(cfg.InitialBackoff, cfg.MaxBackoff, attempts)):
+		}
+	}
+}
+
+func (c *Client) applyFailurePolicy(ctx context.Context, block int32, watched domain.AddressMatcher, attempts int, err error) (domain.Block, *domain.FailedBlock, error) {
+	policy := c.config.BlockFailure.Policy
+	if policy == FailureHalt {
+		return domain.Block{}, nil, err
+	}
+
+	failure := &domain.FailedBlock{
+		Number:   block,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: c.clock.Now().UTC(),
+	}
+
+	// data that doesn't hash to what the node reported is never stored, not even degraded
+	if policy == FailureDegrade && errors.Is(err, ethereum.ErrVerification) {
+		c.logger.Warnf("Block %d failed verification, skipping it instead of degrading it: %v", block, err)
+	} else if policy == FailureDegrade {
+		b, derr := c.ethClient.GetTransactionsWithoutReceipts(ctx, block, watched)
+		if derr == nil {
+			failure.Hash = b.Hash
+			failure.Degraded = true
+			return b, failure, nil
+		}
+
+		c.logger.Warnf("Degraded fetch of block %d failed, skipping it: %v", block, derr)
+	}
+
+	// the header is still needed to check that the following blocks build on this one
+	header, herr := c.ethClient.GetBlockHeader(ctx, block)
+	if herr != nil {
+		return domain.Block{}, nil, fmt.Errorf("%w, unable to skip it: %v", err, herr)
+	}
+	failure.Hash = header.Hash
+
+	return domain.Block{BlockHeader: header}, failure, nil
+}
+
+// recordFailedBlock saves a skipped or degraded block before it is committed
+func (c *Client) recordFailedBlock(failure domain.FailedBlock) error {
+	if err := c.config.BlockFailure.FailedBlocks.Save(failure); err != nil {
+		return fmt.Errorf("error recording failed block %d: %w", failure.Number, err)
+	}
+
+	if failure.Degraded {
+		c.logger.Warnf("Block %d stored unverified after %d attempts: %s", failure.Number, failure.Attempts, failure.Error)
+		c.metrics.IncCounter(metricBlocksDegraded, 1)
+	} else {
+		c.logger.Warnf("Block %d skipped after %d attempts: %s", failure.Number, failure.Attempts, failure.Error)
+		c.metrics.IncCounter(metricBlocksSkipped, 1)
+	}
+
+	return nil
+}
+
+// forgetFailedBlock drops the record of a block orphaned by a reorg, the canonical one replacing it is fetched anew
+func (c *Client) forgetFailedBlock(number int32) {
+	if c.config.BlockFailure.FailedBlocks == nil {
+		return
+	}
+
+	if err := c.config.BlockFailure.FailedBlocks.Delete(number); err != nil {
+		c.logger.Errorf("Error removing failed block %d: %v", number, err)
+	}
+}
+
+// GetFailedBlocks returns the blocks that were skipped or degraded, in ascending order
+func (c *Client) GetFailedBlocks() ([]domain.FailedBlock, error) {
+	if c.config.BlockFailure.FailedBlocks == nil {
+		return nil, fmt.Errorf("failed blocks are not recorded under the halt policy")
+	}
+
+	return c.config.BlockFailure.FailedBlocks.GetAll()
+}
+
+// ReprocessFailedBlock fetches a failed block again, verified this time, replaces the transactions stored from it
+// and removes it from the failed blocks. Transactions that weren't stored before are delivered to the subscriptions.
+func (c *Client) ReprocessFailedBlock(ctx context.Context, number int32) error {
+	failures, err := c.GetFailedBlocks()
+	if err != nil {
+		return err
+	}
+
+	var failure *domain.FailedBlock
+	for i := range failures {
+		if failures[i].Number == number {
+			failure = &failures[i]
+			break
+		}
+	}
+
+	if failure == nil {
+		return fmt.Errorf("block %d is not a failed block", number)
+	}
+
+	b, err := c.fetchTransactions(ctx, number, c.blockMatcher(newWatchSetOf(c.addressesActiveAt(number)...)))
+	if err != nil {
+		return fmt.Errorf("error fetching transactions from block %d: %w", number, err)
+	}
+
+	// keep out of the way of the processing loop, a rollback could otherwise interleave with the replacement
+	c.processMu.Lock()
+	defer c.processMu.Unlock()
+
+	if b.Hash != failure.Hash {
+		return fmt.Errorf("block %d is now %s, it was %s when it failed", number, b.Hash, failure.Hash)
+	}
+
+	state := domain.StatePending
+	if tip := c.tip.Load(); tip != nil {
+		state = c.stateFor(number, *tip)
+	}
+
+	for i := range b.Transactions {
+		b.Transactions[i].State = state
+	}
+
+	stored, err := c.txnStore.GetAllByBlock(number)
+	if err != nil {
+		return fmt.Errorf("error getting transactions of block %d: %w", number, err)
+	}
+
+	known := make(map[string]struct{}, len(stored))
+	for _, tx := range stored {
+		known[tx.TxID] = struct{}{}
+	}
+
+	fresh := make(domain.Transactions, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if _, ok := known[tx.TxID]; !ok {
+			fresh = append(fresh, tx)
+		}
+	}
+
+	if len(fresh) > 0 {
+		if err := c.writeSinks(ctx, domain.Block{BlockHeader: b.BlockHeader, Transactions: fresh}); err != nil {
+			return err
+		}
+	}
+
+	if err := c.txnStore.Replace(number, b.Transactions); err != nil {
+		return fmt.Errorf("error storing transactions for block %d: %w", number, err)
+	}
+
+	// the block was already delivered with the transactions stored when it failed, the new ones get deliveries
+	// of their own rather than replacing those under the same idempotency keys
+	if err := c.enqueueBlockWebhooks(domain.EventTransaction, b.BlockHeader, fresh, "reprocessed"+b.Hash); err != nil {
+		return fmt.Errorf("error enqueuing webhooks for block %d: %w", number, err)
+	}
+
+	if err := c.config.BlockFailure.FailedBlocks.Delete(number); err != nil {
+		return fmt.Errorf("error removing failed block %d: %w", number, err)
+	}
+
+	c.metrics.IncCounter(metricTxsStored, float64(len(fresh)))
+	c.logger.Infof("Reprocessed block %d, %d transactions stored, %d new", number, len(b.Transactions), len(fresh))
+	c.notify(ctx, domain.EventTransaction, fresh)
+	c.evaluateAlerts(fresh)
+
+	return nil
+}
+
+// addressesActiveAt returns the subscribed addresses whose transactions are stored for the given block
+func (c *Client) addressesActiveAt(number int32) []string {
+	var addresses []string
+	c.subscribers.Range(func(key, value interface{}) bool {
+		sub := value.(*subscriber)
+
+		select {
+		case <-sub.activated:
+		default:
+			return true
+		}
+
+		if sub.activeFrom <= number {
+			addresses = append(addresses, sub.address)
+			return true
+		}
+
+		for _, s := range sub.handles() {
+			if s.backfill != nil && s.backfill.get().From <= number {
+				addresses = append(addresses, sub.address)
+				break
+			}
+		}
+		return true
+	})
+
+	return addresses
+}
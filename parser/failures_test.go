@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+func failureConfig(policy BlockFailurePolicy) *Config {
+	cfg := testConfig()
+	cfg.BlockFailure.Policy = policy
+	cfg.BlockFailure.Retries = 0
+	cfg.BlockFailure.FailedBlocks = memory.NewFailedBlockMemoryStore()
+
+	return cfg
+}
+
+// subscribeAndSync subscribes the address and waits for the client to catch up with the chain
+func subscribeAndSync(t *testing.T, c *Client, chain *fakeChain, address string, opts *SubscribeOpts) *Subscription {
+	t.Helper()
+
+	sub, err := c.SubscribeWithOpts(address, opts)
+	if err != nil {
+		t.Fatalf("SubscribeWithOpts: %v", err)
+	}
+	waitForBlock(t, c, chain.head())
+
+	return sub
+}
+
+func TestFailureDegrade(t *testing.T) {
+	tests := []struct {
+		name     string
+		fetchErr error
+		bodyErr  error
+		degraded bool
+	}{
+		{
+			name:     "receipts unavailable",
+			fetchErr: errors.New("receipts unavailable"),
+			degraded: true,
+		},
+		{
+			name:     "verification failure is never degraded",
+			fetchErr: fmt.Errorf("rejecting block: %w", ethereum.ErrVerification),
+		},
+		{
+			name:     "degraded fetch fails",
+			fetchErr: errors.New("receipts unavailable"),
+			bodyErr:  fmt.Errorf("rejecting block: %w", ethereum.ErrVerification),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newFakeChain(3)
+			c := startClient(t, chain, failureConfig(FailureDegrade))
+			subscribeAndSync(t, c, chain, addrA, nil)
+
+			chain.mu.Lock()
+			chain.fetchErr[3] = tt.fetchErr
+			if tt.bodyErr != nil {
+				chain.bodyErr[3] = tt.bodyErr
+			}
+			chain.mu.Unlock()
+
+			chain.extend(2, "a")
+			waitForBlock(t, c, 4)
+
+			failures, err := c.GetFailedBlocks()
+			if err != nil {
+				t.Fatalf("GetFailedBlocks: %v", err)
+			}
+
+			if len(failures) != 1 || failures[0].Number != 3 {
+				t.Fatalf("failed blocks = %+v, want block 3", failures)
+			}
+
+			if failures[0].Degraded != tt.degraded {
+				t.Errorf("Degraded = %v, want %v", failures[0].Degraded, tt.degraded)
+			}
+
+			txs, err := c.GetTransactionsByBlock(3)
+			if err != nil {
+				t.Fatalf("GetTransactionsByBlock: %v", err)
+			}
+
+			want := 0
+			if tt.degraded {
+				want = 1
+			}
+
+			if len(txs) != want {
+				t.Errorf("stored %d transactions from block 3, want %d", len(txs), want)
+			}
+		})
+	}
+}
+
+// failingReplaceStore fails Replace while fail is set
+type failingReplaceStore struct {
+	transaction.Repository
+	fail atomic.Bool
+}
+
+func (s *failingReplaceStore) Replace(block int32, txs domain.Transactions) error {
+	if s.fail.Load() {
+		return errors.New("replace failed")
+	}
+
+	return s.Repository.Replace(block, txs)
+}
+
+// refusingSender never acknowledges, deliveries stay pending
+type refusingSender struct{}
+
+func (refusingSender) Send(ctx context.Context, d domain.Delivery) error {
+	return errors.New("refused")
+}
+
+func TestReprocessFailedBlockEnqueuesAfterStoring(t *testing.T) {
+	chain := newFakeChain(3)
+	deliveries := memory.NewDeliveryMemoryStore()
+	store := &failingReplaceStore{Repository: memory.NewTransactionMemoryStore()}
+
+	cfg := failureConfig(FailureDegrade)
+	cfg.Webhook.Deliveries = deliveries
+	cfg.Webhook.Sender = refusingSender{}
+
+	c := startClientWithStore(t, chain, store, cfg)
+	subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{WebhookURL: "http://receiver.invalid", WebhookSecret: "secret"})
+
+	chain.mu.Lock()
+	chain.fetchErr[3] = errors.New("receipts unavailable")
+	chain.mu.Unlock()
+
+	chain.extend(1, "a")
+	waitForBlock(t, c, 3)
+
+	pending := func() int {
+		due, err := deliveries.GetDue(time.Now().Add(24*time.Hour), 100)
+		if err != nil {
+			t.Fatalf("GetDue: %v", err)
+		}
+		return len(due)
+	}
+
+	waitFor(t, "the degraded transaction delivery", func() bool { return pending() == 3 })
+
+	// the verified block holds a transaction the degraded fetch missed
+	chain.mu.Lock()
+	delete(chain.fetchErr, 3)
+	chain.mu.Unlock()
+	chain.setTransactions(3,
+		domain.Transaction{From: addrA, To: addrB, TxID: "tx-a-3", Value: "0x0"},
+		domain.Transaction{From: addrC, To: addrA, TxID: "tx-missed", Value: "0x0"},
+	)
+
+	store.fail.Store(true)
+	if err := c.ReprocessFailedBlock(context.Background(), 3); err == nil {
+		t.Fatal("ReprocessFailedBlock succeeded with a failing store")
+	}
+
+	if n := pending(); n != 3 {
+		t.Fatalf("%d deliveries pending after a failed replacement, want 3", n)
+	}
+
+	store.fail.Store(false)
+	if err := c.ReprocessFailedBlock(context.Background(), 3); err != nil {
+		t.Fatalf("ReprocessFailedBlock: %v", err)
+	}
+
+	if n := pending(); n != 4 {
+		t.Errorf("%d deliveries pending after reprocessing, want 4", n)
+	}
+
+	failures, err := c.GetFailedBlocks()
+	if err != nil {
+		t.Fatalf("GetFailedBlocks: %v", err)
+	}
+
+	if len(failures) != 0 {
+		t.Errorf("failed blocks = %+v, want none", failures)
+	}
+}
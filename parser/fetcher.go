@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// fetchResult is a fetched and filtered block, or the error that prevented fetching it
+type fetchResult struct {
+	number  int32
+	block   domain.Block
+	matched domain.Transactions // the transactions of the block before the pipeline filters, balances count all of them
+	failure *domain.FailedBlock // set when the block was skipped or degraded by the failure policy
+	err     error
+}
+
+// fetchBlocks fetches the blocks in [from, to] with a pool of workers and delivers them strictly in block order.
+// A block is only handed to a worker once fewer than `ahead` blocks are fetched but not yet received by
+// the caller, so a slow consumer (typically a slow repository) throttles fetching instead of piling up results.
+// The returned channel is closed after the last block or once ctx is cancelled; callers that stop reading early
+// must cancel ctx to release the workers.
+func (c *Client) fetchBlocks(ctx context.Context, from, to int32, watched domain.AddressMatcher, workers, ahead int) <-chan fetchResult {
+	workers = max(workers, 1)
+	ahead = max(ahead, workers)
+
+	jobs := make(chan int32)
+	results := make(chan fetchResult, ahead)
+	ordered := make(chan fetchResult)
+	slots := make(chan struct{}, ahead)
+
+	// dispatcher: hands out block numbers as long as there is room ahead of the consumer
+	go func() {
+		defer close(jobs)
+		for block := from; block <= to; block++ {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- block:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range jobs {
+				b, failure, err := c.fetchBlock(ctx, block, watched)
+				matched := b.Transactions
+				if err == nil {
+					err = c.transformBlock(ctx, &b)
+				}
+
+				select {
+				case results <- fetchResult{number: block, block: b, matched: matched, failure: failure, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// sequencer: buffers out of order results and releases them in order
+	go func() {
+		defer close(ordered)
+
+		pending := make(map[int32]fetchResult)
+		next := from
+		for result := range results {
+			pending[result.number] = result
+
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+
+				select {
+				case ordered <- r:
+				case <-ctx.Done():
+					return
+				}
+
+				<-slots
+				next++
+			}
+		}
+	}()
+
+	return ordered
+}
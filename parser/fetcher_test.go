@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// gatedSource holds each fetch until its block is released, or fails it with the block's error
+type gatedSource struct {
+	mu      sync.Mutex
+	gates   map[int32]chan struct{}
+	errs    map[int32]error
+	fetched []int32
+}
+
+func newGatedSource() *gatedSource {
+	return &gatedSource{gates: make(map[int32]chan struct{}), errs: make(map[int32]error)}
+}
+
+func (s *gatedSource) gate(block int32) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gates[block] == nil {
+		s.gates[block] = make(chan struct{})
+	}
+
+	return s.gates[block]
+}
+
+// release lets the fetches of the blocks complete
+func (s *gatedSource) release(blocks ...int32) {
+	for _, block := range blocks {
+		close(s.gate(block))
+	}
+}
+
+// open releases every fetch from now on
+func (s *gatedSource) open() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.gates = nil
+}
+
+func (s *gatedSource) fetchedBlocks() []int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int32(nil), s.fetched...)
+}
+
+func (s *gatedSource) Fetch(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	s.mu.Lock()
+	s.fetched = append(s.fetched, block)
+	err := s.errs[block]
+	open := s.gates == nil
+	s.mu.Unlock()
+
+	if !open {
+		select {
+		case <-s.gate(block):
+		case <-ctx.Done():
+			return domain.Block{}, ctx.Err()
+		}
+	}
+
+	if err != nil {
+		return domain.Block{}, err
+	}
+
+	return domain.Block{BlockHeader: domain.BlockHeader{Number: block}}, nil
+}
+
+func newFetchClient(source Source) *Client {
+	cfg := testConfig()
+	cfg.Pipeline.Source = source
+	cfg.BlockFailure.Retries = 0
+
+	return NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+}
+
+// receive returns the next result, failing the test if none comes
+func receive(t *testing.T, results <-chan fetchResult) fetchResult {
+	t.Helper()
+
+	select {
+	case r, ok := <-results:
+		if !ok {
+			t.Fatal("results closed early")
+		}
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+
+	return fetchResult{}
+}
+
+func TestFetchBlocksDeliversInOrder(t *testing.T) {
+	source := newGatedSource()
+	c := newFetchClient(source)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := c.fetchBlocks(ctx, 1, 4, firehose{}, 4, 4)
+	waitFor(t, "every block to be fetched", func() bool { return len(source.fetchedBlocks()) == 4 })
+
+	// the later blocks finish first
+	source.release(4, 3, 2)
+	select {
+	case r := <-results:
+		t.Fatalf("received block %d before block 1 was fetched", r.number)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	source.release(1)
+	for want := int32(1); want <= 4; want++ {
+		if r := receive(t, results); r.number != want || r.block.Number != want || r.err != nil {
+			t.Fatalf("received block %d (%v), want block %d", r.number, r.err, want)
+		}
+	}
+
+	if _, ok := <-results; ok {
+		t.Error("results still open after the last block")
+	}
+}
+
+func TestFetchBlocksBoundsBlocksAhead(t *testing.T) {
+	source := newGatedSource()
+	source.open()
+	c := newFetchClient(source)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const ahead = 3
+	results := c.fetchBlocks(ctx, 1, 20, firehose{}, 2, ahead)
+	waitFor(t, "the blocks ahead to be fetched", func() bool { return len(source.fetchedBlocks()) == ahead })
+
+	// nothing is received, so nothing more is fetched
+	time.Sleep(20 * time.Millisecond)
+	if fetched := source.fetchedBlocks(); len(fetched) != ahead {
+		t.Fatalf("fetched %v without a receiver, want only %d blocks ahead", fetched, ahead)
+	}
+
+	receive(t, results)
+	waitFor(t, "a block to be fetched once one is received", func() bool { return len(source.fetchedBlocks()) == ahead+1 })
+
+	time.Sleep(20 * time.Millisecond)
+	if fetched := source.fetchedBlocks(); len(fetched) != ahead+1 {
+		t.Errorf("fetched %v after receiving one block, want %d blocks", fetched, ahead+1)
+	}
+}
+
+func TestFetchBlocksStopsAfterError(t *testing.T) {
+	chain := newFakeChain(30)
+	chain.fetchErr[3] = errors.New("node unavailable")
+
+	var mu sync.Mutex
+	var handled []error
+	cfg := testConfig()
+	cfg.Concurrency = 2
+	cfg.BlockFailure.Retries = 0
+	cfg.ErrorHandler = func(err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		handled = append(handled, err)
+		return nil
+	}
+	c := startClient(t, chain, cfg)
+	if _, err := c.Subscribe(addrA); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	waitFor(t, "the failed block to be retried", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(handled) >= 3
+	})
+
+	if block := c.GetCurrentBlock(); block != 2 {
+		t.Errorf("processed up to block %d, want block 2 before the failing one", block)
+	}
+
+	mu.Lock()
+	if !strings.Contains(handled[0].Error(), "block 3") {
+		t.Errorf("handled %v, want the error of block 3", handled[0])
+	}
+	mu.Unlock()
+
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	// the window ahead, twice the concurrency, moves past the failing block once it is received and no further
+	for block := range chain.fetches {
+		if block > 3+2*int32(cfg.Concurrency) {
+			t.Errorf("block %d was fetched past the failing block 3", block)
+		}
+	}
+}
+
+func TestFetchBlocksReleasesWorkersOnCancel(t *testing.T) {
+	source := newGatedSource()
+	c := newFetchClient(source)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := c.fetchBlocks(ctx, 1, 100, firehose{}, 4, 8)
+	waitFor(t, "the workers to be busy", func() bool { return len(source.fetchedBlocks()) == 4 })
+
+	// one block is fetched and waits for a receiver that never comes
+	source.release(1)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	deadline := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-results:
+			closed = !ok
+		case <-deadline:
+			t.Fatal("results not closed after cancelling")
+		}
+	}
+
+	waitFor(t, "the fetching goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+}
@@ -0,0 +1,55 @@
+package parser
+
+import "github.com/mateeullahmalik/eh_parser/parser/domain"
+
+// subscriptionFilter narrows watched addresses down to the transactions their subscriptions want,
+// so that the node client drops the others before they are stored or notified
+type subscriptionFilter struct {
+	domain.AddressMatcher
+	client *Client
+}
+
+var _ domain.TransactionMatcher = subscriptionFilter{}
+
+// firehose matches every transaction
+type firehose struct{}
+
+var _ domain.TransactionMatcher = firehose{}
+
+func (firehose) Contains(string) bool {
+	return true
+}
+
+func (firehose) MatchesTransaction(domain.Transaction) bool {
+	return true
+}
+
+// blockMatcher returns what the transactions of processed blocks are matched against: everything in firehose mode,
+// otherwise the watched addresses narrowed down by their subscription filters
+func (c *Client) blockMatcher(watched domain.AddressMatcher) domain.AddressMatcher {
+	if c.config.Firehose {
+		return firehose{}
+	}
+
+	return c.subscriptionFilter(watched)
+}
+
+func (c *Client) subscriptionFilter(watched domain.AddressMatcher) subscriptionFilter {
+	return subscriptionFilter{AddressMatcher: watched, client: c}
+}
+
+// MatchesTransaction reports whether a subscription of a watched address of the transaction wants it
+func (f subscriptionFilter) MatchesTransaction(tx domain.Transaction) bool {
+	for _, address := range tx.Addresses() {
+		if !f.Contains(address) {
+			continue
+		}
+
+		value, ok := f.client.subscribers.Load(address)
+		if ok && value.(*subscriber).wants(tx) {
+			return true
+		}
+	}
+
+	return false
+}
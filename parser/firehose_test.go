@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage/file"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+func TestFirehoseRequiresIndexedStore(t *testing.T) {
+	cfg := testConfig()
+	cfg.Firehose = true
+
+	c := NewClientWithConfig(newFakeChain(3), memory.NewTransactionMemoryStore(), cfg)
+	if err := c.Run(context.Background()); err == nil {
+		c.Stop(context.Background())
+		t.Fatal("firehose ran on a store rewriting the history of addresses")
+	}
+}
+
+func TestFirehoseOnFileStore(t *testing.T) {
+	db, err := file.NewKeyValue(filepath.Join(t.TempDir(), "txs.log"))
+	if err != nil {
+		t.Fatalf("NewKeyValue: %v", err)
+	}
+	t.Cleanup(func() { db.(io.Closer).Close() })
+
+	chain := newFakeChain(5)
+	cfg := testConfig()
+	cfg.Firehose = true
+
+	// blocks are processed without any subscription
+	c := startClientWithStore(t, chain, memory.NewIndexedTransactionStoreWithKeyValue(db), cfg)
+	waitForBlock(t, c, chain.head())
+
+	txs, err := c.GetTransactionsByBlock(chain.head())
+	if err != nil {
+		t.Fatalf("GetTransactionsByBlock: %v", err)
+	}
+
+	if len(txs) != 1 || txs[0].TxID != "tx-a-4" {
+		t.Errorf("block 4 holds %+v, want its transaction", txs)
+	}
+}
@@ -0,0 +1,139 @@
+package parser
+
+import (
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	// defaultHeaderWindow is the number of recent headers kept to verify chain continuity
+	defaultHeaderWindow = 128
+)
+
+// headerWindow keeps the most recent processed headers, oldest first
+type headerWindow struct {
+	mu      sync.RWMutex
+	size    int
+	headers []domain.BlockHeader
+}
+
+func newHeaderWindow(size int) *headerWindow {
+	return &headerWindow{
+		size:    size,
+		headers: make([]domain.BlockHeader, 0, size),
+	}
+}
+
+// verify checks that the header directly extends the last header in the window.
+// Any header is accepted when the window is empty, it becomes the anchor of the chain.
+func (w *headerWindow) verify(header domain.BlockHeader) error {
+	last, ok := w.last()
+	if !ok {
+		return nil
+	}
+
+	if header.Number != last.Number+1 {
+		return &BlockGapError{Expected: last.Number + 1, Actual: header.Number}
+	}
+
+	if header.ParentHash != last.Hash {
+		return &ParentMismatchError{
+			Number:         header.Number,
+			ExpectedParent: last.Hash,
+			ActualParent:   header.ParentHash,
+		}
+	}
+
+	return nil
+}
+
+// push appends a verified header, evicting the oldest one when the window is full
+func (w *headerWindow) push(header domain.BlockHeader) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.headers) == w.size {
+		copy(w.headers, w.headers[1:])
+		w.headers = w.headers[:len(w.headers)-1]
+	}
+
+	w.headers = append(w.headers, header)
+}
+
+func (w *headerWindow) last() (domain.BlockHeader, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if len(w.headers) == 0 {
+		return domain.BlockHeader{}, false
+	}
+
+	return w.headers[len(w.headers)-1], true
+}
+
+// get returns the header at the given height if it is still in the window
+func (w *headerWindow) get(number int32) (domain.BlockHeader, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if len(w.headers) == 0 {
+		return domain.BlockHeader{}, false
+	}
+
+	i := int(number - w.headers[0].Number)
+	if i < 0 || i >= len(w.headers) {
+		return domain.BlockHeader{}, false
+	}
+
+	return w.headers[i], true
+}
+
+// truncate drops every header above the given height and returns them, oldest first
+func (w *headerWindow) truncate(number int32) []domain.BlockHeader {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := 0
+	for i < len(w.headers) && w.headers[i].Number <= number {
+		i++
+	}
+
+	dropped := make([]domain.BlockHeader, len(w.headers)-i)
+	copy(dropped, w.headers[i:])
+	w.headers = w.headers[:i]
+
+	return dropped
+}
+
+// through returns a copy of the headers below the given one followed by it, as the window would hold
+// them once it is the last header, oldest first
+func (w *headerWindow) through(header domain.BlockHeader) []domain.BlockHeader {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	i := len(w.headers)
+	for i > 0 && w.headers[i-1].Number >= header.Number {
+		i--
+	}
+
+	headers := w.headers[max(i-w.size+1, 0):i]
+	out := make([]domain.BlockHeader, 0, len(headers)+1)
+
+	return append(append(out, headers...), header)
+}
+
+func (w *headerWindow) len() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return len(w.headers)
+}
+
+// reset drops every header
+func (w *headerWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.headers = w.headers[:0]
+}
@@ -0,0 +1,369 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+const (
+	addrA = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	addrB = "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"
+	addrC = "0xdbf03b407c01e7cd3cbea99509d93f8dddc8c6fb"
+)
+
+// startBalance is what every address holds before the first block of a fakeChain
+var startBalance = big.NewInt(1_000_000)
+
+// fakeChain is an in-memory node. By default each block holds one transaction from addrA to addrB.
+type fakeChain struct {
+	mu     sync.Mutex
+	blocks []domain.Block // indexed by number
+
+	fetchErr map[int32]error // returned by GetTransactionsWithAddressesFilter
+	bodyErr  map[int32]error // returned by GetTransactionsWithoutReceipts
+	fetches  map[int32]int   // GetTransactionsWithAddressesFilter calls per block
+
+	mempool   domain.Transactions // returned, then cleared, by the next GetPendingTransactions
+	noMempool bool
+	counts    map[string][2]uint64 // address to its latest and pending transaction counts
+
+	internal []domain.InternalTransfer
+	noTraces bool
+	unseen   map[string]map[int32]int64 // balance changes the parser can't observe, by address and block
+}
+
+func newFakeChain(n int) *fakeChain {
+	f := &fakeChain{
+		fetchErr: make(map[int32]error),
+		bodyErr:  make(map[int32]error),
+		fetches:  make(map[int32]int),
+		counts:   make(map[string][2]uint64),
+		unseen:   make(map[string]map[int32]int64),
+	}
+	f.extend(n, "a")
+
+	return f
+}
+
+// extend mines n blocks on top of the chain, their hashes are prefixed with fork
+func (f *fakeChain) extend(n int, fork string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.extendLocked(n, fork)
+}
+
+func (f *fakeChain) extendLocked(n int, fork string) {
+	for i := 0; i < n; i++ {
+		number := int32(len(f.blocks))
+		parent := ""
+		if number > 0 {
+			parent = f.blocks[number-1].Hash
+		}
+
+		hash := fmt.Sprintf("%s-%d", fork, number)
+		f.blocks = append(f.blocks, domain.Block{
+			BlockHeader:  domain.BlockHeader{Number: number, Hash: hash, ParentHash: parent, Timestamp: int64(number) * 12},
+			Transactions: domain.Transactions{{From: addrA, To: addrB, TxID: "tx-" + hash, Block: number, Value: "0x0"}},
+		})
+	}
+}
+
+// reorg replaces the blocks from at onwards with n blocks of another fork
+func (f *fakeChain) reorg(at, n int, fork string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blocks = f.blocks[:at]
+	f.extendLocked(n, fork)
+}
+
+// setTransactions replaces the transactions of a block, setting their block number
+func (f *fakeChain) setTransactions(number int32, txs ...domain.Transaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range txs {
+		txs[i].Block = number
+	}
+	f.blocks[number].Transactions = txs
+}
+
+func (f *fakeChain) head() int32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return int32(len(f.blocks) - 1)
+}
+
+func (f *fakeChain) GetBlockCount(ctx context.Context) (int32, error) {
+	return f.head(), nil
+}
+
+func (f *fakeChain) GetBlockNumberByTag(ctx context.Context, tag ethereum.BlockTag) (int32, error) {
+	head := f.head()
+	switch tag {
+	case ethereum.TagFinalized:
+		return max(head-20, 0), nil
+	case ethereum.TagSafe:
+		return max(head-5, 0), nil
+	default:
+		return head, nil
+	}
+}
+
+func (f *fakeChain) GetBlockHeader(ctx context.Context, block int32) (domain.BlockHeader, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if int(block) >= len(f.blocks) {
+		return domain.BlockHeader{}, fmt.Errorf("no block %d", block)
+	}
+
+	return f.blocks[block].BlockHeader, nil
+}
+
+func (f *fakeChain) GetTransactionsWithAddressesFilter(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fetches[block]++
+	if err := f.fetchErr[block]; err != nil {
+		return domain.Block{}, err
+	}
+
+	return f.filter(block, watched)
+}
+
+func (f *fakeChain) GetTransactionsWithoutReceipts(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.bodyErr[block]; err != nil {
+		return domain.Block{}, err
+	}
+
+	b, err := f.filter(block, watched)
+	for i := range b.Transactions {
+		b.Transactions[i].Status = domain.ExecutionUnknown
+	}
+
+	return b, err
+}
+
+func (f *fakeChain) filter(block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	if int(block) >= len(f.blocks) {
+		return domain.Block{}, fmt.Errorf("no block %d", block)
+	}
+
+	b := f.blocks[block]
+	matcher, _ := watched.(domain.TransactionMatcher)
+
+	out := domain.Block{BlockHeader: b.BlockHeader, Transactions: domain.Transactions{}}
+	for _, tx := range b.Transactions {
+		if !slices.ContainsFunc(tx.Addresses(), watched.Contains) {
+			continue
+		}
+
+		if matcher != nil && !matcher.MatchesTransaction(tx) {
+			continue
+		}
+		out.Transactions = append(out.Transactions, tx)
+	}
+
+	for _, w := range b.Withdrawals {
+		if watched.Contains(w.Address) {
+			out.Withdrawals = append(out.Withdrawals, w)
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeChain) GetBlocksWithAddressActivity(ctx context.Context, from, to int32, address string) ([]int32, error) {
+	return nil, ethereum.ErrNotSupported
+}
+
+func (f *fakeChain) GetPendingTransactions(ctx context.Context, watched domain.AddressMatcher) (domain.Transactions, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.noMempool {
+		return nil, ethereum.ErrNotSupported
+	}
+
+	var out domain.Transactions
+	for _, tx := range f.mempool {
+		if watched.Contains(tx.From) || watched.Contains(tx.To) {
+			out = append(out, tx)
+		}
+	}
+	f.mempool = nil
+
+	return out, nil
+}
+
+func (f *fakeChain) GetTransactionByHash(ctx context.Context, hash string) (domain.Transaction, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range f.blocks {
+		for _, tx := range b.Transactions {
+			if tx.TxID == hash {
+				return tx, true, nil
+			}
+		}
+	}
+
+	return domain.Transaction{}, false, nil
+}
+
+func (f *fakeChain) GetTransactionCount(ctx context.Context, address string, tag ethereum.BlockTag) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if tag == ethereum.TagPending {
+		return f.counts[address][1], nil
+	}
+
+	return f.counts[address][0], nil
+}
+
+// GetBalance replays the chain up to the block: transfers, fees, withdrawals, internal transfers and unseen changes
+func (f *fakeChain) GetBalance(ctx context.Context, address, blockHash string) (*big.Int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	balance := new(big.Int).Set(startBalance)
+	for _, b := range f.blocks {
+		for _, tx := range b.Transactions {
+			value := quantity(tx.Value)
+			if tx.Status == domain.ExecutionFailed {
+				value = new(big.Int)
+			}
+
+			if tx.From == address {
+				balance.Sub(balance, value)
+				balance.Sub(balance, txFee(tx))
+			}
+
+			if tx.To == address {
+				balance.Add(balance, value)
+			}
+		}
+
+		for _, w := range b.Withdrawals {
+			if w.Address == address {
+				balance.Add(balance, new(big.Int).Mul(quantity(w.AmountGwei), gwei))
+			}
+		}
+
+		for _, t := range f.internal {
+			if t.Block != b.Number {
+				continue
+			}
+
+			if t.From == address {
+				balance.Sub(balance, quantity(t.Value))
+			}
+
+			if t.To == address {
+				balance.Add(balance, quantity(t.Value))
+			}
+		}
+
+		balance.Add(balance, big.NewInt(f.unseen[address][b.Number]))
+		if b.Hash == blockHash {
+			return balance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown block %s", blockHash)
+}
+
+func (f *fakeChain) GetInternalTransfers(ctx context.Context, from, to int32, addresses []string) ([]domain.InternalTransfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.noTraces {
+		return nil, ethereum.ErrNotSupported
+	}
+
+	var out []domain.InternalTransfer
+	for _, t := range f.internal {
+		if t.Block >= from && t.Block <= to {
+			out = append(out, t)
+		}
+	}
+
+	return out, nil
+}
+
+// testConfig returns a configuration polling every few milliseconds and starting at block 1
+func testConfig() *Config {
+	cfg := NewConfig()
+	cfg.StartBlock = "1"
+	cfg.PollInterval = 5 * time.Millisecond
+	cfg.Mempool.PollInterval = 5 * time.Millisecond
+	cfg.Nonces.CheckInterval = 10 * time.Millisecond
+	cfg.Balances.ReconcileInterval = 10 * time.Millisecond
+	cfg.Logger = quietLogger{}
+
+	return cfg
+}
+
+// startClient runs a client against the chain with a memory store, it is stopped when the test ends
+func startClient(t *testing.T, chain *fakeChain, cfg *Config) *Client {
+	t.Helper()
+
+	return startClientWithStore(t, chain, memory.NewTransactionMemoryStore(), cfg)
+}
+
+func startClientWithStore(t *testing.T, chain *fakeChain, store transaction.Repository, cfg *Config) *Client {
+	t.Helper()
+
+	c := NewClientWithConfig(chain, store, cfg)
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	t.Cleanup(func() { c.Stop(context.Background()) })
+
+	return c
+}
+
+// waitFor polls the condition until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForBlock waits until the client committed the block
+func waitForBlock(t *testing.T, c *Client, block int32) {
+	t.Helper()
+
+	waitFor(t, fmt.Sprintf("block %d", block), func() bool { return int32(c.GetCurrentBlock()) >= block })
+}
+
+type quietLogger struct{}
+
+func (quietLogger) Debugf(format string, args ...interface{}) {}
+func (quietLogger) Infof(format string, args ...interface{})  {}
+func (quietLogger) Warnf(format string, args ...interface{})  {}
+func (quietLogger) Errorf(format string, args ...interface{}) {}
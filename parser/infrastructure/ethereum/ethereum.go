@@ -2,18 +2,43 @@ package ethereum
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/mateeullahmalik/eh_parser/ethereum"
 	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	domainEth "github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+const (
+	// pendingLookupBatchSize is the number of pending transactions looked up per batch request
+	pendingLookupBatchSize = 100
+	// maxPendingBacklog bounds the hashes waiting to be looked up, the oldest are given up first
+	maxPendingBacklog = 10000
+	// maxPendingLookupAttempts is the number of polls a hash whose lookup keeps failing is retried on
+	maxPendingLookupAttempts = 3
+	// transferTopic is the keccak256 hash of Transfer(address,address,uint256), the first topic of an ERC-20 transfer
+	transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
 )
 
 type EthereumBlockchain struct {
 	client ethereum.Client
+
+	pendingMu       sync.Mutex
+	pendingFilter   string         // id of the installed pending transaction filter, empty until the first poll
+	pendingBacklog  []string       // hashes reported by the filter and not looked up yet
+	pendingAttempts map[string]int // failed lookups of the hashes in the backlog
 }
 
 func NewEthereumBlockchain(client ethereum.Client) *EthereumBlockchain {
 	return &EthereumBlockchain{
-		client: client,
+		client:          client,
+		pendingAttempts: make(map[string]int),
 	}
 }
 
@@ -21,44 +46,504 @@ func (e *EthereumBlockchain) GetBlockCount(ctx context.Context) (int32, error) {
 	return e.client.GetLatestBlockNumber(ctx)
 }
 
-func (e *EthereumBlockchain) GetTransactionsWithAddressesFilter(ctx context.Context, block int32, addresses ...string) (txns domain.Transactions, err error) {
-	transactions, err := e.client.GetBlockTransactions(ctx, block)
+// GetBlockHeader returns the canonical header at the given height, after checking that it hashes to the reported hash
+func (e *EthereumBlockchain) GetBlockHeader(ctx context.Context, block int32) (domain.BlockHeader, error) {
+	h, err := e.client.GetHeader(ctx, block)
+	if err != nil {
+		return domain.BlockHeader{}, err
+	}
+
+	if err := ethereum.VerifyHeader(h); err != nil {
+		return domain.BlockHeader{}, fmt.Errorf("rejecting header %d: %w", block, domainVerificationError(err))
+	}
+
+	return toBlockHeader(h)
+}
+
+// GetBlockNumberByTag returns the number of the block the node currently labels with the tag
+func (e *EthereumBlockchain) GetBlockNumberByTag(ctx context.Context, tag domainEth.BlockTag) (int32, error) {
+	h, err := e.client.GetHeaderByTag(ctx, string(tag))
 	if err != nil {
-		return txns, err
+		return 0, err
+	}
+
+	header, err := toBlockHeader(h)
+	if err != nil {
+		return 0, err
+	}
+
+	return header.Number, nil
+}
+
+// GetBlocksWithAddressActivity locates the blocks touching the address with trace_filter, queried once per direction
+// since nodes disagree on whether fromAddress and toAddress are combined as a union or an intersection.
+// eth_getLogs is not used: plain value transfers emit no logs, so it can't tell where an address was active.
+func (e *EthereumBlockchain) GetBlocksWithAddressActivity(ctx context.Context, from, to int32, address string) ([]int32, error) {
+	filters := []ethereum.TraceFilter{
+		{FromBlock: ethereum.ToBlockNumArg(from), ToBlock: ethereum.ToBlockNumArg(to), FromAddress: []string{address}},
+		{FromBlock: ethereum.ToBlockNumArg(from), ToBlock: ethereum.ToBlockNumArg(to), ToAddress: []string{address}},
+	}
+
+	seen := make(map[int32]struct{})
+	for _, filter := range filters {
+		traces, err := e.client.TraceFilter(ctx, filter)
+		if errors.Is(err, ethereum.ErrMethodNotFound) {
+			return nil, fmt.Errorf("%w: %v", domainEth.ErrNotSupported, err)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trace := range traces {
+			seen[int32(trace.BlockNumber)] = struct{}{}
+		}
+	}
+
+	blocks := make([]int32, 0, len(seen))
+	for block := range seen {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+
+	return blocks, nil
+}
+
+func (e *EthereumBlockchain) GetTransactionsWithAddressesFilter(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	b, receipts, err := e.getVerifiedBlock(ctx, block)
+	if err != nil {
+		return domain.Block{}, err
+	}
+
+	return filterTransactions(b, receipts, block, watched)
+}
+
+func (e *EthereumBlockchain) GetTransactionsWithoutReceipts(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	b, err := e.client.GetBlock(ctx, block)
+	if err != nil {
+		return domain.Block{}, err
+	}
+
+	if err := ethereum.VerifyBody(b); err != nil {
+		return domain.Block{}, rejected(block, err)
+	}
+
+	return filterTransactions(b, nil, block, watched)
+}
+
+// filterTransactions keeps the transactions of the watched addresses, as sender, receiver or party of one of their
+// token transfers. When the matcher is also a TransactionMatcher, it gets the final say on each of them.
+// Receipts, in transaction order, give their status and token transfers and can be nil.
+func filterTransactions(b *ethereum.Block, receipts ethereum.Receipts, block int32, watched domain.AddressMatcher) (result domain.Block, err error) {
+	result.BlockHeader, err = toBlockHeader(&b.Header)
+	if err != nil {
+		return result, err
+	}
+
+	matcher, _ := watched.(domain.TransactionMatcher)
+
+	// transactions keep their order in the block
+	result.Transactions = make(domain.Transactions, 0)
+	for i := range b.Transactions {
+		tx := &b.Transactions[i]
+
+		var transfers []domain.TokenTransfer
+		if i < len(receipts) {
+			transfers = tokenTransfers(receipts[i].Logs)
+		}
+
+		if !watched.Contains(tx.From) && !watched.Contains(tx.To) && !transfersWatched(transfers, watched) {
+			continue
+		}
+
+		t, err := toTransaction(tx, block)
+		if err != nil {
+			return result, err
+		}
+
+		if i < len(receipts) {
+			t.Status = executionStatus(receipts[i])
+			t.GasUsed = receipts[i].GasUsed
+			t.EffectiveGasPrice = receipts[i].EffectiveGasPrice
+			t.TokenTransfers = transfers
+		}
+
+		if matcher != nil && !matcher.MatchesTransaction(t) {
+			continue
+		}
+
+		result.Transactions = append(result.Transactions, t)
 	}
 
-	txnsMap := make(map[string]domain.Transactions)
-	for _, addr := range addresses {
-		txnsMap[addr] = domain.Transactions{}
+	for _, w := range b.Withdrawals {
+		if watched.Contains(w.Address) {
+			result.Withdrawals = append(result.Withdrawals, domain.Withdrawal{Address: strings.ToLower(w.Address), AmountGwei: w.Amount})
+		}
 	}
 
-	count := 0
-	for _, tx := range transactions {
-		_, fromExists := txnsMap[tx.From]
-		_, toExists := txnsMap[tx.To]
+	return result, nil
+}
+
+// GetPendingTransactions polls a pending transaction filter, installed on the first call and again whenever the node
+// forgets it, and looks up the new hashes in batches. Hashes not looked up because of an error are kept for the
+// next call. Transactions already mined by the time they are looked up are left to block processing.
+func (e *EthereumBlockchain) GetPendingTransactions(ctx context.Context, watched domain.AddressMatcher) (domain.Transactions, error) {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
 
-		if fromExists || toExists {
-			count++
-			txnsMap[tx.From] = append(txnsMap[tx.From], domain.Transaction{
-				TxID:     tx.Hash,
-				Gas:      tx.Gas,
-				From:     tx.From,
-				To:       tx.To,
-				GasPrice: tx.GasPrice,
-				Value:    tx.Value,
-				Block:    block,
-			})
+	if e.pendingFilter == "" {
+		id, err := e.client.NewPendingTransactionFilter(ctx)
+		if errors.Is(err, ethereum.ErrMethodNotFound) {
+			return nil, fmt.Errorf("%w: %v", domainEth.ErrNotSupported, err)
 		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.pendingFilter = id
+		return nil, nil
+	}
+
+	hashes, err := e.client.GetFilterChanges(ctx, e.pendingFilter)
+	if errors.Is(err, ethereum.ErrFilterNotFound) {
+		// what entered the mempool in between is missed, it still shows up once mined
+		e.pendingFilter = ""
+		return nil, fmt.Errorf("pending transaction filter expired, installing a new one: %w", err)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
-	txns = make(domain.Transactions, count)
-	i := 0
-	for _, tx := range txnsMap {
-		for _, t := range tx {
-			txns[i] = t
-			i++
+	e.pendingBacklog = append(e.pendingBacklog, hashes...)
+	if excess := len(e.pendingBacklog) - maxPendingBacklog; excess > 0 {
+		for _, hash := range e.pendingBacklog[:excess] {
+			delete(e.pendingAttempts, hash)
 		}
+		e.pendingBacklog = e.pendingBacklog[excess:]
+	}
+
+	matcher, _ := watched.(domain.TransactionMatcher)
+
+	txs := make(domain.Transactions, 0)
+	var retry []string
+	var firstErr error
+	for len(e.pendingBacklog) > 0 {
+		batch := e.pendingBacklog[:min(pendingLookupBatchSize, len(e.pendingBacklog))]
+		found, err := e.client.GetTransactionsByHash(ctx, batch)
+		if err != nil {
+			e.pendingBacklog = append(retry, e.pendingBacklog...)
+			return txs, err
+		}
+		e.pendingBacklog = e.pendingBacklog[len(batch):]
+
+		for _, hash := range batch {
+			tx, ok := found[hash]
+			if !ok {
+				if e.pendingAttempts[hash]++; e.pendingAttempts[hash] < maxPendingLookupAttempts {
+					retry = append(retry, hash)
+				} else {
+					delete(e.pendingAttempts, hash)
+				}
+				continue
+			}
+			delete(e.pendingAttempts, hash)
+
+			t, ok, err := pendingTransaction(tx, watched, matcher)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+
+			if ok {
+				txs = append(txs, t)
+			}
+		}
+	}
+	e.pendingBacklog = retry
+
+	return txs, firstErr
+}
+
+// pendingTransaction converts a looked up transaction, ok is false when it isn't one of a watched address
+// still pending
+func pendingTransaction(tx *ethereum.TransactionResult, watched domain.AddressMatcher, matcher domain.TransactionMatcher) (domain.Transaction, bool, error) {
+	// already dropped or mined
+	if tx == nil || tx.BlockNumber != "" {
+		return domain.Transaction{}, false, nil
+	}
+
+	if !watched.Contains(tx.From) && !watched.Contains(tx.To) {
+		return domain.Transaction{}, false, nil
+	}
+
+	t, err := toTransaction(tx, 0)
+	if err != nil {
+		return domain.Transaction{}, false, err
+	}
+
+	if matcher != nil && !matcher.MatchesTransaction(t) {
+		return domain.Transaction{}, false, nil
+	}
+
+	return t, true, nil
+}
+
+func (e *EthereumBlockchain) GetTransactionByHash(ctx context.Context, hash string) (domain.Transaction, bool, error) {
+	tx, err := e.client.GetTransactionByHash(ctx, hash)
+	if err != nil || tx == nil {
+		return domain.Transaction{}, false, err
+	}
+
+	var block int32
+	if tx.BlockNumber != "" {
+		number, err := strconv.ParseInt(tx.BlockNumber, 0, 32)
+		if err != nil {
+			return domain.Transaction{}, false, fmt.Errorf("invalid block number %q for transaction %s: %w", tx.BlockNumber, hash, err)
+		}
+		block = int32(number)
+	}
+
+	t, err := toTransaction(tx, block)
+	if err != nil {
+		return domain.Transaction{}, false, err
+	}
+
+	return t, true, nil
+}
+
+func (e *EthereumBlockchain) GetTransactionCount(ctx context.Context, address string, tag domainEth.BlockTag) (uint64, error) {
+	return e.client.GetTransactionCount(ctx, address, string(tag))
+}
+
+func (e *EthereumBlockchain) GetBalance(ctx context.Context, address string, blockHash string) (*big.Int, error) {
+	return e.client.GetBalance(ctx, address, blockHash)
+}
+
+// GetInternalTransfers looks the traces of the addresses up with trace_filter, once per direction like
+// GetBlocksWithAddressActivity, and keeps the successful ones moving value below the transaction itself
+func (e *EthereumBlockchain) GetInternalTransfers(ctx context.Context, from, to int32, addresses []string) ([]domain.InternalTransfer, error) {
+	filters := []ethereum.TraceFilter{
+		{FromBlock: ethereum.ToBlockNumArg(from), ToBlock: ethereum.ToBlockNumArg(to), FromAddress: addresses},
+		{FromBlock: ethereum.ToBlockNumArg(from), ToBlock: ethereum.ToBlockNumArg(to), ToAddress: addresses},
+	}
+
+	seen := make(map[string]struct{})
+	var transfers []domain.InternalTransfer
+	for _, filter := range filters {
+		traces, err := e.client.TraceFilter(ctx, filter)
+		if errors.Is(err, ethereum.ErrMethodNotFound) {
+			return nil, fmt.Errorf("%w: %v", domainEth.ErrNotSupported, err)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trace := range traces {
+			if len(trace.TraceAddress) == 0 || trace.Error != "" {
+				continue
+			}
+
+			transfer, ok := toInternalTransfer(trace)
+			if !ok {
+				continue
+			}
+
+			// a transfer between two of the addresses is returned by both filters
+			key := fmt.Sprintf("%s:%v", trace.TransactionHash, trace.TraceAddress)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			transfers = append(transfers, transfer)
+		}
+	}
+
+	sort.SliceStable(transfers, func(i, j int) bool { return transfers[i].Block < transfers[j].Block })
+
+	return transfers, nil
+}
+
+// toInternalTransfer reads the value moved by a trace, ok is false when it moves none
+func toInternalTransfer(trace ethereum.Trace) (transfer domain.InternalTransfer, ok bool) {
+	transfer = domain.InternalTransfer{Block: int32(trace.BlockNumber), TxID: trace.TransactionHash}
+	a := trace.Action
+
+	switch trace.Type {
+	case "call":
+		// delegate and static calls move no value of their own
+		if a.CallType == "delegatecall" || a.CallType == "staticcall" || a.CallType == "callcode" {
+			return transfer, false
+		}
+		transfer.Kind, transfer.From, transfer.To, transfer.Value = domain.TransferCall, a.From, a.To, a.Value
+	case "create":
+		if trace.Result == nil {
+			return transfer, false
+		}
+		transfer.Kind, transfer.From, transfer.To, transfer.Value = domain.TransferCreate, a.From, trace.Result.Address, a.Value
+	case "suicide":
+		transfer.Kind, transfer.From, transfer.To, transfer.Value = domain.TransferSelfDestruct, a.Address, a.RefundAddress, a.Balance
+	default:
+		return transfer, false
+	}
+
+	value, ok := new(big.Int).SetString(transfer.Value, 0)
+	if !ok || value.Sign() == 0 {
+		return transfer, false
+	}
+
+	transfer.From, transfer.To = strings.ToLower(transfer.From), strings.ToLower(transfer.To)
+
+	return transfer, true
+}
+
+// toTransaction converts a transaction of the given block, zero for a pending one.
+// Addresses are stored in lowercase, whatever case the node uses.
+func toTransaction(tx *ethereum.TransactionResult, block int32) (domain.Transaction, error) {
+	var nonce uint64
+	if tx.Nonce != "" {
+		n, err := strconv.ParseUint(tx.Nonce, 0, 64)
+		if err != nil {
+			return domain.Transaction{}, fmt.Errorf("invalid nonce %q for transaction %s: %w", tx.Nonce, tx.Hash, err)
+		}
+		nonce = n
+	}
+
+	return domain.Transaction{
+		TxID:     tx.Hash,
+		Gas:      tx.Gas,
+		From:     strings.ToLower(tx.From),
+		To:       strings.ToLower(tx.To),
+		GasPrice: tx.GasPrice,
+		Value:    tx.Value,
+		Nonce:    nonce,
+		Method:   methodSelector(tx.Input),
+		Block:    block,
+	}, nil
+}
+
+// rejected wraps the error of a block that couldn't be verified
+func rejected(block int32, err error) error {
+	return fmt.Errorf("rejecting block %d: %w", block, domainVerificationError(err))
+}
+
+// domainVerificationError makes a verification error recognizable as domainEth.ErrVerification, other errors
+// such as an undecodable field are left as they are
+func domainVerificationError(err error) error {
+	if errors.Is(err, ethereum.ErrVerification) {
+		return fmt.Errorf("%w: %w", domainEth.ErrVerification, err)
+	}
+
+	return err
+}
+
+// getVerifiedBlock fetches the block along with its receipts and only returns it
+// once the header hash, transactions root and receipts root have been recomputed and match.
+// This way a lying or buggy RPC provider is caught before anything reaches the repository.
+func (e *EthereumBlockchain) getVerifiedBlock(ctx context.Context, block int32) (*ethereum.Block, ethereum.Receipts, error) {
+	b, err := e.client.GetBlock(ctx, block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	receipts, err := e.client.GetBlockReceipts(ctx, b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get receipts for block %d: %w", block, err)
+	}
+
+	if err := ethereum.VerifyBlock(b, receipts); err != nil {
+		return nil, nil, rejected(block, err)
+	}
+
+	return b, receipts, nil
+}
+
+// tokenTransfers decodes the ERC-20 Transfer events of a receipt. ERC-721 transfers share the signature but index
+// the token ID as a fourth topic, they are left out.
+func tokenTransfers(logs []ethereum.Log) []domain.TokenTransfer {
+	var transfers []domain.TokenTransfer
+	for _, log := range logs {
+		if len(log.Topics) != 3 || !strings.EqualFold(log.Topics[0], transferTopic) {
+			continue
+		}
+
+		from, okFrom := topicAddress(log.Topics[1])
+		to, okTo := topicAddress(log.Topics[2])
+		value, okValue := new(big.Int).SetString(strings.TrimPrefix(log.Data, "0x"), 16)
+		if !okFrom || !okTo || !okValue || len(log.Data) != 2+64 {
+			continue
+		}
+
+		transfers = append(transfers, domain.TokenTransfer{
+			Contract: strings.ToLower(log.Address),
+			From:     from,
+			To:       to,
+			Value:    "0x" + value.Text(16),
+		})
+	}
+
+	return transfers
+}
+
+// topicAddress returns the lowercase address held in the low 20 bytes of a 32 byte topic
+func topicAddress(topic string) (string, bool) {
+	if len(topic) != 2+64 {
+		return "", false
+	}
+
+	address, err := domain.NormalizeAddress("0x" + strings.ToLower(topic[2+24:]))
+	return address, err == nil
+}
+
+// transfersWatched reports whether a party of one of the token transfers is watched
+func transfersWatched(transfers []domain.TokenTransfer, watched domain.AddressMatcher) bool {
+	for _, t := range transfers {
+		if watched.Contains(t.From) || watched.Contains(t.To) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// methodSelector returns the selector a transaction input starts with, empty when the input is too short to hold one
+func methodSelector(input string) string {
+	if len(input) < 2+2*domain.MethodSelectorLength {
+		return ""
+	}
+
+	return strings.ToLower(input[:2+2*domain.MethodSelectorLength])
+}
+
+// executionStatus reads the status of a receipt, pre-Byzantium receipts carry a state root instead
+func executionStatus(r ethereum.Receipt) domain.ExecutionStatus {
+	switch r.Status {
+	case "0x1":
+		return domain.ExecutionSucceeded
+	case "0x0":
+		return domain.ExecutionFailed
+	default:
+		return domain.ExecutionUnknown
+	}
+}
+
+func toBlockHeader(b *ethereum.Header) (domain.BlockHeader, error) {
+	number, err := strconv.ParseInt(b.Number, 0, 32)
+	if err != nil {
+		return domain.BlockHeader{}, fmt.Errorf("invalid block number %q: %w", b.Number, err)
+	}
+
+	timestamp, err := strconv.ParseInt(b.Timestamp, 0, 64)
+	if err != nil {
+		return domain.BlockHeader{}, fmt.Errorf("invalid timestamp %q for block %d: %w", b.Timestamp, number, err)
 	}
 
-	return txns, nil
+	return domain.BlockHeader{
+		Number:     int32(number),
+		Hash:       b.Hash,
+		ParentHash: b.ParentHash,
+		Timestamp:  timestamp,
+	}, nil
 }
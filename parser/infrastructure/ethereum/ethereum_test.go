@@ -0,0 +1,206 @@
+package ethereum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/ethereum"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const watchedAddress = "0x00000000000000000000000000000000000000aa"
+
+// watchAll matches every address
+type watchAll struct{}
+
+func (watchAll) Contains(address string) bool { return true }
+
+// mempoolClient serves a pending transaction filter from a list of hashes and counts the lookups
+type mempoolClient struct {
+	ethereum.Client
+
+	changes  []string
+	failing  map[string]bool // hashes whose lookup fails
+	down     bool            // fails every batch
+	batches  [][]string
+	lookedUp map[string]int
+}
+
+func (m *mempoolClient) NewPendingTransactionFilter(ctx context.Context) (string, error) {
+	return "filter", nil
+}
+
+func (m *mempoolClient) GetFilterChanges(ctx context.Context, id string) ([]string, error) {
+	changes := m.changes
+	m.changes = nil
+
+	return changes, nil
+}
+
+func (m *mempoolClient) GetTransactionsByHash(ctx context.Context, hashes []string) (map[string]*ethereum.TransactionResult, error) {
+	if m.down {
+		return nil, errors.New("node down")
+	}
+
+	m.batches = append(m.batches, hashes)
+	found := make(map[string]*ethereum.TransactionResult)
+	for _, hash := range hashes {
+		m.lookedUp[hash]++
+		if !m.failing[hash] {
+			found[hash] = &ethereum.TransactionResult{Hash: hash, From: watchedAddress, To: "0x00000000000000000000000000000000000000bb", Nonce: "0x0"}
+		}
+	}
+
+	return found, nil
+}
+
+func hashes(n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		hashes[i] = fmt.Sprintf("0x%064x", i)
+	}
+
+	return hashes
+}
+
+func newMempool(t *testing.T) (*EthereumBlockchain, *mempoolClient) {
+	t.Helper()
+
+	client := &mempoolClient{failing: make(map[string]bool), lookedUp: make(map[string]int)}
+	e := NewEthereumBlockchain(client)
+	if _, err := e.GetPendingTransactions(context.Background(), watchAll{}); err != nil {
+		t.Fatalf("installing the filter: %v", err)
+	}
+
+	return e, client
+}
+
+func TestGetPendingTransactionsBatchesLookups(t *testing.T) {
+	const n = 2*pendingLookupBatchSize + 1
+
+	e, client := newMempool(t)
+	client.changes = hashes(n)
+
+	txs, err := e.GetPendingTransactions(context.Background(), watchAll{})
+	if err != nil {
+		t.Fatalf("GetPendingTransactions: %v", err)
+	}
+
+	if len(txs) != n {
+		t.Errorf("found %d transactions, want %d", len(txs), n)
+	}
+
+	if len(client.batches) != 3 {
+		t.Errorf("looked the hashes up in %d requests, want 3 batches", len(client.batches))
+	}
+}
+
+func TestGetPendingTransactionsKeepsUnfetchedHashes(t *testing.T) {
+	e, client := newMempool(t)
+	client.changes = hashes(3)
+	client.down = true
+
+	if _, err := e.GetPendingTransactions(context.Background(), watchAll{}); err == nil {
+		t.Fatal("GetPendingTransactions succeeded while the node is down")
+	}
+
+	client.down = false
+	txs, err := e.GetPendingTransactions(context.Background(), watchAll{})
+	if err != nil {
+		t.Fatalf("GetPendingTransactions: %v", err)
+	}
+
+	if len(txs) != 3 {
+		t.Errorf("found %d transactions on the next poll, want the 3 not looked up before", len(txs))
+	}
+}
+
+func TestGetPendingTransactionsRetriesFailedLookups(t *testing.T) {
+	e, client := newMempool(t)
+	all := hashes(2)
+	client.changes = all
+	client.failing[all[1]] = true
+
+	for poll := 0; poll < maxPendingLookupAttempts+1; poll++ {
+		txs, err := e.GetPendingTransactions(context.Background(), watchAll{})
+		if err != nil {
+			t.Fatalf("GetPendingTransactions: %v", err)
+		}
+
+		if poll == 0 && len(txs) != 1 {
+			t.Errorf("found %d transactions, want the one whose lookup succeeded", len(txs))
+		}
+	}
+
+	if n := client.lookedUp[all[0]]; n != 1 {
+		t.Errorf("found transaction looked up %d times, want once", n)
+	}
+
+	if n := client.lookedUp[all[1]]; n != maxPendingLookupAttempts {
+		t.Errorf("failing transaction looked up %d times, want %d", n, maxPendingLookupAttempts)
+	}
+}
+
+// watchOnly matches a single address
+type watchOnly string
+
+func (w watchOnly) Contains(address string) bool { return address == string(w) }
+
+// addressTopic pads an address to a 32 byte topic
+func addressTopic(address string) string {
+	return "0x000000000000000000000000" + address[2:]
+}
+
+func TestFilterTransactionsTokenTransfers(t *testing.T) {
+	const (
+		sender = "0x00000000000000000000000000000000000000bb"
+		token  = "0x00000000000000000000000000000000000000cc"
+		amount = "0x0000000000000000000000000000000000000000000000000de0b6b3a7640000"
+		// keccak256 of Approval(address,address,uint256)
+		approvalTopic = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+	)
+
+	transfer := func(topics ...string) ethereum.Log {
+		return ethereum.Log{Address: "0x00000000000000000000000000000000000000CC", Topics: append([]string{transferTopic}, topics...), Data: amount}
+	}
+
+	b := &ethereum.Block{
+		Header: ethereum.Header{Number: "0x1", Timestamp: "0x0"},
+		Transactions: ethereum.TransactionResults{
+			{Hash: "0x01", From: sender, To: token, Value: "0x0"},
+			{Hash: "0x02", From: sender, To: token, Value: "0x0"},
+			{Hash: "0x03", From: sender, To: token, Value: "0x0"},
+			{Hash: "0x04", From: sender, To: token, Value: "0x0"},
+		},
+	}
+	receipts := ethereum.Receipts{
+		{Status: "0x1", Logs: []ethereum.Log{transfer(addressTopic(sender), addressTopic(watchedAddress))}},
+		{Status: "0x1", Logs: []ethereum.Log{transfer(addressTopic(sender), addressTopic(sender))}},
+		// an ERC-721 transfer indexes the token ID as well
+		{Status: "0x1", Logs: []ethereum.Log{transfer(addressTopic(sender), addressTopic(watchedAddress), amount)}},
+		// an Approval has the same layout
+		{Status: "0x1", Logs: []ethereum.Log{{Address: token, Topics: []string{approvalTopic, addressTopic(sender), addressTopic(watchedAddress)}, Data: amount}}},
+	}
+
+	result, err := filterTransactions(b, receipts, 1, watchOnly(watchedAddress))
+	if err != nil {
+		t.Fatalf("filterTransactions: %v", err)
+	}
+
+	if len(result.Transactions) != 1 || result.Transactions[0].TxID != "0x01" {
+		t.Fatalf("kept %+v, want only the token deposit to the watched address", result.Transactions)
+	}
+
+	transfers := result.Transactions[0].TokenTransfers
+	want := domain.TokenTransfer{Contract: token, From: sender, To: watchedAddress, Value: "0xde0b6b3a7640000"}
+	if len(transfers) != 1 || transfers[0] != want {
+		t.Errorf("token transfers %+v, want %+v", transfers, want)
+	}
+
+	// without receipts the transfers are unknown
+	if result, err := filterTransactions(b, nil, 1, watchOnly(watchedAddress)); err != nil || len(result.Transactions) != 0 {
+		t.Errorf("filterTransactions without receipts = %+v, %v, want no transaction", result.Transactions, err)
+	}
+}
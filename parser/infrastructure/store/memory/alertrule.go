@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	alertRuleKeyPrefix = "alertrule:"
+	alertRulesIndexKey = "alertrules"
+)
+
+type AlertRuleMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+func NewAlertRuleMemoryStore() *AlertRuleMemoryStore {
+	return NewAlertRuleStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewAlertRuleStoreWithKeyValue returns a store backed by the given key-value database,
+// a durable one is needed for alert rules to survive restarts
+func NewAlertRuleStoreWithKeyValue(db storage.KeyValue) *AlertRuleMemoryStore {
+	return &AlertRuleMemoryStore{
+		db: db,
+	}
+}
+
+func (a *AlertRuleMemoryStore) Save(r domain.AlertRule) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids, err := a.getIndex()
+	if err != nil {
+		return err
+	}
+
+	batch := storage.NewBatch()
+	if !contains(ids, r.ID) {
+		if err := a.setIndex(batch, append(ids, r.ID)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("unable to marshal alert rule %s: %w", r.ID, err)
+	}
+	batch.Set(alertRuleKeyPrefix+r.ID, data)
+
+	return a.db.Write(batch)
+}
+
+func (a *AlertRuleMemoryStore) Delete(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids, err := a.getIndex()
+	if err != nil {
+		return err
+	}
+
+	if !contains(ids, id) {
+		return nil
+	}
+
+	kept := make([]string, 0, len(ids))
+	for _, existing := range ids {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+
+	batch := storage.NewBatch()
+	if err := a.setIndex(batch, kept); err != nil {
+		return err
+	}
+	batch.Delete(alertRuleKeyPrefix + id)
+
+	return a.db.Write(batch)
+}
+
+func (a *AlertRuleMemoryStore) GetAll() ([]domain.AlertRule, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids, err := a.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]domain.AlertRule, 0, len(ids))
+	for _, id := range ids {
+		data, err := a.db.Get(alertRuleKeyPrefix + id)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get alert rule %s: %w", id, err)
+		}
+
+		var r domain.AlertRule
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal alert rule %s: %w", id, err)
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func (a *AlertRuleMemoryStore) getIndex() (ids []string, err error) {
+	data, err := a.db.Get(alertRulesIndexKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get alert rules index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal alert rules index: %w", err)
+	}
+
+	return ids, nil
+}
+
+func (a *AlertRuleMemoryStore) setIndex(batch *storage.Batch, ids []string) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("unable to marshal alert rules index: %w", err)
+	}
+
+	batch.Set(alertRulesIndexKey, data)
+
+	return nil
+}
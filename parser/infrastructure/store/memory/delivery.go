@@ -0,0 +1,221 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	deliveryKeyPrefix  = "delivery:"
+	pendingIndexKey    = "deliveries:pending"
+	deadLetterIndexKey = "deliveries:dead"
+)
+
+type DeliveryMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+func NewDeliveryMemoryStore() *DeliveryMemoryStore {
+	return NewDeliveryStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewDeliveryStoreWithKeyValue returns a store backed by the given key-value database,
+// a durable one is needed for pending deliveries to survive restarts
+func NewDeliveryStoreWithKeyValue(db storage.KeyValue) *DeliveryMemoryStore {
+	return &DeliveryMemoryStore{
+		db: db,
+	}
+}
+
+func (d *DeliveryMemoryStore) Enqueue(delivery domain.Delivery) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := d.addToIndex(pendingIndexKey, delivery.ID, batch); err != nil {
+		return err
+	}
+
+	if err := d.setDelivery(batch, delivery); err != nil {
+		return err
+	}
+
+	return d.db.Write(batch)
+}
+
+func (d *DeliveryMemoryStore) GetDue(now time.Time, limit int) ([]domain.Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deliveries, err := d.getIndexed(pendingIndexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]domain.Delivery, 0)
+	for _, delivery := range deliveries {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+func (d *DeliveryMemoryStore) Update(delivery domain.Delivery) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := d.setDelivery(batch, delivery); err != nil {
+		return err
+	}
+
+	return d.db.Write(batch)
+}
+
+func (d *DeliveryMemoryStore) Ack(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := d.removeFromIndex(pendingIndexKey, id, batch); err != nil {
+		return err
+	}
+	batch.Delete(deliveryKeyPrefix + id)
+
+	return d.db.Write(batch)
+}
+
+func (d *DeliveryMemoryStore) DeadLetter(delivery domain.Delivery) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := d.removeFromIndex(pendingIndexKey, delivery.ID, batch); err != nil {
+		return err
+	}
+
+	if err := d.addToIndex(deadLetterIndexKey, delivery.ID, batch); err != nil {
+		return err
+	}
+
+	if err := d.setDelivery(batch, delivery); err != nil {
+		return err
+	}
+
+	return d.db.Write(batch)
+}
+
+func (d *DeliveryMemoryStore) GetDeadLetters() ([]domain.Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.getIndexed(deadLetterIndexKey)
+}
+
+func (d *DeliveryMemoryStore) setDelivery(batch *storage.Batch, delivery domain.Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("unable to marshal delivery %s: %w", delivery.ID, err)
+	}
+
+	batch.Set(deliveryKeyPrefix+delivery.ID, data)
+
+	return nil
+}
+
+func (d *DeliveryMemoryStore) getIndexed(indexKey string) ([]domain.Delivery, error) {
+	ids, err := d.getIndex(indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]domain.Delivery, 0, len(ids))
+	for _, id := range ids {
+		data, err := d.db.Get(deliveryKeyPrefix + id)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get delivery %s: %w", id, err)
+		}
+
+		var delivery domain.Delivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal delivery %s: %w", id, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func (d *DeliveryMemoryStore) getIndex(indexKey string) (ids []string, err error) {
+	data, err := d.db.Get(indexKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get %s: %w", indexKey, err)
+	}
+
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s: %w", indexKey, err)
+	}
+
+	return ids, nil
+}
+
+func (d *DeliveryMemoryStore) addToIndex(indexKey, id string, batch *storage.Batch) error {
+	ids, err := d.getIndex(indexKey)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range ids {
+		if existing == id {
+			return nil
+		}
+	}
+
+	return d.setIndex(indexKey, append(ids, id), batch)
+}
+
+func (d *DeliveryMemoryStore) removeFromIndex(indexKey, id string, batch *storage.Batch) error {
+	ids, err := d.getIndex(indexKey)
+	if err != nil {
+		return err
+	}
+
+	kept := make([]string, 0, len(ids))
+	for _, existing := range ids {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+
+	return d.setIndex(indexKey, kept, batch)
+}
+
+func (d *DeliveryMemoryStore) setIndex(indexKey string, ids []string, batch *storage.Batch) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %s: %w", indexKey, err)
+	}
+
+	batch.Set(indexKey, data)
+
+	return nil
+}
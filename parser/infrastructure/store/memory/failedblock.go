@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	failedBlockKeyPrefix = "failedblock:"
+	failedBlocksIndexKey = "failedblocks"
+)
+
+type FailedBlockMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+func NewFailedBlockMemoryStore() *FailedBlockMemoryStore {
+	return NewFailedBlockStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewFailedBlockStoreWithKeyValue returns a store backed by the given key-value database,
+// a durable one keeps track of the failed blocks across restarts
+func NewFailedBlockStoreWithKeyValue(db storage.KeyValue) *FailedBlockMemoryStore {
+	return &FailedBlockMemoryStore{
+		db: db,
+	}
+}
+
+func (f *FailedBlockMemoryStore) Save(b domain.FailedBlock) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	numbers, err := f.getIndex()
+	if err != nil {
+		return err
+	}
+
+	batch := storage.NewBatch()
+	i := sort.Search(len(numbers), func(i int) bool { return numbers[i] >= b.Number })
+	if i == len(numbers) || numbers[i] != b.Number {
+		numbers = append(numbers, 0)
+		copy(numbers[i+1:], numbers[i:])
+		numbers[i] = b.Number
+
+		if err := f.setIndex(batch, numbers); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("unable to marshal failed block %d: %w", b.Number, err)
+	}
+	batch.Set(failedBlockKey(b.Number), data)
+
+	return f.db.Write(batch)
+}
+
+func (f *FailedBlockMemoryStore) Delete(number int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	numbers, err := f.getIndex()
+	if err != nil {
+		return err
+	}
+
+	i := sort.Search(len(numbers), func(i int) bool { return numbers[i] >= number })
+	if i == len(numbers) || numbers[i] != number {
+		return nil
+	}
+
+	batch := storage.NewBatch()
+	if err := f.setIndex(batch, append(numbers[:i], numbers[i+1:]...)); err != nil {
+		return err
+	}
+	batch.Delete(failedBlockKey(number))
+
+	return f.db.Write(batch)
+}
+
+func (f *FailedBlockMemoryStore) GetAll() ([]domain.FailedBlock, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	numbers, err := f.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]domain.FailedBlock, 0, len(numbers))
+	for _, number := range numbers {
+		data, err := f.db.Get(failedBlockKey(number))
+		if err != nil {
+			return nil, fmt.Errorf("unable to get failed block %d: %w", number, err)
+		}
+
+		var b domain.FailedBlock
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal failed block %d: %w", number, err)
+		}
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
+}
+
+func (f *FailedBlockMemoryStore) getIndex() (numbers []int32, err error) {
+	data, err := f.db.Get(failedBlocksIndexKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get failed blocks index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &numbers); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal failed blocks index: %w", err)
+	}
+
+	return numbers, nil
+}
+
+func (f *FailedBlockMemoryStore) setIndex(batch *storage.Batch, numbers []int32) error {
+	data, err := json.Marshal(numbers)
+	if err != nil {
+		return fmt.Errorf("unable to marshal failed blocks index: %w", err)
+	}
+
+	batch.Set(failedBlocksIndexKey, data)
+
+	return nil
+}
+
+func failedBlockKey(number int32) string {
+	return fmt.Sprintf("%s%d", failedBlockKeyPrefix, number)
+}
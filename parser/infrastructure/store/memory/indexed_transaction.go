@@ -0,0 +1,561 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+)
+
+const (
+	// indexedTxKeyPrefix prefixes the single record of a transaction, keyed by hash
+	indexedTxKeyPrefix = "tx:"
+	// indexedBlockKeyPrefix prefixes the hashes of the transactions of a block, in block order
+	indexedBlockKeyPrefix = "blocktxs:"
+	// indexedAddressKeyPrefix prefixes the number of index chunks of an address, and the chunks themselves
+	indexedAddressKeyPrefix = "addresstxs:"
+
+	// addressChunkSize is the number of hashes per chunk of an address index. Appending to an address
+	// rewrites its last chunk only, so the cost of a block doesn't grow with the history of its addresses.
+	addressChunkSize = 64
+)
+
+// IndexedTransactionMemoryStore stores every transaction once, keyed by hash, and indexes it by block and by
+// both participants. Unlike TransactionMemoryStore, which rewrites the whole history of an address on every write,
+// the cost of committing a block only depends on the block, which makes it suitable for the firehose mode.
+// Backed by file.NewKeyValue, only the keys are held in memory.
+type IndexedTransactionMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+var _ transaction.IndexedRepository = (*IndexedTransactionMemoryStore)(nil)
+
+func NewIndexedTransactionMemoryStore() *IndexedTransactionMemoryStore {
+	return NewIndexedTransactionStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewIndexedTransactionStoreWithKeyValue returns a store backed by the given key-value database,
+// for example a file backed one so that transactions and the checkpoint survive restarts
+func NewIndexedTransactionStoreWithKeyValue(db storage.KeyValue) *IndexedTransactionMemoryStore {
+	return &IndexedTransactionMemoryStore{
+		db: db,
+	}
+}
+
+func (t *IndexedTransactionMemoryStore) Indexed() {}
+
+// GetAllByAddress returns the transactions sent or received by the address, in the order they were stored
+func (t *IndexedTransactionMemoryStore) GetAllByAddress(address string) (domain.Transactions, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	address = addressKey(address)
+
+	hashes, err := t.getAddressHashes(batch, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.resolve(batch, hashes, func(tx domain.Transaction) bool {
+		return slices.Contains(participants(tx), address)
+	})
+}
+
+// GetAllByBlock returns the transactions of the block, in block order
+func (t *IndexedTransactionMemoryStore) GetAllByBlock(block int32) (domain.Transactions, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	hashes, err := t.getBlockHashes(batch, block)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.resolve(batch, hashes, func(tx domain.Transaction) bool {
+		return tx.Block == block
+	})
+}
+
+func (t *IndexedTransactionMemoryStore) GetByHash(hash string) (tx domain.Transaction, found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.getTransaction(storage.NewBatch(), hash)
+}
+
+func (t *IndexedTransactionMemoryStore) Save(tx domain.Transaction) error {
+	return t.SaveAll(domain.Transactions{tx})
+}
+
+func (t *IndexedTransactionMemoryStore) SaveAll(txs domain.Transactions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := t.saveAll(batch, txs); err != nil {
+		return err
+	}
+
+	return t.db.Write(batch)
+}
+
+// Commit replaces the transactions of the checkpoint's block and moves the checkpoint in a single batch
+func (t *IndexedTransactionMemoryStore) Commit(checkpoint domain.Checkpoint, txs domain.Transactions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if _, err := t.deleteByBlock(batch, checkpoint.Number); err != nil {
+		return err
+	}
+
+	if err := t.saveAll(batch, txs); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("unable to marshal checkpoint: %w", err)
+	}
+	batch.Set(checkpointKey, data)
+
+	if err := t.db.Write(batch); err != nil {
+		return fmt.Errorf("unable to commit block %d: %w", checkpoint.Number, err)
+	}
+
+	return nil
+}
+
+func (t *IndexedTransactionMemoryStore) Replace(block int32, txs domain.Transactions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if _, err := t.deleteByBlock(batch, block); err != nil {
+		return err
+	}
+
+	if err := t.saveAll(batch, txs); err != nil {
+		return err
+	}
+
+	if err := t.db.Write(batch); err != nil {
+		return fmt.Errorf("unable to replace transactions of block %d: %w", block, err)
+	}
+
+	return nil
+}
+
+// UpdateState upgrades the confirmation state of the transactions of a block, each of them being stored once
+func (t *IndexedTransactionMemoryStore) UpdateState(block int32, state domain.ConfirmationState) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	hashes, err := t.getBlockHashes(batch, block)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		tx, found, err := t.getTransaction(batch, hash)
+		if err != nil {
+			return err
+		}
+
+		if !found || tx.Block != block || tx.State >= state {
+			continue
+		}
+
+		tx.State = state
+		if err := t.setTransaction(batch, tx); err != nil {
+			return err
+		}
+	}
+
+	return t.db.Write(batch)
+}
+
+// DeleteByBlock removes the transactions of a block and returns them,
+// it is used to roll back blocks orphaned by a chain reorganization
+func (t *IndexedTransactionMemoryStore) DeleteByBlock(block int32) (domain.Transactions, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	deleted, err := t.deleteByBlock(batch, block)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.db.Write(batch); err != nil {
+		return nil, fmt.Errorf("unable to delete transactions of block %d: %w", block, err)
+	}
+
+	return deleted, nil
+}
+
+func (t *IndexedTransactionMemoryStore) GetCheckpoint() (checkpoint domain.Checkpoint, found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := t.db.Get(checkpointKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return checkpoint, false, nil
+		}
+
+		return checkpoint, false, fmt.Errorf("unable to get checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, false, fmt.Errorf("unable to unmarshal checkpoint: %w", err)
+	}
+
+	return checkpoint, true, nil
+}
+
+func (t *IndexedTransactionMemoryStore) SaveCheckpoint(checkpoint domain.Checkpoint) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("unable to marshal checkpoint: %w", err)
+	}
+
+	batch := storage.NewBatch()
+	batch.Set(checkpointKey, data)
+
+	return t.db.Write(batch)
+}
+
+func (t *IndexedTransactionMemoryStore) saveAll(batch *storage.Batch, txs domain.Transactions) error {
+	blocks := make(map[int32][]string)
+	addresses := make(map[string][]string)
+
+	for _, tx := range txs {
+		hash := strings.ToLower(tx.TxID)
+		tx.From, tx.To = addressKey(tx.From), addressKey(tx.To)
+
+		prev, existed, err := t.getTransaction(batch, hash)
+		if err != nil {
+			return err
+		}
+
+		if err := t.setTransaction(batch, tx); err != nil {
+			return err
+		}
+
+		// saving a transaction of the same block again only updates its record, it is already indexed
+		if existed && prev.Block == tx.Block {
+			continue
+		}
+
+		blocks[tx.Block] = append(blocks[tx.Block], hash)
+		for _, address := range participants(tx) {
+			addresses[address] = append(addresses[address], hash)
+		}
+	}
+
+	for block, hashes := range blocks {
+		existing, err := t.getBlockHashes(batch, block)
+		if err != nil {
+			return err
+		}
+
+		if err := t.setList(batch, indexedBlockKey(block), append(existing, hashes...)); err != nil {
+			return err
+		}
+	}
+
+	for address, hashes := range addresses {
+		if err := t.appendAddressHashes(batch, address, hashes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *IndexedTransactionMemoryStore) deleteByBlock(batch *storage.Batch, block int32) (domain.Transactions, error) {
+	hashes, err := t.getBlockHashes(batch, block)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make(domain.Transactions, 0, len(hashes))
+	removed := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		tx, found, err := t.getTransaction(batch, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		// the hash may have been stored again from another block since
+		if !found || tx.Block != block {
+			continue
+		}
+
+		deleted = append(deleted, tx)
+		removed[hash] = struct{}{}
+		batch.Delete(indexedTxKey(hash))
+	}
+
+	trimmed := make(map[string]struct{})
+	for _, tx := range deleted {
+		for _, address := range participants(tx) {
+			if _, ok := trimmed[address]; ok {
+				continue
+			}
+			trimmed[address] = struct{}{}
+
+			if err := t.trimAddressHashes(batch, address, removed); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(hashes) > 0 {
+		batch.Delete(indexedBlockKey(block))
+	}
+
+	return deleted, nil
+}
+
+// resolve loads the transactions of the hashes that pass keep, each once
+func (t *IndexedTransactionMemoryStore) resolve(batch *storage.Batch, hashes []string, keep func(domain.Transaction) bool) (domain.Transactions, error) {
+	txns := make(domain.Transactions, 0, len(hashes))
+	seen := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+
+		tx, found, err := t.getTransaction(batch, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		// indexes can point to transactions deleted or moved to another block by Replace, they are skipped
+		if found && keep(tx) {
+			txns = append(txns, tx)
+		}
+	}
+
+	return txns, nil
+}
+
+func (t *IndexedTransactionMemoryStore) getTransaction(batch *storage.Batch, hash string) (tx domain.Transaction, found bool, err error) {
+	data, err := getStaged(t.db, batch, indexedTxKey(hash))
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return tx, false, nil
+		}
+
+		return tx, false, fmt.Errorf("unable to get transaction %s: %w", hash, err)
+	}
+
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return tx, false, fmt.Errorf("unable to unmarshal transaction %s: %w", hash, err)
+	}
+
+	return tx, true, nil
+}
+
+func (t *IndexedTransactionMemoryStore) setTransaction(batch *storage.Batch, tx domain.Transaction) error {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return fmt.Errorf("unable to marshal transaction %s: %w", tx.TxID, err)
+	}
+
+	batch.Set(indexedTxKey(tx.TxID), data)
+
+	return nil
+}
+
+func (t *IndexedTransactionMemoryStore) getBlockHashes(batch *storage.Batch, block int32) ([]string, error) {
+	return t.getList(batch, indexedBlockKey(block))
+}
+
+// getAddressHashes returns the hashes indexed under the address, chunk after chunk
+func (t *IndexedTransactionMemoryStore) getAddressHashes(batch *storage.Batch, address string) ([]string, error) {
+	chunks, err := t.getChunkCount(batch, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for i := 0; i < chunks; i++ {
+		chunk, err := t.getList(batch, indexedChunkKey(address, i))
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, chunk...)
+	}
+
+	return hashes, nil
+}
+
+// appendAddressHashes adds hashes to the last chunk of the address index, starting new chunks as they fill up
+func (t *IndexedTransactionMemoryStore) appendAddressHashes(batch *storage.Batch, address string, hashes []string) error {
+	chunks, err := t.getChunkCount(batch, address)
+	if err != nil {
+		return err
+	}
+
+	var last []string
+	if chunks > 0 {
+		if last, err = t.getList(batch, indexedChunkKey(address, chunks-1)); err != nil {
+			return err
+		}
+	} else {
+		chunks = 1
+	}
+
+	for _, hash := range hashes {
+		if len(last) == addressChunkSize {
+			if err := t.setList(batch, indexedChunkKey(address, chunks-1), last); err != nil {
+				return err
+			}
+			chunks++
+			last = nil
+		}
+		last = append(last, hash)
+	}
+
+	if err := t.setList(batch, indexedChunkKey(address, chunks-1), last); err != nil {
+		return err
+	}
+
+	return t.setChunkCount(batch, address, chunks)
+}
+
+// trimAddressHashes drops the removed hashes from the end of the address index. Blocks are rolled back
+// newest first, so their hashes are the last ones; those of a block replaced further back are skipped on read.
+func (t *IndexedTransactionMemoryStore) trimAddressHashes(batch *storage.Batch, address string, removed map[string]struct{}) error {
+	chunks, err := t.getChunkCount(batch, address)
+	if err != nil {
+		return err
+	}
+
+	for chunks > 0 {
+		key := indexedChunkKey(address, chunks-1)
+		last, err := t.getList(batch, key)
+		if err != nil {
+			return err
+		}
+
+		kept := len(last)
+		for kept > 0 {
+			if _, ok := removed[last[kept-1]]; !ok {
+				break
+			}
+			kept--
+		}
+
+		if kept > 0 {
+			if kept < len(last) {
+				if err := t.setList(batch, key, last[:kept]); err != nil {
+					return err
+				}
+			}
+			break
+		}
+
+		batch.Delete(key)
+		chunks--
+	}
+
+	return t.setChunkCount(batch, address, chunks)
+}
+
+func (t *IndexedTransactionMemoryStore) getChunkCount(batch *storage.Batch, address string) (chunks int, err error) {
+	data, err := getStaged(t.db, batch, indexedAddressKeyPrefix+address)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("unable to get index of address %s: %w", address, err)
+	}
+
+	if err := json.Unmarshal(data, &chunks); err != nil {
+		return 0, fmt.Errorf("unable to unmarshal index of address %s: %w", address, err)
+	}
+
+	return chunks, nil
+}
+
+func (t *IndexedTransactionMemoryStore) setChunkCount(batch *storage.Batch, address string, chunks int) error {
+	if chunks == 0 {
+		batch.Delete(indexedAddressKeyPrefix + address)
+		return nil
+	}
+
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		return fmt.Errorf("unable to marshal index of address %s: %w", address, err)
+	}
+
+	batch.Set(indexedAddressKeyPrefix+address, data)
+
+	return nil
+}
+
+func (t *IndexedTransactionMemoryStore) getList(batch *storage.Batch, key string) (list []string, err error) {
+	data, err := getStaged(t.db, batch, key)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s: %w", key, err)
+	}
+
+	return list, nil
+}
+
+func (t *IndexedTransactionMemoryStore) setList(batch *storage.Batch, key string, list []string) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %s: %w", key, err)
+	}
+
+	batch.Set(key, data)
+
+	return nil
+}
+
+// participants returns the addresses a transaction is indexed under: both sides, once for a transfer
+// to self, only the sender of a contract creation, and the parties of its token transfers
+func participants(tx domain.Transaction) []string {
+	return tx.Addresses()
+}
+
+// indexedTxKey is the key of the record of a transaction, hashes are case insensitive
+func indexedTxKey(hash string) string {
+	return indexedTxKeyPrefix + strings.ToLower(hash)
+}
+
+func indexedBlockKey(block int32) string {
+	return fmt.Sprintf("%s%d", indexedBlockKeyPrefix, block)
+}
+
+func indexedChunkKey(address string, chunk int) string {
+	return fmt.Sprintf("%s%s:%d", indexedAddressKeyPrefix, address, chunk)
+}
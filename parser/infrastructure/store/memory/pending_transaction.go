@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	pendingTxKeyPrefix = "pendingtx:"
+	pendingTxsIndexKey = "pendingtxs"
+)
+
+// PendingTransactionMemoryStore keeps the records of pending transactions keyed by lowercase hash
+type PendingTransactionMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+func NewPendingTransactionMemoryStore() *PendingTransactionMemoryStore {
+	return NewPendingTransactionStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewPendingTransactionStoreWithKeyValue returns a store backed by the given key-value database,
+// a durable one is needed for pending transactions to survive restarts
+func NewPendingTransactionStoreWithKeyValue(db storage.KeyValue) *PendingTransactionMemoryStore {
+	return &PendingTransactionMemoryStore{
+		db: db,
+	}
+}
+
+func (p *PendingTransactionMemoryStore) Save(tx domain.PendingTransaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hashes, err := p.getIndex()
+	if err != nil {
+		return err
+	}
+
+	hash := strings.ToLower(tx.TxID)
+	batch := storage.NewBatch()
+	if !contains(hashes, hash) {
+		if err := p.setIndex(batch, append(hashes, hash)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return fmt.Errorf("unable to marshal pending transaction %s: %w", tx.TxID, err)
+	}
+	batch.Set(pendingTxKeyPrefix+hash, data)
+
+	return p.db.Write(batch)
+}
+
+func (p *PendingTransactionMemoryStore) Delete(hash string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash = strings.ToLower(hash)
+	hashes, err := p.getIndex()
+	if err != nil {
+		return err
+	}
+
+	if !contains(hashes, hash) {
+		return nil
+	}
+
+	kept := make([]string, 0, len(hashes))
+	for _, existing := range hashes {
+		if existing != hash {
+			kept = append(kept, existing)
+		}
+	}
+
+	batch := storage.NewBatch()
+	if err := p.setIndex(batch, kept); err != nil {
+		return err
+	}
+	batch.Delete(pendingTxKeyPrefix + hash)
+
+	return p.db.Write(batch)
+}
+
+func (p *PendingTransactionMemoryStore) GetAll() ([]domain.PendingTransaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hashes, err := p.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]domain.PendingTransaction, 0, len(hashes))
+	for _, hash := range hashes {
+		data, err := p.db.Get(pendingTxKeyPrefix + hash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get pending transaction %s: %w", hash, err)
+		}
+
+		var tx domain.PendingTransaction
+		if err := json.Unmarshal(data, &tx); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal pending transaction %s: %w", hash, err)
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+func (p *PendingTransactionMemoryStore) getIndex() (hashes []string, err error) {
+	data, err := p.db.Get(pendingTxsIndexKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get pending transactions index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal pending transactions index: %w", err)
+	}
+
+	return hashes, nil
+}
+
+func (p *PendingTransactionMemoryStore) setIndex(batch *storage.Batch, hashes []string) error {
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return fmt.Errorf("unable to marshal pending transactions index: %w", err)
+	}
+
+	batch.Set(pendingTxsIndexKey, data)
+
+	return nil
+}
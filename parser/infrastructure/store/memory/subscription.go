@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	// subscriptionKeyPrefix prefixes the record of each subscription, they are listed by scanning it
+	subscriptionKeyPrefix = "subscription:"
+)
+
+type SubscriptionMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+func NewSubscriptionMemoryStore() *SubscriptionMemoryStore {
+	return NewSubscriptionStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewSubscriptionStoreWithKeyValue returns a store backed by the given key-value database,
+// a durable one is needed for subscriptions to survive restarts
+func NewSubscriptionStoreWithKeyValue(db storage.KeyValue) *SubscriptionMemoryStore {
+	return &SubscriptionMemoryStore{
+		db: db,
+	}
+}
+
+func (s *SubscriptionMemoryStore) Save(sub domain.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("unable to marshal subscription %s: %w", sub.ID, err)
+	}
+
+	return s.db.Set(subscriptionKeyPrefix+sub.ID, data)
+}
+
+func (s *SubscriptionMemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Delete(subscriptionKeyPrefix + id)
+}
+
+func (s *SubscriptionMemoryStore) GetAll() ([]domain.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subs []domain.Subscription
+	err := s.db.Scan(subscriptionKeyPrefix, func(key string, data []byte) error {
+		var sub domain.Subscription
+		if err := json.Unmarshal(data, &sub); err != nil {
+			return fmt.Errorf("unable to unmarshal subscription %s: %w", key[len(subscriptionKeyPrefix):], err)
+		}
+		subs = append(subs, sub)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get subscriptions: %w", err)
+	}
+
+	return subs, nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+
+	return false
+}
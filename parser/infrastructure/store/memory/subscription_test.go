@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+func TestSubscriptionStoreKeyPerSubscription(t *testing.T) {
+	db := memory.NewKeyValue()
+	s := NewSubscriptionStoreWithKeyValue(db)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Save(domain.Subscription{ID: id, Address: "0x" + id}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if err := s.Delete("b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	for id, stored := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, err := db.Get(subscriptionKeyPrefix + id)
+		if stored && err != nil {
+			t.Errorf("subscription %s isn't stored under its own key: %v", id, err)
+		}
+		if !stored && !errors.Is(err, storage.ErrKeyValueNotFound) {
+			t.Errorf("deleted subscription %s is still stored", id)
+		}
+	}
+
+	subs, err := s.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	if len(subs) != 2 {
+		t.Errorf("GetAll = %+v, want a and c", subs)
+	}
+}
@@ -3,26 +3,142 @@ package memory
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/mateeullahmalik/eh_parser/common/storage"
 	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
 	"github.com/mateeullahmalik/eh_parser/parser/domain"
 )
 
+const (
+	// blockKeyPrefix prefixes the index of the addresses that hold transactions from a block
+	blockKeyPrefix = "block:"
+	// txHashKeyPrefix prefixes the block a transaction hash was stored from
+	txHashKeyPrefix = "txhash:"
+	// checkpointKey holds the last committed block
+	checkpointKey = "checkpoint"
+)
+
 type TransactionMemoryStore struct {
+	// mu serialises the read-modify-write cycles on the per address lists
+	mu sync.Mutex
 	db storage.KeyValue
 }
 
 func NewTransactionMemoryStore() *TransactionMemoryStore {
+	return NewTransactionStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewTransactionStoreWithKeyValue returns a store backed by the given key-value database,
+// for example a file backed one so that transactions and the checkpoint survive restarts
+func NewTransactionStoreWithKeyValue(db storage.KeyValue) *TransactionMemoryStore {
 	return &TransactionMemoryStore{
-		db: memory.NewKeyValue(),
+		db: db,
 	}
 }
 
 func (t *TransactionMemoryStore) GetAllByAddress(address string) (txns domain.Transactions, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.getAllByAddress(storage.NewBatch(), addressKey(address))
+}
+
+func (t *TransactionMemoryStore) GetAllByBlock(block int32) (domain.Transactions, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	addresses, err := t.getBlockAddresses(batch, block)
+	if err != nil {
+		return nil, err
+	}
+
+	txns := make(domain.Transactions, 0)
+	seen := make(map[string]struct{})
+	for _, address := range addresses {
+		stored, err := t.getAllByAddress(batch, address)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range stored {
+			if tx.Block != block {
+				continue
+			}
+
+			if _, ok := seen[tx.TxID]; !ok {
+				seen[tx.TxID] = struct{}{}
+				txns = append(txns, tx)
+			}
+		}
+	}
+
+	return txns, nil
+}
+
+func (t *TransactionMemoryStore) GetByHash(hash string) (tx domain.Transaction, found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	data, err := t.get(batch, txHashKey(hash))
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return tx, false, nil
+		}
+
+		return tx, false, fmt.Errorf("unable to get block of transaction %s: %w", hash, err)
+	}
+
+	var block int32
+	if err := json.Unmarshal(data, &block); err != nil {
+		return tx, false, fmt.Errorf("unable to unmarshal block of transaction %s: %w", hash, err)
+	}
+
+	addresses, err := t.getBlockAddresses(batch, block)
+	if err != nil {
+		return tx, false, err
+	}
+
+	for _, address := range addresses {
+		txns, err := t.getAllByAddress(batch, address)
+		if err != nil {
+			return tx, false, err
+		}
+
+		for _, stored := range txns {
+			if stored.Block == block && strings.EqualFold(stored.TxID, hash) {
+				return stored, true, nil
+			}
+		}
+	}
+
+	return tx, false, nil
+}
+
+// get reads a key, seeing the writes already staged in the batch
+func (t *TransactionMemoryStore) get(batch *storage.Batch, key string) ([]byte, error) {
+	return getStaged(t.db, batch, key)
+}
+
+// getStaged reads a key from the database, seeing the writes already staged in the batch
+func getStaged(db storage.KeyValue, batch *storage.Batch, key string) ([]byte, error) {
+	if op, ok := batch.Lookup(key); ok {
+		if op.Delete {
+			return nil, storage.ErrKeyValueNotFound
+		}
+		return op.Value, nil
+	}
+
+	return db.Get(key)
+}
+
+func (t *TransactionMemoryStore) getAllByAddress(batch *storage.Batch, address string) (txns domain.Transactions, err error) {
 	txns = make(domain.Transactions, 0)
 
-	data, err := t.db.Get(address)
+	data, err := t.get(batch, address)
 	if err != nil {
 		if err == storage.ErrKeyValueNotFound {
 			return txns, nil
@@ -38,61 +154,346 @@ func (t *TransactionMemoryStore) GetAllByAddress(address string) (txns domain.Tr
 	return
 }
 
-func (t *TransactionMemoryStore) insertTransactions(id string, txs domain.Transactions) error {
-	txns, err := t.GetAllByAddress(id)
+func (t *TransactionMemoryStore) insertTransactions(batch *storage.Batch, id string, txs domain.Transactions) error {
+	txns, err := t.getAllByAddress(batch, id)
 	if err != nil {
 		return fmt.Errorf("unable to get transactions for address %s: %w", id, err)
 	}
 
 	txns = append(txns, txs...)
 
+	return t.setTransactions(batch, id, txns)
+}
+
+func (t *TransactionMemoryStore) setTransactions(batch *storage.Batch, id string, txns domain.Transactions) error {
 	data, err := json.Marshal(txns)
 	if err != nil {
 		return fmt.Errorf("unable to marshal transactions for address %s: %w", id, err)
 	}
 
-	if err := t.db.Set(id, data); err != nil {
-		return fmt.Errorf("unable to insert transactions for address %s: %w", id, err)
+	batch.Set(id, data)
+
+	return nil
+}
+
+// getBlockAddresses returns the addresses holding transactions from the block
+func (t *TransactionMemoryStore) getBlockAddresses(batch *storage.Batch, block int32) (addresses []string, err error) {
+	data, err := t.get(batch, blockKey(block))
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get index for block %d: %w", block, err)
+	}
+
+	if err := json.Unmarshal(data, &addresses); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal index for block %d: %w", block, err)
+	}
+
+	return addresses, nil
+}
+
+// indexTransactions records the addresses the transactions were stored under, keyed by block,
+// so that a block can be rolled back without scanning every address, and the block of each hash
+func (t *TransactionMemoryStore) indexTransactions(batch *storage.Batch, txs domain.Transactions) error {
+	blocks := make(map[int32]map[string]struct{})
+	for _, tx := range txs {
+		data, err := json.Marshal(tx.Block)
+		if err != nil {
+			return fmt.Errorf("unable to marshal block of transaction %s: %w", tx.TxID, err)
+		}
+		batch.Set(txHashKey(tx.TxID), data)
+
+		if blocks[tx.Block] == nil {
+			blocks[tx.Block] = make(map[string]struct{})
+		}
+		for _, address := range tx.Addresses() {
+			blocks[tx.Block][addressKey(address)] = struct{}{}
+		}
+	}
+
+	for block, addrs := range blocks {
+		existing, err := t.getBlockAddresses(batch, block)
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range existing {
+			delete(addrs, addr)
+		}
+
+		if len(addrs) == 0 {
+			continue
+		}
+
+		for addr := range addrs {
+			existing = append(existing, addr)
+		}
+
+		data, err := json.Marshal(existing)
+		if err != nil {
+			return fmt.Errorf("unable to marshal index for block %d: %w", block, err)
+		}
+
+		batch.Set(blockKey(block), data)
 	}
 
 	return nil
 }
 
-// Save inserts the transaction for both the sender and the receiver
+func blockKey(block int32) string {
+	return fmt.Sprintf("%s%d", blockKeyPrefix, block)
+}
+
+func txHashKey(hash string) string {
+	return txHashKeyPrefix + strings.ToLower(hash)
+}
+
+// addressKey is the key transactions are stored under for an address, addresses are case insensitive
+func addressKey(address string) string {
+	return strings.ToLower(address)
+}
+
+// Save inserts the transaction for the sender, the receiver and the parties of its token transfers
 // Its understood that there's an overhead of inserting the same transaction twice
 // but assuming that (a) we are concered about the overhead at this time
 // and (b) the goal here is to keep the implementation simple and flexible for other storage implementations
 // for example, the sqlite implmentaion can implement this method in a way that it only inserts the transaction once
 func (t *TransactionMemoryStore) Save(tx domain.Transaction) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
 	txs := domain.Transactions{tx}
-	if err := t.insertTransactions(tx.From, txs); err != nil {
-		return fmt.Errorf("unable to insert transaction for address %s: %w", tx.From, err)
+	for _, address := range tx.Addresses() {
+		if err := t.insertTransactions(batch, addressKey(address), txs); err != nil {
+			return fmt.Errorf("unable to insert transaction for address %s: %w", address, err)
+		}
 	}
 
-	if err := t.insertTransactions(tx.To, txs); err != nil {
-		return fmt.Errorf("unable to insert transaction for address %s: %w", tx.To, err)
+	if err := t.indexTransactions(batch, txs); err != nil {
+		return err
 	}
 
-	return nil
+	return t.db.Write(batch)
 }
 
-// SaveAll inserts transactions for both the sender and the receiver
+// SaveAll inserts transactions for the sender, the receiver and the parties of their token transfers
 // while this is understood that there's an overhead of inserting the same transaction twice
 // keeping the interface simple and flexible for other storage implementations where
 // we can batch insert transactions for an effecient insert
 func (t *TransactionMemoryStore) SaveAll(txs domain.Transactions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := t.saveAll(batch, txs); err != nil {
+		return err
+	}
+
+	return t.db.Write(batch)
+}
+
+func (t *TransactionMemoryStore) saveAll(batch *storage.Batch, txs domain.Transactions) error {
 	groupedTxs := make(map[string]domain.Transactions)
 
 	for _, tx := range txs {
-		groupedTxs[tx.From] = append(groupedTxs[tx.From], tx)
-		groupedTxs[tx.To] = append(groupedTxs[tx.To], tx)
+		for _, address := range tx.Addresses() {
+			groupedTxs[addressKey(address)] = append(groupedTxs[addressKey(address)], tx)
+		}
 	}
 
 	for address, txsForAddress := range groupedTxs {
-		if err := t.insertTransactions(address, txsForAddress); err != nil {
+		if err := t.insertTransactions(batch, address, txsForAddress); err != nil {
 			return fmt.Errorf("unable to insert transaction for address %s: %w", address, err)
 		}
 	}
 
+	return t.indexTransactions(batch, txs)
+}
+
+// Commit replaces the transactions of the checkpoint's block and moves the checkpoint in a single batch
+func (t *TransactionMemoryStore) Commit(checkpoint domain.Checkpoint, txs domain.Transactions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if _, err := t.deleteByBlock(batch, checkpoint.Number); err != nil {
+		return err
+	}
+
+	if err := t.saveAll(batch, txs); err != nil {
+		return err
+	}
+
+	if err := t.setCheckpoint(batch, checkpoint); err != nil {
+		return err
+	}
+
+	if err := t.db.Write(batch); err != nil {
+		return fmt.Errorf("unable to commit block %d: %w", checkpoint.Number, err)
+	}
+
+	return nil
+}
+
+func (t *TransactionMemoryStore) Replace(block int32, txs domain.Transactions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if _, err := t.deleteByBlock(batch, block); err != nil {
+		return err
+	}
+
+	if err := t.saveAll(batch, txs); err != nil {
+		return err
+	}
+
+	if err := t.db.Write(batch); err != nil {
+		return fmt.Errorf("unable to replace transactions of block %d: %w", block, err)
+	}
+
+	return nil
+}
+
+// UpdateState upgrades the confirmation state of the transactions of a block under every address they are stored under
+func (t *TransactionMemoryStore) UpdateState(block int32, state domain.ConfirmationState) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	addresses, err := t.getBlockAddresses(batch, block)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		txns, err := t.getAllByAddress(batch, address)
+		if err != nil {
+			return err
+		}
+
+		updated := false
+		for i := range txns {
+			if txns[i].Block == block && txns[i].State < state {
+				txns[i].State = state
+				updated = true
+			}
+		}
+
+		if !updated {
+			continue
+		}
+
+		if err := t.setTransactions(batch, address, txns); err != nil {
+			return err
+		}
+	}
+
+	return t.db.Write(batch)
+}
+
+// DeleteByBlock removes the transactions of a block from every address they were stored under,
+// it is used to roll back blocks orphaned by a chain reorganization
+func (t *TransactionMemoryStore) DeleteByBlock(block int32) (domain.Transactions, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	deleted, err := t.deleteByBlock(batch, block)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.db.Write(batch); err != nil {
+		return nil, fmt.Errorf("unable to delete transactions of block %d: %w", block, err)
+	}
+
+	return deleted, nil
+}
+
+func (t *TransactionMemoryStore) deleteByBlock(batch *storage.Batch, block int32) (domain.Transactions, error) {
+	addresses, err := t.getBlockAddresses(batch, block)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make(domain.Transactions, 0)
+	seen := make(map[string]struct{})
+	for _, address := range addresses {
+		txns, err := t.getAllByAddress(batch, address)
+		if err != nil {
+			return nil, err
+		}
+
+		kept := make(domain.Transactions, 0, len(txns))
+		for _, tx := range txns {
+			if tx.Block != block {
+				kept = append(kept, tx)
+				continue
+			}
+
+			// every transaction is stored under both participants, report it once
+			if _, ok := seen[tx.TxID]; !ok {
+				seen[tx.TxID] = struct{}{}
+				deleted = append(deleted, tx)
+				batch.Delete(txHashKey(tx.TxID))
+			}
+		}
+
+		if err := t.setTransactions(batch, address, kept); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(addresses) > 0 {
+		batch.Delete(blockKey(block))
+	}
+
+	return deleted, nil
+}
+
+func (t *TransactionMemoryStore) GetCheckpoint() (checkpoint domain.Checkpoint, found bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := t.db.Get(checkpointKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return checkpoint, false, nil
+		}
+
+		return checkpoint, false, fmt.Errorf("unable to get checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, false, fmt.Errorf("unable to unmarshal checkpoint: %w", err)
+	}
+
+	return checkpoint, true, nil
+}
+
+func (t *TransactionMemoryStore) SaveCheckpoint(checkpoint domain.Checkpoint) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := storage.NewBatch()
+	if err := t.setCheckpoint(batch, checkpoint); err != nil {
+		return err
+	}
+
+	return t.db.Write(batch)
+}
+
+func (t *TransactionMemoryStore) setCheckpoint(batch *storage.Batch, checkpoint domain.Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("unable to marshal checkpoint: %w", err)
+	}
+
+	batch.Set(checkpointKey, data)
+
 	return nil
 }
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage"
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	watchlistKeyPrefix = "watchlist:"
+	watchlistsIndexKey = "watchlists"
+)
+
+type WatchlistMemoryStore struct {
+	mu sync.Mutex
+	db storage.KeyValue
+}
+
+func NewWatchlistMemoryStore() *WatchlistMemoryStore {
+	return NewWatchlistStoreWithKeyValue(memory.NewKeyValue())
+}
+
+// NewWatchlistStoreWithKeyValue returns a store backed by the given key-value database,
+// a durable one is needed for watchlists to survive restarts
+func NewWatchlistStoreWithKeyValue(db storage.KeyValue) *WatchlistMemoryStore {
+	return &WatchlistMemoryStore{
+		db: db,
+	}
+}
+
+func (l *WatchlistMemoryStore) Save(w domain.Watchlist) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names, err := l.getIndex()
+	if err != nil {
+		return err
+	}
+
+	batch := storage.NewBatch()
+	if !contains(names, w.Name) {
+		if err := l.setIndex(batch, append(names, w.Name)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("unable to marshal watchlist %s: %w", w.Name, err)
+	}
+	batch.Set(watchlistKeyPrefix+w.Name, data)
+
+	return l.db.Write(batch)
+}
+
+func (l *WatchlistMemoryStore) Delete(name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names, err := l.getIndex()
+	if err != nil {
+		return err
+	}
+
+	if !contains(names, name) {
+		return nil
+	}
+
+	kept := make([]string, 0, len(names))
+	for _, existing := range names {
+		if existing != name {
+			kept = append(kept, existing)
+		}
+	}
+
+	batch := storage.NewBatch()
+	if err := l.setIndex(batch, kept); err != nil {
+		return err
+	}
+	batch.Delete(watchlistKeyPrefix + name)
+
+	return l.db.Write(batch)
+}
+
+func (l *WatchlistMemoryStore) GetAll() ([]domain.Watchlist, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names, err := l.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]domain.Watchlist, 0, len(names))
+	for _, name := range names {
+		data, err := l.db.Get(watchlistKeyPrefix + name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get watchlist %s: %w", name, err)
+		}
+
+		var w domain.Watchlist
+		if err := json.Unmarshal(data, &w); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal watchlist %s: %w", name, err)
+		}
+		lists = append(lists, w)
+	}
+
+	return lists, nil
+}
+
+func (l *WatchlistMemoryStore) getIndex() (names []string, err error) {
+	data, err := l.db.Get(watchlistsIndexKey)
+	if err != nil {
+		if err == storage.ErrKeyValueNotFound {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unable to get watchlists index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal watchlists index: %w", err)
+	}
+
+	return names, nil
+}
+
+func (l *WatchlistMemoryStore) setIndex(batch *storage.Batch, names []string) error {
+	data, err := json.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("unable to marshal watchlists index: %w", err)
+	}
+
+	batch.Set(watchlistsIndexKey, data)
+
+	return nil
+}
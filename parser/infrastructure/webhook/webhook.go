@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	timeout = 10 * time.Second
+
+	// HeaderSignature carries "sha256=" followed by the hex HMAC-SHA256 of the body
+	HeaderSignature = "X-Signature-256"
+	// HeaderIdempotencyKey is the same on every attempt of a delivery, receivers use it to drop duplicates
+	HeaderIdempotencyKey = "Idempotency-Key"
+)
+
+type Sender struct {
+	httpClient *http.Client
+}
+
+func NewSender() *Sender {
+	return NewSenderWithClient(&http.Client{Timeout: timeout})
+}
+
+func NewSenderWithClient(httpClient *http.Client) *Sender {
+	return &Sender{
+		httpClient: httpClient,
+	}
+}
+
+// Send posts the delivery payload, any 2xx response acknowledges it
+func (s *Sender) Send(ctx context.Context, d domain.Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return fmt.Errorf("unable to create request for %s: %w", d.URL, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderIdempotencyKey, d.ID)
+	if d.Signature != "" {
+		req.Header.Set(HeaderSignature, "sha256="+d.Signature)
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post to %s: %w", d.URL, err)
+	}
+	defer res.Body.Close()
+
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("post to %s returned status %d", d.URL, res.StatusCode)
+	}
+
+	return nil
+}
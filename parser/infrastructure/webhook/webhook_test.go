@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		signature string
+		wantErr   bool
+	}{
+		{name: "acknowledged", status: http.StatusOK, signature: "abcd"},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "redirect is not an acknowledgement", status: http.StatusNotModified, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			d := domain.Delivery{ID: "delivery-1", URL: server.URL, Payload: []byte(`{"id":"delivery-1"}`), Signature: tt.signature}
+			err := NewSender().Send(context.Background(), d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("request is %s %s, want a JSON post", got.Method, got.Header.Get("Content-Type"))
+			}
+
+			if key := got.Header.Get(HeaderIdempotencyKey); key != d.ID {
+				t.Errorf("idempotency key = %q, want %q", key, d.ID)
+			}
+
+			wantSignature := ""
+			if tt.signature != "" {
+				wantSignature = "sha256=" + tt.signature
+			}
+
+			if signature := got.Header.Get(HeaderSignature); signature != wantSignature {
+				t.Errorf("signature = %q, want %q", signature, wantSignature)
+			}
+
+			if string(body) != string(d.Payload) {
+				t.Errorf("body = %s, want %s", body, d.Payload)
+			}
+		})
+	}
+}
+
+func TestSendUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	if err := NewSender().Send(context.Background(), domain.Delivery{ID: "delivery-1", URL: server.URL}); err == nil {
+		t.Error("Send to a closed server succeeded")
+	}
+}
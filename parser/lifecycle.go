@@ -0,0 +1,246 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// RunState is the lifecycle state of the parser
+type RunState int
+
+const (
+	// RunStateStopped is the state before the first Run and after a clean stop
+	RunStateStopped RunState = iota
+	// RunStateStarting is the state while Run loads the checkpoint and the subscriptions
+	RunStateStarting
+	// RunStateRunning is the state while the parser follows the chain
+	RunStateRunning
+	// RunStateStopping is the state while the in-flight block is drained and the background work ends
+	RunStateStopping
+	// RunStateFailed is the state after the parser stopped on an error, Wait returns it
+	RunStateFailed
+)
+
+func (s RunState) String() string {
+	switch s {
+	case RunStateStopped:
+		return "stopped"
+	case RunStateStarting:
+		return "starting"
+	case RunStateRunning:
+		return "running"
+	case RunStateStopping:
+		return "stopping"
+	case RunStateFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("RunState(%d)", int(s))
+	}
+}
+
+// active reports whether the state belongs to a run that hasn't ended yet
+func (s RunState) active() bool {
+	return s == RunStateStarting || s == RunStateRunning || s == RunStateStopping
+}
+
+// State returns the current lifecycle state
+func (c *Client) State() RunState {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+
+	return c.state
+}
+
+// OnStateChange registers a handler that is called on every lifecycle transition.
+// Handlers are called synchronously from the goroutine making the transition and must not block.
+func (c *Client) OnStateChange(handler func(from, to RunState)) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.stateHandlers = append(c.stateHandlers, handler)
+}
+
+// transition moves to the given state if the current one is accepted by from
+func (c *Client) transition(to RunState, from func(RunState) bool) bool {
+	c.lifeMu.Lock()
+	prev := c.state
+	if !from(prev) {
+		c.lifeMu.Unlock()
+		return false
+	}
+	c.state = to
+	c.lifeMu.Unlock()
+
+	c.emitStateChange(prev, to)
+
+	return true
+}
+
+func (c *Client) emitStateChange(from, to RunState) {
+	c.stateMu.RLock()
+	handlers := c.stateHandlers
+	c.stateMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(from, to)
+	}
+}
+
+// Stop stops following the chain once the block being committed is stored, waits for the backfills
+// and the webhook dispatcher to end and flushes the checkpoint. When ctx expires first the in-flight work
+// is cancelled, blocks being committed atomically nothing partial is stored, and ctx.Err() is returned.
+// Stopping a parser that isn't running does nothing.
+func (c *Client) Stop(ctx context.Context) error {
+	stopped := c.transition(RunStateStopping, func(s RunState) bool {
+		return s == RunStateStarting || s == RunStateRunning
+	})
+
+	c.lifeMu.Lock()
+	state, done, stopping, cancel := c.state, c.done, c.stopping, c.cancelRun
+	c.lifeMu.Unlock()
+
+	if !state.active() {
+		return nil
+	}
+
+	if stopped {
+		atomic.StoreInt32(&c.isRunning, 0)
+		close(stopping)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until the current run ends and returns the error that ended it,
+// nil when it was stopped or its context cancelled. It returns right away if the parser never ran.
+func (c *Client) Wait() error {
+	c.lifeMu.Lock()
+	done := c.done
+	c.lifeMu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	<-done
+
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+
+	return c.runErr
+}
+
+// Pause stops following the chain tip: the block being committed is stored, then no new block is processed
+// until Resume. Subscriptions, webhooks, the mempool and rescans carry on. Pausing lasts across runs.
+func (c *Client) Pause() {
+	if c.paused.CompareAndSwap(false, true) {
+		c.logger.Infof("Tip-following paused")
+	}
+}
+
+// Resume starts following the chain tip again from the last processed block, on the next check for new blocks
+func (c *Client) Resume() {
+	if c.paused.CompareAndSwap(true, false) {
+		c.logger.Infof("Tip-following resumed")
+	}
+}
+
+// Paused reports whether tip-following is paused
+func (c *Client) Paused() bool {
+	return c.paused.Load()
+}
+
+// stopRequested reports whether Stop was called, the processing loop checks it between blocks
+func (c *Client) stopRequested() bool {
+	select {
+	case <-c.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// goWorker runs background work tied to the current run, such as a backfill,
+// Stop waits for it to return after cancelling its context. It reports false, running nothing, outside of a run.
+func (c *Client) goWorker(work func(ctx context.Context)) bool {
+	c.lifeMu.Lock()
+	defer c.lifeMu.Unlock()
+
+	if c.state != RunStateStarting && c.state != RunStateRunning {
+		return false
+	}
+
+	ctx := c.runCtx
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		work(ctx)
+	}()
+
+	return true
+}
+
+// reset clears the state of the processing loop so that a new run resumes from the checkpoint like a fresh client
+func (c *Client) reset() {
+	c.headers.reset()
+	c.positioned = false
+	c.confirmedBlock = 0
+	c.finalizedBlock = 0
+	c.unenqueued = nil
+	c.tip.Store(nil)
+	c.resetBalances()
+}
+
+// finish ends the run: it cancels the background work, waits for it and flushes the checkpoint
+func (c *Client) finish(err error) {
+	c.transition(RunStateStopping, func(s RunState) bool {
+		return s == RunStateStarting || s == RunStateRunning
+	})
+	atomic.StoreInt32(&c.isRunning, 0)
+
+	c.cancelRun()
+	c.workers.Wait()
+
+	if ferr := c.flushCheckpoint(); ferr != nil {
+		if err == nil {
+			err = ferr
+		} else {
+			c.logger.Errorf("Error flushing checkpoint: %v", ferr)
+		}
+	}
+
+	final := RunStateStopped
+	if err != nil {
+		final = RunStateFailed
+	}
+
+	c.lifeMu.Lock()
+	c.state = final
+	c.runErr = err
+	close(c.done)
+	c.lifeMu.Unlock()
+
+	c.emitStateChange(RunStateStopping, final)
+}
+
+// flushCheckpoint writes the last processed block as the checkpoint. Commits and rollbacks already move it
+// along with the processed chain, this leaves the store consistent whatever the run was interrupted by.
+func (c *Client) flushCheckpoint() error {
+	last, ok := c.headers.last()
+	if !ok {
+		return nil
+	}
+
+	if err := c.txnStore.SaveCheckpoint(c.checkpointAt(last)); err != nil {
+		return fmt.Errorf("error flushing checkpoint at block %d: %w", last.Number, err)
+	}
+
+	return nil
+}
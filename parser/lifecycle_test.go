@@ -0,0 +1,276 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// transitions records the state changes of a client
+type transitions struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+func (tr *transitions) record(from, to RunState) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.changes = append(tr.changes, fmt.Sprintf("%s->%s", from, to))
+}
+
+// take returns the changes recorded since the last call
+func (tr *transitions) take() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	changes := tr.changes
+	tr.changes = nil
+
+	return changes
+}
+
+func expectTransitions(t *testing.T, tr *transitions, want ...string) {
+	t.Helper()
+
+	got := tr.take()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("transitions %v, want %v", got, want)
+	}
+}
+
+// blockingSink holds the write of a block until it is released
+type blockingSink struct {
+	block   int32
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s blockingSink) Write(ctx context.Context, b domain.Block) error {
+	if b.Number != s.block {
+		return nil
+	}
+
+	close(s.entered)
+	<-s.release
+
+	return nil
+}
+
+// checkpointRecorder records the checkpoints saved outside of commits
+type checkpointRecorder struct {
+	transaction.Repository
+
+	mu    sync.Mutex
+	saved []int32
+}
+
+func (r *checkpointRecorder) SaveCheckpoint(checkpoint domain.Checkpoint) error {
+	r.mu.Lock()
+	r.saved = append(r.saved, checkpoint.Number)
+	r.mu.Unlock()
+
+	return r.Repository.SaveCheckpoint(checkpoint)
+}
+
+func TestLifecycleStateTransitions(t *testing.T) {
+	chain := newFakeChain(3)
+	c := NewClientWithConfig(chain, memory.NewTransactionMemoryStore(), testConfig())
+
+	var tr transitions
+	c.OnStateChange(tr.record)
+	if s := c.State(); s != RunStateStopped {
+		t.Errorf("state before Run = %s, want stopped", s)
+	}
+
+	if err := c.Wait(); err != nil {
+		t.Errorf("Wait before Run = %v, want nil", err)
+	}
+
+	for run := 1; run <= 2; run++ {
+		if err := c.Run(context.Background()); err != nil {
+			t.Fatalf("Run %d: %v", run, err)
+		}
+		expectTransitions(t, &tr, "stopped->starting", "starting->running")
+
+		if err := c.Run(context.Background()); err == nil {
+			t.Errorf("Run %d succeeded while running", run)
+		}
+
+		subscribeAndSync(t, c, chain, addrA, nil)
+		if err := c.Stop(context.Background()); err != nil {
+			t.Fatalf("Stop %d: %v", run, err)
+		}
+		expectTransitions(t, &tr, "running->stopping", "stopping->stopped")
+
+		if s := c.State(); s != RunStateStopped {
+			t.Errorf("state after Stop %d = %s, want stopped", run, s)
+		}
+
+		if err := c.Wait(); err != nil {
+			t.Errorf("Wait after Stop %d = %v, want nil", run, err)
+		}
+
+		if err := c.Stop(context.Background()); err != nil {
+			t.Errorf("Stop of a stopped parser = %v, want nil", err)
+		}
+		expectTransitions(t, &tr)
+
+		// the next run picks up the blocks mined in between
+		chain.extend(2, "a")
+	}
+
+	// blocks 3 and 4 were mined between the runs
+	if block := c.GetCurrentBlock(); block != 4 {
+		t.Errorf("processed up to block %d after the second run, want 4", block)
+	}
+}
+
+func TestStopDrainsInFlightBlock(t *testing.T) {
+	chain := newFakeChain(5)
+	store := &checkpointRecorder{Repository: memory.NewTransactionMemoryStore()}
+	sink := blockingSink{block: 3, entered: make(chan struct{}), release: make(chan struct{})}
+
+	cfg := testConfig()
+	cfg.Pipeline.AddSink("blocking", sink, StageFail)
+	c := startClientWithStore(t, chain, store, cfg)
+	if _, err := c.Subscribe(addrA); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	<-sink.entered
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- c.Stop(context.Background()) }()
+	waitFor(t, "the parser to be stopping", func() bool { return c.State() == RunStateStopping })
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop returned %v before the in-flight block was stored", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(sink.release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if block := c.GetCurrentBlock(); block != 3 {
+		t.Errorf("processed up to block %d, want the in-flight block 3 and nothing after it", block)
+	}
+
+	if txs, _ := c.GetTransactionsByBlock(3); len(txs) != 1 {
+		t.Errorf("in-flight block holds %+v, want its transaction stored", txs)
+	}
+
+	checkpoint, found, err := store.GetCheckpoint()
+	if err != nil || !found || checkpoint.Number != 3 {
+		t.Errorf("checkpoint = %+v, %v, %v, want block 3", checkpoint, found, err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if len(store.saved) != 1 || store.saved[0] != 3 {
+		t.Errorf("checkpoints flushed %v, want block 3 flushed once on stop", store.saved)
+	}
+}
+
+func TestStopCancelsInFlightBlockWhenContextExpires(t *testing.T) {
+	chain := newFakeChain(5)
+	sink := blockingSink{block: 3, entered: make(chan struct{}), release: make(chan struct{})}
+	defer close(sink.release)
+
+	cfg := testConfig()
+	cfg.Pipeline.AddSink("blocking", cancellableSink{sink}, StageFail)
+	c := startClient(t, chain, cfg)
+	if _, err := c.Subscribe(addrA); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	<-sink.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop = %v, want the deadline of its context", err)
+	}
+
+	if txs, _ := c.GetTransactionsByBlock(3); len(txs) != 0 {
+		t.Errorf("cancelled block holds %+v, want nothing stored", txs)
+	}
+
+	if s := c.State(); s != RunStateStopped {
+		t.Errorf("state after Stop = %s, want stopped", s)
+	}
+}
+
+// cancellableSink is a blockingSink giving up when its context is cancelled
+type cancellableSink struct {
+	blockingSink
+}
+
+func (s cancellableSink) Write(ctx context.Context, b domain.Block) error {
+	if b.Number != s.block {
+		return nil
+	}
+
+	close(s.entered)
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestWaitReturnsTerminalError(t *testing.T) {
+	chain := newFakeChain(3)
+	chain.fetchErr[2] = errors.New("node unavailable")
+
+	cfg := testConfig()
+	cfg.BlockFailure.Retries = 0
+	cfg.ErrorHandler = func(err error) error { return err }
+	c := NewClientWithConfig(chain, memory.NewTransactionMemoryStore(), cfg)
+
+	var tr transitions
+	c.OnStateChange(tr.record)
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := c.Subscribe(addrA); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "node unavailable") {
+			t.Errorf("Wait = %v, want the error that stopped the parser", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait didn't return after the parser failed")
+	}
+
+	if s := c.State(); s != RunStateFailed {
+		t.Errorf("state = %s, want failed", s)
+	}
+	expectTransitions(t, &tr, "stopped->starting", "starting->running", "running->stopping", "stopping->failed")
+
+	// a failed parser can be run again
+	delete(chain.fetchErr, 2)
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run after failure: %v", err)
+	}
+	t.Cleanup(func() { c.Stop(context.Background()) })
+	waitForBlock(t, c, chain.head())
+	expectTransitions(t, &tr, "failed->starting", "starting->running")
+}
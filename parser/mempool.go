@@ -0,0 +1,399 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+// pendingChange is a record that changed, along with the event telling its subscriptions about it
+type pendingChange struct {
+	eventType domain.EventType
+	tx        domain.PendingTransaction
+}
+
+// runMempoolWatcher polls the mempool for transactions of the watched addresses
+// and settles the ones that stayed pending for too long
+func (c *Client) runMempoolWatcher(ctx context.Context) {
+	for {
+		err := c.pollMempool(ctx)
+		if errors.Is(err, ethereum.ErrNotSupported) {
+			c.logger.Errorf("Not watching the mempool: %v", err)
+			return
+		}
+
+		if err != nil && ctx.Err() == nil {
+			c.logger.Warnf("Error polling the mempool: %v", err)
+		}
+
+		c.expirePending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(c.config.Mempool.PollInterval):
+		}
+	}
+}
+
+func (c *Client) pollMempool(ctx context.Context) error {
+	watched := c.watched.Load()
+	if watched.len() == 0 {
+		return nil
+	}
+
+	// the transactions found before an error are still returned
+	txs, err := c.ethClient.GetPendingTransactions(ctx, c.subscriptionFilter(watched))
+	for _, tx := range txs {
+		c.trackPending(ctx, tx)
+	}
+
+	return err
+}
+
+// trackPending starts following a transaction seen in the mempool. Pending transactions it outbids
+// for the same sender and nonce are settled as replaced.
+func (c *Client) trackPending(ctx context.Context, tx domain.Transaction) {
+	now := c.clock.Now().UTC()
+	hash := strings.ToLower(tx.TxID)
+
+	c.pendingMu.Lock()
+	if _, ok := c.pending[hash]; ok {
+		c.pendingMu.Unlock()
+		return
+	}
+
+	p := &domain.PendingTransaction{Transaction: tx, Status: domain.PendingStatusPending, FirstSeen: now, UpdatedAt: now}
+	changes := []pendingChange{{eventType: domain.EventPending, tx: *p}}
+
+	slot := tx.SenderNonce()
+	for _, other := range append([]string(nil), c.pendingSlots[slot]...) {
+		prev := c.pending[other]
+		if higherFee(tx, prev.Transaction) {
+			changes = append(changes, c.settle(prev, domain.PendingStatusReplaced, now, func(prev *domain.PendingTransaction) {
+				prev.ReplacedBy = tx.TxID
+			}))
+		}
+	}
+
+	c.pending[hash] = p
+	c.addPendingSlot(tx, hash)
+	c.savePending(*p)
+	c.metrics.IncCounter(metricPendingSeen, 1)
+	c.updatePendingGauge()
+	c.pendingMu.Unlock()
+
+	c.publishPending(ctx, changes)
+}
+
+// settleMined settles the transactions seen in the mempool and included in a committed block as mined,
+// even those already thought replaced or dropped, and the pending ones taking the same sender and nonce as replaced
+func (c *Client) settleMined(ctx context.Context, txs domain.Transactions) {
+	now := c.clock.Now().UTC()
+
+	c.pendingMu.Lock()
+	if len(c.pending) == 0 {
+		c.pendingMu.Unlock()
+		return
+	}
+
+	var changes []pendingChange
+	for _, tx := range txs {
+		if p, ok := c.pending[strings.ToLower(tx.TxID)]; ok && p.Status != domain.PendingStatusMined {
+			changes = append(changes, c.settle(p, domain.PendingStatusMined, now, func(p *domain.PendingTransaction) {
+				p.Transaction = tx
+				p.ReplacedBy = ""
+			}))
+		}
+
+		for _, other := range append([]string(nil), c.pendingSlots[tx.SenderNonce()]...) {
+			changes = append(changes, c.settle(c.pending[other], domain.PendingStatusReplaced, now, func(p *domain.PendingTransaction) {
+				p.ReplacedBy = tx.TxID
+			}))
+		}
+	}
+	c.updatePendingGauge()
+	c.pendingMu.Unlock()
+
+	c.publishPending(ctx, changes)
+}
+
+// unsettleReverted puts the mined transactions reverted by a reorg back to pending, they usually return to the mempool
+func (c *Client) unsettleReverted(ctx context.Context, reverted domain.Transactions) {
+	now := c.clock.Now().UTC()
+
+	c.pendingMu.Lock()
+	var changes []pendingChange
+	for _, tx := range reverted {
+		hash := strings.ToLower(tx.TxID)
+		p, ok := c.pending[hash]
+		if !ok || p.Status != domain.PendingStatusMined {
+			continue
+		}
+
+		p.Status = domain.PendingStatusPending
+		p.Block = 0
+		p.State = domain.StatePending
+		p.UpdatedAt = now
+		c.addPendingSlot(p.Transaction, hash)
+		c.savePending(*p)
+		changes = append(changes, pendingChange{eventType: domain.EventPending, tx: *p})
+	}
+	c.updatePendingGauge()
+	c.pendingMu.Unlock()
+
+	c.publishPending(ctx, changes)
+}
+
+// expirePending looks up the transactions pending for longer than the drop timeout, settling them as mined
+// if they were, and as dropped otherwise. Settled records past their retention are deleted.
+func (c *Client) expirePending(ctx context.Context) {
+	cfg := c.config.Mempool
+	now := c.clock.Now().UTC()
+
+	c.pendingMu.Lock()
+	var stale []domain.Transaction
+	for hash, p := range c.pending {
+		switch {
+		case p.Settled() && now.Sub(p.UpdatedAt) >= cfg.Retention:
+			delete(c.pending, hash)
+			if cfg.Transactions != nil {
+				if err := cfg.Transactions.Delete(hash); err != nil {
+					c.logger.Errorf("Error deleting pending transaction %s: %v", p.TxID, err)
+				}
+			}
+		case !p.Settled() && now.Sub(p.UpdatedAt) >= cfg.DropTimeout:
+			stale = append(stale, p.Transaction)
+		}
+	}
+	c.pendingMu.Unlock()
+
+	for _, tx := range stale {
+		found, ok, err := c.ethClient.GetTransactionByHash(ctx, tx.TxID)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Warnf("Error looking up pending transaction %s: %v", tx.TxID, err)
+			}
+			continue
+		}
+
+		status := domain.PendingStatusDropped
+		if ok && found.Block > 0 {
+			status = domain.PendingStatusMined
+		}
+
+		c.pendingMu.Lock()
+		var changes []pendingChange
+		if p, ok := c.pending[strings.ToLower(tx.TxID)]; ok && !p.Settled() {
+			changes = append(changes, c.settle(p, status, c.clock.Now().UTC(), func(p *domain.PendingTransaction) {
+				if status == domain.PendingStatusMined {
+					p.Block = found.Block
+				}
+			}))
+		}
+		c.updatePendingGauge()
+		c.pendingMu.Unlock()
+
+		c.publishPending(ctx, changes)
+	}
+}
+
+// settle moves a pending record to a final status, it is called with pendingMu held
+func (c *Client) settle(p *domain.PendingTransaction, status domain.PendingStatus, now time.Time, update func(p *domain.PendingTransaction)) pendingChange {
+	c.removePendingSlot(p.Transaction, strings.ToLower(p.TxID))
+
+	update(p)
+	p.Status = status
+	p.UpdatedAt = now
+
+	c.savePending(*p)
+
+	return pendingChange{eventType: pendingEvents[status], tx: *p}
+}
+
+var pendingEvents = map[domain.PendingStatus]domain.EventType{
+	domain.PendingStatusPending:  domain.EventPending,
+	domain.PendingStatusMined:    domain.EventMined,
+	domain.PendingStatusReplaced: domain.EventReplaced,
+	domain.PendingStatusDropped:  domain.EventDropped,
+}
+
+// savePending persists a record, it is called with pendingMu held so that records are saved in the order they change
+func (c *Client) savePending(p domain.PendingTransaction) {
+	if c.config.Mempool.Transactions == nil {
+		return
+	}
+
+	if err := c.config.Mempool.Transactions.Save(p); err != nil {
+		c.logger.Errorf("Error saving pending transaction %s: %v", p.TxID, err)
+	}
+}
+
+// addPendingSlot files a pending transaction under its sender and nonce, it is called with pendingMu held
+func (c *Client) addPendingSlot(tx domain.Transaction, hash string) {
+	slot := tx.SenderNonce()
+	c.pendingSlots[slot] = append(c.pendingSlots[slot], hash)
+	c.pendingCount++
+
+	sender := strings.ToLower(tx.From)
+	if c.pendingSenders[sender] == nil {
+		c.pendingSenders[sender] = make(map[string]struct{})
+	}
+	c.pendingSenders[sender][slot] = struct{}{}
+}
+
+// removePendingSlot takes a transaction out of its sender and nonce slot, if it was pending.
+// It is called with pendingMu held.
+func (c *Client) removePendingSlot(tx domain.Transaction, hash string) {
+	slot := tx.SenderNonce()
+
+	kept := c.pendingSlots[slot][:0]
+	for _, other := range c.pendingSlots[slot] {
+		if other != hash {
+			kept = append(kept, other)
+		} else {
+			c.pendingCount--
+		}
+	}
+
+	if len(kept) > 0 {
+		c.pendingSlots[slot] = kept
+		return
+	}
+
+	delete(c.pendingSlots, slot)
+	sender := strings.ToLower(tx.From)
+	delete(c.pendingSenders[sender], slot)
+	if len(c.pendingSenders[sender]) == 0 {
+		delete(c.pendingSenders, sender)
+	}
+}
+
+// updatePendingGauge reports the number of transactions still pending, it is called with pendingMu held
+func (c *Client) updatePendingGauge() {
+	c.metrics.SetGauge(metricPendingTracked, float64(c.pendingCount))
+}
+
+// publishPending tells the subscriptions of the sender and receiver of each changed record about it
+func (c *Client) publishPending(ctx context.Context, changes []pendingChange) {
+	for _, change := range changes {
+		p := change.tx
+
+		delivered := make(map[string]struct{})
+		for _, address := range []string{p.From, p.To} {
+			value, ok := c.subscribers.Load(address)
+			if !ok {
+				continue
+			}
+
+			for _, s := range value.(*subscriber).handles() {
+				if _, ok := delivered[s.ID]; ok || !s.filter.Matches(address, p.Transaction) {
+					continue
+				}
+				delivered[s.ID] = struct{}{}
+				s.deliver(ctx, domain.Event{Type: change.eventType, Pending: &p})
+			}
+		}
+
+		if err := c.enqueuePendingWebhooks(change.eventType, p); err != nil {
+			c.logger.Errorf("Error enqueuing webhooks for pending transaction %s: %v", p.TxID, err)
+		}
+	}
+}
+
+// loadPendingTransactions restores the persisted records, the ones still pending are followed again
+func (c *Client) loadPendingTransactions() error {
+	if c.config.Mempool.Transactions == nil {
+		return nil
+	}
+
+	records, err := c.config.Mempool.Transactions.GetAll()
+	if err != nil {
+		return fmt.Errorf("error loading pending transactions: %w", err)
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	c.pending = make(map[string]*domain.PendingTransaction, len(records))
+	c.pendingSlots = make(map[string][]string)
+	c.pendingSenders = make(map[string]map[string]struct{})
+	c.pendingCount = 0
+	for i := range records {
+		p := &records[i]
+		hash := strings.ToLower(p.TxID)
+		c.pending[hash] = p
+		if !p.Settled() {
+			c.addPendingSlot(p.Transaction, hash)
+		}
+	}
+	c.updatePendingGauge()
+
+	return nil
+}
+
+// pendingFrom returns the transactions of a sender still pending, in nonce order
+func (c *Client) pendingFrom(address string) []domain.PendingTransaction {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	var txs []domain.PendingTransaction
+	for slot := range c.pendingSenders[address] {
+		for _, hash := range c.pendingSlots[slot] {
+			txs = append(txs, *c.pending[hash])
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce < txs[j].Nonce })
+
+	return txs
+}
+
+// GetPendingTransactions returns the records of the transactions of an address seen in the mempool, oldest first.
+// Settled ones are kept for the configured retention.
+func (c *Client) GetPendingTransactions(address string) ([]domain.PendingTransaction, error) {
+	address, err := domain.NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	var txs []domain.PendingTransaction
+	for _, p := range c.pending {
+		if p.From == address || p.To == address {
+			txs = append(txs, *p)
+		}
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].FirstSeen.Before(txs[j].FirstSeen) })
+
+	return txs, nil
+}
+
+// GetPendingTransaction returns the record of a transaction seen in the mempool
+func (c *Client) GetPendingTransaction(hash string) (domain.PendingTransaction, error) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	p, ok := c.pending[strings.ToLower(hash)]
+	if !ok {
+		return domain.PendingTransaction{}, fmt.Errorf("pending transaction %s not found", hash)
+	}
+
+	return *p, nil
+}
+
+// higherFee reports whether a outbids b, fees that can't be read never do
+func higherFee(a, b domain.Transaction) bool {
+	feeA, okA := new(big.Int).SetString(a.GasPrice, 0)
+	feeB, okB := new(big.Int).SetString(b.GasPrice, 0)
+
+	return okA && okB && feeA.Cmp(feeB) > 0
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// gauges keeps the last value of each gauge
+type gauges struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (g *gauges) IncCounter(name string, delta float64, labels ...metrics.Label) {}
+
+func (g *gauges) Observe(name string, value float64, labels ...metrics.Label) {}
+
+func (g *gauges) SetGauge(name string, value float64, labels ...metrics.Label) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[name] = value
+}
+
+func (g *gauges) get(name string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.values[name]
+}
+
+func TestPendingIndexedBySender(t *testing.T) {
+	sink := &gauges{values: make(map[string]float64)}
+	cfg := testConfig()
+	cfg.Metrics = sink
+	c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+	ctx := context.Background()
+
+	pending := func(id string, from string, nonce uint64, gasPrice string) domain.Transaction {
+		return domain.Transaction{TxID: id, From: from, To: addrC, Nonce: nonce, GasPrice: gasPrice}
+	}
+
+	c.trackPending(ctx, pending("a1", addrA, 1, "0x1"))
+	c.trackPending(ctx, pending("a0", addrA, 0, "0x1"))
+	c.trackPending(ctx, pending("b0", addrB, 0, "0x1"))
+	c.trackPending(ctx, pending("a1-replacement", addrA, 1, "0x2"))
+
+	ids := func(address string) []string {
+		var ids []string
+		for _, p := range c.pendingFrom(address) {
+			ids = append(ids, p.TxID)
+		}
+		return ids
+	}
+
+	if got := ids(addrA); len(got) != 2 || got[0] != "a0" || got[1] != "a1-replacement" {
+		t.Errorf("pending from A = %v, want a0 then the replacement of a1", got)
+	}
+
+	if n := sink.get(metricPendingTracked); n != 3 {
+		t.Errorf("pending gauge = %v, want 3", n)
+	}
+
+	c.settleMined(ctx, domain.Transactions{pending("a0", addrA, 0, "0x1"), pending("b0", addrB, 0, "0x1")})
+
+	if got := ids(addrB); len(got) != 0 {
+		t.Errorf("pending from B = %v after it was mined, want none", got)
+	}
+
+	if _, ok := c.pendingSenders[addrB]; ok {
+		t.Error("sender without pending transactions is still indexed")
+	}
+
+	if n := sink.get(metricPendingTracked); n != 1 {
+		t.Errorf("pending gauge = %v, want 1", n)
+	}
+}
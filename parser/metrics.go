@@ -0,0 +1,39 @@
+package parser
+
+// names of the measurements reported to Config.Metrics
+const (
+	metricTickDuration    = "parser_tick_duration_seconds"
+	metricTickErrors      = "parser_tick_errors_total" // labelled with the kind of error
+	metricBlocksProcessed = "parser_blocks_processed_total"
+	metricLatestBlock     = "parser_latest_block"
+	metricHeadBlock       = "parser_head_block"
+	metricHeadLag         = "parser_head_lag_blocks"
+	metricTxsMatched      = "parser_transactions_matched_total"
+	metricTxsStored       = "parser_transactions_stored_total"
+	metricSubscribers     = "parser_subscribers"
+	metricSubscriptions   = "parser_subscriptions"
+	metricEventsDropped   = "parser_subscription_events_dropped_total"
+	metricSlowDisconnects = "parser_slow_subscriptions_disconnected_total"
+	metricBlockRetries    = "parser_block_retries_total"
+	metricBlocksSkipped   = "parser_blocks_skipped_total"
+	metricBlocksDegraded  = "parser_blocks_degraded_total"
+	metricBlocksRescanned = "parser_blocks_rescanned_total"
+	metricAlertsRaised    = "parser_alerts_raised_total"
+	metricAlertRuleErrors = "parser_alert_rule_errors_total"
+	metricPendingSeen     = "parser_pending_transactions_seen_total"
+	metricPendingTracked  = "parser_pending_transactions"
+	metricNonceErrors     = "parser_nonce_check_errors_total"
+	metricStuckSenders    = "parser_stuck_senders"
+
+	metricBalanceDiscrepancies = "parser_balance_discrepancies_total"
+	metricBalanceErrors        = "parser_balance_reconcile_errors_total"
+
+	// transaction repository operations, labelled with the operation
+	metricRepositoryDuration = "parser_repository_operation_duration_seconds"
+	metricRepositoryErrors   = "parser_repository_errors_total"
+
+	// pipeline stages, labelled with the kind and the name of the stage
+	metricStageDuration = "parser_stage_duration_seconds"
+	metricStageErrors   = "parser_stage_errors_total"
+	metricStageDropped  = "parser_stage_dropped_transactions_total"
+)
@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/ethereum"
+)
+
+// maxNonceGaps bounds the gaps reported for a sender, a transaction with a nonce far ahead would list millions
+const maxNonceGaps = 100
+
+// runNonceChecker checks the senders tracking their nonces every check interval
+func (c *Client) runNonceChecker(ctx context.Context) {
+	for {
+		c.checkNonces(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(c.config.Nonces.CheckInterval):
+		}
+	}
+}
+
+func (c *Client) checkNonces(ctx context.Context) {
+	tracked := c.nonceTracked()
+
+	c.nonceMu.Lock()
+	for address := range c.nonces {
+		if _, ok := tracked[address]; !ok {
+			delete(c.nonces, address)
+		}
+	}
+	c.nonceMu.Unlock()
+
+	for address := range tracked {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := c.checkNonce(ctx, address); err != nil && ctx.Err() == nil {
+			c.metrics.IncCounter(metricNonceErrors, 1)
+			c.logger.Warnf("Error checking the nonces of %s: %v", address, err)
+		}
+	}
+
+	c.nonceMu.RLock()
+	stuck := 0
+	for _, state := range c.nonces {
+		if state.Stuck {
+			stuck++
+		}
+	}
+	c.nonceMu.RUnlock()
+	c.metrics.SetGauge(metricStuckSenders, float64(stuck))
+}
+
+// checkNonce compares the transaction counts the node reports for a sender with the nonces seen in committed blocks
+// and in the mempool, and raises an alert when a new gap appears or the sender becomes stuck
+func (c *Client) checkNonce(ctx context.Context, address string) (domain.NonceState, error) {
+	latest, err := c.ethClient.GetTransactionCount(ctx, address, ethereum.TagLatest)
+	if err != nil {
+		return domain.NonceState{}, fmt.Errorf("error getting the latest transaction count: %w", err)
+	}
+
+	pending, err := c.ethClient.GetTransactionCount(ctx, address, ethereum.TagPending)
+	if err != nil {
+		return domain.NonceState{}, fmt.Errorf("error getting the pending transaction count: %w", err)
+	}
+
+	waiting := c.pendingFrom(address)
+	now := c.clock.Now().UTC()
+	stuckAfter := c.config.Nonces.StuckAfter
+
+	c.nonceMu.Lock()
+	prev, ok := c.nonces[address]
+	if !ok {
+		prev = &domain.NonceState{Address: address}
+		c.nonces[address] = prev
+	}
+
+	state := domain.NonceState{Address: address, Latest: latest, Pending: pending, Observed: prev.Observed, CheckedAt: now}
+	next := state.Next()
+
+	// records left pending below the next nonce were mined or replaced unseen, they no longer wait
+	i := sort.Search(len(waiting), func(i int) bool { return waiting[i].Nonce >= next })
+	waiting = waiting[i:]
+
+	state.Gaps = nonceGaps(max(next, pending), waiting)
+	if pending > next || len(waiting) > 0 {
+		state.WaitingSince = prev.WaitingSince
+		if state.WaitingSince.IsZero() || next != prev.Next() {
+			state.WaitingSince = now
+		}
+		state.Stuck = now.Sub(state.WaitingSince) >= stuckAfter
+	}
+
+	for _, p := range waiting {
+		if now.Sub(p.UpdatedAt) >= stuckAfter {
+			state.StuckTransactions = append(state.StuckTransactions, p.TxID)
+		}
+	}
+	state.Stuck = state.Stuck || len(state.StuckTransactions) > 0
+
+	var alerts []domain.Alert
+	if newGaps(prev.Gaps, state.Gaps) {
+		alerts = append(alerts, nonceAlert(domain.AlertRuleNonceGap, "Nonce gap", state, waiting, now))
+	}
+	if state.Stuck && !prev.Stuck {
+		alerts = append(alerts, nonceAlert(domain.AlertRuleStuckTransactions, "Stuck transactions", state, waiting, now))
+	}
+
+	*prev = state
+	c.nonceMu.Unlock()
+
+	c.raiseAlerts(alerts)
+
+	return state, nil
+}
+
+// observeNonces records the nonces of the tracked senders mined in a committed block
+func (c *Client) observeNonces(txs domain.Transactions) {
+	c.nonceMu.Lock()
+	defer c.nonceMu.Unlock()
+
+	if len(c.nonces) == 0 {
+		return
+	}
+
+	for _, tx := range txs {
+		if state, ok := c.nonces[tx.From]; ok && tx.Nonce+1 > state.Observed {
+			state.Observed = tx.Nonce + 1
+		}
+	}
+}
+
+// unobserveNonces forgets the nonces seen for the senders of transactions reverted by a reorg,
+// the next check and the re-ingested blocks tell where they stand again
+func (c *Client) unobserveNonces(reverted domain.Transactions) {
+	c.nonceMu.Lock()
+	defer c.nonceMu.Unlock()
+
+	for _, tx := range reverted {
+		if state, ok := c.nonces[tx.From]; ok {
+			state.Observed = 0
+		}
+	}
+}
+
+// nonceTracked returns the addresses with a subscription tracking their nonces
+func (c *Client) nonceTracked() map[string]struct{} {
+	tracked := make(map[string]struct{})
+	c.subscribers.Range(func(key, value interface{}) bool {
+		for _, s := range value.(*subscriber).handles() {
+			if s.trackNonces {
+				tracked[key.(string)] = struct{}{}
+				break
+			}
+		}
+		return true
+	})
+
+	return tracked
+}
+
+// CheckNonces checks a sender tracking its nonces right away instead of waiting for the next check
+func (c *Client) CheckNonces(ctx context.Context, address string) (domain.NonceState, error) {
+	address, err := domain.NormalizeAddress(address)
+	if err != nil {
+		return domain.NonceState{}, err
+	}
+
+	if _, ok := c.nonceTracked()[address]; !ok {
+		return domain.NonceState{}, fmt.Errorf("address %s is not tracking its nonces", address)
+	}
+
+	return c.checkNonce(ctx, address)
+}
+
+// GetNonceState returns the last checked state of a sender tracking its nonces
+func (c *Client) GetNonceState(address string) (domain.NonceState, error) {
+	address, err := domain.NormalizeAddress(address)
+	if err != nil {
+		return domain.NonceState{}, err
+	}
+
+	c.nonceMu.RLock()
+	defer c.nonceMu.RUnlock()
+
+	state, ok := c.nonces[address]
+	if !ok || state.CheckedAt.IsZero() {
+		return domain.NonceState{}, fmt.Errorf("no nonce state for %s", address)
+	}
+
+	return *state, nil
+}
+
+// GetNonceStates returns the last checked state of every sender tracking its nonces, sorted by address
+func (c *Client) GetNonceStates() []domain.NonceState {
+	c.nonceMu.RLock()
+	defer c.nonceMu.RUnlock()
+
+	states := make([]domain.NonceState, 0, len(c.nonces))
+	for _, state := range c.nonces {
+		if !state.CheckedAt.IsZero() {
+			states = append(states, *state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Address < states[j].Address })
+
+	return states
+}
+
+// nonceGaps returns the nonces from the given one up to the highest waiting one that no waiting transaction takes
+func nonceGaps(from uint64, waiting []domain.PendingTransaction) []uint64 {
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	taken := make(map[uint64]struct{}, len(waiting))
+	for _, p := range waiting {
+		taken[p.Nonce] = struct{}{}
+	}
+
+	var gaps []uint64
+	for n := from; n < waiting[len(waiting)-1].Nonce && len(gaps) < maxNonceGaps; n++ {
+		if _, ok := taken[n]; !ok {
+			gaps = append(gaps, n)
+		}
+	}
+
+	return gaps
+}
+
+// newGaps reports whether gaps holds a nonce that prev doesn't, both being sorted
+func newGaps(prev, gaps []uint64) bool {
+	for _, gap := range gaps {
+		i := sort.Search(len(prev), func(i int) bool { return prev[i] >= gap })
+		if i == len(prev) || prev[i] != gap {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nonceAlert(ruleID, name string, state domain.NonceState, waiting []domain.PendingTransaction, now time.Time) domain.Alert {
+	alert := domain.Alert{RuleID: ruleID, RuleName: name, Nonce: &state, CreatedAt: now}
+	if len(waiting) > 0 {
+		alert.Transaction = waiting[0].Transaction
+	}
+
+	return alert
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// manualClock only moves when advanced, polling waits on the system clock
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// nonceStep sets the transaction counts of the sender, adds transactions to the mempool and checks the sender
+type nonceStep struct {
+	advance        time.Duration
+	latest         uint64
+	pending        uint64
+	waiting        []uint64 // nonces of the transactions seen in the mempool at this step
+	wantGaps       []uint64
+	wantStuck      bool
+	wantStuckTxs   []string
+	wantAlerts     []string
+	wantWaitingFor time.Duration // how long the sender has been waiting, checked when set
+}
+
+func TestCheckNonce(t *testing.T) {
+	const stuckAfter = 10 * time.Minute
+
+	tests := []struct {
+		name  string
+		steps []nonceStep
+	}{
+		{
+			name: "in order",
+			steps: []nonceStep{
+				{latest: 5, pending: 7, waiting: []uint64{5, 6}},
+			},
+		},
+		{
+			name: "gap",
+			steps: []nonceStep{
+				{latest: 5, pending: 5, waiting: []uint64{7, 9}, wantGaps: []uint64{5, 6, 8}, wantAlerts: []string{domain.AlertRuleNonceGap}},
+				// the same gaps are only reported once
+				{advance: time.Minute, latest: 5, pending: 5, wantGaps: []uint64{5, 6, 8}, wantWaitingFor: time.Minute},
+			},
+		},
+		{
+			name: "gap filled",
+			steps: []nonceStep{
+				{latest: 5, pending: 5, waiting: []uint64{7}, wantGaps: []uint64{5, 6}, wantAlerts: []string{domain.AlertRuleNonceGap}},
+				{advance: time.Minute, latest: 5, pending: 8, waiting: []uint64{5, 6}, wantWaitingFor: time.Minute},
+				// mined, nothing waits anymore
+				{advance: time.Minute, latest: 8, pending: 8},
+			},
+		},
+		{
+			name: "stuck pending nonce",
+			steps: []nonceStep{
+				{latest: 5, pending: 6, waiting: []uint64{5}},
+				{advance: stuckAfter - time.Second, latest: 5, pending: 6, wantWaitingFor: stuckAfter - time.Second},
+				{advance: time.Second, latest: 5, pending: 6, wantStuck: true, wantStuckTxs: []string{"tx-5"}, wantAlerts: []string{domain.AlertRuleStuckTransactions}, wantWaitingFor: stuckAfter},
+				// stuck senders are only reported once
+				{advance: time.Minute, latest: 5, pending: 6, wantStuck: true, wantStuckTxs: []string{"tx-5"}, wantWaitingFor: stuckAfter + time.Minute},
+				{advance: time.Minute, latest: 6, pending: 6},
+			},
+		},
+		{
+			name: "mined before the threshold",
+			steps: []nonceStep{
+				{latest: 5, pending: 6, waiting: []uint64{5}},
+				{advance: stuckAfter / 2, latest: 6, pending: 7, waiting: []uint64{6}},
+				// the next nonce moved, so the wait started over
+				{advance: stuckAfter / 2, latest: 6, pending: 7, wantWaitingFor: stuckAfter / 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newFakeChain(1)
+			clock := &manualClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			cfg := testConfig()
+			cfg.Clock = clock
+			cfg.Nonces.StuckAfter = stuckAfter
+			c := NewClientWithConfig(chain, memory.NewTransactionMemoryStore(), cfg)
+
+			var raised []string
+			c.OnAlert(func(alert domain.Alert) { raised = append(raised, alert.RuleID) })
+
+			for i, step := range tt.steps {
+				clock.advance(step.advance)
+				checkedAt := clock.Now()
+
+				chain.mu.Lock()
+				chain.counts[addrA] = [2]uint64{step.latest, step.pending}
+				chain.mu.Unlock()
+
+				for _, nonce := range step.waiting {
+					c.trackPending(context.Background(), domain.Transaction{From: addrA, To: addrB, TxID: fmt.Sprintf("tx-%d", nonce), Nonce: nonce, GasPrice: "0x1"})
+				}
+
+				raised = nil
+				state, err := c.checkNonce(context.Background(), addrA)
+				if err != nil {
+					t.Fatalf("step %d: checkNonce: %v", i, err)
+				}
+
+				if fmt.Sprint(state.Gaps) != fmt.Sprint(step.wantGaps) {
+					t.Errorf("step %d: gaps %v, want %v", i, state.Gaps, step.wantGaps)
+				}
+
+				if state.Stuck != step.wantStuck || fmt.Sprint(state.StuckTransactions) != fmt.Sprint(step.wantStuckTxs) {
+					t.Errorf("step %d: stuck %v with %v, want %v with %v", i, state.Stuck, state.StuckTransactions, step.wantStuck, step.wantStuckTxs)
+				}
+
+				if fmt.Sprint(raised) != fmt.Sprint(step.wantAlerts) {
+					t.Errorf("step %d: raised %v, want %v", i, raised, step.wantAlerts)
+				}
+
+				if step.wantWaitingFor > 0 && checkedAt.Sub(state.WaitingSince) != step.wantWaitingFor {
+					t.Errorf("step %d: waiting for %v, want %v", i, checkedAt.Sub(state.WaitingSince), step.wantWaitingFor)
+				}
+			}
+		})
+	}
+}
@@ -6,9 +6,18 @@ type Parser interface {
 	// GetCurrentBlock returns last parsed block
 	GetCurrentBlock() int
 
-	// Subscribe adds address to observer
-	Subscribe(address string) (bool, error)
+	// Subscribe adds address to observer and returns a handle delivering its transactions as they are committed
+	Subscribe(address string) (*Subscription, error)
+
+	// Unsubscribe ends a subscription, the address stops being tracked once its last subscription ends
+	Unsubscribe(id string) error
+
+	// ListSubscriptions returns a page of subscriptions matching the query and the cursor of the next page
+	ListSubscriptions(query domain.SubscriptionQuery) ([]domain.Subscription, string, error)
 
 	// GetTransactions returns list of inbound or outbound transactions for an address
 	GetTransactions(address string) (domain.Transactions, error)
+
+	// GetTransactionsWithStateFilter returns transactions for an address that reached at least the given confirmation state
+	GetTransactionsWithStateFilter(address string, minState domain.ConfirmationState) (domain.Transactions, error)
 }
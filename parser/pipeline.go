@@ -0,0 +1,225 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// Source fetches the transactions of a block matching the watched addresses. Every transaction it returns
+// has to involve a watched address and be wanted by the watched matcher when it is a domain.TransactionMatcher.
+// It is called concurrently for different blocks.
+type Source interface {
+	Fetch(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error)
+}
+
+// Filter decides whether a matched transaction goes on through the pipeline, transactions it drops are
+// neither stored nor notified. It is called concurrently for different blocks.
+type Filter interface {
+	Keep(ctx context.Context, tx domain.Transaction) (bool, error)
+}
+
+// Enricher adds to the transactions of a fetched block in place, typically to their labels.
+// It is called concurrently for different blocks.
+type Enricher interface {
+	Enrich(ctx context.Context, block *domain.Block) error
+}
+
+// Sink receives the transactions of every committed block, blocks without transactions included, before
+// they are stored. It also receives the transactions found by backfills, rescans and reprocessed blocks.
+// A block can be written again when it is processed again, such as after a failed stage or a restart.
+// It is called from one block at a time.
+type Sink interface {
+	Write(ctx context.Context, block domain.Block) error
+}
+
+// StageErrorPolicy decides what an error of a registered stage does to the block being processed
+type StageErrorPolicy int
+
+const (
+	// StageFail fails the block: nothing of it is stored and it is processed again on the next check for new blocks,
+	// the error going to the error handler
+	StageFail StageErrorPolicy = iota
+	// StageSkip logs the error and goes on without the stage for the block: a filter keeps the transaction,
+	// an enricher leaves the block as it is and a sink misses the block
+	StageSkip
+)
+
+// PipelineConfig is how the transactions of a block make their way from the node to the store:
+// they are fetched by the source, narrowed down by the filters, enriched by the enrichers in turn,
+// then written to the sinks and stored. Stages are registered before Run and run in registration order.
+type PipelineConfig struct {
+	// Source replaces the node as where the transactions of blocks come from. Source errors go through
+	// the block failure policy, the degrade policy still fetching from the node.
+	Source Source
+
+	filters   []stage[Filter]
+	enrichers []stage[Enricher]
+	sinks     []stage[Sink]
+}
+
+type stage[T any] struct {
+	name    string
+	impl    T
+	onError StageErrorPolicy
+}
+
+// AddFilter registers a filter under a name identifying it in logs and metrics
+func (p *PipelineConfig) AddFilter(name string, filter Filter, onError StageErrorPolicy) {
+	p.filters = append(p.filters, stage[Filter]{name: name, impl: filter, onError: onError})
+}
+
+// AddEnricher registers an enricher under a name identifying it in logs and metrics
+func (p *PipelineConfig) AddEnricher(name string, enricher Enricher, onError StageErrorPolicy) {
+	p.enrichers = append(p.enrichers, stage[Enricher]{name: name, impl: enricher, onError: onError})
+}
+
+// AddSink registers a sink under a name identifying it in logs and metrics
+func (p *PipelineConfig) AddSink(name string, sink Sink, onError StageErrorPolicy) {
+	p.sinks = append(p.sinks, stage[Sink]{name: name, impl: sink, onError: onError})
+}
+
+// nodeSource fetches blocks from the node, it is the default source
+type nodeSource struct {
+	client *Client
+}
+
+func (s nodeSource) Fetch(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	b, err := s.client.ethClient.GetTransactionsWithAddressesFilter(ctx, block, watched)
+	return b, withKind(errorKindRPC, err)
+}
+
+// source returns the configured source, the node by default
+func (c *Client) source() Source {
+	if c.config.Pipeline.Source != nil {
+		return c.config.Pipeline.Source
+	}
+
+	return nodeSource{client: c}
+}
+
+// fetchSource fetches a block from the source, without retrying
+func (c *Client) fetchSource(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	start := time.Now()
+	b, err := c.source().Fetch(ctx, block, watched)
+	c.observeStage("source", "source", start, err)
+
+	var tagged *kindError
+	if err != nil && !errors.As(err, &tagged) {
+		err = withKind(errorKindSource, err)
+	}
+
+	return b, err
+}
+
+// fetchTransactions fetches a block from the source without retrying, then runs the filters and enrichers on it
+func (c *Client) fetchTransactions(ctx context.Context, block int32, watched domain.AddressMatcher) (domain.Block, error) {
+	b, err := c.fetchSource(ctx, block, watched)
+	if err != nil {
+		return domain.Block{}, err
+	}
+
+	if err := c.transformBlock(ctx, &b); err != nil {
+		return domain.Block{}, err
+	}
+
+	return b, nil
+}
+
+// transformBlock runs the filters then the enrichers on the transactions of a fetched block
+func (c *Client) transformBlock(ctx context.Context, b *domain.Block) error {
+	c.metrics.IncCounter(metricTxsMatched, float64(len(b.Transactions)))
+
+	for _, f := range c.config.Pipeline.filters {
+		kept := make(domain.Transactions, 0, len(b.Transactions))
+		for _, tx := range b.Transactions {
+			start := time.Now()
+			keep, err := f.impl.Keep(ctx, tx)
+			c.observeStage("filter", f.name, start, err)
+
+			if err != nil {
+				if serr := stageError(c, "filter", f, b.Number, err); serr != nil {
+					return serr
+				}
+				keep = true
+			}
+
+			if keep {
+				kept = append(kept, tx)
+			} else {
+				c.metrics.IncCounter(metricStageDropped, 1, metrics.Label{Name: "kind", Value: "filter"}, metrics.Label{Name: "stage", Value: f.name})
+			}
+		}
+		b.Transactions = kept
+	}
+
+	for _, e := range c.config.Pipeline.enrichers {
+		if len(b.Transactions) == 0 {
+			break
+		}
+
+		// enrich a copy, so that a skipped enricher doesn't leave the block half enriched
+		enriched := *b
+		enriched.Transactions = append(domain.Transactions(nil), b.Transactions...)
+		for i := range enriched.Transactions {
+			enriched.Transactions[i].Labels = maps.Clone(enriched.Transactions[i].Labels)
+		}
+
+		start := time.Now()
+		err := e.impl.Enrich(ctx, &enriched)
+		c.observeStage("enricher", e.name, start, err)
+
+		if err != nil {
+			if serr := stageError(c, "enricher", e, b.Number, err); serr != nil {
+				return serr
+			}
+			continue
+		}
+
+		*b = enriched
+	}
+
+	return nil
+}
+
+// writeSinks writes a block to every sink
+func (c *Client) writeSinks(ctx context.Context, b domain.Block) error {
+	for _, s := range c.config.Pipeline.sinks {
+		start := time.Now()
+		err := s.impl.Write(ctx, b)
+		c.observeStage("sink", s.name, start, err)
+
+		if err != nil {
+			if serr := stageError(c, "sink", s, b.Number, err); serr != nil {
+				return serr
+			}
+		}
+	}
+
+	return nil
+}
+
+// stageError applies the error policy of a stage, it returns the error failing the block or nil to go on
+func stageError[T any](c *Client, kind string, s stage[T], block int32, err error) error {
+	if s.onError == StageFail {
+		return withKind(errorKindStage, fmt.Errorf("%s %s failed on block %d: %w", kind, s.name, block, err))
+	}
+
+	c.logger.Warnf("Skipping %s %s on block %d: %v", kind, s.name, block, err)
+
+	return nil
+}
+
+// observeStage records the latency, measured with the system clock, and the error of a stage
+func (c *Client) observeStage(kind, name string, start time.Time, err error) {
+	labels := []metrics.Label{{Name: "kind", Value: kind}, {Name: "stage", Value: name}}
+	c.metrics.Observe(metricStageDuration, time.Since(start).Seconds(), labels...)
+	if err != nil {
+		c.metrics.IncCounter(metricStageErrors, 1, labels...)
+	}
+}
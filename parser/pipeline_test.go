@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// calls records the order stages are called in
+type calls struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (c *calls) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.names = append(c.names, name)
+}
+
+// failingFilter fails while fail is set, then keeps every transaction
+type failingFilter struct {
+	mu   sync.Mutex
+	fail bool
+}
+
+func (f *failingFilter) Keep(ctx context.Context, tx domain.Transaction) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fail {
+		return false, errors.New("filter unavailable")
+	}
+
+	return true, nil
+}
+
+func (f *failingFilter) recover() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fail = false
+}
+
+// orderedFilter records its call and drops the transactions with the given id
+type orderedFilter struct {
+	name  string
+	calls *calls
+	drop  string
+}
+
+func (f orderedFilter) Keep(ctx context.Context, tx domain.Transaction) (bool, error) {
+	f.calls.add(f.name)
+	return tx.TxID != f.drop, nil
+}
+
+// labelEnricher records its call and labels every transaction with its name, along with the labels it saw.
+// It fails after labelling when err is set.
+type labelEnricher struct {
+	name  string
+	calls *calls
+	err   error
+}
+
+func (e labelEnricher) Enrich(ctx context.Context, block *domain.Block) error {
+	e.calls.add(e.name)
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if tx.Labels == nil {
+			tx.Labels = make(map[string]string)
+		}
+		tx.Labels[e.name] = "seen " + labelNames(tx.Labels)
+	}
+
+	return e.err
+}
+
+func labelNames(labels map[string]string) string {
+	names := ""
+	for _, name := range []string{"first", "failing", "second"} {
+		if _, ok := labels[name]; ok {
+			names += name + " "
+		}
+	}
+
+	return names
+}
+
+// recordingSink records its call and the blocks written to it
+type recordingSink struct {
+	name   string
+	calls  *calls
+	blocks *[]domain.Block
+}
+
+func (s recordingSink) Write(ctx context.Context, block domain.Block) error {
+	s.calls.add(s.name)
+	*s.blocks = append(*s.blocks, block)
+	return nil
+}
+
+func TestPipelineOrder(t *testing.T) {
+	var order calls
+	var written []domain.Block
+
+	cfg := testConfig()
+	cfg.Pipeline.AddFilter("drop-a", orderedFilter{name: "filter 1", calls: &order, drop: "a"}, StageFail)
+	cfg.Pipeline.AddFilter("drop-b", orderedFilter{name: "filter 2", calls: &order, drop: "b"}, StageFail)
+	cfg.Pipeline.AddEnricher("first", labelEnricher{name: "first", calls: &order}, StageFail)
+	cfg.Pipeline.AddEnricher("second", labelEnricher{name: "second", calls: &order}, StageFail)
+	cfg.Pipeline.AddSink("sink 1", recordingSink{name: "sink 1", calls: &order, blocks: &written}, StageFail)
+	cfg.Pipeline.AddSink("sink 2", recordingSink{name: "sink 2", calls: &order, blocks: &written}, StageFail)
+	c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+
+	b := domain.Block{Transactions: domain.Transactions{{TxID: "a"}, {TxID: "b"}, {TxID: "c"}}}
+	if err := c.transformBlock(context.Background(), &b); err != nil {
+		t.Fatalf("transformBlock: %v", err)
+	}
+	if err := c.writeSinks(context.Background(), b); err != nil {
+		t.Fatalf("writeSinks: %v", err)
+	}
+
+	// the second filter only sees what the first one kept
+	want := []string{"filter 1", "filter 1", "filter 1", "filter 2", "filter 2", "first", "second", "sink 1", "sink 2"}
+	if len(order.names) != len(want) {
+		t.Fatalf("stages called in order %v, want %v", order.names, want)
+	}
+	for i := range want {
+		if order.names[i] != want[i] {
+			t.Fatalf("stages called in order %v, want %v", order.names, want)
+		}
+	}
+
+	if len(b.Transactions) != 1 || b.Transactions[0].TxID != "c" {
+		t.Fatalf("transactions %+v went through, want only c", b.Transactions)
+	}
+
+	if labels := b.Transactions[0].Labels; labels["first"] != "seen " || labels["second"] != "seen first " {
+		t.Errorf("labels = %v, want the second enricher to see the labels of the first", labels)
+	}
+
+	for _, w := range written {
+		if w.Transactions[0].Labels["second"] == "" {
+			t.Errorf("sink received %+v, want the enriched transactions", w.Transactions)
+		}
+	}
+}
+
+func TestPipelineSkippedEnricherLeavesBlockUnchanged(t *testing.T) {
+	var order calls
+
+	cfg := testConfig()
+	cfg.Pipeline.AddEnricher("first", labelEnricher{name: "first", calls: &order}, StageFail)
+	cfg.Pipeline.AddEnricher("failing", labelEnricher{name: "failing", calls: &order, err: errors.New("price feed down")}, StageSkip)
+	cfg.Pipeline.AddEnricher("second", labelEnricher{name: "second", calls: &order}, StageFail)
+	c := NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+
+	b := domain.Block{Transactions: domain.Transactions{{TxID: "a", Labels: map[string]string{"source": "node"}}}}
+	original := b.Transactions[0].Labels
+	if err := c.transformBlock(context.Background(), &b); err != nil {
+		t.Fatalf("transformBlock: %v", err)
+	}
+
+	labels := b.Transactions[0].Labels
+	if _, ok := labels["failing"]; ok {
+		t.Errorf("labels = %v, the skipped enricher's changes were kept", labels)
+	}
+
+	if labels["second"] != "seen first " || labels["source"] != "node" {
+		t.Errorf("labels = %v, want the enrichers around the skipped one applied", labels)
+	}
+
+	if len(original) != 1 {
+		t.Errorf("labels of the fetched transaction were changed in place: %v", original)
+	}
+
+	cfg = testConfig()
+	cfg.Pipeline.AddEnricher("failing", labelEnricher{name: "failing", calls: &order, err: errors.New("price feed down")}, StageFail)
+	c = NewClientWithConfig(newFakeChain(1), memory.NewTransactionMemoryStore(), cfg)
+	if err := c.transformBlock(context.Background(), &b); err == nil {
+		t.Error("transformBlock succeeded with a failing enricher whose policy is StageFail")
+	}
+}
+
+func TestPipelineFilterErrorPolicy(t *testing.T) {
+	t.Run("fail", func(t *testing.T) {
+		chain := newFakeChain(3)
+		filter := &failingFilter{fail: true}
+
+		var mu sync.Mutex
+		var handled []error
+		cfg := testConfig()
+		cfg.Pipeline.AddFilter("flaky", filter, StageFail)
+		cfg.ErrorHandler = func(err error) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			handled = append(handled, err)
+			return nil
+		}
+		c := startClient(t, chain, cfg)
+		if _, err := c.SubscribeWithOpts(addrA, nil); err != nil {
+			t.Fatalf("SubscribeWithOpts: %v", err)
+		}
+
+		waitFor(t, "the filter error to be handled", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return len(handled) > 0
+		})
+
+		if txs, _ := c.GetTransactionsByBlock(1); len(txs) != 0 {
+			t.Errorf("block 1 holds %+v while its filter fails", txs)
+		}
+
+		filter.recover()
+		waitForBlock(t, c, chain.head())
+		if txs, _ := c.GetTransactionsByBlock(1); len(txs) != 1 {
+			t.Errorf("block 1 holds %+v once the filter recovers, want its transaction", txs)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		chain := newFakeChain(3)
+		cfg := testConfig()
+		cfg.Pipeline.AddFilter("flaky", &failingFilter{fail: true}, StageSkip)
+		c := startClient(t, chain, cfg)
+		subscribeAndSync(t, c, chain, addrA, nil)
+
+		if txs, _ := c.GetTransactionsByBlock(1); len(txs) != 1 {
+			t.Errorf("block 1 holds %+v, want its transaction kept by the skipped filter", txs)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	defaultPageSize = 100
+)
+
+// restoreSubscriptions re-attaches the persisted subscriptions when the parser starts.
+// Their delivery options aren't persisted: channel consumers get them back with GetSubscription,
+// webhook subscriptions resume delivering right away.
+func (c *Client) restoreSubscriptions() error {
+	if c.config.Subscriptions == nil {
+		return nil
+	}
+
+	subs, err := c.config.Subscriptions.GetAll()
+	if err != nil {
+		return fmt.Errorf("error loading subscriptions: %w", err)
+	}
+
+	for _, info := range subs {
+		address, err := domain.NormalizeAddress(info.Address)
+		if err != nil {
+			c.logger.Errorf("Not restoring subscription %s: %v", info.ID, err)
+			continue
+		}
+		info.Address = address
+
+		if info.Filter, err = info.Filter.Normalize(); err != nil {
+			c.logger.Errorf("Not restoring subscription %s: %v", info.ID, err)
+			continue
+		}
+
+		if _, ok := c.subscriptions.Load(info.ID); ok {
+			continue
+		}
+
+		if len(info.SealedWebhookSecret) > 0 {
+			if info.WebhookSecret, err = c.config.Webhook.openSecret(info.SealedWebhookSecret); err != nil {
+				c.logger.Errorf("Not restoring subscription %s: %v", info.ID, err)
+				continue
+			}
+		}
+		c.attach(c.newSubscription(info, &SubscribeOpts{}))
+	}
+
+	if len(subs) > 0 {
+		c.logger.Infof("Restored %d subscriptions", len(subs))
+	}
+
+	return nil
+}
+
+// GetSubscription returns the live handle of a subscription
+func (c *Client) GetSubscription(id string) (*Subscription, error) {
+	value, ok := c.subscriptions.Load(id)
+	if !ok {
+		return nil, fmt.Errorf("subscription %s not found", id)
+	}
+
+	return value.(*Subscription), nil
+}
+
+// Unsubscribe ends a subscription by ID
+func (c *Client) Unsubscribe(id string) error {
+	s, err := c.GetSubscription(id)
+	if err != nil {
+		return err
+	}
+
+	s.Unsubscribe()
+
+	return nil
+}
+
+// SetSubscriptionLabels replaces the labels of a subscription
+func (c *Client) SetSubscriptionLabels(id string, labels map[string]string) error {
+	s, err := c.GetSubscription(id)
+	if err != nil {
+		return err
+	}
+
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	info := s.info
+	info.Labels = copyLabels(labels)
+
+	if c.config.Subscriptions != nil {
+		if err := c.config.Subscriptions.Save(info); err != nil {
+			return fmt.Errorf("unable to save subscription %s: %w", id, err)
+		}
+	}
+
+	s.info = info
+
+	return nil
+}
+
+// ListSubscriptions returns a page of the subscriptions matching the query, ordered by creation time then ID,
+// along with the cursor of the next page, empty on the last page. The cursor is the position of the last
+// subscription of the page, so pages stay consistent when subscriptions end in between.
+func (c *Client) ListSubscriptions(query domain.SubscriptionQuery) ([]domain.Subscription, string, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	if query.Address != "" {
+		address, err := domain.NormalizeAddress(query.Address)
+		if err != nil {
+			return nil, "", err
+		}
+		query.Address = address
+	}
+
+	var matches []domain.Subscription
+	c.subscriptions.Range(func(key, value interface{}) bool {
+		info := value.(*Subscription).Info()
+		if matchesQuery(info, query) {
+			matches = append(matches, info)
+		}
+		return true
+	})
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].CreatedAt.Equal(matches[j].CreatedAt) {
+			return matches[i].CreatedAt.Before(matches[j].CreatedAt)
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	start := 0
+	if query.Cursor != "" {
+		createdAt, id, err := parseCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		start = sort.Search(len(matches), func(i int) bool {
+			if !matches[i].CreatedAt.Equal(createdAt) {
+				return matches[i].CreatedAt.After(createdAt)
+			}
+			return matches[i].ID > id
+		})
+	}
+
+	end := min(start+limit, len(matches))
+	page := matches[start:end]
+
+	next := ""
+	if end < len(matches) {
+		last := page[len(page)-1]
+		next = strconv.FormatInt(last.CreatedAt.UnixNano(), 10) + "-" + last.ID
+	}
+
+	return page, next, nil
+}
+
+// parseCursor returns the creation time and the ID of the subscription a cursor points after
+func parseCursor(cursor string) (time.Time, string, error) {
+	nanos, id, ok := strings.Cut(cursor, "-")
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor %q: %w", cursor, err)
+	}
+
+	return time.Unix(0, n).UTC(), id, nil
+}
+
+func matchesQuery(info domain.Subscription, query domain.SubscriptionQuery) bool {
+	if query.Owner != "" && info.Owner != query.Owner {
+		return false
+	}
+
+	if query.Address != "" && info.Address != query.Address {
+		return false
+	}
+
+	for k, v := range query.Labels {
+		if label, ok := info.Labels[k]; !ok || label != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+
+	return copied
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// frozenClock always tells the same time, so that subscriptions share their creation time
+type frozenClock struct{ now time.Time }
+
+func (c frozenClock) Now() time.Time {
+	return c.now
+}
+
+func (frozenClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// listAll pages through the subscriptions, calling between before fetching each page after the first
+func listAll(t *testing.T, c *Client, limit int, between func()) []string {
+	t.Helper()
+
+	var ids []string
+	cursor := ""
+	for {
+		page, next, err := c.ListSubscriptions(domain.SubscriptionQuery{Limit: limit, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("ListSubscriptions: %v", err)
+		}
+
+		for _, info := range page {
+			ids = append(ids, info.ID)
+		}
+
+		if next == "" {
+			return ids
+		}
+		cursor = next
+		between()
+	}
+}
+
+func TestListSubscriptionsPaging(t *testing.T) {
+	chain := newFakeChain(3)
+	cfg := testConfig()
+	cfg.Clock = frozenClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := startClient(t, chain, cfg)
+
+	subs := make(map[string]*Subscription)
+	for _, address := range []string{addrA, addrB, addrC, addrA, addrB} {
+		s, err := c.Subscribe(address)
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		subs[s.ID] = s
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range listAll(t, c, 2, func() {}) {
+		if seen[id] {
+			t.Errorf("subscription %s listed twice", id)
+		}
+		seen[id] = true
+	}
+
+	if len(seen) != len(subs) {
+		t.Errorf("listed %d subscriptions created at the same time, want %d", len(seen), len(subs))
+	}
+
+	// ending the last subscription of a page mustn't restart or skip the listing
+	first := listAll(t, c, 5, func() {})
+	ended := false
+	ids := listAll(t, c, 2, func() {
+		if !ended {
+			subs[first[1]].Unsubscribe()
+			ended = true
+		}
+	})
+
+	if !slices.Equal(ids, first) {
+		t.Errorf("listed %v after ending %s between pages, want %v", ids, first[1], first)
+	}
+
+	if _, _, err := c.ListSubscriptions(domain.SubscriptionQuery{Cursor: "not-a-cursor"}); err == nil {
+		t.Error("ListSubscriptions accepted a malformed cursor")
+	}
+}
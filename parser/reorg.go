@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// OnReorg registers a handler that is called after every chain reorganization has been rolled back.
+// Handlers are called synchronously from the processing loop and must not block.
+func (c *Client) OnReorg(handler func(domain.ReorgEvent)) {
+	c.reorgMu.Lock()
+	defer c.reorgMu.Unlock()
+
+	c.reorgHandlers = append(c.reorgHandlers, handler)
+}
+
+// rollback walks back from the last processed block until the stored header matches the canonical one,
+// deletes the transactions stored from the orphaned blocks and rewinds the parser to the common ancestor.
+// It returns the common ancestor, from which the canonical branch should be re-ingested.
+func (c *Client) rollback(ctx context.Context) (domain.BlockHeader, error) {
+	last, ok := c.headers.last()
+	if !ok {
+		return domain.BlockHeader{}, fmt.Errorf("no processed blocks to roll back")
+	}
+
+	ancestor, found := domain.BlockHeader{}, false
+	for number := last.Number; ; number-- {
+		stored, ok := c.headers.get(number)
+		if !ok {
+			break
+		}
+
+		canonical, err := c.ethClient.GetBlockHeader(ctx, number)
+		if err != nil {
+			return domain.BlockHeader{}, withKind(errorKindRPC, fmt.Errorf("error fetching canonical header %d: %w", number, err))
+		}
+
+		if canonical.Hash == stored.Hash {
+			ancestor, found = stored, true
+			break
+		}
+	}
+
+	if !found {
+		return domain.BlockHeader{}, &ReorgTooDeepError{Depth: c.headers.len()}
+	}
+
+	if ancestor.Number == last.Number {
+		return domain.BlockHeader{}, fmt.Errorf("last processed block %d is still canonical, the node served an inconsistent view", last.Number)
+	}
+
+	c.confirmedBlock = min(c.confirmedBlock, ancestor.Number)
+	c.finalizedBlock = min(c.finalizedBlock, ancestor.Number)
+
+	// Rewind the checkpoint first: if we stop halfway, the orphaned blocks are replaced when re-ingested
+	if err := c.txnStore.SaveCheckpoint(c.checkpointAt(ancestor)); err != nil {
+		return domain.BlockHeader{}, fmt.Errorf("error rewinding checkpoint to block %d: %w", ancestor.Number, err)
+	}
+
+	// The processed chain follows the checkpoint so that both point at the ancestor from here on
+	event := domain.ReorgEvent{CommonAncestor: ancestor}
+	event.Orphaned = c.headers.truncate(ancestor.Number)
+	atomic.StoreInt32(&c.latestBlock, ancestor.Number)
+	if c.unenqueued != nil && c.unenqueued.Number > ancestor.Number {
+		c.unenqueued = nil
+	}
+
+	c.rollbackBalances(ancestor)
+
+	for number := last.Number; number > ancestor.Number; number-- {
+		reverted, err := c.txnStore.DeleteByBlock(number)
+		if err != nil {
+			return domain.BlockHeader{}, fmt.Errorf("error reverting transactions of block %d: %w", number, err)
+		}
+		event.Reverted = append(event.Reverted, reverted...)
+		c.forgetFailedBlock(number)
+	}
+
+	c.logger.Warnf("Chain reorganization: rolled back %d blocks to common ancestor %d (%s), reverted %d transactions",
+		len(event.Orphaned), ancestor.Number, ancestor.Hash, len(event.Reverted))
+
+	if err := c.enqueueReorgWebhooks(event); err != nil {
+		c.logger.Errorf("Error enqueuing reorg webhooks: %v", err)
+	}
+
+	c.emitReorg(event)
+	c.notifyReorg(ctx, event)
+	c.unsettleReverted(ctx, event.Reverted)
+	c.unobserveNonces(event.Reverted)
+
+	return ancestor, nil
+}
+
+func (c *Client) emitReorg(event domain.ReorgEvent) {
+	c.reorgMu.RLock()
+	defer c.reorgMu.RUnlock()
+
+	for _, handler := range c.reorgHandlers {
+		handler(event)
+	}
+}
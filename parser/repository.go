@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+)
+
+// instrumentedRepository measures the latency and the errors of every operation of the transaction repository,
+// its errors are counted as storage errors when they fail a check for new blocks
+type instrumentedRepository struct {
+	repo   transaction.Repository
+	client *Client
+}
+
+var _ transaction.Repository = instrumentedRepository{}
+
+// observe records an operation started at the given time and tags its error. Latencies are measured
+// with the system clock, the configured one may not move with real time.
+func (r instrumentedRepository) observe(operation string, start time.Time, err error) error {
+	label := metrics.Label{Name: "operation", Value: operation}
+	r.client.metrics.Observe(metricRepositoryDuration, time.Since(start).Seconds(), label)
+	if err != nil {
+		r.client.metrics.IncCounter(metricRepositoryErrors, 1, label)
+	}
+
+	return withKind(errorKindStorage, err)
+}
+
+func (r instrumentedRepository) GetAllByAddress(address string) (domain.Transactions, error) {
+	start := time.Now()
+	txs, err := r.repo.GetAllByAddress(address)
+	return txs, r.observe("get_all_by_address", start, err)
+}
+
+func (r instrumentedRepository) GetAllByBlock(block int32) (domain.Transactions, error) {
+	start := time.Now()
+	txs, err := r.repo.GetAllByBlock(block)
+	return txs, r.observe("get_all_by_block", start, err)
+}
+
+func (r instrumentedRepository) GetByHash(hash string) (domain.Transaction, bool, error) {
+	start := time.Now()
+	tx, found, err := r.repo.GetByHash(hash)
+	return tx, found, r.observe("get_by_hash", start, err)
+}
+
+func (r instrumentedRepository) Save(tx domain.Transaction) error {
+	start := time.Now()
+	return r.observe("save", start, r.repo.Save(tx))
+}
+
+func (r instrumentedRepository) SaveAll(txs domain.Transactions) error {
+	start := time.Now()
+	return r.observe("save_all", start, r.repo.SaveAll(txs))
+}
+
+func (r instrumentedRepository) Commit(checkpoint domain.Checkpoint, txs domain.Transactions) error {
+	start := time.Now()
+	return r.observe("commit", start, r.repo.Commit(checkpoint, txs))
+}
+
+func (r instrumentedRepository) Replace(block int32, txs domain.Transactions) error {
+	start := time.Now()
+	return r.observe("replace", start, r.repo.Replace(block, txs))
+}
+
+func (r instrumentedRepository) UpdateState(block int32, state domain.ConfirmationState) error {
+	start := time.Now()
+	return r.observe("update_state", start, r.repo.UpdateState(block, state))
+}
+
+func (r instrumentedRepository) DeleteByBlock(block int32) (domain.Transactions, error) {
+	start := time.Now()
+	txs, err := r.repo.DeleteByBlock(block)
+	return txs, r.observe("delete_by_block", start, err)
+}
+
+func (r instrumentedRepository) GetCheckpoint() (domain.Checkpoint, bool, error) {
+	start := time.Now()
+	checkpoint, found, err := r.repo.GetCheckpoint()
+	return checkpoint, found, r.observe("get_checkpoint", start, err)
+}
+
+func (r instrumentedRepository) SaveCheckpoint(checkpoint domain.Checkpoint) error {
+	start := time.Now()
+	return r.observe("save_checkpoint", start, r.repo.SaveCheckpoint(checkpoint))
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// durations keeps the observations of each measurement
+type durations struct {
+	mu     sync.Mutex
+	values map[string][]float64
+}
+
+func (d *durations) IncCounter(name string, delta float64, labels ...metrics.Label) {}
+
+func (d *durations) SetGauge(name string, value float64, labels ...metrics.Label) {}
+
+func (d *durations) Observe(name string, value float64, labels ...metrics.Label) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.values[name] = append(d.values[name], value)
+}
+
+// slowRepository takes a while to list the transactions of an address
+type slowRepository struct {
+	transaction.Repository
+}
+
+func (r slowRepository) GetAllByAddress(address string) (domain.Transactions, error) {
+	time.Sleep(10 * time.Millisecond)
+	return r.Repository.GetAllByAddress(address)
+}
+
+func TestRepositoryLatencyUsesSystemClock(t *testing.T) {
+	sink := &durations{values: make(map[string][]float64)}
+	cfg := testConfig()
+	cfg.Metrics = sink
+	cfg.Clock = frozenClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewClientWithConfig(newFakeChain(1), slowRepository{memory.NewTransactionMemoryStore()}, cfg)
+
+	if _, err := c.txnStore.GetAllByAddress(addrA); err != nil {
+		t.Fatalf("GetAllByAddress: %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	observed := sink.values[metricRepositoryDuration]
+	if len(observed) != 1 || observed[0] < (10*time.Millisecond).Seconds() {
+		t.Errorf("observed %v seconds with a frozen clock, want the 10ms the operation took", observed)
+	}
+}
+
+// slowChain takes a while to report its head
+type slowChain struct {
+	*fakeChain
+}
+
+func (c slowChain) GetBlockCount(ctx context.Context) (int32, error) {
+	time.Sleep(5 * time.Millisecond)
+	return c.fakeChain.GetBlockCount(ctx)
+}
+
+func TestTickAndProbeLatencyUseSystemClock(t *testing.T) {
+	sink := &durations{values: make(map[string][]float64)}
+	cfg := testConfig()
+	cfg.Metrics = sink
+	cfg.Clock = frozenClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewClientWithConfig(slowChain{newFakeChain(1)}, memory.NewTransactionMemoryStore(), cfg)
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	t.Cleanup(func() { c.Stop(context.Background()) })
+
+	waitFor(t, "a tick", func() bool {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+
+		return len(sink.values[metricTickDuration]) > 0
+	})
+
+	sink.mu.Lock()
+	tick := sink.values[metricTickDuration][0]
+	sink.mu.Unlock()
+	if tick < (5 * time.Millisecond).Seconds() {
+		t.Errorf("tick took %v seconds with a frozen clock, want at least the 5ms the node took", tick)
+	}
+
+	if latency := c.Status().RPC.Latency; latency < 5*time.Millisecond {
+		t.Errorf("RPC latency is %v with a frozen clock, want at least the 5ms the node took", latency)
+	}
+}
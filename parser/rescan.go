@@ -0,0 +1,321 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	// maxFinishedRescans is the number of finished rescans whose progress is kept, the oldest are forgotten first
+	maxFinishedRescans = 100
+)
+
+// rescan reprocesses a range of blocks already processed by tip-following
+type rescan struct {
+	mu        sync.RWMutex
+	progress  domain.RescanProgress
+	cancel    context.CancelFunc // cancels the rescan once it started
+	cancelled bool               // whether CancelRescan was called, possibly before the rescan started
+}
+
+func (r *rescan) get() domain.RescanProgress {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p := r.progress
+	p.Addresses = append([]string(nil), p.Addresses...)
+
+	return p
+}
+
+func (r *rescan) update(fn func(p *domain.RescanProgress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fn(&r.progress)
+}
+
+// Rescan reprocesses the blocks in [from, to] in the background and returns the ID to follow it with.
+// The range can't go past the last processed block. With no address, the addresses subscribed at the time
+// of each block are rescanned; in firehose mode every transaction is rescanned whatever the addresses.
+//
+// Blocks are stored again in place of what was stored from them for the rescanned addresses, so that rescanning
+// is idempotent. Transactions that weren't stored before are notified, posted to webhooks and evaluated by the
+// alert rules like newly committed ones. The rescan ends with the run, or earlier with CancelRescan.
+func (c *Client) Rescan(from, to int32, addresses ...string) (string, error) {
+	if from < 0 || from > to {
+		return "", fmt.Errorf("invalid block range [%d, %d]", from, to)
+	}
+
+	if last := atomic.LoadInt32(&c.latestBlock); to > last {
+		return "", fmt.Errorf("block %d is past the last processed block %d", to, last)
+	}
+
+	normalized := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		address, err := domain.NormalizeAddress(address)
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := c.subscribers.Load(address); !ok {
+			return "", fmt.Errorf("address %s is not subscribed", address)
+		}
+		normalized = append(normalized, address)
+	}
+	sort.Strings(normalized)
+
+	r := &rescan{progress: domain.RescanProgress{
+		ID:        newID(),
+		Addresses: normalized,
+		Status:    domain.RescanRunning,
+		From:      from,
+		To:        to,
+		Current:   from - 1,
+		StartedAt: c.clock.Now().UTC(),
+	}}
+
+	c.rescanMu.Lock()
+	c.pruneRescans()
+	c.rescans[r.progress.ID] = r
+	c.rescanMu.Unlock()
+
+	started := c.goWorker(func(ctx context.Context) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		r.mu.Lock()
+		r.cancel = cancel
+		if r.cancelled {
+			cancel()
+		}
+		r.mu.Unlock()
+
+		c.runRescan(ctx, r)
+	})
+
+	if !started {
+		c.rescanMu.Lock()
+		delete(c.rescans, r.progress.ID)
+		c.rescanMu.Unlock()
+
+		return "", fmt.Errorf("parser is not running")
+	}
+
+	c.logger.Infof("Rescanning blocks %d to %d", from, to)
+
+	return r.progress.ID, nil
+}
+
+// CancelRescan stops a rescan after the block being rescanned, what was already rescanned stays stored
+func (c *Client) CancelRescan(id string) error {
+	c.rescanMu.Lock()
+	r, ok := c.rescans[id]
+	c.rescanMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("rescan %s not found", id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cancelled = true
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	return nil
+}
+
+// GetRescanProgress returns the progress of a rescan
+func (c *Client) GetRescanProgress(id string) (domain.RescanProgress, error) {
+	c.rescanMu.Lock()
+	r, ok := c.rescans[id]
+	c.rescanMu.Unlock()
+
+	if !ok {
+		return domain.RescanProgress{}, fmt.Errorf("rescan %s not found", id)
+	}
+
+	return r.get(), nil
+}
+
+// GetRescans returns the progress of the running rescans and of the last finished ones, oldest first
+func (c *Client) GetRescans() []domain.RescanProgress {
+	c.rescanMu.Lock()
+	rescans := make([]domain.RescanProgress, 0, len(c.rescans))
+	for _, r := range c.rescans {
+		rescans = append(rescans, r.get())
+	}
+	c.rescanMu.Unlock()
+
+	sort.Slice(rescans, func(i, j int) bool { return rescans[i].StartedAt.Before(rescans[j].StartedAt) })
+
+	return rescans
+}
+
+// pruneRescans forgets the oldest finished rescans beyond maxFinishedRescans, rescanMu must be held
+func (c *Client) pruneRescans() {
+	var finished []domain.RescanProgress
+	for _, r := range c.rescans {
+		if p := r.get(); p.Finished() {
+			finished = append(finished, p)
+		}
+	}
+
+	if len(finished) < maxFinishedRescans {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool { return finished[i].EndedAt.Before(finished[j].EndedAt) })
+	for _, p := range finished[:len(finished)-maxFinishedRescans+1] {
+		delete(c.rescans, p.ID)
+	}
+}
+
+func (c *Client) runRescan(ctx context.Context, r *rescan) {
+	p := r.get()
+
+	var err error
+	for block := p.From; block <= p.To && err == nil; block++ {
+		var stored, found int
+		if stored, found, err = c.rescanBlock(ctx, block, p.Addresses); err == nil {
+			r.update(func(p *domain.RescanProgress) {
+				p.Current = block
+				p.Stored += stored
+				p.Found += found
+			})
+		}
+	}
+
+	status := domain.RescanDone
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = domain.RescanCancelled
+		c.logger.Infof("Rescan %s cancelled", p.ID)
+	case err != nil:
+		status = domain.RescanFailed
+		c.logger.Errorf("Rescan %s failed: %v", p.ID, err)
+	default:
+		c.logger.Infof("Rescan %s of blocks %d to %d done", p.ID, p.From, p.To)
+	}
+
+	r.update(func(p *domain.RescanProgress) {
+		p.Status = status
+		p.EndedAt = c.clock.Now().UTC()
+		if err != nil && status == domain.RescanFailed {
+			p.Error = err.Error()
+		}
+	})
+}
+
+// rescanBlock fetches a block again and replaces what was stored from it for the rescanned addresses.
+// It returns the number of transactions stored and how many of them weren't stored before.
+func (c *Client) rescanBlock(ctx context.Context, number int32, addresses []string) (int, int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	active := c.addressesActiveAt(number)
+	rescanned := addresses
+	if len(rescanned) == 0 || c.config.Firehose {
+		rescanned = active
+	}
+
+	if len(rescanned) == 0 && !c.config.Firehose {
+		return 0, 0, nil
+	}
+
+	b, err := c.fetchTransactions(ctx, number, c.blockMatcher(newWatchSetOf(rescanned...)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("error fetching transactions from block %d: %w", number, err)
+	}
+
+	// keep out of the way of the processing loop, a rollback could otherwise interleave with the replacement
+	c.processMu.Lock()
+	defer c.processMu.Unlock()
+
+	if header, ok := c.headers.get(number); ok && header.Hash != b.Hash {
+		return 0, 0, fmt.Errorf("block %d is now %s, it was processed as %s", number, b.Hash, header.Hash)
+	}
+
+	state := domain.StatePending
+	if tip := c.tip.Load(); tip != nil {
+		state = c.stateFor(number, *tip)
+	}
+
+	for i := range b.Transactions {
+		b.Transactions[i].State = state
+	}
+
+	stored, err := c.txnStore.GetAllByBlock(number)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error getting transactions of block %d: %w", number, err)
+	}
+
+	// what was stored for the addresses left out of the rescan stays as it is
+	others := make(map[string]struct{}, len(active))
+	for _, address := range active {
+		others[address] = struct{}{}
+	}
+	for _, address := range rescanned {
+		delete(others, address)
+	}
+
+	fetched := make(map[string]struct{}, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		fetched[tx.TxID] = struct{}{}
+	}
+
+	known := make(map[string]struct{}, len(stored))
+	txs := b.Transactions
+	for _, tx := range stored {
+		known[tx.TxID] = struct{}{}
+		if _, ok := fetched[tx.TxID]; ok || c.config.Firehose {
+			continue
+		}
+
+		for _, address := range tx.Addresses() {
+			if _, ok := others[address]; ok {
+				txs = append(txs, tx)
+				break
+			}
+		}
+	}
+
+	fresh := make(domain.Transactions, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if _, ok := known[tx.TxID]; !ok {
+			fresh = append(fresh, tx)
+		}
+	}
+
+	if len(fresh) > 0 {
+		if err := c.writeSinks(ctx, domain.Block{BlockHeader: b.BlockHeader, Transactions: fresh}); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := c.txnStore.Replace(number, txs); err != nil {
+		return 0, 0, fmt.Errorf("error storing transactions for block %d: %w", number, err)
+	}
+
+	// the block may have been delivered already, the new transactions get deliveries of their own
+	if err := c.enqueueBlockWebhooks(domain.EventTransaction, b.BlockHeader, fresh, "rescanned"+b.Hash); err != nil {
+		return 0, 0, fmt.Errorf("error enqueuing webhooks for block %d: %w", number, err)
+	}
+
+	c.metrics.IncCounter(metricBlocksRescanned, 1)
+	c.metrics.IncCounter(metricTxsStored, float64(len(fresh)))
+	c.notify(ctx, domain.EventTransaction, fresh)
+	c.evaluateAlerts(fresh)
+
+	return len(b.Transactions), len(fresh), nil
+}
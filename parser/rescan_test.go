@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// holdFilter holds the transactions of the held blocks until they are released, it keeps every transaction
+type holdFilter struct {
+	mu    sync.Mutex
+	gates map[int32]chan struct{}
+}
+
+func (f *holdFilter) hold(block int32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.gates[block] = make(chan struct{})
+}
+
+func (f *holdFilter) release(block int32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	close(f.gates[block])
+	delete(f.gates, block)
+}
+
+func (f *holdFilter) Keep(ctx context.Context, tx domain.Transaction) (bool, error) {
+	f.mu.Lock()
+	gate := f.gates[tx.Block]
+	f.mu.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+
+	return true, nil
+}
+
+// startRescanClient runs a client synced to the head of a four block chain with addrA subscribed
+func startRescanClient(t *testing.T) (*Client, *fakeChain, *holdFilter, *Subscription) {
+	t.Helper()
+
+	chain := newFakeChain(4)
+	filter := &holdFilter{gates: make(map[int32]chan struct{})}
+	cfg := testConfig()
+	cfg.Pipeline.AddFilter("hold", filter, StageFail)
+	c := startClient(t, chain, cfg)
+	sub := subscribeAndSync(t, c, chain, addrA, nil)
+
+	return c, chain, filter, sub
+}
+
+// waitForRescan waits until the progress of the rescan satisfies the condition and returns it
+func waitForRescan(t *testing.T, c *Client, id, what string, condition func(p domain.RescanProgress) bool) domain.RescanProgress {
+	t.Helper()
+
+	var p domain.RescanProgress
+	waitFor(t, what, func() bool {
+		var err error
+		if p, err = c.GetRescanProgress(id); err != nil {
+			t.Fatalf("GetRescanProgress: %v", err)
+		}
+		return condition(p)
+	})
+
+	return p
+}
+
+func TestRescanStoresMissedTransactionsOnce(t *testing.T) {
+	c, chain, _, sub := startRescanClient(t)
+
+	// the node now returns a transaction that was missed the first time around
+	chain.setTransactions(2,
+		domain.Transaction{From: addrA, To: addrB, TxID: "tx-a-2", Value: "0x0"},
+		domain.Transaction{From: addrC, To: addrA, TxID: "missed", Value: "0x1"},
+	)
+
+	id, err := c.Rescan(1, 3, addrA)
+	if err != nil {
+		t.Fatalf("Rescan: %v", err)
+	}
+
+	p := waitForRescan(t, c, id, "the rescan to finish", domain.RescanProgress.Finished)
+	if p.Status != domain.RescanDone || p.Stored != 4 || p.Found != 1 {
+		t.Errorf("rescan ended %s with %d stored and %d found, want done with 4 stored and 1 found", p.Status, p.Stored, p.Found)
+	}
+
+	txs, err := c.GetTransactions(addrA)
+	if err != nil {
+		t.Fatalf("GetTransactions: %v", err)
+	}
+
+	stored := make(map[string]int)
+	for _, tx := range txs {
+		stored[tx.TxID]++
+	}
+
+	for _, id := range []string{"tx-a-1", "tx-a-2", "tx-a-3", "missed"} {
+		if stored[id] != 1 {
+			t.Errorf("%s stored %d times, want once", id, stored[id])
+		}
+	}
+
+	if len(stored) != 4 {
+		t.Errorf("stored %v, want the three committed transactions and the missed one", stored)
+	}
+
+	// only the missed transaction is notified again
+	sub.Unsubscribe()
+	notified := make(map[string]int)
+	for event := range sub.Events() {
+		if event.Type == domain.EventTransaction {
+			notified[event.Transaction.TxID]++
+		}
+	}
+
+	for id, n := range notified {
+		if n != 1 {
+			t.Errorf("%s notified %d times, want once", id, n)
+		}
+	}
+
+	if notified["missed"] != 1 {
+		t.Errorf("notified %v, the missed transaction should be notified", notified)
+	}
+}
+
+func TestRescanReportsProgress(t *testing.T) {
+	c, _, filter, _ := startRescanClient(t)
+
+	filter.hold(2)
+	id, err := c.Rescan(1, 3)
+	if err != nil {
+		t.Fatalf("Rescan: %v", err)
+	}
+
+	p := waitForRescan(t, c, id, "block 1 to be rescanned", func(p domain.RescanProgress) bool { return p.Current == 1 })
+	if p.Status != domain.RescanRunning || p.From != 1 || p.To != 3 || p.Stored != 1 || p.Found != 0 || !p.EndedAt.IsZero() {
+		t.Errorf("progress after block 1 = %+v, want running with 1 stored", p)
+	}
+
+	if rescans := c.GetRescans(); len(rescans) != 1 || rescans[0].ID != id {
+		t.Errorf("GetRescans = %+v, want the running rescan", rescans)
+	}
+
+	filter.release(2)
+	p = waitForRescan(t, c, id, "the rescan to finish", domain.RescanProgress.Finished)
+	if p.Status != domain.RescanDone || p.Current != 3 || p.Stored != 3 || p.Found != 0 || p.Error != "" {
+		t.Errorf("progress at the end = %+v, want done at block 3 with 3 stored", p)
+	}
+
+	if p.EndedAt.Before(p.StartedAt) {
+		t.Errorf("ended at %v, before it started at %v", p.EndedAt, p.StartedAt)
+	}
+
+	if _, err := c.GetRescanProgress("unknown"); err == nil {
+		t.Error("GetRescanProgress of an unknown rescan succeeded")
+	}
+}
+
+func TestCancelRescanMidRange(t *testing.T) {
+	c, chain, filter, _ := startRescanClient(t)
+
+	chain.setTransactions(3,
+		domain.Transaction{From: addrA, To: addrB, TxID: "tx-a-3", Value: "0x0"},
+		domain.Transaction{From: addrC, To: addrA, TxID: "missed", Value: "0x1"},
+	)
+
+	filter.hold(2)
+	id, err := c.Rescan(1, 3)
+	if err != nil {
+		t.Fatalf("Rescan: %v", err)
+	}
+	waitForRescan(t, c, id, "block 1 to be rescanned", func(p domain.RescanProgress) bool { return p.Current == 1 })
+
+	if err := c.CancelRescan(id); err != nil {
+		t.Fatalf("CancelRescan: %v", err)
+	}
+	filter.release(2)
+
+	// the block being rescanned completes, the rest of the range is left alone
+	p := waitForRescan(t, c, id, "the rescan to finish", domain.RescanProgress.Finished)
+	if p.Status != domain.RescanCancelled || p.Current != 2 || p.Stored != 2 || p.Error != "" {
+		t.Errorf("progress = %+v, want cancelled after block 2", p)
+	}
+
+	txs, err := c.GetTransactionsByBlock(3)
+	if err != nil {
+		t.Fatalf("GetTransactionsByBlock: %v", err)
+	}
+
+	if len(txs) != 1 || txs[0].TxID != "tx-a-3" {
+		t.Errorf("block 3 holds %+v, it shouldn't have been rescanned", txs)
+	}
+
+	if err := c.CancelRescan("unknown"); err == nil {
+		t.Error("CancelRescan of an unknown rescan succeeded")
+	}
+}
+
+func TestPauseStopsTicksUntilResume(t *testing.T) {
+	chain := newFakeChain(3)
+	c := startClient(t, chain, testConfig())
+	subscribeAndSync(t, c, chain, addrA, nil)
+
+	c.Pause()
+	if !c.Paused() || !c.Status().Paused {
+		t.Fatal("not paused after Pause")
+	}
+
+	chain.extend(3, "a")
+	time.Sleep(20 * testConfig().PollInterval)
+
+	if block := c.GetCurrentBlock(); block != 2 {
+		t.Fatalf("current block %d while paused, want 2", block)
+	}
+
+	chain.mu.Lock()
+	fetched := chain.fetches[3]
+	chain.mu.Unlock()
+
+	if fetched != 0 {
+		t.Errorf("block 3 fetched %d times while paused", fetched)
+	}
+
+	c.Resume()
+	if c.Paused() {
+		t.Fatal("still paused after Resume")
+	}
+	waitForBlock(t, c, chain.head())
+}
@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	// StartLatest starts processing at the block the parser follows when it first runs
+	StartLatest = "latest"
+)
+
+// startBlock is the parsed form of Config.StartBlock
+type startBlock struct {
+	number int32
+	time   time.Time
+	latest bool
+}
+
+// parseStartBlock accepts "latest", a block number, or a point in time given either as an RFC 3339
+// timestamp or as "@" followed by unix seconds, which starts at the first block mined at or after it
+func parseStartBlock(s string) (startBlock, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == StartLatest {
+		return startBlock{latest: true}, nil
+	}
+
+	if strings.HasPrefix(s, "@") {
+		secs, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return startBlock{}, fmt.Errorf("invalid start block timestamp %q: %w", s, err)
+		}
+		return startBlock{time: time.Unix(secs, 0)}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return startBlock{time: t}, nil
+	}
+
+	number, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || number < 0 {
+		return startBlock{}, fmt.Errorf("invalid start block %q: expected %s, a block number or a timestamp", s, StartLatest)
+	}
+
+	return startBlock{number: int32(number)}, nil
+}
+
+// loadCheckpoint resumes from the last committed block, if any.
+// The headers saved with the checkpoint anchor the chain so the first new block is checked for continuity.
+func (c *Client) loadCheckpoint() error {
+	checkpoint, found, err := c.txnStore.GetCheckpoint()
+	if err != nil {
+		return fmt.Errorf("error loading checkpoint: %w", err)
+	}
+
+	if !found {
+		return nil
+	}
+
+	// checkpoints saved before the headers were only anchored by their own block
+	if len(checkpoint.Headers) == 0 {
+		checkpoint.Headers = []domain.BlockHeader{{Number: checkpoint.Number, Hash: checkpoint.Hash}}
+	}
+
+	for _, header := range checkpoint.Headers {
+		c.headers.push(header)
+	}
+	atomic.StoreInt32(&c.latestBlock, checkpoint.Number)
+	c.confirmedBlock = checkpoint.Confirmed
+	c.finalizedBlock = checkpoint.Finalized
+	c.positioned = true
+
+	c.logger.Infof("Resuming from checkpoint at block %d (%s)", checkpoint.Number, checkpoint.Hash)
+
+	return nil
+}
+
+// checkpointAt returns the checkpoint at a processed header, along with the recent headers it extends
+// and the confirmation watermarks so that a restart resumes with the same view of the chain
+func (c *Client) checkpointAt(header domain.BlockHeader) domain.Checkpoint {
+	return domain.Checkpoint{
+		Number:    header.Number,
+		Hash:      header.Hash,
+		Confirmed: c.confirmedBlock,
+		Finalized: c.finalizedBlock,
+		Headers:   c.headers.through(header),
+	}
+}
+
+// position resolves the configured start block on the first tick when there was no checkpoint to resume from
+func (c *Client) position(ctx context.Context, tip chainTip) error {
+	first := c.start.number
+	switch {
+	case c.start.latest:
+		first = tip.target(c.config.Follow)
+	case !c.start.time.IsZero():
+		block, err := c.findBlockByTime(ctx, c.start.time, tip.target(c.config.Follow))
+		if err != nil {
+			return fmt.Errorf("error resolving start block at %s: %w", c.start.time, err)
+		}
+		first = block
+	}
+
+	if first < 1 {
+		first = 1
+	}
+
+	// nothing is stored below the first block, there is nothing to upgrade there
+	atomic.StoreInt32(&c.latestBlock, first-1)
+	c.confirmedBlock = first - 1
+	c.finalizedBlock = first - 1
+	c.positioned = true
+
+	c.logger.Infof("Starting at block %d", first)
+
+	return nil
+}
+
+// findBlockByTime binary searches the first block mined at or after t, or head+1 if there is none yet
+func (c *Client) findBlockByTime(ctx context.Context, t time.Time, head int32) (int32, error) {
+	lo, hi := int32(1), head+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		header, err := c.ethClient.GetBlockHeader(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if header.Timestamp >= t.Unix() {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return lo, nil
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+func TestReorgOfCheckpointAfterRestart(t *testing.T) {
+	chain := newFakeChain(10)
+	store := memory.NewTransactionMemoryStore()
+
+	c := NewClientWithConfig(chain, store, testConfig())
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	subscribeAndSync(t, c, chain, addrA, nil)
+
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	// the checkpoint's block and the one before it are orphaned while the parser is down
+	chain.reorg(8, 5, "b")
+
+	c = startClientWithStore(t, chain, store, testConfig())
+	subscribeAndSync(t, c, chain, addrA, nil)
+
+	for number := int32(8); number <= chain.head(); number++ {
+		txs, err := c.GetTransactionsByBlock(number)
+		if err != nil {
+			t.Fatalf("GetTransactionsByBlock: %v", err)
+		}
+
+		if len(txs) != 1 || txs[0].TxID != fmt.Sprintf("tx-b-%d", number) {
+			t.Errorf("block %d holds %+v, want the transaction of the canonical fork", number, txs)
+		}
+	}
+
+	if state := c.State(); state != RunStateRunning {
+		t.Errorf("parser is %v, want running", state)
+	}
+}
+
+func TestHeaderWindowThrough(t *testing.T) {
+	chain := newFakeChain(10)
+	w := newHeaderWindow(4)
+	for _, b := range chain.blocks[:8] {
+		w.push(b.BlockHeader)
+	}
+
+	// a header extending the window evicts the oldest one, like push would
+	headers := w.through(chain.blocks[8].BlockHeader)
+	if len(headers) != 4 || headers[0].Number != 5 || headers[3].Number != 8 {
+		t.Errorf("through(8) = %+v, want blocks 5 to 8", headers)
+	}
+
+	// a header within the window drops the ones above it
+	headers = w.through(chain.blocks[6].BlockHeader)
+	if len(headers) != 3 || headers[0].Number != 4 || headers[2].Number != 6 {
+		t.Errorf("through(6) = %+v, want blocks 4 to 6", headers)
+	}
+
+	if w.len() != 4 {
+		t.Errorf("through changed the window, it holds %d headers", w.len())
+	}
+}
@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// throughputWindow is the period the blocks/sec throughput is averaged over
+	throughputWindow = time.Minute
+)
+
+// Status is a snapshot of the parser health, meant to back liveness and readiness checks
+type Status struct {
+	State RunState
+	// Paused is set while tip-following is paused, the lag then grows with the head
+	Paused bool
+
+	// Head is the latest block of the chain seen by the last check for new blocks
+	Head int32
+	// LastProcessedBlock is the last committed block
+	LastProcessedBlock int32
+	// Lag is the number of blocks the parser is behind the head. Blocks skipped for lack of subscribers count as caught up.
+	Lag int32
+
+	// LastSuccess is the time of the last check for new blocks that completed without error
+	LastSuccess time.Time
+	// LastError is the error of the last failed check, kept after later checks succeed
+	LastError   string
+	LastErrorAt time.Time
+	// ConsecutiveErrors is the number of checks that failed in a row, zero once one succeeds
+	ConsecutiveErrors int
+
+	// Subscribers is the number of tracked addresses, Subscriptions the number of subscriptions on them
+	Subscribers   int
+	Subscriptions int
+
+	// BlocksPerSecond is the commit throughput over the last minute
+	BlocksPerSecond float64
+
+	RPC RPCStatus
+}
+
+// RPCStatus is the health of the node endpoint, probed at the start of every check for new blocks
+type RPCStatus struct {
+	Healthy     bool
+	LastSuccess time.Time
+	LastError   string
+	LastErrorAt time.Time
+	// Latency is the duration of the last successful probe
+	Latency time.Duration
+}
+
+// Live reports whether the parser is running, a stopped or failed parser has to be restarted
+func (s Status) Live() bool {
+	return s.State == RunStateStarting || s.State == RunStateRunning
+}
+
+// Ready reports whether the parser is running against a healthy node, at most maxLag blocks behind the head
+func (s Status) Ready(maxLag int32) bool {
+	return s.State == RunStateRunning && s.RPC.Healthy && !s.LastSuccess.IsZero() && s.Lag <= maxLag
+}
+
+// health gathers what the status reports and isn't tracked elsewhere
+type health struct {
+	mu sync.Mutex
+
+	lastSuccess       time.Time
+	lastError         string
+	lastErrorAt       time.Time
+	consecutiveErrors int
+
+	rpc RPCStatus
+
+	committed uint64             // blocks committed since the client was created
+	samples   []throughputSample // oldest first, spanning at most throughputWindow
+}
+
+type throughputSample struct {
+	at        time.Time
+	committed uint64
+}
+
+func (h *health) recordTick(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.lastError = err.Error()
+		h.lastErrorAt = now
+		h.consecutiveErrors++
+		return
+	}
+
+	h.lastSuccess = now
+	h.consecutiveErrors = 0
+}
+
+func (h *health) recordRPC(now time.Time, latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.rpc.Healthy = false
+		h.rpc.LastError = err.Error()
+		h.rpc.LastErrorAt = now
+		return
+	}
+
+	h.rpc.Healthy = true
+	h.rpc.LastSuccess = now
+	h.rpc.Latency = latency
+}
+
+// recordCommit counts a committed block and samples the total for the throughput
+func (h *health) recordCommit(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.committed++
+	h.samples = append(h.samples, throughputSample{at: now, committed: h.committed})
+	h.trim(now)
+}
+
+// trim drops the samples that fell out of the window, keeping one at its edge to measure from
+func (h *health) trim(now time.Time) {
+	i := 0
+	for i < len(h.samples)-1 && now.Sub(h.samples[i+1].at) >= throughputWindow {
+		i++
+	}
+	h.samples = h.samples[i:]
+}
+
+func (h *health) throughput(now time.Time) float64 {
+	h.trim(now)
+	if len(h.samples) == 0 {
+		return 0
+	}
+
+	oldest := h.samples[0]
+	elapsed := now.Sub(oldest.at)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	// the oldest sample's own block was committed before the measured period started
+	return float64(h.committed-oldest.committed) / elapsed.Seconds()
+}
+
+// Status returns a snapshot of the parser health
+func (c *Client) Status() Status {
+	now := c.clock.Now()
+
+	status := Status{
+		State:              c.State(),
+		Paused:             c.Paused(),
+		LastProcessedBlock: int32(c.GetCurrentBlock()),
+	}
+
+	if tip := c.tip.Load(); tip != nil {
+		status.Head = tip.head
+		status.Lag = max(tip.head-max(status.LastProcessedBlock, c.idleThrough.Load()), 0)
+	}
+
+	status.Subscribers = int(c.subscriberN.Load())
+	status.Subscriptions = int(c.subscriptionN.Load())
+
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+
+	status.LastSuccess = c.health.lastSuccess
+	status.LastError = c.health.lastError
+	status.LastErrorAt = c.health.lastErrorAt
+	status.ConsecutiveErrors = c.health.consecutiveErrors
+	status.RPC = c.health.rpc
+	status.BlocksPerSecond = c.health.throughput(now)
+
+	return status
+}
+
+// recordGauges reports the head, the lag and the number of subscriptions, after every poll whether paused or not
+func (c *Client) recordGauges() {
+	status := c.Status()
+	c.metrics.SetGauge(metricHeadBlock, float64(status.Head))
+	c.metrics.SetGauge(metricHeadLag, float64(status.Lag))
+	c.metrics.SetGauge(metricSubscribers, float64(status.Subscribers))
+	c.metrics.SetGauge(metricSubscriptions, float64(status.Subscriptions))
+}
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// downChain fails to report its head while down is set
+type downChain struct {
+	*fakeChain
+	down *atomic.Bool
+}
+
+func (c downChain) GetBlockCount(ctx context.Context) (int32, error) {
+	if c.down.Load() {
+		return 0, errors.New("node unreachable")
+	}
+
+	return c.fakeChain.GetBlockCount(ctx)
+}
+
+func TestStatusLag(t *testing.T) {
+	c, chain, filter, _ := startRescanClient(t)
+
+	filter.hold(5)
+	chain.extend(4, "a")
+	waitFor(t, "the parser to be held at block 4", func() bool {
+		s := c.Status()
+		return s.Head == 7 && s.LastProcessedBlock == 4
+	})
+
+	s := c.Status()
+	if s.Lag != 3 || !s.Ready(3) || s.Ready(2) {
+		t.Errorf("lag %d, ready within 3 blocks %v and within 2 %v, want 3, true and false", s.Lag, s.Ready(3), s.Ready(2))
+	}
+
+	filter.release(5)
+	waitForBlock(t, c, chain.head())
+
+	if s := c.Status(); s.Lag != 0 || !s.Ready(0) {
+		t.Errorf("lag %d once caught up, want 0", s.Lag)
+	}
+}
+
+func TestStatusIdleParserIsReady(t *testing.T) {
+	chain := newFakeChain(5)
+	c := startClient(t, chain, testConfig())
+
+	waitFor(t, "a check of the head", func() bool {
+		s := c.Status()
+		return s.Head == 4 && !s.LastSuccess.IsZero()
+	})
+
+	// with nobody subscribed, no block is committed but none is waiting to be processed either
+	chain.extend(3, "a")
+	waitFor(t, "the new head", func() bool { return c.Status().Head == 7 })
+
+	s := c.Status()
+	if s.Lag != 0 || !s.Ready(0) {
+		t.Errorf("idle parser at block %d lags %d blocks, ready %v, want 0 and ready", s.LastProcessedBlock, s.Lag, s.Ready(0))
+	}
+
+	// once subscribed, the skipped blocks are processed and the lag is real again
+	subscribeAndSync(t, c, chain, addrA, nil)
+	if s := c.Status(); s.Lag != 0 || s.LastProcessedBlock != 7 {
+		t.Errorf("lag %d at block %d after subscribing, want 0 at block 7", s.Lag, s.LastProcessedBlock)
+	}
+}
+
+func TestStatusConsecutiveErrorsAndRPCHealth(t *testing.T) {
+	down := &atomic.Bool{}
+	c := NewClientWithConfig(downChain{fakeChain: newFakeChain(3), down: down}, memory.NewTransactionMemoryStore(), testConfig())
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	t.Cleanup(func() { c.Stop(context.Background()) })
+
+	waitFor(t, "a successful check", func() bool {
+		s := c.Status()
+		return !s.LastSuccess.IsZero() && s.RPC.Healthy
+	})
+
+	down.Store(true)
+	waitFor(t, "three failed checks", func() bool { return c.Status().ConsecutiveErrors >= 3 })
+
+	s := c.Status()
+	if s.RPC.Healthy || s.RPC.LastError != "node unreachable" || s.RPC.LastErrorAt.IsZero() {
+		t.Errorf("RPC status %+v while the node is down, want unhealthy with its error", s.RPC)
+	}
+
+	if s.LastError == "" || s.LastErrorAt.Before(s.LastSuccess) {
+		t.Errorf("last error %q at %v, want the failed check after the success at %v", s.LastError, s.LastErrorAt, s.LastSuccess)
+	}
+
+	if !s.Live() || s.Ready(math.MaxInt32) {
+		t.Errorf("live %v and ready %v while the node is down, want live and not ready", s.Live(), s.Ready(math.MaxInt32))
+	}
+
+	down.Store(false)
+	waitFor(t, "a successful check", func() bool { return c.Status().ConsecutiveErrors == 0 })
+
+	s = c.Status()
+	if !s.RPC.Healthy || s.RPC.LastSuccess.Before(s.RPC.LastErrorAt) {
+		t.Errorf("RPC status %+v after the node came back, want healthy", s.RPC)
+	}
+
+	// the last error is kept for diagnosis
+	if s.LastError == "" || s.RPC.LastError == "" {
+		t.Errorf("errors cleared after recovering: %q and %q", s.LastError, s.RPC.LastError)
+	}
+
+	if !s.Ready(0) {
+		t.Error("not ready after the node came back")
+	}
+}
+
+func TestHealthThroughput(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	tests := []struct {
+		name    string
+		commits []int // seconds after the start
+		now     int
+		want    float64
+	}{
+		{"no commit", nil, 10, 0},
+		{"single commit", []int{0}, 10, 0},
+		{"steady", []int{0, 10, 20, 30}, 30, 0.1},
+		{"measured from the window edge", []int{0, 10, 20, 30}, 75, 2.0 / 65},
+		{"idle for longer than the window", []int{0, 10, 20, 30}, 100, 0},
+		{"measured at the last commit", []int{0, 1}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h health
+			for _, commit := range tt.commits {
+				h.recordCommit(at(commit))
+			}
+
+			if got := h.throughput(at(tt.now)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("throughput = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
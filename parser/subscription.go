@@ -0,0 +1,505 @@
+package parser
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+// SlowConsumerPolicy decides what happens to an event when the subscription buffer is full
+type SlowConsumerPolicy int
+
+const (
+	// PolicyDrop discards the event and counts it in Dropped
+	PolicyDrop SlowConsumerPolicy = iota
+	// PolicyBlock waits for the consumer, which holds up block processing for every subscriber
+	PolicyBlock
+	// PolicyDisconnect closes the subscription with ErrSlowConsumer. Its persisted record is kept,
+	// so it is restored when the parser starts again.
+	PolicyDisconnect
+)
+
+// SubscribeOpts can be provided to SubscribeWithOpts to change how events are delivered
+// and to backfill the history of the address
+type SubscribeOpts struct {
+	// FromBlock is the first block to backfill, zero means no backfill
+	FromBlock int32
+	// FromGenesis backfills the whole history of the address
+	FromGenesis bool
+
+	// BufferSize is the number of undelivered events kept for the consumer, Config.SubscriptionBufferSize by default
+	BufferSize int
+	// Policy applies once the buffer is full, PolicyDrop by default
+	Policy SlowConsumerPolicy
+	// Callback, when set, is called for every event from a dedicated goroutine instead of exposing the channel
+	Callback func(domain.Event)
+
+	// WebhookURL, when set, receives the events as signed JSON posts instead of the channel
+	WebhookURL string
+	// WebhookSecret is the HMAC-SHA256 key used to sign the posts, it is persisted encrypted with Webhook.SecretKey
+	WebhookSecret string
+
+	// Owner identifies the tenant the subscription belongs to
+	Owner string
+	// Labels are free-form metadata that subscriptions can be listed by
+	Labels map[string]string
+
+	// Filter selects the transactions of the address to receive, all of them by default.
+	// Transactions no subscription of the address wants are not stored either.
+	Filter domain.TransactionFilter
+
+	// TrackNonces checks the transaction sequence of the address as a sender for nonce gaps and stuck transactions
+	TrackNonces bool
+
+	// TrackBalance keeps a running balance of the address and reconciles it with the node.
+	// Every transaction of the address is then stored, whatever the filters.
+	TrackBalance bool
+}
+
+// Subscription is a handle on a subscribed address that receives its transactions as they are committed,
+// the transactions reverted by reorgs and the reorgs themselves
+type Subscription struct {
+	ID      string
+	Address string
+
+	client     *Client
+	policy     SlowConsumerPolicy
+	bufferSize int
+	events     chan domain.Event // allocated on first use, webhook subscriptions never need it
+	eventsOnce sync.Once
+	callback   bool   // events are consumed by the callback goroutine
+	dropped    uint64 // atomic
+
+	webhookURL    string
+	webhookSecret string
+	filter        domain.TransactionFilter
+	trackNonces   bool
+	trackBalance  bool
+
+	backfill *backfill    // nil when no history was requested
+	liveFrom atomic.Int32 // first block delivered by tip-following, the backfill delivers the ones before it
+
+	infoMu sync.RWMutex // guards info, only its labels change after creation
+	info   domain.Subscription
+
+	mu        sync.RWMutex // held for reading while sending, for writing while closing events
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Events returns the channel events are delivered on, it is closed when the subscription ends.
+// It returns nil for subscriptions delivering to a callback or a webhook.
+func (s *Subscription) Events() <-chan domain.Event {
+	if s.callback || s.webhookURL != "" {
+		return nil
+	}
+
+	return s.channel()
+}
+
+// channel returns the events channel, allocating its buffer the first time
+func (s *Subscription) channel() chan domain.Event {
+	s.eventsOnce.Do(func() {
+		s.events = make(chan domain.Event, s.bufferSize)
+	})
+
+	return s.events
+}
+
+// Done is closed when the subscription ends
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended: nil when unsubscribed, ErrSlowConsumer when disconnected
+func (s *Subscription) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.err
+}
+
+// Info returns the subscription record
+func (s *Subscription) Info() domain.Subscription {
+	s.infoMu.RLock()
+	defer s.infoMu.RUnlock()
+
+	info := s.info
+	info.Labels = copyLabels(s.info.Labels)
+	info.WebhookSecret = ""
+	info.SealedWebhookSecret = nil
+	if s.backfill != nil {
+		progress := s.backfill.get()
+		info.Backfill = &progress
+	}
+
+	return info
+}
+
+// live reports whether tip-following delivers the transaction to the subscription,
+// a pending one has no block and always is
+func (s *Subscription) live(tx domain.Transaction) bool {
+	return tx.Block == 0 || tx.Block >= s.liveFrom.Load()
+}
+
+// activateBackfill hands the blocks from next on over to tip-following and ends the backfill before them
+func (s *Subscription) activateBackfill(next int32) {
+	s.liveFrom.Store(next)
+	s.backfill.activate(next)
+}
+
+// Dropped returns the number of events discarded because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe stops the delivery of events. The address stops being tracked once its last subscription ends.
+func (s *Subscription) Unsubscribe() {
+	s.close(nil)
+}
+
+func (s *Subscription) close(reason error) {
+	s.closeOnce.Do(func() {
+		// unblock senders waiting on a full buffer before taking the write lock
+		close(s.done)
+
+		s.mu.Lock()
+		s.closed = true
+		s.err = reason
+		// a channel nobody asked for is closed without its buffer
+		s.eventsOnce.Do(func() {
+			s.events = make(chan domain.Event)
+		})
+		close(s.events)
+		s.mu.Unlock()
+
+		if reason == ErrSlowConsumer {
+			s.client.metrics.IncCounter(metricSlowDisconnects, 1)
+		}
+
+		// only unsubscribing forgets the subscription, a disconnected one is restored on the next run
+		s.client.removeSubscription(s, reason == nil)
+	})
+}
+
+func (s *Subscription) deliver(ctx context.Context, event domain.Event) {
+	// webhook subscriptions get their events through the persisted deliveries instead
+	if s.webhookURL != "" {
+		return
+	}
+
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return
+	}
+
+	events := s.channel()
+	delivered := true
+	select {
+	case events <- event:
+	default:
+		switch s.policy {
+		case PolicyBlock:
+			select {
+			case events <- event:
+			case <-s.done:
+			case <-ctx.Done():
+			}
+		case PolicyDisconnect:
+			delivered = false
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			s.client.metrics.IncCounter(metricEventsDropped, 1)
+		}
+	}
+	s.mu.RUnlock()
+
+	if !delivered {
+		s.close(ErrSlowConsumer)
+	}
+}
+
+// subscriber is a subscribed address, picked up by the processing loop on its next tick
+type subscriber struct {
+	address    string
+	activeFrom int32         // first block processed with the address, set once by the processing loop
+	activated  chan struct{} // closed once activeFrom is set
+
+	mu            sync.RWMutex
+	removed       bool // set when the last subscription ended, a new subscriber must then be created
+	subscriptions map[string]*Subscription
+}
+
+func (sub *subscriber) handles() []*Subscription {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	handles := make([]*Subscription, 0, len(sub.subscriptions))
+	for _, s := range sub.subscriptions {
+		handles = append(handles, s)
+	}
+
+	return handles
+}
+
+// wants reports whether a subscription of the address is interested in the transaction,
+// one tracking the balance needs all of them
+func (sub *subscriber) wants(tx domain.Transaction) bool {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	for _, s := range sub.subscriptions {
+		if s.trackBalance || s.filter.Matches(sub.address, tx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Client) Subscribe(address string) (*Subscription, error) {
+	return c.SubscribeWithOpts(address, nil)
+}
+
+// SubscribeWithOpts subscribes the address, in any case or in its EIP-55 checksummed form, and, if requested, backfills its history in the background.
+// An address can have several subscriptions, each backfills its own history and receives live blocks once it is done with them.
+func (c *Client) SubscribeWithOpts(address string, opts *SubscribeOpts) (*Subscription, error) {
+	if atomic.LoadInt32(&c.isRunning) == 0 {
+		return nil, fmt.Errorf("cannot subscribe while parser is not running")
+	}
+
+	address, err := domain.NormalizeAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot subscribe: %w", err)
+	}
+
+	if opts == nil {
+		opts = &SubscribeOpts{}
+	}
+
+	if opts.WebhookURL != "" && (c.config.Webhook.Deliveries == nil || c.config.Webhook.Sender == nil) {
+		return nil, fmt.Errorf("cannot subscribe with a webhook: webhook delivery is not configured")
+	}
+
+	filter, err := opts.Filter.Normalize()
+	if err != nil {
+		return nil, fmt.Errorf("cannot subscribe: %w", err)
+	}
+
+	backfillFrom := int32(0)
+	if opts.FromBlock > 0 || opts.FromGenesis {
+		backfillFrom = max(opts.FromBlock, 1)
+	}
+
+	startBlock := backfillFrom
+	if startBlock == 0 {
+		startBlock = atomic.LoadInt32(&c.latestBlock) + 1
+	}
+
+	info := domain.Subscription{
+		ID:            newID(),
+		Address:       address,
+		Owner:         opts.Owner,
+		Labels:        copyLabels(opts.Labels),
+		StartBlock:    startBlock,
+		CreatedAt:     c.clock.Now().UTC(),
+		Filter:        filter,
+		TrackNonces:   opts.TrackNonces,
+		TrackBalance:  opts.TrackBalance,
+		WebhookURL:    opts.WebhookURL,
+		WebhookSecret: opts.WebhookSecret,
+	}
+
+	if backfillFrom > 0 {
+		info.Backfill = &domain.BackfillProgress{
+			SubscriptionID: info.ID,
+			Address:        address,
+			Status:         domain.BackfillWaiting,
+			From:           backfillFrom,
+			Current:        backfillFrom - 1,
+		}
+	}
+
+	if c.config.Subscriptions != nil {
+		if info.WebhookSecret != "" {
+			if info.SealedWebhookSecret, err = c.config.Webhook.sealSecret(info.WebhookSecret); err != nil {
+				return nil, fmt.Errorf("cannot subscribe with a webhook secret: %w", err)
+			}
+		}
+
+		if err := c.config.Subscriptions.Save(info); err != nil {
+			return nil, fmt.Errorf("unable to save subscription: %w", err)
+		}
+	}
+
+	s := c.newSubscription(info, opts)
+	c.attach(s)
+	c.startBackfill(s)
+
+	return s, nil
+}
+
+func (c *Client) newSubscription(info domain.Subscription, opts *SubscribeOpts) *Subscription {
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = c.config.SubscriptionBufferSize
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriptionBufferSize
+	}
+
+	s := &Subscription{
+		ID:         info.ID,
+		Address:    info.Address,
+		client:     c,
+		policy:     opts.Policy,
+		bufferSize: bufferSize,
+		done:       make(chan struct{}),
+		info:       info,
+
+		webhookURL:    info.WebhookURL,
+		webhookSecret: info.WebhookSecret,
+		filter:        info.Filter,
+		trackNonces:   info.TrackNonces,
+		trackBalance:  info.TrackBalance,
+	}
+
+	if info.Backfill != nil {
+		s.backfill = newBackfill(*info.Backfill)
+		switch info.Backfill.Status {
+		case domain.BackfillWaiting:
+			// nothing is delivered live until the processing loop fixes where the backfill ends
+			s.liveFrom.Store(math.MaxInt32)
+		case domain.BackfillRunning:
+			s.liveFrom.Store(info.Backfill.To + 1)
+		}
+	}
+
+	if opts.Callback != nil {
+		s.callback = true
+		events := s.channel()
+		go func() {
+			for event := range events {
+				opts.Callback(event)
+			}
+		}()
+	}
+
+	return s
+}
+
+// attach adds the subscription to the subscriber of its address, creating the subscriber if needed
+func (c *Client) attach(s *Subscription) {
+	if _, loaded := c.subscriptions.LoadOrStore(s.ID, s); !loaded {
+		c.subscriptionN.Add(1)
+	}
+
+	for {
+		sub := &subscriber{
+			address:       s.Address,
+			activated:     make(chan struct{}),
+			subscriptions: make(map[string]*Subscription),
+		}
+
+		value, loaded := c.subscribers.LoadOrStore(s.Address, sub)
+		actual := value.(*subscriber)
+
+		actual.mu.Lock()
+		if actual.removed {
+			// lost a race with the last subscription of the address ending, try again
+			actual.mu.Unlock()
+			continue
+		}
+		actual.subscriptions[s.ID] = s
+		if !loaded {
+			c.subscriberN.Add(1)
+			c.queueWatch(watchUpdate{change: watchChange{address: s.Address}, sub: actual})
+		}
+		actual.mu.Unlock()
+		return
+	}
+}
+
+func (c *Client) removeSubscription(s *Subscription, forget bool) {
+	if _, loaded := c.subscriptions.LoadAndDelete(s.ID); loaded {
+		c.subscriptionN.Add(-1)
+	}
+
+	if forget && c.config.Subscriptions != nil {
+		if err := c.config.Subscriptions.Delete(s.ID); err != nil {
+			c.logger.Errorf("Error deleting subscription %s: %v", s.ID, err)
+		}
+	}
+
+	value, ok := c.subscribers.Load(s.Address)
+	if !ok {
+		return
+	}
+
+	sub := value.(*subscriber)
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	delete(sub.subscriptions, s.ID)
+	if len(sub.subscriptions) == 0 && !sub.removed {
+		sub.removed = true
+		// queued before the subscriber is deleted so that it precedes the addition of the next one
+		c.queueWatch(watchUpdate{change: watchChange{address: s.Address, remove: true}})
+		if c.subscribers.CompareAndDelete(s.Address, sub) {
+			c.subscriberN.Add(-1)
+		}
+	}
+}
+
+// notify delivers committed transactions to the subscriptions of their sender, receiver and token transfer parties
+func (c *Client) notify(ctx context.Context, eventType domain.EventType, txs domain.Transactions) {
+	for _, tx := range txs {
+		delivered := make(map[string]struct{})
+		for _, address := range tx.Addresses() {
+			value, ok := c.subscribers.Load(address)
+			if !ok {
+				continue
+			}
+
+			for _, s := range value.(*subscriber).handles() {
+				if _, ok := delivered[s.ID]; ok || !s.live(tx) || !s.filter.Matches(address, tx) {
+					continue
+				}
+				delivered[s.ID] = struct{}{}
+				s.deliver(ctx, domain.Event{Type: eventType, Transaction: tx})
+			}
+		}
+	}
+}
+
+// notifyReorg delivers the reverted transactions to their subscriptions and the reorg summary to every subscription
+func (c *Client) notifyReorg(ctx context.Context, event domain.ReorgEvent) {
+	c.notify(ctx, domain.EventRevert, event.Reverted)
+
+	c.subscribers.Range(func(key, value interface{}) bool {
+		for _, s := range value.(*subscriber).handles() {
+			s.deliver(ctx, domain.Event{Type: domain.EventReorg, Reorg: &event})
+		}
+		return true
+	})
+}
+
+// newID returns a random identifier for a subscription or an alert rule
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("unable to generate id: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,330 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/metrics"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+)
+
+// allocated returns the events channel of the subscription without allocating it
+func allocated(s *Subscription) chan domain.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.events
+}
+
+func TestSubscriptionChannelAllocatedOnUse(t *testing.T) {
+	r := newReceiver(t)
+	chain := newFakeChain(3)
+
+	cfg := webhookConfig()
+	cfg.SubscriptionBufferSize = 4
+	c := startClient(t, chain, cfg)
+
+	hook := subscribeAndSync(t, c, chain, addrA, webhookOpts(r))
+	idle, err := c.Subscribe(addrC)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	busy := subscribeAndSync(t, c, chain, addrB, nil)
+	chain.extend(1, "a")
+	waitFor(t, "an event", func() bool { return allocated(busy) != nil })
+
+	if hook.Events() != nil || allocated(hook) != nil {
+		t.Error("webhook subscription has an events channel")
+	}
+
+	if allocated(idle) != nil {
+		t.Error("subscription without events allocated its channel")
+	}
+
+	if n := cap(allocated(busy)); n != 4 {
+		t.Errorf("channel of the subscription with events holds %d, want the configured 4", n)
+	}
+
+	if events := idle.Events(); cap(events) != 4 {
+		t.Errorf("Events() holds %d, want the configured 4", cap(events))
+	}
+
+	idle.Unsubscribe()
+	hook.Unsubscribe()
+	if _, ok := <-idle.Events(); ok {
+		t.Error("channel of an ended subscription is open")
+	}
+}
+
+func TestStatusCountsSubscriptions(t *testing.T) {
+	chain := newFakeChain(3)
+	c := startClient(t, chain, testConfig())
+
+	first := subscribeAndSync(t, c, chain, addrA, nil)
+	second := subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{Filter: domain.TransactionFilter{Direction: domain.DirectionOut}})
+	subscribeAndSync(t, c, chain, addrB, nil)
+
+	if s := c.Status(); s.Subscribers != 2 || s.Subscriptions != 3 {
+		t.Errorf("status counts %d addresses and %d subscriptions, want 2 and 3", s.Subscribers, s.Subscriptions)
+	}
+
+	first.Unsubscribe()
+	first.Unsubscribe()
+	second.Unsubscribe()
+
+	if s := c.Status(); s.Subscribers != 1 || s.Subscriptions != 1 {
+		t.Errorf("status counts %d addresses and %d subscriptions after unsubscribing, want 1 and 1", s.Subscribers, s.Subscriptions)
+	}
+}
+
+func TestDisconnectedSubscriptionIsRestored(t *testing.T) {
+	chain := newFakeChain(3)
+	subs := memory.NewSubscriptionMemoryStore()
+
+	cfg := testConfig()
+	cfg.Subscriptions = subs
+	c := startClient(t, chain, cfg)
+
+	slow := subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{BufferSize: 1, Policy: PolicyDisconnect})
+	ended := subscribeAndSync(t, c, chain, addrA, nil)
+	ended.Unsubscribe()
+
+	chain.extend(3, "a")
+	select {
+	case <-slow.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow consumer wasn't disconnected")
+	}
+
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Fatalf("Err() = %v, want ErrSlowConsumer", slow.Err())
+	}
+
+	saved, err := subs.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	if len(saved) != 1 || saved[0].ID != slow.ID {
+		t.Fatalf("persisted subscriptions = %+v, want only the disconnected one", saved)
+	}
+
+	c.Stop(context.Background())
+	restarted := startClient(t, chain, cfg)
+	if _, err := restarted.GetSubscription(slow.ID); err != nil {
+		t.Errorf("disconnected subscription wasn't restored: %v", err)
+	}
+}
+
+func TestSubscriptionReceivesTokenDeposits(t *testing.T) {
+	const token = "0x00000000000000000000000000000000000000cc"
+
+	chain := newFakeChain(3)
+	chain.setTransactions(1, domain.Transaction{From: addrA, To: token, TxID: "deposit", Value: "0x0", TokenTransfers: []domain.TokenTransfer{{Contract: token, From: addrA, To: addrB, Value: "0x64"}}})
+	chain.setTransactions(2, domain.Transaction{From: addrA, To: token, TxID: "elsewhere", Value: "0x0", TokenTransfers: []domain.TokenTransfer{{Contract: token, From: addrA, To: addrC, Value: "0x64"}}})
+	c := startClient(t, chain, testConfig())
+	deposits := subscribeAndSync(t, c, chain, addrB, &SubscribeOpts{Filter: domain.TransactionFilter{Direction: domain.DirectionIn, TokenContracts: []string{token}}})
+
+	select {
+	case event := <-deposits.Events():
+		if event.Transaction.TxID != "deposit" {
+			t.Errorf("received %s, want the token deposit", event.Transaction.TxID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("token deposit not delivered")
+	}
+
+	select {
+	case event := <-deposits.Events():
+		t.Errorf("received %+v, want only the token deposit", event)
+	default:
+	}
+
+	txs, err := c.GetTransactions(addrB)
+	if err != nil {
+		t.Fatalf("GetTransactions: %v", err)
+	}
+
+	if len(txs) != 1 || txs[0].TxID != "deposit" {
+		t.Errorf("stored transactions of the recipient %+v, want the token deposit", txs)
+	}
+}
+
+// counters keeps the totals of the counters
+type counters struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (c *counters) IncCounter(name string, delta float64, labels ...metrics.Label) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[name] += delta
+}
+
+func (c *counters) SetGauge(name string, value float64, labels ...metrics.Label) {}
+
+func (c *counters) Observe(name string, value float64, labels ...metrics.Label) {}
+
+func (c *counters) get(name string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[name]
+}
+
+// drain reads the buffered events until the channel is closed, failing the test if it isn't
+func drain(t *testing.T, events <-chan domain.Event) int {
+	t.Helper()
+
+	n := 0
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return n
+			}
+			n++
+		case <-time.After(5 * time.Second):
+			t.Fatal("events channel not closed")
+		}
+	}
+}
+
+func TestSlowConsumerDrop(t *testing.T) {
+	chain := newFakeChain(10)
+	sink := &counters{values: make(map[string]float64)}
+	cfg := testConfig()
+	cfg.Metrics = sink
+	c := startClient(t, chain, cfg)
+
+	// never read
+	s := subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{BufferSize: 2, Policy: PolicyDrop})
+
+	// blocks 1 to 9 each hold a transaction of the address, the buffer keeps the first two
+	if n := s.Dropped(); n != 7 {
+		t.Errorf("Dropped = %d, want 7", n)
+	}
+
+	if n := sink.get(metricEventsDropped); n != 7 {
+		t.Errorf("dropped events counter = %v, want 7", n)
+	}
+
+	if n := sink.get(metricSlowDisconnects); n != 0 {
+		t.Errorf("disconnects counter = %v, want 0", n)
+	}
+
+	if event := <-s.Events(); event.Transaction.Block != 1 {
+		t.Errorf("first buffered event is of block %d, want 1", event.Transaction.Block)
+	}
+
+	select {
+	case <-s.Done():
+		t.Error("subscription dropping events was closed")
+	default:
+	}
+}
+
+func TestSlowConsumerBlock(t *testing.T) {
+	chain := newFakeChain(6)
+	c := startClient(t, chain, testConfig())
+
+	s, err := c.SubscribeWithOpts(addrA, &SubscribeOpts{BufferSize: 1, Policy: PolicyBlock})
+	if err != nil {
+		t.Fatalf("SubscribeWithOpts: %v", err)
+	}
+
+	// block 1 fills the buffer and block 2, committed, waits for the consumer
+	waitForBlock(t, c, 2)
+	time.Sleep(20 * time.Millisecond)
+	if block := c.GetCurrentBlock(); block != 2 {
+		t.Fatalf("processed up to block %d while the consumer doesn't read, want 2", block)
+	}
+
+	for want := int32(1); want <= chain.head(); want++ {
+		if event := <-s.Events(); event.Transaction.Block != want {
+			t.Fatalf("received an event of block %d, want %d", event.Transaction.Block, want)
+		}
+	}
+	waitForBlock(t, c, chain.head())
+
+	if n := s.Dropped(); n != 0 {
+		t.Errorf("Dropped = %d, want 0", n)
+	}
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	chain := newFakeChain(6)
+	sink := &counters{values: make(map[string]float64)}
+	cfg := testConfig()
+	cfg.Metrics = sink
+	c := startClient(t, chain, cfg)
+
+	// never read
+	s := subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{BufferSize: 1, Policy: PolicyDisconnect})
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow subscription not disconnected")
+	}
+
+	if err := s.Err(); !errors.Is(err, ErrSlowConsumer) {
+		t.Errorf("Err = %v, want ErrSlowConsumer", err)
+	}
+
+	if n := drain(t, s.Events()); n != 1 {
+		t.Errorf("%d events left in the buffer, want the one it holds", n)
+	}
+
+	if n := sink.get(metricSlowDisconnects); n != 1 {
+		t.Errorf("disconnects counter = %v, want 1", n)
+	}
+
+	if n := sink.get(metricEventsDropped); n != 0 {
+		t.Errorf("dropped events counter = %v, want 0", n)
+	}
+
+	if status := c.Status(); status.Subscriptions != 0 {
+		t.Errorf("%d subscriptions left after the disconnect, want 0", status.Subscriptions)
+	}
+}
+
+func TestUnsubscribeWhileDelivering(t *testing.T) {
+	chain := newFakeChain(6)
+	c := startClient(t, chain, testConfig())
+
+	s, err := c.SubscribeWithOpts(addrA, &SubscribeOpts{BufferSize: 1, Policy: PolicyBlock})
+	if err != nil {
+		t.Fatalf("SubscribeWithOpts: %v", err)
+	}
+
+	// the delivery of block 2 waits on the full buffer
+	waitForBlock(t, c, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Unsubscribe()
+		}()
+	}
+	wg.Wait()
+
+	if n := drain(t, s.Events()); n != 1 {
+		t.Errorf("%d events left in the buffer, want the one it holds", n)
+	}
+
+	if err := s.Err(); err != nil {
+		t.Errorf("Err = %v after unsubscribing, want nil", err)
+	}
+
+	// the pending delivery gave up and processing carries on
+	waitForBlock(t, c, chain.head())
+}
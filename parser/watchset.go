@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"encoding/binary"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	// watchShards is the number of shards of a watch set, an update only copies the shards it touches
+	watchShards = 256
+
+	// bloomBitsPerAddress and bloomHashes give a false positive rate of about 1%
+	bloomBitsPerAddress = 10
+	bloomHashes         = 4
+)
+
+// watchSet is an immutable snapshot of the watched addresses. Readers, such as the fetch workers matching
+// the transactions of a block, use a snapshot without locking while updates build the next one.
+// Addresses are sharded on their first byte and an update copies only the shards it touches,
+// so that subscribing a few addresses next to millions of others doesn't copy them all.
+type watchSet struct {
+	shards [watchShards]*watchShard // nil when the shard is empty
+	size   int
+	bloom  bool
+}
+
+type watchShard struct {
+	addresses map[domain.Address]struct{}
+	// bloom is a prefilter that rejects most unwatched addresses before the map lookup, nil when disabled
+	bloom []uint64
+}
+
+// watchChange adds or removes an address
+type watchChange struct {
+	address string
+	remove  bool
+}
+
+// watchUpdate is a change waiting to be applied, along with the subscriber to activate for an added address
+// or the subscription whose backfill ends before the next block
+type watchUpdate struct {
+	change   watchChange // empty for a backfill only
+	sub      *subscriber
+	backfill *Subscription
+}
+
+var _ domain.AddressMatcher = (*watchSet)(nil)
+
+func newWatchSet(bloom bool) *watchSet {
+	return &watchSet{bloom: bloom}
+}
+
+// newWatchSetOf returns a set of the given addresses, for one-off filters such as a backfill
+func newWatchSetOf(addresses ...string) *watchSet {
+	changes := make([]watchChange, len(addresses))
+	for i, address := range addresses {
+		changes[i] = watchChange{address: address}
+	}
+
+	return newWatchSet(false).apply(changes)
+}
+
+func (w *watchSet) len() int {
+	return w.size
+}
+
+// Contains reports whether the address, in any case, is watched
+func (w *watchSet) Contains(address string) bool {
+	a, ok := decodeWatched(address)
+	if !ok {
+		return false
+	}
+
+	shard := w.shards[a[0]]
+	if shard == nil {
+		return false
+	}
+
+	if shard.bloom != nil && !shard.mayContain(a) {
+		return false
+	}
+
+	_, ok = shard.addresses[a]
+	return ok
+}
+
+// apply returns a new snapshot with the changes applied in order, copying only the shards they touch
+func (w *watchSet) apply(changes []watchChange) *watchSet {
+	next := *w
+
+	touched := make(map[byte]*watchShard)
+	for _, change := range changes {
+		// addresses are validated when subscribing
+		a, err := domain.ParseAddress(change.address)
+		if err != nil {
+			continue
+		}
+
+		shard, ok := touched[a[0]]
+		if !ok {
+			shard = w.shards[a[0]].clone()
+			touched[a[0]] = shard
+		}
+
+		if change.remove {
+			delete(shard.addresses, a)
+		} else {
+			shard.addresses[a] = struct{}{}
+		}
+	}
+
+	for i, shard := range touched {
+		next.size += len(shard.addresses)
+		if old := w.shards[i]; old != nil {
+			next.size -= len(old.addresses)
+		}
+
+		if len(shard.addresses) == 0 {
+			next.shards[i] = nil
+			continue
+		}
+
+		if w.bloom {
+			shard.buildBloom()
+		}
+		next.shards[i] = shard
+	}
+
+	return &next
+}
+
+func (s *watchShard) clone() *watchShard {
+	if s == nil {
+		return &watchShard{addresses: make(map[domain.Address]struct{})}
+	}
+
+	addresses := make(map[domain.Address]struct{}, len(s.addresses)+1)
+	for a := range s.addresses {
+		addresses[a] = struct{}{}
+	}
+
+	return &watchShard{addresses: addresses}
+}
+
+func (s *watchShard) buildBloom() {
+	words := 1
+	for words*64 < len(s.addresses)*bloomBitsPerAddress {
+		words *= 2
+	}
+
+	s.bloom = make([]uint64, words)
+	for a := range s.addresses {
+		for _, bit := range bloomBits(a, len(s.bloom)*64) {
+			s.bloom[bit/64] |= 1 << (bit % 64)
+		}
+	}
+}
+
+func (s *watchShard) mayContain(a domain.Address) bool {
+	for _, bit := range bloomBits(a, len(s.bloom)*64) {
+		if s.bloom[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bloomBits returns the bits of an address in a bloom filter of m bits, m being a power of two.
+// Addresses are derived from hashes, so their bytes serve as independent hashes; the first byte picks the shard.
+func bloomBits(a domain.Address, m int) [bloomHashes]uint32 {
+	var bits [bloomHashes]uint32
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint32(a[1+4*i:]) & uint32(m-1)
+	}
+
+	return bits
+}
+
+// decodeWatched decodes a 0x prefixed hex address without allocating, ok is false for anything else
+func decodeWatched(s string) (a domain.Address, ok bool) {
+	if len(s) != 2+2*domain.AddressLength || s[0] != '0' || (s[1] != 'x' && s[1] != 'X') {
+		return a, false
+	}
+
+	for i := 0; i < domain.AddressLength; i++ {
+		hi, ok1 := fromHexChar(s[2+2*i])
+		lo, ok2 := fromHexChar(s[3+2*i])
+		if !ok1 || !ok2 {
+			return a, false
+		}
+		a[i] = hi<<4 | lo
+	}
+
+	return a, true
+}
+
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+
+	return 0, false
+}
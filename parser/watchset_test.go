@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// testAddresses returns n distinct addresses derived from seed
+func testAddresses(n int, seed uint64) []string {
+	addresses := make([]string, n)
+	var a [20]byte
+	for i := range addresses {
+		// spread the counter over the first byte too so that every shard is used
+		binary.BigEndian.PutUint64(a[:8], (uint64(i)*0x9e3779b97f4a7c15)^seed)
+		binary.BigEndian.PutUint64(a[12:], uint64(i))
+		addresses[i] = "0x" + hex.EncodeToString(a[:])
+	}
+
+	return addresses
+}
+
+func watchSetOf(bloom bool, addresses []string) *watchSet {
+	changes := make([]watchChange, len(addresses))
+	for i, address := range addresses {
+		changes[i] = watchChange{address: address}
+	}
+
+	return newWatchSet(bloom).apply(changes)
+}
+
+func TestWatchSetApply(t *testing.T) {
+	for _, bloom := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bloom=%v", bloom), func(t *testing.T) {
+			addresses := testAddresses(1000, 1)
+			before := watchSetOf(bloom, addresses[:500])
+
+			after := before.apply([]watchChange{
+				{address: addresses[0], remove: true},
+				{address: addresses[500]},
+				{address: addresses[500]},
+			})
+
+			if before.len() != 500 || !before.Contains(addresses[0]) || before.Contains(addresses[500]) {
+				t.Errorf("apply changed the snapshot it was called on")
+			}
+
+			if after.len() != 500 || after.Contains(addresses[0]) || !after.Contains(addresses[500]) {
+				t.Errorf("snapshot holds %d addresses, want the first one swapped for a new one", after.len())
+			}
+
+			for _, address := range addresses[501:] {
+				if after.Contains(address) {
+					t.Fatalf("unwatched %s matched", address)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWatchSetContains matches addresses against a set of a million, half of the lookups miss
+func BenchmarkWatchSetContains(b *testing.B) {
+	const size = 1 << 20
+
+	watched := testAddresses(size, 1)
+	unwatched := testAddresses(size, 2)
+	for _, bloom := range []bool{false, true} {
+		set := watchSetOf(bloom, watched)
+		b.Run(fmt.Sprintf("bloom=%v", bloom), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if i%2 == 0 {
+					set.Contains(watched[i%size])
+				} else {
+					set.Contains(unwatched[i%size])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWatchSetApply adds then removes an address from a set of a million, as a subscription does
+func BenchmarkWatchSetApply(b *testing.B) {
+	const size = 1 << 20
+
+	extra := testAddresses(1, 3)[0]
+	for _, bloom := range []bool{false, true} {
+		set := watchSetOf(bloom, testAddresses(size, 1))
+		b.Run(fmt.Sprintf("bloom=%v", bloom), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				set.apply([]watchChange{{address: extra}}).apply([]watchChange{{address: extra, remove: true}})
+			}
+		})
+	}
+}
+
+// BenchmarkWatchSetMemory reports the heap held per address by a set of a million
+func BenchmarkWatchSetMemory(b *testing.B) {
+	const size = 1 << 20
+
+	addresses := testAddresses(size, 1)
+	for _, bloom := range []bool{false, true} {
+		b.Run(fmt.Sprintf("bloom=%v", bloom), func(b *testing.B) {
+			var held uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				set := watchSetOf(bloom, addresses)
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(set)
+				held = after.HeapAlloc - before.HeapAlloc
+			}
+			b.ReportMetric(float64(held)/size, "B/address")
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package parser
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+)
+
+const (
+	// webhookPollInterval is how often due retries are looked for when no new delivery wakes the dispatcher up
+	webhookPollInterval = time.Second
+	// webhookBatchSize is the number of due deliveries attempted per round
+	webhookBatchSize = 100
+)
+
+// webhookEvent is what is posted to one webhook subscription for one block or one reorg
+type webhookEvent struct {
+	subscription *Subscription
+	payload      domain.WebhookPayload
+}
+
+// enqueueWebhooks persists a delivery per webhook subscription touched by the transactions of a block
+func (c *Client) enqueueWebhooks(eventType domain.EventType, header domain.BlockHeader, txs domain.Transactions) error {
+	return c.enqueueBlockWebhooks(eventType, header, txs, string(eventType)+header.Hash)
+}
+
+// enqueueBlockWebhooks is enqueueWebhooks with the event key the idempotency keys are derived from
+func (c *Client) enqueueBlockWebhooks(eventType domain.EventType, header domain.BlockHeader, txs domain.Transactions, eventKey string) error {
+	if c.config.Webhook.Deliveries == nil {
+		return nil
+	}
+
+	events := make(map[string]*webhookEvent)
+	for _, tx := range txs {
+		for _, s := range c.webhookSubscriptions(tx) {
+			event, ok := events[s.ID]
+			if !ok {
+				event = &webhookEvent{subscription: s, payload: domain.WebhookPayload{
+					SubscriptionID: s.ID,
+					Address:        s.Address,
+					Type:           eventType,
+					Block:          header,
+				}}
+				events[s.ID] = event
+			}
+			event.payload.Transactions = append(event.payload.Transactions, tx)
+		}
+	}
+
+	return c.enqueueWebhookEvents(events, eventKey)
+}
+
+// enqueueSubscriptionWebhooks persists the delivery of backfilled transactions of a block to the one subscription
+// that requested them, under the same idempotency key as had the block been delivered live
+func (c *Client) enqueueSubscriptionWebhooks(s *Subscription, header domain.BlockHeader, txs domain.Transactions) error {
+	if c.config.Webhook.Deliveries == nil || s.webhookURL == "" {
+		return nil
+	}
+
+	events := map[string]*webhookEvent{s.ID: {subscription: s, payload: domain.WebhookPayload{
+		SubscriptionID: s.ID,
+		Address:        s.Address,
+		Type:           domain.EventTransaction,
+		Block:          header,
+		Transactions:   txs,
+	}}}
+
+	return c.enqueueWebhookEvents(events, string(domain.EventTransaction)+header.Hash)
+}
+
+// enqueueCommitted enqueues the webhooks of a committed block, so that nothing is delivered for a block that
+// failed to be stored. When that fails, the block is enqueued again before the next one is processed, and
+// every run starts by enqueueing the checkpoint's block again in case the previous one ended in between:
+// deliveries can be repeated under the same idempotency keys but never lost.
+func (c *Client) enqueueCommitted(b domain.Block) error {
+	if err := c.enqueueWebhooks(domain.EventTransaction, b.BlockHeader, b.Transactions); err != nil {
+		c.unenqueued = &b
+		return fmt.Errorf("error enqueuing webhooks for block %d: %w", b.Number, err)
+	}
+	c.unenqueued = nil
+
+	return nil
+}
+
+// enqueueUnenqueued retries the webhooks of the last committed block if they couldn't be enqueued
+func (c *Client) enqueueUnenqueued() error {
+	if c.unenqueued == nil {
+		return nil
+	}
+
+	return c.enqueueCommitted(*c.unenqueued)
+}
+
+// loadUnenqueued marks the checkpoint's block for its webhooks to be enqueued again when the run starts
+func (c *Client) loadUnenqueued() error {
+	last, ok := c.headers.last()
+	if !ok || c.config.Webhook.Deliveries == nil {
+		return nil
+	}
+
+	txs, err := c.txnStore.GetAllByBlock(last.Number)
+	if err != nil {
+		return fmt.Errorf("error getting transactions of block %d: %w", last.Number, err)
+	}
+
+	if len(txs) > 0 {
+		c.unenqueued = &domain.Block{BlockHeader: last, Transactions: txs}
+	}
+
+	return nil
+}
+
+// enqueueReorgWebhooks tells the webhook subscriptions whose transactions were reverted by a reorg about it
+func (c *Client) enqueueReorgWebhooks(event domain.ReorgEvent) error {
+	if c.config.Webhook.Deliveries == nil {
+		return nil
+	}
+
+	events := make(map[string]*webhookEvent)
+	for _, tx := range event.Reverted {
+		for _, s := range c.webhookSubscriptions(tx) {
+			e, ok := events[s.ID]
+			if !ok {
+				// each subscription only sees its own reverted transactions
+				reorg := event
+				reorg.Reverted = nil
+				e = &webhookEvent{subscription: s, payload: domain.WebhookPayload{
+					SubscriptionID: s.ID,
+					Address:        s.Address,
+					Type:           domain.EventReorg,
+					Block:          event.CommonAncestor,
+					Reorg:          &reorg,
+				}}
+				events[s.ID] = e
+			}
+			e.payload.Transactions = append(e.payload.Transactions, tx)
+			e.payload.Reorg.Reverted = append(e.payload.Reorg.Reverted, tx)
+		}
+	}
+
+	orphanedHead := ""
+	if len(event.Orphaned) > 0 {
+		orphanedHead = event.Orphaned[len(event.Orphaned)-1].Hash
+	}
+
+	return c.enqueueWebhookEvents(events, string(domain.EventReorg)+event.CommonAncestor.Hash+orphanedHead)
+}
+
+// enqueuePendingWebhooks persists a delivery per webhook subscription wanting a change of a pending transaction
+func (c *Client) enqueuePendingWebhooks(eventType domain.EventType, p domain.PendingTransaction) error {
+	if c.config.Webhook.Deliveries == nil {
+		return nil
+	}
+
+	events := make(map[string]*webhookEvent)
+	for _, s := range c.webhookSubscriptions(p.Transaction) {
+		events[s.ID] = &webhookEvent{subscription: s, payload: domain.WebhookPayload{
+			SubscriptionID: s.ID,
+			Address:        s.Address,
+			Type:           eventType,
+			Pending:        &p,
+		}}
+	}
+
+	return c.enqueueWebhookEvents(events, string(eventType)+p.TxID+strconv.FormatInt(p.UpdatedAt.UnixNano(), 10))
+}
+
+func (c *Client) enqueueWebhookEvents(events map[string]*webhookEvent, eventKey string) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	now := c.clock.Now().UTC()
+	for _, event := range events {
+		id := idempotencyKey(event.subscription.ID, eventKey)
+		event.payload.ID = id
+		event.payload.CreatedAt = now
+
+		payload, err := json.Marshal(event.payload)
+		if err != nil {
+			return fmt.Errorf("unable to marshal webhook payload for subscription %s: %w", event.subscription.ID, err)
+		}
+
+		d := domain.Delivery{
+			ID:             id,
+			SubscriptionID: event.subscription.ID,
+			URL:            event.subscription.webhookURL,
+			Payload:        payload,
+			Signature:      sign(payload, event.subscription.webhookSecret),
+			NextAttempt:    now,
+			CreatedAt:      now,
+		}
+
+		if err := c.config.Webhook.Deliveries.Enqueue(d); err != nil {
+			return fmt.Errorf("unable to enqueue webhook delivery %s: %w", id, err)
+		}
+	}
+
+	// wake the dispatcher up, unless it already has a pending wake up
+	select {
+	case c.webhookWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// webhookSubscriptions returns the webhook subscriptions of the addresses of the transaction wanting it, each once
+func (c *Client) webhookSubscriptions(tx domain.Transaction) []*Subscription {
+	var subs []*Subscription
+	seen := make(map[string]struct{})
+	for _, address := range tx.Addresses() {
+		value, ok := c.subscribers.Load(address)
+		if !ok {
+			continue
+		}
+
+		for _, s := range value.(*subscriber).handles() {
+			if _, ok := seen[s.ID]; ok || s.webhookURL == "" || !s.live(tx) || !s.filter.Matches(address, tx) {
+				continue
+			}
+			seen[s.ID] = struct{}{}
+			subs = append(subs, s)
+		}
+	}
+
+	return subs
+}
+
+// runWebhookDispatcher posts pending deliveries, including the ones left over from before a restart,
+// retrying failed ones with exponential backoff until they are acknowledged or dead-lettered
+func (c *Client) runWebhookDispatcher(ctx context.Context) {
+	for {
+		c.dispatchDueWebhooks(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(webhookPollInterval):
+		case <-c.webhookWake:
+		}
+	}
+}
+
+func (c *Client) dispatchDueWebhooks(ctx context.Context) {
+	cfg := c.config.Webhook
+
+	due, err := cfg.Deliveries.GetDue(c.clock.Now(), webhookBatchSize)
+	if err != nil {
+		c.logger.Errorf("Error getting due webhook deliveries: %v", err)
+		return
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		sendErr := cfg.Sender.Send(ctx, d)
+		if sendErr == nil {
+			if err := cfg.Deliveries.Ack(d.ID); err != nil {
+				c.logger.Errorf("Error acknowledging webhook delivery %s: %v", d.ID, err)
+			}
+			continue
+		}
+
+		// interrupted by a stop, the delivery stays due for the next run
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.Attempts++
+		d.LastError = sendErr.Error()
+
+		if d.Attempts >= cfg.MaxAttempts {
+			c.logger.Warnf("Webhook delivery %s to %s dead-lettered after %d attempts: %v", d.ID, d.URL, d.Attempts, sendErr)
+			if err := cfg.Deliveries.DeadLetter(d); err != nil {
+				c.logger.Errorf("Error dead-lettering webhook delivery %s: %v", d.ID, err)
+			}
+			continue
+		}
+
+		d.NextAttempt = c.clock.Now().Add(cfg.backoff(d.Attempts))
+		if err := cfg.Deliveries.Update(d); err != nil {
+			c.logger.Errorf("Error rescheduling webhook delivery %s: %v", d.ID, err)
+		}
+	}
+}
+
+// GetWebhookDeadLetters returns the webhook deliveries that exhausted their attempts
+func (c *Client) GetWebhookDeadLetters() ([]domain.Delivery, error) {
+	if c.config.Webhook.Deliveries == nil {
+		return nil, fmt.Errorf("webhook delivery is not configured")
+	}
+
+	return c.config.Webhook.Deliveries.GetDeadLetters()
+}
+
+// backoff returns the delay before the next attempt: InitialBackoff doubled after every failed attempt, capped at MaxBackoff
+func (w WebhookConfig) backoff(attempts int) time.Duration {
+	return exponentialBackoff(w.InitialBackoff, w.MaxBackoff, attempts)
+}
+
+func exponentialBackoff(initial, limit time.Duration, attempts int) time.Duration {
+	delay := initial
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+
+	return min(delay, limit)
+}
+
+func idempotencyKey(subscriptionID, eventKey string) string {
+	sum := sha256.Sum256([]byte(subscriptionID + ":" + eventKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// sealSecret encrypts a webhook secret with AES-GCM under the secret key, the nonce prefixing the result
+func (w WebhookConfig) sealSecret(secret string) ([]byte, error) {
+	if len(w.SecretKey) == 0 {
+		return nil, fmt.Errorf("no webhook secret key is configured to persist it with")
+	}
+
+	gcm, err := w.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+// openSecret decrypts a webhook secret sealed by sealSecret
+func (w WebhookConfig) openSecret(sealed []byte) (string, error) {
+	gcm, err := w.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("sealed webhook secret is too short")
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt webhook secret: %w", err)
+	}
+
+	return string(secret), nil
+}
+
+func (w WebhookConfig) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(w.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook secret key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func sign(payload []byte, secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
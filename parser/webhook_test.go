@@ -0,0 +1,257 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateeullahmalik/eh_parser/common/storage/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/domain"
+	"github.com/mateeullahmalik/eh_parser/parser/domain/transaction"
+	store "github.com/mateeullahmalik/eh_parser/parser/infrastructure/store/memory"
+	"github.com/mateeullahmalik/eh_parser/parser/infrastructure/webhook"
+)
+
+const webhookSecret = "webhook secret"
+
+var secretKey = bytes.Repeat([]byte{7}, 32)
+
+// receiver is a webhook endpoint recording the payloads it acknowledges, it rejects bad signatures
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	payloads []domain.WebhookPayload
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		mac := hmac.New(sha256.New, []byte(webhookSecret))
+		mac.Write(body)
+		if req.Header.Get(webhook.HeaderSignature) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload domain.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.ID != req.Header.Get(webhook.HeaderIdempotencyKey) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		r.payloads = append(r.payloads, payload)
+		r.mu.Unlock()
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+// received returns the acknowledged payloads of the given type
+func (r *receiver) received(eventType domain.EventType) []domain.WebhookPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []domain.WebhookPayload
+	for _, p := range r.payloads {
+		if p.Type == eventType {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+func webhookConfig() *Config {
+	cfg := testConfig()
+	cfg.Webhook.Deliveries = store.NewDeliveryMemoryStore()
+	cfg.Webhook.Sender = webhook.NewSender()
+	cfg.Webhook.InitialBackoff = time.Millisecond
+	cfg.Webhook.MaxBackoff = time.Millisecond
+
+	return cfg
+}
+
+func webhookOpts(r *receiver) *SubscribeOpts {
+	return &SubscribeOpts{WebhookURL: r.URL, WebhookSecret: webhookSecret}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	r := newReceiver(t)
+	chain := newFakeChain(4)
+	c := startClient(t, chain, webhookConfig())
+	sub := subscribeAndSync(t, c, chain, addrB, webhookOpts(r))
+
+	waitFor(t, "a delivery per block", func() bool { return len(r.received(domain.EventTransaction)) == 3 })
+
+	for _, p := range r.received(domain.EventTransaction) {
+		if p.SubscriptionID != sub.ID || p.Address != addrB {
+			t.Errorf("payload for %s of %s, want %s of %s", p.SubscriptionID, p.Address, sub.ID, addrB)
+		}
+
+		if len(p.Transactions) != 1 || p.Transactions[0].TxID != "tx-"+p.Block.Hash {
+			t.Errorf("payload of block %d holds %+v", p.Block.Number, p.Transactions)
+		}
+	}
+}
+
+// failingCommitStore fails Commit while fail is set
+type failingCommitStore struct {
+	transaction.Repository
+	fail atomic.Bool
+}
+
+func (s *failingCommitStore) Commit(checkpoint domain.Checkpoint, txs domain.Transactions) error {
+	if s.fail.Load() {
+		return errors.New("commit failed")
+	}
+
+	return s.Repository.Commit(checkpoint, txs)
+}
+
+func TestWebhookEnqueuedOnlyOnceCommitted(t *testing.T) {
+	r := newReceiver(t)
+	chain := newFakeChain(3)
+	txs := &failingCommitStore{Repository: store.NewTransactionMemoryStore()}
+	c := startClientWithStore(t, chain, txs, webhookConfig())
+	subscribeAndSync(t, c, chain, addrA, webhookOpts(r))
+	waitFor(t, "the deliveries of the first blocks", func() bool { return len(r.received(domain.EventTransaction)) == 2 })
+
+	txs.fail.Store(true)
+	chain.extend(1, "a")
+
+	// give the failing commit a few ticks
+	time.Sleep(50 * time.Millisecond)
+	if n := len(r.received(domain.EventTransaction)); n != 2 {
+		t.Fatalf("%d deliveries while block 3 fails to be committed, want 2", n)
+	}
+
+	txs.fail.Store(false)
+	waitFor(t, "the delivery of block 3", func() bool { return len(r.received(domain.EventTransaction)) == 3 })
+}
+
+func TestWebhookOfCheckpointEnqueuedAgainOnRun(t *testing.T) {
+	chain := newFakeChain(2)
+	deliveries := store.NewDeliveryMemoryStore()
+	txs := store.NewTransactionMemoryStore()
+
+	cfg := testConfig()
+	cfg.Subscriptions = store.NewSubscriptionMemoryStore()
+	cfg.Webhook.Deliveries = deliveries
+	cfg.Webhook.Sender = refusingSender{}
+	cfg.Webhook.SecretKey = secretKey
+
+	c := startClientWithStore(t, chain, txs, cfg)
+	subscribeAndSync(t, c, chain, addrA, &SubscribeOpts{WebhookURL: "http://receiver.invalid", WebhookSecret: webhookSecret})
+	c.Stop(context.Background())
+
+	// lose the deliveries, as a crash between the commit and the enqueue would
+	pending, _ := deliveries.GetDue(time.Now().Add(time.Hour), 100)
+	for _, d := range pending {
+		deliveries.Ack(d.ID)
+	}
+
+	startClientWithStore(t, chain, txs, cfg)
+	waitFor(t, "the delivery of the checkpoint's block", func() bool {
+		due, _ := deliveries.GetDue(time.Now().Add(time.Hour), 100)
+		return len(due) == 1
+	})
+}
+
+func TestReorgWebhookOnlyForRevertedSubscriptions(t *testing.T) {
+	touched, untouched := newReceiver(t), newReceiver(t)
+	chain := newFakeChain(6)
+	c := startClient(t, chain, webhookConfig())
+	subscribeAndSync(t, c, chain, addrC, webhookOpts(untouched))
+	subscribeAndSync(t, c, chain, addrA, webhookOpts(touched))
+
+	chain.extend(2, "a")
+	waitForBlock(t, c, 7)
+	chain.reorg(6, 3, "b")
+	waitForBlock(t, c, 8)
+
+	waitFor(t, "the reorg delivery", func() bool { return len(touched.received(domain.EventReorg)) == 1 })
+
+	reorg := touched.received(domain.EventReorg)[0]
+	if len(reorg.Transactions) != 2 || reorg.Reorg == nil || len(reorg.Reorg.Reverted) != 2 {
+		t.Errorf("reorg payload = %+v, want the 2 reverted transactions", reorg)
+	}
+
+	// the deliveries are dispatched in order, the untouched receiver got everything it was due by now
+	time.Sleep(20 * time.Millisecond)
+	if n := len(untouched.received(domain.EventReorg)); n != 0 {
+		t.Errorf("subscription without reverted transactions got %d reorg deliveries", n)
+	}
+}
+
+func TestWebhookSecretPersistedSealed(t *testing.T) {
+	r := newReceiver(t)
+	chain := newFakeChain(2)
+	db := memory.NewKeyValue()
+	subs := store.NewSubscriptionStoreWithKeyValue(db)
+	txs := store.NewTransactionMemoryStore()
+
+	cfg := webhookConfig()
+	cfg.Subscriptions = subs
+
+	c := startClientWithStore(t, chain, txs, cfg)
+	if _, err := c.SubscribeWithOpts(addrA, webhookOpts(r)); err == nil {
+		t.Fatal("subscription with a secret persisted without a secret key")
+	}
+	c.Stop(context.Background())
+
+	cfg.Webhook.SecretKey = secretKey
+	c = startClientWithStore(t, chain, txs, cfg)
+	sub := subscribeAndSync(t, c, chain, addrA, webhookOpts(r))
+
+	saved, err := subs.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	if len(saved) != 1 || saved[0].WebhookSecret != "" || len(saved[0].SealedWebhookSecret) == 0 {
+		t.Fatalf("persisted subscriptions = %+v, want the secret sealed only", saved)
+	}
+
+	raw, err := db.Get("subscription:" + sub.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if bytes.Contains(raw, []byte(webhookSecret)) {
+		t.Errorf("persisted record holds the plaintext secret: %s", raw)
+	}
+
+	if info := sub.Info(); info.WebhookSecret != "" || info.SealedWebhookSecret != nil {
+		t.Errorf("Info exposes the secret: %+v", info)
+	}
+
+	// the restored subscription signs with the decrypted secret, which the receiver checks
+	c.Stop(context.Background())
+	c = startClientWithStore(t, chain, txs, cfg)
+	chain.extend(1, "a")
+	waitForBlock(t, c, 2)
+	waitFor(t, "the delivery of block 2", func() bool {
+		for _, p := range r.received(domain.EventTransaction) {
+			if p.Block.Number == 2 {
+				return true
+			}
+		}
+		return false
+	})
+}